@@ -0,0 +1,156 @@
+package main
+
+import (
+	"cloudshell/pkg/xtermjs"
+	"cloudshell/pkg/xtermjs/client"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// exitStatusError is returned by commands that should exit with a
+// specific status without printing an error
+type exitStatusError struct {
+	status int
+}
+
+func (e *exitStatusError) Error() string {
+	return fmt.Sprintf("exited with status %v", e.status)
+}
+
+type attachOptions struct {
+	headers               []string
+	insecureSkipTLSVerify bool
+	parameters            []string
+	profile               string
+	session               string
+}
+
+func getAttachCommand() *cobra.Command {
+	options := attachOptions{}
+	command := &cobra.Command{
+		Use:   "attach <server-url>",
+		Short: "Attaches the local terminal to a shell on a cloudshell server",
+		Long: "Attaches the local terminal to a new shell (or the existing session specified by --session) on the cloudshell server at <server-url> (eg. https://cloudshell.example.com), " +
+			"the xterm.js endpoint path is taken from --path-xtermjs. Exits with the exit status of the remote process",
+		Args:          cobra.ExactArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(command *cobra.Command, args []string) error {
+			err := runAttach(args[0], options)
+			var exitErr *exitStatusError
+			if err != nil && !errors.As(err, &exitErr) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			return err
+		},
+	}
+	command.Flags().StringArrayVar(&options.headers, "header", []string{}, "header to send when connecting in the format 'Name: value' (can be repeated), use this with authenticating proxies")
+	command.Flags().BoolVar(&options.insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "skip verification of the server's tls certificate")
+	command.Flags().StringArrayVar(&options.parameters, "param", []string{}, "profile parameter in the format 'name=value' (can be repeated)")
+	command.Flags().StringVar(&options.profile, "profile", "", "name of the profile to use (defaults to the server's default profile)")
+	command.Flags().StringVar(&options.session, "session", "", "id of an existing session to attach to instead of starting a new one")
+	return command
+}
+
+// getAttachURL returns the url of the xterm.js websocket endpoint of the
+// server at serverURL
+func getAttachURL(serverURL string, options attachOptions) (string, error) {
+	query := url.Values{}
+	if options.session != "" {
+		if options.profile != "" || len(options.parameters) > 0 {
+			return "", errors.New("--profile and --param cannot be used with --session")
+		}
+		query.Set(xtermjs.SessionQueryParameter, options.session)
+	}
+	if options.profile != "" {
+		query.Set("profile", options.profile)
+	}
+	for _, parameter := range options.parameters {
+		pair := strings.SplitN(parameter, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return "", fmt.Errorf("parameter '%s' should be in the format 'name=value'", parameter)
+		}
+		query.Add(pair[0], pair[1])
+	}
+	return client.GetURL(serverURL, conf.GetString("path-xtermjs"), query)
+}
+
+// getAttachHeaders parses headers in the format 'Name: value'
+func getAttachHeaders(headers []string) (http.Header, error) {
+	parsedHeaders := http.Header{}
+	for _, header := range headers {
+		pair := strings.SplitN(header, ":", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("header '%s' should be in the format 'Name: value'", header)
+		}
+		parsedHeaders.Add(strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1]))
+	}
+	return parsedHeaders, nil
+}
+
+func runAttach(serverURL string, options attachOptions) error {
+	attachURL, err := getAttachURL(serverURL, options)
+	if err != nil {
+		return err
+	}
+	headers, err := getAttachHeaders(options.headers)
+	if err != nil {
+		return err
+	}
+	connection, err := client.Dial(attachURL, client.Options{
+		Header:          headers,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: options.insecureSkipTLSVerify},
+	})
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	stdinFd := int(os.Stdin.Fd())
+	if term.IsTerminal(stdinFd) {
+		previousState, err := term.MakeRaw(stdinFd)
+		if err != nil {
+			return fmt.Errorf("failed to put terminal into raw mode: %s", err)
+		}
+		defer term.Restore(stdinFd, previousState)
+
+		// keep the remote tty size in sync with the local terminal
+		sendSize := func() {
+			if cols, rows, err := term.GetSize(stdinFd); err == nil {
+				connection.Resize(uint16(rows), uint16(cols))
+			}
+		}
+		resizes := make(chan os.Signal, 1)
+		signal.Notify(resizes, syscall.SIGWINCH)
+		defer signal.Stop(resizes)
+		go func() {
+			for range resizes {
+				sendSize()
+			}
+		}()
+		sendSize()
+	}
+
+	// local terminal >> cloudshell
+	go io.Copy(connection, os.Stdin)
+
+	// local terminal << cloudshell
+	if _, err := io.Copy(os.Stdout, connection); err != nil {
+		return fmt.Errorf("connection to '%s' was terminated: %s", attachURL, err)
+	}
+	if exitCode, ok := connection.ExitCode(); ok && exitCode != 0 {
+		return &exitStatusError{status: exitCode}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"cloudshell/internal/log"
+	"cloudshell/pkg/session"
 	"fmt"
 	"strings"
 
@@ -19,6 +20,27 @@ var conf = config.Map{
 		Usage:     "comma-delimited list of arguments that should be passed to the terminal command",
 		Shorthand: "r",
 	},
+	"audit-log": &config.String{
+		Default: "",
+		Usage:   "path to a file that the commands executed in sessions are appended to as lines of json, '-' writes to stdout (auditing is disabled when not specified)",
+	},
+	"auth-header-claims": &config.StringSlice{
+		Default: []string{},
+		Usage:   "comma-delimited list of claim=Header-Name pairs defining claims read from headers set by an authenticating proxy",
+	},
+	"auth-header-user": &config.String{
+		Default: "",
+		Usage:   "name of the header set by an authenticating proxy containing the user's identity (enables authentication)",
+	},
+	"config": &config.String{
+		Default:   "",
+		Usage:     "path to a yaml or toml configuration file, values from flags and environment take precedence",
+		Shorthand: "c",
+	},
+	"config-reload-interval": &config.Int{
+		Default: 5,
+		Usage:   "interval in seconds between checks of the configuration and profiles files for changes (0 to disable)",
+	},
 	"command": &config.String{
 		Default:   "/bin/bash",
 		Usage:     "absolute path to command to run",
@@ -29,6 +51,10 @@ var conf = config.Map{
 		Usage:     "number of times a connection should be re-attempted before it's considered dead",
 		Shorthand: "l",
 	},
+	"default-profile": &config.String{
+		Default: "default",
+		Usage:   "name of the profile to use when a connection does not request one",
+	},
 	"keepalive-ping-timeout": &config.Int{
 		Default:   20,
 		Usage:     "maximum duration in seconds between a ping message and its response to tolerate",
@@ -55,14 +81,50 @@ var conf = config.Map{
 		Default: "/metrics",
 		Usage:   "url path to the prometheus metrics endpoint",
 	},
+	"path-profiles": &config.String{
+		Default: "/profiles",
+		Usage:   "url path to the endpoint listing available profiles",
+	},
+	"path-recordings": &config.String{
+		Default: "/recordings",
+		Usage:   "url path to the recordings api",
+	},
 	"path-readiness": &config.String{
 		Default: "/readyz",
 		Usage:   "url path to the readiness probe endpoint",
 	},
+	"path-search": &config.String{
+		Default: "/search",
+		Usage:   "url path to the endpoint searching the output of sessions and recordings",
+	},
+	"path-sessions": &config.String{
+		Default: "/sessions",
+		Usage:   "url path to the sessions api",
+	},
 	"path-xtermjs": &config.String{
 		Default: "/xterm.js",
 		Usage:   "url path to the endpoint that xterm.js should attach to",
 	},
+	"policy-file": &config.String{
+		Default: "",
+		Usage:   "path to a JSON file defining rules that deny, warn about or require confirmation of commands entered into sessions",
+	},
+	"port-forward-range": &config.String{
+		Default: "",
+		Usage:   "range of ports in the format '<first>-<last>' that can be reached on localhost in sessions through the sessions api (port forwarding is disabled when empty)",
+	},
+	"profiles-file": &config.String{
+		Default: "",
+		Usage:   "path to a json file defining additional profiles (the command and arguments define the 'default' profile)",
+	},
+	"recordings-dir": &config.String{
+		Default: "",
+		Usage:   "directory that the output of sessions is recorded to (recording is disabled when not specified)",
+	},
+	"redact-patterns-file": &config.String{
+		Default: "",
+		Usage:   "path to a file of regular expressions, one per line, matching secrets to redact from recordings and the audit log in addition to the built-in detectors",
+	},
 	"server-addr": &config.String{
 		Default:   "0.0.0.0",
 		Usage:     "ip interface the server should listen on",
@@ -73,9 +135,85 @@ var conf = config.Map{
 		Usage:     "port the server should listen on",
 		Shorthand: "p",
 	},
+	"session-scrollback-lines": &config.Int{
+		Default: 1000,
+		Usage:   "number of lines that scrolled off the screen of a session that are sent to connections attaching to it",
+	},
+	"ssh-authorized-keys-file": &config.String{
+		Default: "",
+		Usage:   "path to an authorized_keys file of the public keys accepted by the ssh server, each naming its principal with a principal=\"<name>\" option",
+	},
+	"ssh-host-key-file": &config.String{
+		Default: "",
+		Usage:   "path to the private key identifying the ssh server",
+	},
+	"ssh-server-port": &config.Int{
+		Default: 0,
+		Usage:   "port the ssh server should listen on (the ssh server is disabled when 0)",
+	},
+	"upload-max-size-bytes": &config.Int{
+		Default: 100 << 20,
+		Usage:   "maximum size in bytes of each file uploaded into a session",
+	},
 	"workdir": &config.String{
 		Default:   ".",
 		Usage:     "working directory",
 		Shorthand: "w",
 	},
+	"zmodem-max-size-bytes": &config.Int{
+		Default: 100 << 20,
+		Usage:   "maximum size in bytes of each file transferred with zmodem by sz and rz in sessions (0 disables handing zmodem transfers to the browser)",
+	},
+}
+
+// validateConfig returns an error describing the first invalid value found
+// in the configuration
+func validateConfig() error {
+	if !isOneOf(conf.GetString("log-format"), log.ValidFormatStrings) {
+		return fmt.Errorf("log-format '%s' is not one of ['%s']", conf.GetString("log-format"), strings.Join(log.ValidFormatStrings, "', '"))
+	}
+	if !isOneOf(conf.GetString("log-level"), log.ValidLevelStrings) {
+		return fmt.Errorf("log-level '%s' is not one of ['%s']", conf.GetString("log-level"), strings.Join(log.ValidLevelStrings, "', '"))
+	}
+	if port := conf.GetInt("server-port"); port < 1 || port > 65535 {
+		return fmt.Errorf("server-port %v is not between 1 and 65535", port)
+	}
+	if port := conf.GetInt("ssh-server-port"); port < 0 || port > 65535 {
+		return fmt.Errorf("ssh-server-port %v is not between 0 and 65535", port)
+	}
+	if conf.GetInt("ssh-server-port") > 0 {
+		for _, key := range []string{"ssh-authorized-keys-file", "ssh-host-key-file"} {
+			if conf.GetString(key) == "" {
+				return fmt.Errorf("%s is required when ssh-server-port is set", key)
+			}
+		}
+	}
+	if _, err := session.ParsePortRange(conf.GetString("port-forward-range")); err != nil {
+		return fmt.Errorf("port-forward-range is invalid: %s", err)
+	}
+	for _, key := range []string{"config-reload-interval", "connection-error-limit", "keepalive-ping-timeout", "zmodem-max-size-bytes"} {
+		if conf.GetInt(key) < 0 {
+			return fmt.Errorf("%s %v cannot be negative", key, conf.GetInt(key))
+		}
+	}
+	for _, key := range []string{"max-buffer-size-bytes", "session-scrollback-lines", "upload-max-size-bytes"} {
+		if conf.GetInt(key) <= 0 {
+			return fmt.Errorf("%s %v should be greater than 0", key, conf.GetInt(key))
+		}
+	}
+	for _, key := range []string{"path-liveness", "path-metrics", "path-profiles", "path-readiness", "path-recordings", "path-search", "path-sessions", "path-xtermjs"} {
+		if !strings.HasPrefix(conf.GetString(key), "/") {
+			return fmt.Errorf("%s '%s' should begin with '/'", key, conf.GetString(key))
+		}
+	}
+	return nil
+}
+
+func isOneOf(value string, validValues []string) bool {
+	for _, validValue := range validValues {
+		if value == validValue {
+			return true
+		}
+	}
+	return false
 }
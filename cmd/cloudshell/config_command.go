@@ -0,0 +1,321 @@
+package main
+
+import (
+	"cloudshell/pkg/profile"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	configSourceDefault      = "default"
+	configSourceEnvironment  = "environment"
+	configSourceFile         = "file"
+	configSourceFlag         = "flag"
+	configSourceProfilesFile = "profiles-file"
+
+	maskedValue = "********"
+)
+
+// secretKeyPattern matches names of configuration keys and environment
+// variables whose values should not be displayed
+var secretKeyPattern = regexp.MustCompile(`(?i)(password|passphrase|secret|token|credential|private[-_]?key|api[-_]?key)`)
+
+func getConfigCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "config",
+		Short: "Inspects the configuration that the server would use",
+	}
+	command.AddCommand(&cobra.Command{
+		Use:          "validate",
+		Short:        "Validates the configuration from flags, environment and configuration file",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         runConfigValidateE,
+	})
+	command.AddCommand(&cobra.Command{
+		Use:          "dump",
+		Short:        "Prints the effective configuration with the source of each value",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         runConfigDumpE,
+	})
+	return command
+}
+
+func runConfigValidateE(command *cobra.Command, _ []string) error {
+	output := command.OutOrStdout()
+	failures := 0
+	report := func(check string, err error) {
+		if err != nil {
+			failures++
+			fmt.Fprintf(output, "[error] %s: %s\n", check, err)
+			return
+		}
+		fmt.Fprintf(output, "[ok]    %s\n", check)
+	}
+
+	var file *configFile
+	if configFilePath := conf.GetString("config"); configFilePath != "" {
+		loadedFile, err := loadConfigFile(configFilePath)
+		if err == nil {
+			err = loadedFile.applyTo(conf)
+		}
+		report(fmt.Sprintf("config file '%s' is valid", configFilePath), err)
+		if err != nil {
+			return errors.New("configuration is invalid")
+		}
+		file = loadedFile
+	}
+	report("configuration values are valid", validateConfig())
+
+	defaultProfile, profiles, err := loadProfiles(file)
+	if err == nil {
+		_, err = profile.NewRegistry(defaultProfile, profiles...)
+	}
+	report("profiles are valid", err)
+	if err == nil {
+		for _, p := range profiles {
+			// the command and workdir of other backends are on the remote
+			// host, in the container or in the pod
+			if !p.IsLocal() {
+				continue
+			}
+			report(fmt.Sprintf("profile '%s' command '%s' is executable", p.Name, p.Command), checkExecutable(p.Command, p.Workdir))
+			if p.Workdir != "" {
+				report(fmt.Sprintf("profile '%s' workdir '%s' exists", p.Name, p.Workdir), checkDirectory(p.Workdir))
+			}
+		}
+	}
+
+	report(fmt.Sprintf("workdir '%s' exists", conf.GetString("workdir")), checkDirectory(conf.GetString("workdir")))
+	if recordingsDirectory := conf.GetString("recordings-dir"); recordingsDirectory != "" {
+		report(fmt.Sprintf("recordings-dir '%s' exists", recordingsDirectory), checkDirectory(recordingsDirectory))
+	}
+	if policyFile := conf.GetString("policy-file"); policyFile != "" {
+		_, err := loadPolicy()
+		report(fmt.Sprintf("policy-file '%s' is valid", policyFile), err)
+	}
+	if patternsFile := conf.GetString("redact-patterns-file"); patternsFile != "" {
+		_, err := loadRedactor()
+		report(fmt.Sprintf("redact-patterns-file '%s' is valid", patternsFile), err)
+	}
+	report("url paths do not collide", checkPathCollisions())
+	listenOnAddresses := listenerAddresses()
+	for _, listenOnAddress := range listenOnAddresses {
+		report(fmt.Sprintf("address '%s' is available", listenOnAddress), checkAddressAvailable(listenOnAddress))
+	}
+	if len(listenOnAddresses) > 1 {
+		report("listener addresses are distinct", checkAddressesDistinct(listenOnAddresses))
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("configuration is invalid (%v check(s) failed)", failures)
+	}
+	return nil
+}
+
+func runConfigDumpE(command *cobra.Command, _ []string) error {
+	file, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	output := command.OutOrStdout()
+	keys := make([]string, 0, len(conf))
+	for key := range conf {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := conf[key].GetValue()
+		if secretKeyPattern.MatchString(key) && !isZeroConfigValue(value) {
+			value = maskedValue
+		}
+		serialised, err := yaml.Marshal(map[string]interface{}{key: value})
+		if err != nil {
+			return fmt.Errorf("failed to serialise '%s': %s", key, err)
+		}
+		fmt.Fprintf(output, "# source: %s\n%s", getConfigSource(key, file), serialised)
+	}
+
+	defaultProfile, profiles, err := loadProfiles(file)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "# default profile: %s\n%s:\n", defaultProfile, configFileSectionProfiles)
+	fileProfilesOffset := len(profiles) - len(getFileProfiles(file))
+	for index, p := range profiles {
+		source := getConfigSource("command", file)
+		if index >= fileProfilesOffset {
+			source = fmt.Sprintf("%s (%s)", configSourceFile, file.Path)
+		} else if index > 0 {
+			source = fmt.Sprintf("%s (%s)", configSourceProfilesFile, conf.GetString("profiles-file"))
+		}
+		env := make(map[string]string, len(p.Env))
+		for key, value := range p.Env {
+			if secretKeyPattern.MatchString(key) {
+				value = maskedValue
+			}
+			env[key] = value
+		}
+		p.Env = env
+		serialised, err := yaml.Marshal([]interface{}{toYAMLCompatible(p)})
+		if err != nil {
+			return fmt.Errorf("failed to serialise profile '%s': %s", p.Name, err)
+		}
+		fmt.Fprintf(output, "# source: %s\n%s", source, serialised)
+	}
+	return nil
+}
+
+// getConfigSource returns where the value of key was provided from
+func getConfigSource(key string, file *configFile) string {
+	if conf[key].IsSetExplicitlyByFlag() {
+		return configSourceFlag
+	}
+	if value, ok := os.LookupEnv(getEnvironmentKey(key)); ok && value != "" {
+		return configSourceEnvironment
+	}
+	if file != nil {
+		if _, ok := file.Values[key]; ok {
+			return fmt.Sprintf("%s (%s)", configSourceFile, file.Path)
+		}
+	}
+	return configSourceDefault
+}
+
+func getFileProfiles(file *configFile) []profile.Profile {
+	if file == nil {
+		return nil
+	}
+	return file.Profiles
+}
+
+func isZeroConfigValue(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue == ""
+	case []string:
+		return len(typedValue) == 0
+	}
+	return false
+}
+
+// checkExecutable returns an error if command cannot be executed from workdir
+func checkExecutable(command, workdir string) error {
+	if !strings.Contains(command, "/") {
+		_, err := exec.LookPath(command)
+		return err
+	}
+	if !path.IsAbs(command) && workdir != "" {
+		command = path.Join(workdir, command)
+	}
+	info, err := os.Stat(command)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("'%s' is a directory", command)
+	}
+	if info.Mode()&0111 == 0 {
+		return fmt.Errorf("'%s' does not have executable permissions", command)
+	}
+	return nil
+}
+
+// checkDirectory returns an error if directory does not exist
+func checkDirectory(directory string) error {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", directory)
+	}
+	return nil
+}
+
+// checkPathCollisions returns an error if the url paths of any two
+// endpoints are the same or shadow each other
+func checkPathCollisions() error {
+	paths := map[string]string{
+		"/version": "version endpoint",
+		"/assets":  "assets endpoint",
+	}
+	for _, key := range []string{"path-liveness", "path-metrics", "path-profiles", "path-readiness", "path-recordings", "path-search", "path-sessions", "path-xtermjs"} {
+		pathValue := path.Clean(conf.GetString(key))
+		if existing, ok := paths[pathValue]; ok {
+			return fmt.Errorf("%s '%s' collides with %s", key, pathValue, existing)
+		}
+		if pathValue == "/" {
+			return fmt.Errorf("%s cannot be '/' as it is used by the website", key)
+		}
+		paths[pathValue] = key
+	}
+	// the assets endpoint, recordings and sessions apis and xterm.js
+	// profile paths match everything below them
+	for _, prefixKey := range []string{"/assets", path.Clean(conf.GetString("path-recordings")), path.Clean(conf.GetString("path-sessions")), path.Clean(conf.GetString("path-xtermjs"))} {
+		for pathValue, key := range paths {
+			if strings.HasPrefix(pathValue, prefixKey+"/") {
+				return fmt.Errorf("%s '%s' is shadowed by %s", key, pathValue, paths[prefixKey])
+			}
+		}
+	}
+	return nil
+}
+
+// listenerAddresses returns the addresses of all the servers that are
+// started, the ssh server is only started when its port is set
+func listenerAddresses() []string {
+	addresses := []string{fmt.Sprintf("%s:%v", conf.GetString("server-addr"), conf.GetInt("server-port"))}
+	if port := conf.GetInt("ssh-server-port"); port > 0 {
+		addresses = append(addresses, fmt.Sprintf("%s:%v", conf.GetString("server-addr"), port))
+	}
+	return addresses
+}
+
+// checkAddressesDistinct returns an error if two servers would listen on
+// the same address
+func checkAddressesDistinct(addresses []string) error {
+	seen := map[string]bool{}
+	for _, address := range addresses {
+		if seen[address] {
+			return fmt.Errorf("address '%s' is used by more than one server", address)
+		}
+		seen[address] = true
+	}
+	return nil
+}
+
+// checkAddressAvailable returns an error if the server cannot listen on address
+func checkAddressAvailable(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// toYAMLCompatible converts value into a structure that serialises to YAML
+// using the JSON field names
+func toYAMLCompatible(value interface{}) interface{} {
+	var converted interface{}
+	asJSON, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	if err := yaml.Unmarshal(asJSON, &converted); err != nil {
+		return value
+	}
+	return converted
+}
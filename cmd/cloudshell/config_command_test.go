@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validateProfiles runs `config validate` with profiles loaded from a
+// profiles file holding profiles and returns its output
+func validateProfiles(t *testing.T, profiles []map[string]interface{}) string {
+	previousValues := map[string]interface{}{}
+	for key, definition := range conf {
+		previousValues[key] = definition.GetValue()
+	}
+	t.Cleanup(func() {
+		for key, value := range previousValues {
+			conf[key].SetValue(value)
+		}
+	})
+	contents, err := json.Marshal(profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profilesFile := filepath.Join(t.TempDir(), "profiles.json")
+	if err := ioutil.WriteFile(profilesFile, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf["profiles-file"].SetValue(profilesFile)
+	command := getConfigCommand()
+	output := &bytes.Buffer{}
+	command.SetOut(output)
+	command.SetErr(ioutil.Discard)
+	command.SetArgs([]string{"validate"})
+	command.Execute()
+	return output.String()
+}
+
+func TestConfigValidateProfiles(t *testing.T) {
+	// the command and workdir only exist on the side of the backend
+	tests := []struct {
+		backend string
+		profile map[string]interface{}
+		// checked is true when the command and workdir are checked locally
+		checked bool
+	}{
+		{"local", map[string]interface{}{}, true},
+		{"ssh", map[string]interface{}{"ssh": map[string]interface{}{"host": "example.com", "user": "alice", "agent": true}}, false},
+		{"container", map[string]interface{}{"container": map[string]interface{}{"name": "toolbox"}}, false},
+		{"kubernetes", map[string]interface{}{"kubernetes": map[string]interface{}{"pod": "toolbox"}}, false},
+	}
+	for _, test := range tests {
+		test.profile["name"] = test.backend
+		test.profile["command"] = "/opt/toolbox/bin/zsh"
+		test.profile["workdir"] = "/home/alice"
+		output := validateProfiles(t, []map[string]interface{}{test.profile})
+		if !strings.Contains(output, "[ok]    profiles are valid") {
+			t.Errorf("%s: expected the profiles to be valid, got %s", test.backend, output)
+		}
+		commandChecked := strings.Contains(output, "[error] profile '"+test.backend+"' command '/opt/toolbox/bin/zsh' is executable")
+		workdirChecked := strings.Contains(output, "[error] profile '"+test.backend+"' workdir '/home/alice' exists")
+		if commandChecked != test.checked || workdirChecked != test.checked {
+			t.Errorf("%s: expected the command and workdir to be checked locally to be %v, got %s", test.backend, test.checked, output)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"cloudshell/internal/log"
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/policy"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/redact"
+	"cloudshell/pkg/session"
+	"cloudshell/pkg/xtermjs"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/usvc/go-config"
+	"gopkg.in/yaml.v2"
+)
+
+// configFileSectionProfiles is the key of the configuration file section
+// defining profiles
+const configFileSectionProfiles = "profiles"
+
+// reloadableConfigKeys lists the configuration keys whose changes in the
+// configuration file are applied without restarting the server
+var reloadableConfigKeys = []string{
+	"allowed-hostnames",
+	"arguments",
+	"command",
+	"connection-error-limit",
+	"default-profile",
+	"keepalive-ping-timeout",
+	"log-level",
+	"max-buffer-size-bytes",
+	"profiles-file",
+}
+
+// configFile holds the configuration loaded from a configuration file
+type configFile struct {
+	// Path is the path the configuration was loaded from
+	Path string
+	// Values maps configuration keys to their values
+	Values map[string]interface{}
+	// Profiles are the profiles defined in the profiles section
+	Profiles []profile.Profile
+}
+
+// loadConfig applies the configuration file specified by the config key (if
+// any) on top of the flags and environment and validates the result
+func loadConfig() (*configFile, error) {
+	var file *configFile
+	if configFilePath := conf.GetString("config"); configFilePath != "" {
+		loadedFile, err := loadConfigFile(configFilePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := loadedFile.applyTo(conf); err != nil {
+			return nil, err
+		}
+		file = loadedFile
+	}
+	if err := validateConfig(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %s", err)
+	}
+	return file, nil
+}
+
+// loadConfigFile loads and validates the YAML or TOML configuration file at
+// filePath, the format is derived from the file extension
+func loadConfigFile(filePath string) (*configFile, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file '%s': %s", filePath, err)
+	}
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".toml":
+		if err := toml.Unmarshal(contents, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse config file '%s' as toml: %s", filePath, err)
+		}
+	case ".yaml", ".yml":
+		rawYAML := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(contents, &rawYAML); err != nil {
+			return nil, fmt.Errorf("failed to parse config file '%s' as yaml: %s", filePath, err)
+		}
+		raw = normaliseYAML(rawYAML).(map[string]interface{})
+	default:
+		return nil, fmt.Errorf("failed to parse config file '%s': extension should be one of ['.yaml', '.yml', '.toml']", filePath)
+	}
+
+	file := &configFile{Path: filePath, Values: map[string]interface{}{}}
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == configFileSectionProfiles {
+			profiles, err := parseConfigFileProfiles(raw[key])
+			if err != nil {
+				return nil, fmt.Errorf("config file '%s': %s", filePath, err)
+			}
+			file.Profiles = profiles
+			continue
+		}
+		if key == "config" {
+			return nil, fmt.Errorf("config file '%s': key 'config' can only be set via flag or environment", filePath)
+		}
+		definition, ok := conf[key]
+		if !ok {
+			return nil, fmt.Errorf("config file '%s': unknown key '%s'", filePath, key)
+		}
+		value, err := convertConfigFileValue(definition, raw[key])
+		if err != nil {
+			return nil, fmt.Errorf("config file '%s': key '%s' %s", filePath, key, err)
+		}
+		file.Values[key] = value
+	}
+	return file, nil
+}
+
+// parseConfigFileProfiles decodes the profiles section using the same
+// schema as the profiles file, unknown fields are rejected
+func parseConfigFileProfiles(section interface{}) ([]profile.Profile, error) {
+	var items []interface{}
+	switch typedSection := section.(type) {
+	case []interface{}:
+		items = typedSection
+	case []map[string]interface{}:
+		for _, item := range typedSection {
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("section '%s' should be a list", configFileSectionProfiles)
+	}
+	profiles := make([]profile.Profile, 0, len(items))
+	for index, item := range items {
+		asJSON, err := json.Marshal(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%v]: %s", configFileSectionProfiles, index, err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(asJSON))
+		decoder.DisallowUnknownFields()
+		var p profile.Profile
+		if err := decoder.Decode(&p); err != nil {
+			return nil, fmt.Errorf("%s[%v]: %s", configFileSectionProfiles, index, err)
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%s[%v]: %s", configFileSectionProfiles, index, err)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// convertConfigFileValue converts value into the type expected by definition
+func convertConfigFileValue(definition config.Config, value interface{}) (interface{}, error) {
+	switch definition.(type) {
+	case *config.String:
+		if stringValue, ok := value.(string); ok {
+			return stringValue, nil
+		}
+		return nil, fmt.Errorf("should be a string but got '%v'", value)
+	case *config.Int:
+		switch intValue := value.(type) {
+		case int:
+			return intValue, nil
+		case int64:
+			return int(intValue), nil
+		}
+		return nil, fmt.Errorf("should be an integer but got '%v'", value)
+	case *config.Bool:
+		if boolValue, ok := value.(bool); ok {
+			return boolValue, nil
+		}
+		return nil, fmt.Errorf("should be a boolean but got '%v'", value)
+	case *config.StringSlice:
+		switch sliceValue := value.(type) {
+		case string:
+			if sliceValue == "" {
+				return []string{}, nil
+			}
+			return strings.Split(sliceValue, ","), nil
+		case []interface{}:
+			stringSlice := make([]string, 0, len(sliceValue))
+			for index, item := range sliceValue {
+				stringItem, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("should be a list of strings but item %v is '%v'", index, item)
+				}
+				stringSlice = append(stringSlice, stringItem)
+			}
+			return stringSlice, nil
+		}
+		return nil, fmt.Errorf("should be a list of strings but got '%v'", value)
+	}
+	return nil, fmt.Errorf("has an unsupported type")
+}
+
+// normaliseYAML converts the map[interface{}]interface{} maps produced by
+// the yaml decoder into map[string]interface{} maps
+func normaliseYAML(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[interface{}]interface{}:
+		normalised := make(map[string]interface{}, len(typedValue))
+		for key, item := range typedValue {
+			normalised[fmt.Sprintf("%v", key)] = normaliseYAML(item)
+		}
+		return normalised
+	case []interface{}:
+		for index, item := range typedValue {
+			typedValue[index] = normaliseYAML(item)
+		}
+		return typedValue
+	}
+	return value
+}
+
+// getEnvironmentKey returns the environment variable name of key
+func getEnvironmentKey(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// isSetByFlagOrEnvironment returns true if the value of key was provided
+// via flag or environment, these take precedence over the configuration file
+func isSetByFlagOrEnvironment(key string) bool {
+	if conf[key].IsSetExplicitlyByFlag() {
+		return true
+	}
+	value, ok := os.LookupEnv(getEnvironmentKey(key))
+	return ok && value != ""
+}
+
+// applyTo sets the values of the configuration file on keys that were not
+// provided via flag or environment, keys which are not in the configuration
+// file are reset to their defaults
+func (c *configFile) applyTo(m config.Map) error {
+	for key, definition := range m {
+		if isSetByFlagOrEnvironment(key) {
+			continue
+		}
+		value, ok := c.Values[key]
+		if !ok {
+			value = definition.GetDefault()
+		}
+		if err := definition.SetValue(value); err != nil {
+			return fmt.Errorf("failed to set '%s' from config file: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// reloadConfig re-applies the configuration file at configFilePath (if
+// any) and updates the profiles, xterm.js handler options and allowed
+// origins, the previous configuration remains in use when the new
+// configuration is invalid
+func reloadConfig(configFilePath string, profileRegistry *profile.Registry, sessionRegistry *session.Registry, auditSink audit.Sink, commandPolicy *policy.Policy, redactor *redact.Redactor, xtermjsHandler *xtermjs.Handler, originChecker *session.OriginChecker) {
+	previousValues := map[string]interface{}{}
+	for key, definition := range conf {
+		previousValues[key] = definition.GetValue()
+	}
+	restore := func() {
+		for key, value := range previousValues {
+			conf[key].SetValue(value)
+		}
+	}
+
+	var file *configFile
+	if configFilePath != "" {
+		loadedFile, err := loadConfigFile(configFilePath)
+		if err != nil {
+			log.Errorf("failed to reload configuration, keeping previous configuration: %s", err)
+			return
+		}
+		if err := loadedFile.applyTo(conf); err != nil {
+			restore()
+			log.Errorf("failed to reload configuration, keeping previous configuration: %s", err)
+			return
+		}
+		file = loadedFile
+	}
+	if err := validateConfig(); err != nil {
+		restore()
+		log.Errorf("failed to reload configuration, keeping previous configuration: invalid configuration: %s", err)
+		return
+	}
+	defaultProfile, profiles, err := loadProfiles(file)
+	if err == nil {
+		err = profileRegistry.Replace(defaultProfile, profiles)
+	}
+	if err != nil {
+		restore()
+		log.Errorf("failed to reload configuration, keeping previous configuration: failed to load profiles: %s", err)
+		return
+	}
+
+	for key, previousValue := range previousValues {
+		if !reflect.DeepEqual(previousValue, conf[key].GetValue()) && !isOneOf(key, reloadableConfigKeys) {
+			log.Warnf("change to '%s' will only be applied after a restart", key)
+		}
+	}
+	log.SetLevel(log.Level(conf.GetString("log-level")))
+	xtermjsHandler.Update(getXTermJSHandlerOptions(profileRegistry, sessionRegistry, auditSink, commandPolicy, redactor))
+	originChecker.Update(conf.GetStringSlice("allowed-hostnames"))
+	for _, p := range profileRegistry.List() {
+		log.Infof("reloaded profile '%s' (command: '%s')", p.Name, p.Command)
+	}
+	log.Info("configuration reloaded")
+}
+
+// fileWatcher polls a set of files and calls a handler when their contents
+// change, polling is used so that atomic replacements such as those done
+// for mounted Kubernetes ConfigMaps are detected
+type fileWatcher struct {
+	paths    []string
+	checksum [sha256.Size]byte
+}
+
+func newFileWatcher(paths ...string) *fileWatcher {
+	watcher := &fileWatcher{paths: paths}
+	watcher.checksum = watcher.getChecksum()
+	return watcher
+}
+
+// getChecksum returns a checksum over the contents of all watched files
+func (f *fileWatcher) getChecksum() [sha256.Size]byte {
+	var contents []byte
+	for _, filePath := range f.paths {
+		fileContents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			fileContents = []byte(err.Error())
+		}
+		contents = append(contents, fileContents...)
+		contents = append(contents, 0)
+	}
+	return sha256.Sum256(contents)
+}
+
+// watch calls onChange every time the contents of the watched files change
+// and never returns
+func (f *fileWatcher) watch(interval time.Duration, onChange func()) {
+	for range time.Tick(interval) {
+		checksum := f.getChecksum()
+		if checksum == f.checksum {
+			continue
+		}
+		f.checksum = checksum
+		log.Infof("detected changes in ['%s']", strings.Join(f.paths, "', '"))
+		onChange()
+	}
+}
@@ -2,9 +2,20 @@ package main
 
 import (
 	"cloudshell/internal/log"
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/auth"
+	"cloudshell/pkg/policy"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/recording"
+	"cloudshell/pkg/redact"
+	"cloudshell/pkg/search"
+	"cloudshell/pkg/session"
+	"cloudshell/pkg/sshserver"
 	"cloudshell/pkg/xtermjs"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -14,6 +25,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 )
 
 var VersionInfo string
@@ -28,28 +40,64 @@ func main() {
 		Version: VersionInfo,
 		RunE:    runE,
 	}
-	conf.ApplyToCobra(&command)
-	command.Execute()
+	command.AddCommand(getAttachCommand())
+	command.AddCommand(getConfigCommand())
+	conf.ApplyToCobraPersistent(&command)
+	if err := command.Execute(); err != nil {
+		var exitErr *exitStatusError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.status)
+		}
+		os.Exit(1)
+	}
 }
 
 func runE(_ *cobra.Command, _ []string) error {
+	// load the configuration file
+	configFilePath := conf.GetString("config")
+	file, err := loadConfig()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
 	// initialise the logger
 	log.Init(log.Format(conf.GetString("log-format")), log.Level(conf.GetString("log-level")))
 
 	// debug stuff
 	command := conf.GetString("command")
+	configReloadInterval := time.Duration(conf.GetInt("config-reload-interval")) * time.Second
 	connectionErrorLimit := conf.GetInt("connection-error-limit")
 	arguments := conf.GetStringSlice("arguments")
+	defaultProfile := conf.GetString("default-profile")
+	profilesFile := conf.GetString("profiles-file")
+	policyFile := conf.GetString("policy-file")
+	portForwardRange := conf.GetString("port-forward-range")
 	allowedHostnames := conf.GetStringSlice("allowed-hostnames")
+	auditLog := conf.GetString("audit-log")
+	authHeaderClaims := conf.GetStringSlice("auth-header-claims")
+	authHeaderUser := conf.GetString("auth-header-user")
 	keepalivePingTimeout := time.Duration(conf.GetInt("keepalive-ping-timeout")) * time.Second
 	maxBufferSizeBytes := conf.GetInt("max-buffer-size-bytes")
 	pathLiveness := conf.GetString("path-liveness")
 	pathMetrics := conf.GetString("path-metrics")
+	pathProfiles := conf.GetString("path-profiles")
 	pathReadiness := conf.GetString("path-readiness")
+	pathRecordings := conf.GetString("path-recordings")
+	pathSearch := conf.GetString("path-search")
+	pathSessions := conf.GetString("path-sessions")
 	pathXTermJS := conf.GetString("path-xtermjs")
+	recordingsDirectory := conf.GetString("recordings-dir")
+	redactPatternsFile := conf.GetString("redact-patterns-file")
 	serverAddress := conf.GetString("server-addr")
 	serverPort := conf.GetInt("server-port")
+	sessionScrollbackLines := conf.GetInt("session-scrollback-lines")
+	sshAuthorizedKeysFile := conf.GetString("ssh-authorized-keys-file")
+	sshHostKeyFile := conf.GetString("ssh-host-key-file")
+	sshServerPort := conf.GetInt("ssh-server-port")
+	uploadMaxSizeBytes := conf.GetInt("upload-max-size-bytes")
 	workingDirectory := conf.GetString("workdir")
+	zmodemMaxSizeBytes := conf.GetInt("zmodem-max-size-bytes")
 	if !path.IsAbs(workingDirectory) {
 		wd, err := os.Getwd()
 		if err != nil {
@@ -60,38 +108,195 @@ func runE(_ *cobra.Command, _ []string) error {
 		workingDirectory = path.Join(wd, workingDirectory)
 	}
 	log.Infof("working directory     : '%s'", workingDirectory)
+	log.Infof("config file           : '%s'", configFilePath)
+	log.Infof("config reload interval: %v", configReloadInterval)
 	log.Infof("command               : '%s'", command)
 	log.Infof("arguments             : ['%s']", strings.Join(arguments, "', '"))
+	log.Infof("profiles file         : '%s'", profilesFile)
+	log.Infof("policy file           : '%s'", policyFile)
+	log.Infof("default profile       : '%s'", defaultProfile)
 
 	log.Infof("allowed hosts         : ['%s']", strings.Join(allowedHostnames, "', '"))
+	log.Infof("audit log             : '%s'", auditLog)
+	log.Infof("auth user header      : '%s'", authHeaderUser)
+	log.Infof("auth claim headers    : ['%s']", strings.Join(authHeaderClaims, "', '"))
 	log.Infof("connection error limit: %v", connectionErrorLimit)
 	log.Infof("keepalive ping timeout: %v", keepalivePingTimeout)
 	log.Infof("max buffer size       : %v bytes", maxBufferSizeBytes)
+	log.Infof("session scrollback    : %v lines", sessionScrollbackLines)
+	log.Infof("upload max size       : %v bytes", uploadMaxSizeBytes)
+	log.Infof("zmodem max size       : %v bytes", zmodemMaxSizeBytes)
+	log.Infof("port forward range    : '%s'", portForwardRange)
+	log.Infof("recordings directory  : '%s'", recordingsDirectory)
+	log.Infof("redact patterns file  : '%s'", redactPatternsFile)
 	log.Infof("server address        : '%s' ", serverAddress)
 	log.Infof("server port           : %v", serverPort)
+	log.Infof("ssh server port       : %v", sshServerPort)
+	log.Infof("ssh host key file     : '%s'", sshHostKeyFile)
+	log.Infof("ssh authorized keys   : '%s'", sshAuthorizedKeysFile)
 
 	log.Infof("liveness checks path  : '%s'", pathLiveness)
 	log.Infof("readiness checks path : '%s'", pathReadiness)
 	log.Infof("metrics endpoint path : '%s'", pathMetrics)
+	log.Infof("profiles endpoint path: '%s'", pathProfiles)
+	log.Infof("sessions api path     : '%s'", pathSessions)
+	log.Infof("recordings api path   : '%s'", pathRecordings)
+	log.Infof("search endpoint path  : '%s'", pathSearch)
 	log.Infof("xtermjs endpoint path : '%s'", pathXTermJS)
 
+	// load profiles
+	defaultProfile, profiles, err := loadProfiles(file)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	profileRegistry, err := profile.NewRegistry(defaultProfile, profiles...)
+	if err != nil {
+		message := fmt.Sprintf("failed to load profiles: %s", err)
+		log.Error(message)
+		return errors.New(message)
+	}
+	for _, p := range profileRegistry.List() {
+		log.Infof("loaded profile        : '%s' (command: '%s')", p.Name, p.Command)
+	}
+	// workspace pods left behind by a previous run are deleted once idle
+	go session.AdoptWorkspaces(profileRegistry.List())
+
+	// configure authentication
+	var authenticator auth.Authenticator
+	// authHeaders identify users and are not forwarded to ports in sessions
+	var authHeaders []string
+	if authHeaderUser != "" {
+		headerAuthenticator, err := auth.NewHeaderAuthenticator(authHeaderUser, authHeaderClaims)
+		if err != nil {
+			message := fmt.Sprintf("failed to configure authentication: %s", err)
+			log.Error(message)
+			return errors.New(message)
+		}
+		authenticator = headerAuthenticator
+		authHeaders = headerAuthenticator.Headers()
+	}
+	requireAuth := auth.Middleware(authenticator)
+
+	// configure auditing
+	var auditSink audit.Sink
+	if auditLog != "" {
+		fileSink, err := audit.NewFileSink(auditLog)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		defer fileSink.Close()
+		auditSink = fileSink
+	}
+
+	// configure the command policy
+	commandPolicy, err := loadPolicy()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// configure redaction of recordings and the audit log
+	redactor, err := loadRedactor()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
 	// configure routing
 	router := mux.NewRouter()
 
 	// this is the endpoint for xterm.js to connect to
-	xtermjsHandlerOptions := xtermjs.HandlerOpts{
-		AllowedHostnames:     allowedHostnames,
-		Arguments:            arguments,
-		Command:              command,
-		ConnectionErrorLimit: connectionErrorLimit,
-		CreateLogger: func(connectionUUID string, r *http.Request) xtermjs.Logger {
-			createRequestLog(r, map[string]interface{}{"connection_uuid": connectionUUID}).Infof("created logger for connection '%s'", connectionUUID)
-			return createRequestLog(nil, map[string]interface{}{"connection_uuid": connectionUUID})
-		},
-		KeepalivePingTimeout: keepalivePingTimeout,
-		MaxBufferSizeBytes:   maxBufferSizeBytes,
+	sessionRegistry := session.NewRegistry()
+	xtermjsHandler := xtermjs.NewHandler(getXTermJSHandlerOptions(profileRegistry, sessionRegistry, auditSink, commandPolicy, redactor))
+	router.Handle(pathXTermJS, requireAuth(xtermjsHandler))
+	router.Handle(path.Join(pathXTermJS, "{profile}"), requireAuth(xtermjsHandler))
+
+	// profiles listing endpoint
+	router.Handle(pathProfiles, requireAuth(http.HandlerFunc(profile.GetListHandler(profileRegistry))))
+
+	// sessions api endpoints
+	headlessSessionOptions := session.Options{
+		Audit:               auditSink,
+		MaxBufferSizeBytes:  maxBufferSizeBytes,
+		Policy:              commandPolicy,
+		RecordingsDirectory: recordingsDirectory,
+		Redactor:            redactor,
+		ScrollbackLines:     sessionScrollbackLines,
+		ZModemMaxSizeBytes:  int64(zmodemMaxSizeBytes),
+	}
+	// requests changing sessions are only accepted from the allowed
+	// hostnames so that other websites cannot make browsers send them
+	originChecker := session.NewOriginChecker(allowedHostnames)
+	requireOrigin := originChecker.Middleware
+	router.Handle(pathSessions, requireAuth(http.HandlerFunc(session.GetListHandler(sessionRegistry)))).Methods(http.MethodGet)
+	router.Handle(pathSessions, requireAuth(requireOrigin(http.HandlerFunc(session.GetCreateHandler(sessionRegistry, profileRegistry, headlessSessionOptions))))).Methods(http.MethodPost)
+	router.Handle(path.Join(pathSessions, "{id}"), requireAuth(http.HandlerFunc(session.GetHandler(sessionRegistry)))).Methods(http.MethodGet)
+	router.Handle(path.Join(pathSessions, "{id}"), requireAuth(requireOrigin(http.HandlerFunc(session.GetDeleteHandler(sessionRegistry))))).Methods(http.MethodDelete)
+	router.Handle(path.Join(pathSessions, "{id}", "commands"), requireAuth(http.HandlerFunc(session.GetCommandsHandler(sessionRegistry)))).Methods(http.MethodGet)
+	router.Handle(path.Join(pathSessions, "{id}", "files"), requireAuth(http.HandlerFunc(session.GetDownloadHandler(sessionRegistry)))).Methods(http.MethodGet)
+	router.Handle(path.Join(pathSessions, "{id}", "files"), requireAuth(requireOrigin(http.HandlerFunc(session.GetUploadHandler(sessionRegistry, int64(uploadMaxSizeBytes)))))).Methods(http.MethodPost)
+	router.Handle(path.Join(pathSessions, "{id}", "transfers", "{transfer}"), requireAuth(http.HandlerFunc(session.GetTransferDownloadHandler(sessionRegistry)))).Methods(http.MethodGet)
+	router.Handle(path.Join(pathSessions, "{id}", "transfers", "{transfer}"), requireAuth(requireOrigin(http.HandlerFunc(session.GetTransferUploadHandler(sessionRegistry))))).Methods(http.MethodPost)
+	router.Handle(path.Join(pathSessions, "{id}", "transfers", "{transfer}"), requireAuth(requireOrigin(http.HandlerFunc(session.GetTransferCancelHandler(sessionRegistry))))).Methods(http.MethodDelete)
+	router.Handle(path.Join(pathSessions, "{id}", "expect"), requireAuth(requireOrigin(http.HandlerFunc(session.GetExpectHandler(sessionRegistry))))).Methods(http.MethodPost)
+	forwardedPorts, err := session.ParsePortRange(portForwardRange)
+	if err != nil {
+		message := fmt.Sprintf("failed to parse port forward range: %s", err)
+		log.Error(message)
+		return errors.New(message)
+	}
+	if !forwardedPorts.IsEmpty() {
+		portHandler := requireAuth(http.HandlerFunc(session.GetPortHandler(sessionRegistry, forwardedPorts, authHeaders)))
+		pathPort := path.Join(pathSessions, "{id}", "port", "{port:[0-9]+}")
+		router.Handle(pathPort, portHandler)
+		router.Handle(pathPort+"/{path:.*}", portHandler)
 	}
-	router.HandleFunc(pathXTermJS, xtermjs.GetHandler(xtermjsHandlerOptions))
+
+	// ssh server
+	if sshServerPort > 0 {
+		sshServer, err := createSSHServer(sshHostKeyFile, sshAuthorizedKeysFile, sshserver.Options{
+			Profiles:       profileRegistry,
+			Sessions:       sessionRegistry,
+			SessionOptions: headlessSessionOptions,
+		})
+		if err != nil {
+			message := fmt.Sprintf("failed to configure ssh server: %s", err)
+			log.Error(message)
+			return errors.New(message)
+		}
+		sshListenOnAddress := fmt.Sprintf("%s:%v", serverAddress, sshServerPort)
+		sshListener, err := net.Listen("tcp", sshListenOnAddress)
+		if err != nil {
+			message := fmt.Sprintf("failed to listen for ssh connections: %s", err)
+			log.Error(message)
+			return errors.New(message)
+		}
+		log.Infof("starting ssh server on interface:port '%s'...", sshListenOnAddress)
+		go func() {
+			if err := sshServer.Serve(sshListener); err != nil {
+				log.Errorf("ssh server stopped: %s", err)
+			}
+		}()
+	}
+
+	// recordings api and search endpoints
+	if recordingsDirectory != "" {
+		if err := os.MkdirAll(recordingsDirectory, 0700); err != nil {
+			message := fmt.Sprintf("failed to create recordings directory: %s", err)
+			log.Error(message)
+			return errors.New(message)
+		}
+	}
+	searcher := &search.Searcher{Sessions: sessionRegistry, PathRecordings: pathRecordings}
+	if recordingsDirectory != "" {
+		recordingStore := recording.NewStore(recordingsDirectory)
+		searcher.Recordings = recordingStore
+		router.Handle(pathRecordings, requireAuth(http.HandlerFunc(recording.GetListHandler(recordingStore)))).Methods(http.MethodGet)
+		router.Handle(path.Join(pathRecordings, "{id}"), requireAuth(http.HandlerFunc(recording.GetHandler(recordingStore)))).Methods(http.MethodGet)
+	}
+	router.Handle(pathSearch, requireAuth(http.HandlerFunc(search.GetHandler(searcher)))).Methods(http.MethodGet)
 
 	// readiness probe endpoint
 	router.HandleFunc(pathReadiness, func(w http.ResponseWriter, r *http.Request) {
@@ -122,6 +327,22 @@ func runE(_ *cobra.Command, _ []string) error {
 	publicAssetsDirectory := path.Join(workingDirectory, "./public")
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir(publicAssetsDirectory)))
 
+	// watch the configuration for changes
+	if configReloadInterval > 0 {
+		watchedFiles := []string{}
+		for _, filePath := range []string{configFilePath, profilesFile} {
+			if filePath != "" {
+				watchedFiles = append(watchedFiles, filePath)
+			}
+		}
+		if len(watchedFiles) > 0 {
+			log.Infof("watching ['%s'] for changes...", strings.Join(watchedFiles, "', '"))
+			go newFileWatcher(watchedFiles...).watch(configReloadInterval, func() {
+				reloadConfig(configFilePath, profileRegistry, sessionRegistry, auditSink, commandPolicy, redactor, xtermjsHandler, originChecker)
+			})
+		}
+	}
+
 	// start memory logging pulse
 	logWithMemory := createMemoryLog()
 	go func(tick *time.Ticker) {
@@ -141,3 +362,50 @@ func runE(_ *cobra.Command, _ []string) error {
 	log.Infof("starting server on interface:port '%s'...", listenOnAddress)
 	return server.ListenAndServe()
 }
+
+// createSSHServer returns an ssh server identified by the private key at
+// hostKeyFile which accepts the keys at authorizedKeysFile
+func createSSHServer(hostKeyFile, authorizedKeysFile string, opts sshserver.Options) (*sshserver.Server, error) {
+	hostKey, err := ioutil.ReadFile(hostKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %s", err)
+	}
+	opts.HostKey, err = ssh.ParsePrivateKey(hostKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %s", err)
+	}
+	opts.Keys, err = auth.LoadAuthorizedKeys(authorizedKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	return sshserver.New(opts), nil
+}
+
+// getXTermJSHandlerOptions returns the options for the xterm.js handler
+// based on the current configuration
+func getXTermJSHandlerOptions(profileRegistry *profile.Registry, sessionRegistry *session.Registry, auditSink audit.Sink, commandPolicy *policy.Policy, redactor *redact.Redactor) xtermjs.HandlerOpts {
+	return xtermjs.HandlerOpts{
+		AllowedHostnames:     conf.GetStringSlice("allowed-hostnames"),
+		Audit:                auditSink,
+		ConnectionErrorLimit: conf.GetInt("connection-error-limit"),
+		CreateLogger: func(connectionUUID string, r *http.Request) xtermjs.Logger {
+			createRequestLog(r, map[string]interface{}{"connection_uuid": connectionUUID}).Infof("created logger for connection '%s'", connectionUUID)
+			return createRequestLog(nil, map[string]interface{}{"connection_uuid": connectionUUID})
+		},
+		GetProfileName: func(r *http.Request) string {
+			if profileName, ok := mux.Vars(r)["profile"]; ok {
+				return profileName
+			}
+			return r.URL.Query().Get("profile")
+		},
+		KeepalivePingTimeout: time.Duration(conf.GetInt("keepalive-ping-timeout")) * time.Second,
+		MaxBufferSizeBytes:   conf.GetInt("max-buffer-size-bytes"),
+		Policy:               commandPolicy,
+		Profiles:             profileRegistry,
+		RecordingsDirectory:  conf.GetString("recordings-dir"),
+		Redactor:             redactor,
+		ScrollbackLines:      conf.GetInt("session-scrollback-lines"),
+		Sessions:             sessionRegistry,
+		ZModemMaxSizeBytes:   int64(conf.GetInt("zmodem-max-size-bytes")),
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"cloudshell/pkg/policy"
+)
+
+// loadPolicy returns the command policy defined by the policy file, nil
+// is returned when no policy file is specified
+func loadPolicy() (*policy.Policy, error) {
+	policyFile := conf.GetString("policy-file")
+	if policyFile == "" {
+		return nil, nil
+	}
+	rules, err := policy.LoadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	return policy.New(rules...)
+}
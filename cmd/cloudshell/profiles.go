@@ -0,0 +1,40 @@
+package main
+
+import (
+	"cloudshell/pkg/profile"
+)
+
+// builtInProfileName is the name of the profile defined by the command and
+// arguments
+const builtInProfileName = "default"
+
+// loadProfiles returns the name of the default profile and all profiles
+// defined by the command and arguments, the profiles file and the profiles
+// section of the configuration file (when file is not nil), a profile
+// called `default` in either file replaces the one defined by the command
+// and arguments
+func loadProfiles(file *configFile) (string, []profile.Profile, error) {
+	profiles := []profile.Profile{}
+	if profilesFile := conf.GetString("profiles-file"); profilesFile != "" {
+		additionalProfiles, err := profile.LoadFile(profilesFile)
+		if err != nil {
+			return "", nil, err
+		}
+		profiles = append(profiles, additionalProfiles...)
+	}
+	if file != nil {
+		profiles = append(profiles, file.Profiles...)
+	}
+	for _, p := range profiles {
+		if p.Name == builtInProfileName {
+			return conf.GetString("default-profile"), profiles, nil
+		}
+	}
+	builtIn := profile.Profile{
+		Name:      builtInProfileName,
+		Command:   conf.GetString("command"),
+		Arguments: conf.GetStringSlice("arguments"),
+	}
+	profiles = append([]profile.Profile{builtIn}, profiles...)
+	return conf.GetString("default-profile"), profiles, nil
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"cloudshell/pkg/redact"
+)
+
+// loadRedactor returns the redactor using the built-in detectors and the
+// patterns of the redaction patterns file when it is specified
+func loadRedactor() (*redact.Redactor, error) {
+	if patternsFile := conf.GetString("redact-patterns-file"); patternsFile != "" {
+		return redact.LoadFile(patternsFile)
+	}
+	return redact.New(nil)
+}
@@ -3,10 +3,25 @@ package constants
 type KeySequence []byte
 
 var (
-	KeySeqBackspace = []byte{127}
-	KeySeqDownArrow = []byte{27, 91, 66}
-	KeySeqLinefeed  = []byte{13}
-	KeySeqUpArrow   = []byte{27, 91, 65}
-	KeySeqSigInt    = []byte{3}
-	KeySeqEOF       = []byte{4}
+	KeySeqBackspace  = []byte{127}
+	KeySeqCtrlA      = []byte{1}
+	KeySeqCtrlE      = []byte{5}
+	KeySeqCtrlH      = []byte{8}
+	KeySeqCtrlK      = []byte{11}
+	KeySeqCtrlU      = []byte{21}
+	KeySeqCtrlW      = []byte{23}
+	KeySeqDelete     = []byte{27, 91, 51, 126}
+	KeySeqDownArrow  = []byte{27, 91, 66}
+	KeySeqEnd        = []byte{27, 91, 70}
+	KeySeqHome       = []byte{27, 91, 72}
+	KeySeqLeftArrow  = []byte{27, 91, 68}
+	KeySeqLinefeed   = []byte{13}
+	KeySeqNewline    = []byte{10}
+	KeySeqPasteEnd   = []byte{27, 91, 50, 48, 49, 126}
+	KeySeqPasteStart = []byte{27, 91, 50, 48, 48, 126}
+	KeySeqRightArrow = []byte{27, 91, 67}
+	KeySeqTab        = []byte{9}
+	KeySeqUpArrow    = []byte{27, 91, 65}
+	KeySeqSigInt     = []byte{3}
+	KeySeqEOF        = []byte{4}
 )
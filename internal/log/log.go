@@ -73,6 +73,11 @@ var Tracef,
 		fmt.Printf("\n")
 	}
 
+// SetLevel changes the minimum level of logs to output
+func SetLevel(logLevel Level) {
+	logger.SetLevel(LevelMap[logLevel])
+}
+
 func Init(
 	logFormat Format,
 	logLevel Level,
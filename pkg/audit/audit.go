@@ -0,0 +1,138 @@
+// Package audit records the commands executed in sessions
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// EventTypeCommand is the type of events recording an executed command
+	EventTypeCommand = "command"
+	// EventTypeCommandFinished is the type of events recording the exit
+	// status of a command, these are only available through shell
+	// integration marks
+	EventTypeCommandFinished = "command_finished"
+	// EventTypePolicy is the type of events recording a decision of the
+	// command policy about a command that was about to be submitted
+	EventTypePolicy = "policy"
+	// EventTypeClipboard is the type of events recording content that a
+	// program copied to the clipboard of the terminal
+	EventTypeClipboard = "clipboard"
+	// SourceInput indicates that the command was reconstructed from the
+	// input sent to the session
+	SourceInput = "input"
+	// SourceShell indicates that the command was reported by the shell
+	// using shell integration marks
+	SourceShell = "shell"
+)
+
+const (
+	// DecisionWarned indicates that the command was submitted after showing
+	// a warning
+	DecisionWarned = "warned"
+	// DecisionDenied indicates that the command was discarded
+	DecisionDenied = "denied"
+	// DecisionConfirmationRequired indicates that the user was asked to
+	// confirm the command before it is submitted
+	DecisionConfirmationRequired = "confirmation_required"
+	// DecisionConfirmed indicates that the user confirmed the command and
+	// that it was submitted
+	DecisionConfirmed = "confirmed"
+	// DecisionRejected indicates that the user did not confirm the command
+	// and that it was discarded
+	DecisionRejected = "rejected"
+	// DecisionCopied indicates that the content was copied to the
+	// clipboard of the clients
+	DecisionCopied = "copied"
+	// DecisionDiscarded indicates that the content was discarded because
+	// the clipboard is disabled
+	DecisionDiscarded = "discarded"
+	// DecisionTooLarge indicates that the content was discarded because it
+	// exceeded the maximum size
+	DecisionTooLarge = "too_large"
+)
+
+// Event is an entry of the audit log
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	User      string    `json:"user,omitempty"`
+	SessionID string    `json:"sessionId"`
+	Profile   string    `json:"profile"`
+	Cwd       string    `json:"cwd,omitempty"`
+	Command   string    `json:"command"`
+	Source    string    `json:"source"`
+	// Approximate is true when the command was reconstructed from input
+	// that the shell may have interpreted differently, such as history
+	// navigation and tab completion
+	Approximate bool `json:"approximate,omitempty"`
+	// ExitCode is the exit status of the command, it is only set on
+	// command_finished events when the shell reported it
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Duration is the number of seconds the command ran for, it is only
+	// set on command_finished events
+	Duration *float64 `json:"duration,omitempty"`
+	// Rule is the name of the policy rule that matched the command, it is
+	// only set on policy events
+	Rule string `json:"rule,omitempty"`
+	// Decision is the outcome of applying the policy rule on policy events
+	// or of copying to the clipboard on clipboard events
+	Decision string `json:"decision,omitempty"`
+	// Size is the size of the copied content, it is only set on clipboard
+	// events
+	Size *int `json:"size,omitempty"`
+	// Content is the copied content, it is only set on clipboard events
+	// that were not too large
+	Content string `json:"content,omitempty"`
+}
+
+// Sink receives audit events
+type Sink interface {
+	Write(Event) error
+}
+
+// FileSink writes audit events to a file as lines of JSON
+type FileSink struct {
+	writer io.WriteCloser
+	mutex  sync.Mutex
+}
+
+// NewFileSink returns a sink appending to the file at filePath, a path
+// of "-" writes to stdout
+func NewFileSink(filePath string) (*FileSink, error) {
+	if filePath == "-" {
+		return &FileSink{writer: os.Stdout}, nil
+	}
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+	return &FileSink{writer: file}, nil
+}
+
+// Write implements Sink
+func (s *FileSink) Write(event Event) error {
+	serialised, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialise audit event: %s", err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.writer.Write(append(serialised, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit event: %s", err)
+	}
+	return nil
+}
+
+// Close closes the file written to
+func (s *FileSink) Close() error {
+	if s.writer == os.Stdout {
+		return nil
+	}
+	return s.writer.Close()
+}
@@ -0,0 +1,238 @@
+package audit
+
+import (
+	"bytes"
+	"cloudshell/internal/constants"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxLineLength is the number of characters after which further input
+	// to a line is ignored
+	maxLineLength = 16384
+	// maxHistoryLength is the number of submitted lines kept to follow
+	// history navigation
+	maxHistoryLength = 500
+)
+
+// Line is a line of input submitted by pressing enter
+type Line struct {
+	Text string
+	// Approximate is true when the line was edited using keys whose effect
+	// depends on the state of the shell, such as history navigation and
+	// tab completion
+	Approximate bool
+}
+
+// LineEditor reconstructs the lines submitted to a shell from the keys
+// sent to it by emulating the basic editing keys of readline, keys can be
+// split across writes
+type LineEditor struct {
+	line        []rune
+	cursor      int
+	approximate bool
+	history     []string
+	// historyIndex is the position in history of the line being edited,
+	// it is len(history) for a new line
+	historyIndex int
+	// sequence holds a partially received escape sequence
+	sequence []byte
+	// pending holds the bytes of a partially received character
+	pending []byte
+}
+
+// Write processes keys sent to the shell and returns the lines that were
+// submitted
+func (e *LineEditor) Write(p []byte) []Line {
+	lines := []Line{}
+	for _, b := range p {
+		if len(e.sequence) > 0 {
+			e.sequence = append(e.sequence, b)
+			if isSequenceComplete(e.sequence) {
+				e.handleSequence(e.sequence)
+				e.sequence = e.sequence[:0]
+			}
+			continue
+		}
+		if len(e.pending) > 0 || b >= utf8.RuneSelf {
+			e.pending = append(e.pending, b)
+			if utf8.FullRune(e.pending) {
+				r, _ := utf8.DecodeRune(e.pending)
+				e.pending = e.pending[:0]
+				e.insert(r)
+			}
+			continue
+		}
+		key := []byte{b}
+		switch {
+		case b == 0x1b:
+			e.sequence = append(e.sequence, b)
+		case bytes.Equal(key, constants.KeySeqLinefeed), bytes.Equal(key, constants.KeySeqNewline):
+			if line, ok := e.submit(); ok {
+				lines = append(lines, line)
+			}
+		case bytes.Equal(key, constants.KeySeqSigInt):
+			e.clear()
+		case bytes.Equal(key, constants.KeySeqEOF):
+			// end of file on an empty line ends the shell instead
+			e.deleteAt(e.cursor)
+		case bytes.Equal(key, constants.KeySeqBackspace), bytes.Equal(key, constants.KeySeqCtrlH):
+			e.deleteAt(e.cursor - 1)
+		case bytes.Equal(key, constants.KeySeqCtrlA):
+			e.cursor = 0
+		case bytes.Equal(key, constants.KeySeqCtrlE):
+			e.cursor = len(e.line)
+		case bytes.Equal(key, constants.KeySeqCtrlK):
+			e.line = e.line[:e.cursor]
+		case bytes.Equal(key, constants.KeySeqCtrlU):
+			e.line = append([]rune{}, e.line[e.cursor:]...)
+			e.cursor = 0
+		case bytes.Equal(key, constants.KeySeqCtrlW):
+			e.deleteWord()
+		case bytes.Equal(key, constants.KeySeqTab):
+			e.approximate = true
+		case b < 0x20:
+			e.approximate = true
+		default:
+			e.insert(rune(b))
+		}
+	}
+	return lines
+}
+
+// Current returns the line being edited as it would be submitted
+func (e *LineEditor) Current() Line {
+	return Line{Text: strings.TrimSpace(string(e.line)), Approximate: e.approximate}
+}
+
+// Reset discards the line being edited and any partially received keys
+func (e *LineEditor) Reset() {
+	e.clear()
+	e.sequence = e.sequence[:0]
+	e.pending = e.pending[:0]
+}
+
+// isSequenceComplete returns true when sequence is a complete escape
+// sequence, CSI sequences end with a final byte and SS3 sequences and
+// other escapes consist of a single byte after the introducer
+func isSequenceComplete(sequence []byte) bool {
+	if len(sequence) < 2 {
+		return false
+	}
+	switch sequence[1] {
+	case '[':
+		last := sequence[len(sequence)-1]
+		return len(sequence) > 2 && last >= 0x40 && last <= 0x7e || len(sequence) > 32
+	case 'O':
+		return len(sequence) > 2
+	}
+	return true
+}
+
+func (e *LineEditor) handleSequence(sequence []byte) {
+	if len(sequence) == 3 && sequence[1] == 'O' {
+		// keys sent in application cursor mode
+		sequence = []byte{0x1b, '[', sequence[2]}
+	}
+	switch {
+	case bytes.Equal(sequence, constants.KeySeqLeftArrow):
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case bytes.Equal(sequence, constants.KeySeqRightArrow):
+		if e.cursor < len(e.line) {
+			e.cursor++
+		}
+	case bytes.Equal(sequence, constants.KeySeqHome):
+		e.cursor = 0
+	case bytes.Equal(sequence, constants.KeySeqEnd):
+		e.cursor = len(e.line)
+	case bytes.Equal(sequence, constants.KeySeqDelete):
+		e.deleteAt(e.cursor)
+	case bytes.Equal(sequence, constants.KeySeqUpArrow):
+		e.recall(e.historyIndex - 1)
+	case bytes.Equal(sequence, constants.KeySeqDownArrow):
+		e.recall(e.historyIndex + 1)
+	case bytes.Equal(sequence, constants.KeySeqPasteStart), bytes.Equal(sequence, constants.KeySeqPasteEnd):
+	default:
+		e.approximate = true
+	}
+}
+
+func (e *LineEditor) insert(r rune) {
+	if !unicode.IsPrint(r) {
+		e.approximate = true
+		return
+	}
+	if len(e.line) >= maxLineLength {
+		return
+	}
+	e.line = append(e.line, 0)
+	copy(e.line[e.cursor+1:], e.line[e.cursor:])
+	e.line[e.cursor] = r
+	e.cursor++
+}
+
+func (e *LineEditor) deleteAt(index int) {
+	if index < 0 || index >= len(e.line) {
+		return
+	}
+	e.line = append(e.line[:index], e.line[index+1:]...)
+	if e.cursor > index {
+		e.cursor--
+	}
+}
+
+// deleteWord deletes the word before the cursor and the whitespace after it
+func (e *LineEditor) deleteWord() {
+	start := e.cursor
+	for start > 0 && unicode.IsSpace(e.line[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(e.line[start-1]) {
+		start--
+	}
+	e.line = append(e.line[:start], e.line[e.cursor:]...)
+	e.cursor = start
+}
+
+// recall replaces the line with the entry of the history at index, this
+// is approximate as the history of the shell may contain other entries
+func (e *LineEditor) recall(index int) {
+	e.approximate = true
+	if index < 0 || index > len(e.history) {
+		return
+	}
+	e.historyIndex = index
+	e.line = []rune{}
+	if index < len(e.history) {
+		e.line = []rune(e.history[index])
+	}
+	e.cursor = len(e.line)
+}
+
+// submit ends the line being edited, false is returned for blank lines
+func (e *LineEditor) submit() (Line, bool) {
+	line := e.Current()
+	e.clear()
+	if line.Text == "" {
+		return line, false
+	}
+	if len(e.history) == 0 || e.history[len(e.history)-1] != line.Text {
+		e.history = append(e.history, line.Text)
+		if len(e.history) > maxHistoryLength {
+			e.history = e.history[1:]
+		}
+	}
+	e.historyIndex = len(e.history)
+	return line, true
+}
+
+func (e *LineEditor) clear() {
+	e.line = e.line[:0]
+	e.cursor = 0
+	e.approximate = false
+	e.historyIndex = len(e.history)
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	tests := []struct {
+		name string
+		// writes are applied one after another
+		writes []string
+		lines  []Line
+		// current is the line being edited after the writes
+		current Line
+	}{
+		{"typed", []string{"ls -la\r"}, []Line{{Text: "ls -la"}}, Line{}},
+		{"newline", []string{"ls\n"}, []Line{{Text: "ls"}}, Line{}},
+		{"several lines", []string{"cd /tmp\rls\r"}, []Line{{Text: "cd /tmp"}, {Text: "ls"}}, Line{}},
+		{"split", []string{"l", "s", " -la", "\r"}, []Line{{Text: "ls -la"}}, Line{}},
+		{"blank lines", []string{"\r  \r\n"}, []Line{}, Line{}},
+		{"surrounding whitespace", []string{"  ls  \r"}, []Line{{Text: "ls"}}, Line{}},
+		{"unsubmitted", []string{"rm -rf"}, []Line{}, Line{Text: "rm -rf"}},
+		{"backspace", []string{"lss\x7f\r"}, []Line{{Text: "ls"}}, Line{}},
+		{"ctrl-h", []string{"lss\x08\r"}, []Line{{Text: "ls"}}, Line{}},
+		{"backspace at start", []string{"\x7f\x7fls\r"}, []Line{{Text: "ls"}}, Line{}},
+		{"left arrow insert", []string{"l\x1b[Ds\r"}, []Line{{Text: "sl"}}, Line{}},
+		{"left and right arrows", []string{"ac\x1b[D\x1b[D\x1b[Cb\r"}, []Line{{Text: "abc"}}, Line{}},
+		{"application cursor keys", []string{"ac\x1bODb\r"}, []Line{{Text: "abc"}}, Line{}},
+		{"arrow split", []string{"ac\x1b", "[", "Db\r"}, []Line{{Text: "abc"}}, Line{}},
+		{"home and end", []string{"bc\x1b[Ha\x1b[Fd\r"}, []Line{{Text: "abcd"}}, Line{}},
+		{"ctrl-a and ctrl-e", []string{"bc\x01a\x05d\r"}, []Line{{Text: "abcd"}}, Line{}},
+		{"delete", []string{"abxc\x1b[D\x1b[D\x1b[3~\r"}, []Line{{Text: "abc"}}, Line{}},
+		{"ctrl-d", []string{"abxc\x1b[D\x1b[D\x04\r"}, []Line{{Text: "abc"}}, Line{}},
+		{"ctrl-k", []string{"ls /tmp\x01\x1b[C\x1b[C\x0b\r"}, []Line{{Text: "ls"}}, Line{}},
+		{"ctrl-u", []string{"rm -rf /\x15ls\r"}, []Line{{Text: "ls"}}, Line{}},
+		{"ctrl-u keeps text after cursor", []string{"echo ls\x1b[D\x1b[D\x15\r"}, []Line{{Text: "ls"}}, Line{}},
+		{"ctrl-w", []string{"ls /tmp\x17/home\r"}, []Line{{Text: "ls /home"}}, Line{}},
+		{"ctrl-w trailing whitespace", []string{"ls /tmp  \x17\x17echo\r"}, []Line{{Text: "echo"}}, Line{}},
+		{"ctrl-c", []string{"rm -rf /\x03ls\r"}, []Line{{Text: "ls"}}, Line{}},
+		{"utf-8", []string{"echo caf\xc3\xa9\r"}, []Line{{Text: "echo café"}}, Line{}},
+		{"utf-8 split", []string{"echo caf\xc3", "\xa9\r"}, []Line{{Text: "echo café"}}, Line{}},
+		{"bracketed paste", []string{"\x1b[200~echo pasted\x1b[201~\r"}, []Line{{Text: "echo pasted"}}, Line{}},
+		{"bracketed paste split", []string{"\x1b[20", "0~echo pasted\x1b[2", "01~\r"}, []Line{{Text: "echo pasted"}}, Line{}},
+		{"bracketed paste with newlines", []string{"\x1b[200~ls\rpwd\r\x1b[201~"}, []Line{{Text: "ls"}, {Text: "pwd"}}, Line{}},
+		{"tab completion", []string{"ls /t\t\r"}, []Line{{Text: "ls /t", Approximate: true}}, Line{}},
+		{"unknown control character", []string{"ls\x12\r"}, []Line{{Text: "ls", Approximate: true}}, Line{}},
+		{"unknown escape sequence", []string{"ls\x1b[1;5C\r"}, []Line{{Text: "ls", Approximate: true}}, Line{}},
+		{"approximate is reset for the next line", []string{"ls\t\rpwd\r"}, []Line{{Text: "ls", Approximate: true}, {Text: "pwd"}}, Line{}},
+		{"history", []string{"ls\rpwd\r\x1b[A\x1b[A\r"}, []Line{{Text: "ls"}, {Text: "pwd"}, {Text: "ls", Approximate: true}}, Line{}},
+		{"history and back", []string{"ls\r\x1b[A\x1b[Bpwd\r"}, []Line{{Text: "ls"}, {Text: "pwd", Approximate: true}}, Line{}},
+		{"history edited", []string{"ls\r\x1b[A -la\r"}, []Line{{Text: "ls"}, {Text: "ls -la", Approximate: true}}, Line{}},
+		{"history beyond start", []string{"ls\r\x1b[A\x1b[A\x1b[A\r"}, []Line{{Text: "ls"}, {Text: "ls", Approximate: true}}, Line{}},
+		{"current approximate", []string{"ls\t"}, []Line{}, Line{Text: "ls", Approximate: true}},
+	}
+	for _, test := range tests {
+		check := func(how string, lines []Line, current Line) {
+			if !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("%s (%s): expected lines %+v, got %+v", test.name, how, test.lines, lines)
+			}
+			if current != test.current {
+				t.Errorf("%s (%s): expected the current line %+v, got %+v", test.name, how, test.current, current)
+			}
+		}
+		editor := LineEditor{}
+		lines := []Line{}
+		for _, write := range test.writes {
+			lines = append(lines, editor.Write([]byte(write))...)
+		}
+		check("as written", lines, editor.Current())
+		// the result must not depend on how the keys are split
+		editor = LineEditor{}
+		lines = []Line{}
+		for _, b := range []byte(strings.Join(test.writes, "")) {
+			lines = append(lines, editor.Write([]byte{b})...)
+		}
+		check("byte by byte", lines, editor.Current())
+	}
+}
+
+func TestLineEditorReset(t *testing.T) {
+	editor := LineEditor{}
+	editor.Write([]byte("rm -rf /\t\x1b["))
+	editor.Reset()
+	lines := editor.Write([]byte("Als\r"))
+	if !reflect.DeepEqual(lines, []Line{{Text: "Als"}}) {
+		t.Errorf("expected the line and the partial escape sequence to be discarded, got %+v", lines)
+	}
+}
+
+func TestLineEditorMaxLineLength(t *testing.T) {
+	editor := LineEditor{}
+	editor.Write([]byte(strings.Repeat("a", maxLineLength+10)))
+	if length := len(editor.Current().Text); length != maxLineLength {
+		t.Errorf("expected the line to be limited to %v characters, got %v", maxLineLength, length)
+	}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ClaimUser is the claim containing the name of the authenticated principal
+const ClaimUser = "user"
+
+// ErrUnauthenticated is returned by an Authenticator when a request does
+// not carry valid credentials
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+// Principal is an authenticated identity
+type Principal struct {
+	// Name identifies the principal
+	Name string
+	// Claims are additional attributes of the principal provided by the
+	// authentication source
+	Claims map[string]string
+}
+
+// Authenticator identifies the principal making a request
+type Authenticator interface {
+	Authenticate(*http.Request) (*Principal, error)
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// GetPrincipal returns the principal stored in ctx, nil is returned when
+// the request was not authenticated
+func GetPrincipal(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
+
+// GetClaims returns the claims of the principal stored in ctx, an empty map
+// is returned when the request was not authenticated
+func GetClaims(ctx context.Context) map[string]string {
+	principal := GetPrincipal(ctx)
+	if principal == nil {
+		return map[string]string{}
+	}
+	return principal.Claims
+}
+
+// Middleware returns a http middleware that authenticates requests using
+// authenticator before passing them on, unauthenticated requests are
+// rejected. When authenticator is nil, requests are passed on as-is
+func Middleware(authenticator Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if authenticator == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticator.Authenticate(r)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HeaderAuthenticator trusts identity headers set by an authenticating
+// reverse proxy (eg. oauth2-proxy) in front of the server. It must only be
+// used when clients cannot reach the server without going through the proxy
+type HeaderAuthenticator struct {
+	// UserHeader is the header containing the name of the principal
+	UserHeader string
+	// ClaimHeaders maps claim names to the headers containing their values
+	ClaimHeaders map[string]string
+}
+
+// NewHeaderAuthenticator returns an authenticator reading the principal's
+// name from userHeader; claimHeaders is a list of `claim=Header-Name` pairs
+func NewHeaderAuthenticator(userHeader string, claimHeaders []string) (*HeaderAuthenticator, error) {
+	authenticator := &HeaderAuthenticator{
+		UserHeader:   userHeader,
+		ClaimHeaders: map[string]string{},
+	}
+	for _, claimHeader := range claimHeaders {
+		pair := strings.SplitN(claimHeader, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("claim header '%s' should be in the format 'claim=Header-Name'", claimHeader)
+		}
+		if pair[0] == ClaimUser {
+			return nil, fmt.Errorf("claim '%s' is reserved for the user header", ClaimUser)
+		}
+		authenticator.ClaimHeaders[pair[0]] = pair[1]
+	}
+	return authenticator, nil
+}
+
+// Headers returns the names of the headers that the principal is read from
+func (h *HeaderAuthenticator) Headers() []string {
+	headers := []string{h.UserHeader}
+	for _, header := range h.ClaimHeaders {
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+// Authenticate implements Authenticator
+func (h *HeaderAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	name := r.Header.Get(h.UserHeader)
+	if name == "" {
+		return nil, fmt.Errorf("%s: missing header '%s'", ErrUnauthenticated, h.UserHeader)
+	}
+	principal := &Principal{
+		Name:   name,
+		Claims: map[string]string{ClaimUser: name},
+	}
+	for claim, header := range h.ClaimHeaders {
+		if value := r.Header.Get(header); value != "" {
+			principal.Claims[claim] = value
+		}
+	}
+	return principal, nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// keyOptionPrincipal is the option of authorized keys naming the
+	// principal that the key authenticates
+	keyOptionPrincipal = "principal"
+	// keyOptionClaim is the option of authorized keys adding a claim in the
+	// format `claim=value` to the principal, it can be repeated
+	keyOptionClaim = "claim"
+)
+
+// KeyAuthenticator identifies principals by their ssh public keys
+type KeyAuthenticator struct {
+	principals map[string]*Principal
+}
+
+// LoadAuthorizedKeys returns an authenticator for the keys in the file at
+// filePath, which uses the format of OpenSSH's authorized_keys with the
+// principal of each key given by the `principal="<name>"` option and its
+// claims by `claim="<claim>=<value>"` options
+func LoadAuthorizedKeys(filePath string) (*KeyAuthenticator, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %s", err)
+	}
+	authenticator := &KeyAuthenticator{principals: map[string]*Principal{}}
+	for lineNumber, line := range bytes.Split(contents, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, options, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse authorized key on line %v: %s", lineNumber+1, err)
+		}
+		principal, err := parseKeyOptions(options)
+		if err != nil {
+			return nil, fmt.Errorf("authorized key on line %v %s", lineNumber+1, err)
+		}
+		authenticator.principals[string(key.Marshal())] = principal
+	}
+	return authenticator, nil
+}
+
+// parseKeyOptions returns the principal described by the options of an
+// authorized key
+func parseKeyOptions(options []string) (*Principal, error) {
+	principal := &Principal{Claims: map[string]string{}}
+	for _, option := range options {
+		pair := strings.SplitN(option, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("has unsupported option '%s'", option)
+		}
+		value, err := strconv.Unquote(pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("has option '%s' with a value that is not quoted", pair[0])
+		}
+		switch pair[0] {
+		case keyOptionPrincipal:
+			principal.Name = value
+		case keyOptionClaim:
+			claim := strings.SplitN(value, "=", 2)
+			if len(claim) != 2 || claim[0] == "" {
+				return nil, fmt.Errorf("has claim '%s' that is not in the format 'claim=value'", value)
+			}
+			if claim[0] == ClaimUser {
+				return nil, fmt.Errorf("has claim '%s' which is reserved for the principal", ClaimUser)
+			}
+			principal.Claims[claim[0]] = claim[1]
+		default:
+			return nil, fmt.Errorf("has unsupported option '%s'", pair[0])
+		}
+	}
+	if principal.Name == "" {
+		return nil, fmt.Errorf("does not specify a %s", keyOptionPrincipal)
+	}
+	principal.Claims[ClaimUser] = principal.Name
+	return principal, nil
+}
+
+// AuthenticateKey returns the principal identified by key
+func (k *KeyAuthenticator) AuthenticateKey(key ssh.PublicKey) (*Principal, error) {
+	principal, ok := k.principals[string(key.Marshal())]
+	if !ok {
+		return nil, fmt.Errorf("%s: key '%s' is not authorized", ErrUnauthenticated, ssh.FingerprintSHA256(key))
+	}
+	return principal, nil
+}
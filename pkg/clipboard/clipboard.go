@@ -0,0 +1,194 @@
+// Package clipboard extracts the OSC 52 sequences that programs such as
+// tmux and vim write to set the clipboard of the terminal from output so
+// that their content can be forwarded to the clipboard of clients
+package clipboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	esc = 0x1b
+	bel = 0x07
+	// can and sub abort control sequences
+	can = 0x18
+	sub = 0x1a
+	// maxSelectionLength is the maximum length of the selection parameter
+	// that is kept when the content is too large
+	maxSelectionLength = 16
+)
+
+// introducer starts the sequences setting or querying the clipboard, which
+// continue with the selection, a semicolon and the base64 encoded content
+// and end with BEL or ST
+var introducer = []byte("\x1b]52;")
+
+// Copy is content that a program copied to the clipboard
+type Copy struct {
+	// Selection holds the clipboards to set, such as `c` for the clipboard
+	// and `p` for the primary selection, an empty selection means the
+	// default of the terminal
+	Selection string
+	// Data is the copied content, it is nil when the content is too large
+	Data []byte
+	// Size is the size of the copied content, it is approximate when the
+	// content is too large
+	Size int
+	// TooLarge is true when the content exceeds the maximum size
+	TooLarge bool
+}
+
+// Format returns the OSC 52 sequence that copies c to the clipboard of a
+// terminal
+func Format(c Copy) []byte {
+	return []byte(fmt.Sprintf("\x1b]52;%s;%s\x07", c.Selection, base64.StdEncoding.EncodeToString(c.Data)))
+}
+
+// Filter removes OSC 52 sequences from output and returns the content
+// they copy, sequences querying the clipboard are removed without being
+// answered so that programs cannot read the clipboard of clients
+type Filter struct {
+	maxSizeBytes int
+	// maxSequenceLength is the length after which the parameters of a
+	// sequence are no longer kept
+	maxSequenceLength int
+	// pending holds the end of the last output when it may be the start of
+	// a sequence or of its terminator, it is prepended to the next output
+	pending []byte
+	// inSequence is true while the parameters of a sequence are read
+	inSequence bool
+	// sequence holds the parameters of the current sequence
+	sequence []byte
+	// sequenceLength is the length of the parameters of the current
+	// sequence including those that were not kept
+	sequenceLength int
+}
+
+// NewFilter returns a filter that reports content larger than maxSizeBytes
+// as too large without keeping it
+func NewFilter(maxSizeBytes int) *Filter {
+	return &Filter{
+		maxSizeBytes:      maxSizeBytes,
+		maxSequenceLength: maxSelectionLength + 1 + base64.StdEncoding.EncodedLen(maxSizeBytes),
+	}
+}
+
+// Write returns output without OSC 52 sequences and the content copied by
+// the sequences that ended in output, the end of output is held back until
+// the next write when it may be the start of a sequence
+func (f *Filter) Write(output []byte) ([]byte, []Copy) {
+	if len(f.pending) > 0 {
+		output = append(f.pending, output...)
+		f.pending = nil
+	}
+	if !f.inSequence && bytes.IndexByte(output, esc) < 0 {
+		return output, nil
+	}
+	filtered := make([]byte, 0, len(output))
+	var copies []Copy
+	for len(output) > 0 {
+		if !f.inSequence {
+			start := bytes.Index(output, introducer)
+			if start < 0 {
+				held := partialIntroducerLength(output)
+				filtered = append(filtered, output[:len(output)-held]...)
+				f.pending = append(f.pending, output[len(output)-held:]...)
+				break
+			}
+			filtered = append(filtered, output[:start]...)
+			output = output[start+len(introducer):]
+			f.inSequence = true
+			f.sequence = f.sequence[:0]
+			f.sequenceLength = 0
+			continue
+		}
+		end := bytes.IndexAny(output, "\x07\x1b\x18\x1a")
+		if end < 0 {
+			f.add(output)
+			break
+		}
+		f.add(output[:end])
+		switch output[end] {
+		case bel:
+			output = output[end+1:]
+		case esc:
+			if end+1 == len(output) {
+				f.pending = append(f.pending, esc)
+				return filtered, copies
+			}
+			if output[end+1] == '\\' {
+				output = output[end+2:]
+			} else {
+				// another escape sequence interrupts the sequence, which is
+				// discarded
+				f.inSequence = false
+				output = output[end:]
+				continue
+			}
+		case can, sub:
+			f.inSequence = false
+			output = output[end+1:]
+			continue
+		}
+		f.inSequence = false
+		if c, ok := f.parse(); ok {
+			copies = append(copies, c)
+		}
+	}
+	return filtered, copies
+}
+
+// add appends parameters to the current sequence until it is too long
+func (f *Filter) add(parameters []byte) {
+	f.sequenceLength += len(parameters)
+	if room := f.maxSequenceLength - len(f.sequence); room > 0 {
+		if len(parameters) > room {
+			parameters = parameters[:room]
+		}
+		f.sequence = append(f.sequence, parameters...)
+	}
+}
+
+// parse returns the content copied by the sequence that ended, false is
+// returned for queries and malformed sequences
+func (f *Filter) parse() (Copy, bool) {
+	separator := bytes.IndexByte(f.sequence, ';')
+	if separator < 0 || separator > maxSelectionLength {
+		return Copy{}, false
+	}
+	c := Copy{Selection: string(f.sequence[:separator])}
+	encoded := f.sequence[separator+1:]
+	if f.sequenceLength > len(f.sequence) {
+		c.Size = base64.StdEncoding.DecodedLen(f.sequenceLength - separator - 1)
+		c.TooLarge = true
+		return c, true
+	}
+	if string(encoded) == "?" {
+		return Copy{}, false
+	}
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	length, err := base64.StdEncoding.Decode(data, encoded)
+	if err != nil {
+		return Copy{}, false
+	}
+	c.Size = length
+	if length > f.maxSizeBytes {
+		c.TooLarge = true
+		return c, true
+	}
+	c.Data = data[:length]
+	return c, true
+}
+
+// partialIntroducerLength returns the length of the end of output that is
+// the start of a sequence
+func partialIntroducerLength(output []byte) int {
+	for length := len(introducer) - 1; length > 0; length-- {
+		if length <= len(output) && bytes.HasSuffix(output, introducer[:length]) {
+			return length
+		}
+	}
+	return 0
+}
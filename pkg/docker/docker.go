@@ -0,0 +1,231 @@
+// Package docker is a minimal client of the Docker Engine API for running
+// processes in existing containers with exec
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// apiVersion is the version of the API that requests are made with
+const apiVersion = "v1.40"
+
+// ExecConfig defines a process to run in a container
+type ExecConfig struct {
+	AttachStdin  bool     `json:"AttachStdin"`
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+	Tty          bool     `json:"Tty"`
+	Cmd          []string `json:"Cmd"`
+	Env          []string `json:"Env,omitempty"`
+	User         string   `json:"User,omitempty"`
+	WorkingDir   string   `json:"WorkingDir,omitempty"`
+}
+
+// ExecState is the state of a process started with exec
+type ExecState struct {
+	Running  bool `json:"Running"`
+	ExitCode int  `json:"ExitCode"`
+}
+
+// Client makes requests to the Docker Engine API listening on a unix socket
+type Client struct {
+	socket string
+	client *http.Client
+}
+
+// NewClient returns a client of the API listening on the unix socket at
+// socket
+func NewClient(socket string) *Client {
+	c := &Client{socket: socket}
+	c.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return c.dial(ctx)
+			},
+		},
+	}
+	return c
+}
+
+// CreateExec creates a process defined by config in container and returns
+// its id, the process is only started by StartExec
+func (c *Client) CreateExec(container string, config ExecConfig) (string, error) {
+	created := struct {
+		ID string `json:"Id"`
+	}{}
+	if err := c.do(http.MethodPost, "/containers/"+url.PathEscape(container)+"/exec", nil, config, &created); err != nil {
+		return "", fmt.Errorf("failed to create exec in container '%s': %s", container, err)
+	}
+	return created.ID, nil
+}
+
+// StartExec starts the process created with the id and returns the
+// connection that its input is written to and its output is read from
+func (c *Client) StartExec(id string) (net.Conn, error) {
+	body, err := json.Marshal(map[string]bool{"Detach": false, "Tty": true})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPost, c.url("/exec/"+url.PathEscape(id)+"/start", nil), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "tcp")
+	connection, err := c.dial(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to start exec: %s", err)
+	}
+	if err := request.Write(connection); err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("failed to start exec: %s", err)
+	}
+	reader := bufio.NewReader(connection)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("failed to start exec: %s", err)
+	}
+	// older versions of the api reply with 200 rather than switching
+	// protocols, the stream follows the headers in both cases
+	if response.StatusCode != http.StatusSwitchingProtocols && response.StatusCode != http.StatusOK {
+		defer connection.Close()
+		return nil, fmt.Errorf("failed to start exec: %s", readError(response))
+	}
+	return &hijackedConn{Conn: connection, reader: reader}, nil
+}
+
+// ResizeExec sets the size of the tty of the process with the id
+func (c *Client) ResizeExec(id string, rows, cols uint16) error {
+	query := url.Values{"h": {strconv.Itoa(int(rows))}, "w": {strconv.Itoa(int(cols))}}
+	if err := c.do(http.MethodPost, "/exec/"+url.PathEscape(id)+"/resize", query, nil, nil); err != nil {
+		return fmt.Errorf("failed to resize exec: %s", err)
+	}
+	return nil
+}
+
+// InspectExec returns the state of the process with the id
+func (c *Client) InspectExec(id string) (ExecState, error) {
+	state := ExecState{}
+	if err := c.do(http.MethodGet, "/exec/"+url.PathEscape(id)+"/json", nil, nil, &state); err != nil {
+		return ExecState{}, fmt.Errorf("failed to inspect exec: %s", err)
+	}
+	return state, nil
+}
+
+// ContainerAddress returns the ip address of container in the first of its
+// networks that has one, an empty string is returned for containers using
+// the network of the host
+func (c *Client) ContainerAddress(container string) (string, error) {
+	inspected := struct {
+		NetworkSettings struct {
+			IPAddress string `json:"IPAddress"`
+			Networks  map[string]struct {
+				IPAddress string `json:"IPAddress"`
+			} `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}{}
+	if err := c.do(http.MethodGet, "/containers/"+url.PathEscape(container)+"/json", nil, nil, &inspected); err != nil {
+		return "", fmt.Errorf("failed to inspect container '%s': %s", container, err)
+	}
+	if inspected.NetworkSettings.IPAddress != "" {
+		return inspected.NetworkSettings.IPAddress, nil
+	}
+	for _, network := range inspected.NetworkSettings.Networks {
+		if network.IPAddress != "" {
+			return network.IPAddress, nil
+		}
+	}
+	return "", nil
+}
+
+// do sends a request with body encoded as json and decodes the response
+// into result when it is not nil
+func (c *Client) do(method, path string, query url.Values, body, result interface{}) error {
+	var requestBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, c.url(path, query), requestBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New(readError(response))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// url returns the url of path in the api, whose segments are escaped, the
+// host is ignored as requests are sent to the socket
+func (c *Client) url(path string, query url.Values) string {
+	rawPath := "/" + apiVersion + path
+	unescapedPath, err := url.PathUnescape(rawPath)
+	if err != nil {
+		unescapedPath = rawPath
+	}
+	u := url.URL{Scheme: "http", Host: "docker", Path: unescapedPath, RawPath: rawPath}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", c.socket)
+}
+
+// readError returns the message of an error response of the api
+func readError(response *http.Response) string {
+	contents, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64<<10))
+	message := struct {
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(contents, &message) == nil && message.Message != "" {
+		return message.Message
+	}
+	if len(bytes.TrimSpace(contents)) > 0 {
+		return fmt.Sprintf("%s: %s", response.Status, bytes.TrimSpace(contents))
+	}
+	return response.Status
+}
+
+// hijackedConn is a connection taken over from http whose reads start with
+// what was buffered while reading the response headers
+type hijackedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read implements io.Reader by reading the buffer before the connection
+func (h *hijackedConn) Read(p []byte) (int, error) {
+	return h.reader.Read(p)
+}
@@ -0,0 +1,169 @@
+package docker
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves handler on a unix socket and returns a client of it
+func newTestServer(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return NewClient(socket)
+}
+
+func TestCreateExec(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1.40/containers/dev shell/exec" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a json body, got '%s'", r.Header.Get("Content-Type"))
+		}
+		config := ExecConfig{}
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			t.Errorf("failed to decode config: %s", err)
+		}
+		if strings.Join(config.Cmd, " ") != "bash -l" || !config.Tty || config.User != "dev" {
+			t.Errorf("unexpected config %+v", config)
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"Id": "exec-1"}`)
+	})
+	id, err := client.CreateExec("dev shell", ExecConfig{Tty: true, Cmd: []string{"bash", "-l"}, User: "dev"})
+	if err != nil {
+		t.Fatalf("failed to create exec: %s", err)
+	}
+	if id != "exec-1" {
+		t.Errorf("expected id 'exec-1', got '%s'", id)
+	}
+}
+
+func TestCreateExecError(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such container: missing"}`)
+	})
+	_, err := client.CreateExec("missing", ExecConfig{})
+	if err == nil || !strings.Contains(err.Error(), "No such container: missing") {
+		t.Errorf("expected the message of the api in the error, got %v", err)
+	}
+}
+
+func TestStartExec(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1.40/exec/exec-1/start" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Upgrade") != "tcp" {
+			t.Errorf("expected an upgrade to tcp, got '%s'", r.Header.Get("Upgrade"))
+		}
+		body := map[string]bool{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode body: %s", err)
+		}
+		if body["Detach"] || !body["Tty"] {
+			t.Errorf("unexpected body %v", body)
+		}
+		connection, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack: %s", err)
+			return
+		}
+		defer connection.Close()
+		// the stream starts in the same write as the headers so that it is
+		// buffered by the client while reading them
+		io.WriteString(connection, "HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n$ ")
+		io.Copy(connection, buffered)
+	})
+	connection, err := client.StartExec("exec-1")
+	if err != nil {
+		t.Fatalf("failed to start exec: %s", err)
+	}
+	defer connection.Close()
+	prompt := make([]byte, 2)
+	if _, err := io.ReadFull(connection, prompt); err != nil || string(prompt) != "$ " {
+		t.Fatalf("expected the output buffered with the headers, got '%s' (%v)", prompt, err)
+	}
+	io.WriteString(connection, "ls\n")
+	echoed := make([]byte, 3)
+	if _, err := io.ReadFull(connection, echoed); err != nil || string(echoed) != "ls\n" {
+		t.Errorf("expected the input to be echoed, got '%s' (%v)", echoed, err)
+	}
+}
+
+func TestStartExecError(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, `{"message": "container is paused"}`)
+	})
+	_, err := client.StartExec("exec-1")
+	if err == nil || !strings.Contains(err.Error(), "container is paused") {
+		t.Errorf("expected the message of the api in the error, got %v", err)
+	}
+}
+
+func TestResizeExec(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1.40/exec/exec-1/resize" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.URL.Query().Get("h") != "40" || r.URL.Query().Get("w") != "120" {
+			t.Errorf("unexpected size %s", r.URL.RawQuery)
+		}
+	})
+	if err := client.ResizeExec("exec-1", 40, 120); err != nil {
+		t.Errorf("failed to resize exec: %s", err)
+	}
+}
+
+func TestInspectExec(t *testing.T) {
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1.40/exec/exec-1/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		io.WriteString(w, `{"ID": "exec-1", "Running": false, "ExitCode": 3, "Pid": 4242}`)
+	})
+	state, err := client.InspectExec("exec-1")
+	if err != nil {
+		t.Fatalf("failed to inspect exec: %s", err)
+	}
+	if state.Running || state.ExitCode != 3 {
+		t.Errorf("unexpected state %+v", state)
+	}
+}
+
+func TestContainerAddress(t *testing.T) {
+	responses := map[string]string{
+		"bridge": `{"NetworkSettings": {"IPAddress": "172.17.0.2"}}`,
+		"custom": `{"NetworkSettings": {"IPAddress": "", "Networks": {"custom": {"IPAddress": "10.0.0.5"}}}}`,
+		"host":   `{"NetworkSettings": {"IPAddress": "", "Networks": {"host": {"IPAddress": ""}}}}`,
+	}
+	client := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1.40/containers/"), "/json")
+		io.WriteString(w, responses[name])
+	})
+	for name, expected := range map[string]string{"bridge": "172.17.0.2", "custom": "10.0.0.5", "host": ""} {
+		address, err := client.ContainerAddress(name)
+		if err != nil {
+			t.Fatalf("failed to get address of '%s': %s", name, err)
+		}
+		if address != expected {
+			t.Errorf("expected address '%s' for '%s', got '%s'", expected, name, address)
+		}
+	}
+}
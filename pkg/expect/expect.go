@@ -0,0 +1,127 @@
+// Package expect automates interactive programs by sending input and
+// waiting for output matching regular expressions, it works with anything
+// that can be read from and written to such as a session subscription or a
+// xterm.js protocol client
+package expect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the time to wait for a match when no timeout is specified
+const DefaultTimeout = 10 * time.Second
+
+// ErrTimeout is returned when the output does not match before the timeout
+var ErrTimeout = errors.New("timed out waiting for match")
+
+// Expecter sends input to and matches the output of a connection
+type Expecter struct {
+	connection io.ReadWriter
+	// output holds all output received so far
+	output bytes.Buffer
+	// offset is the position in output after the previous match, matching
+	// starts from here
+	offset int
+	// err is the error that ended reading from the connection
+	err    error
+	mutex  sync.Mutex
+	update *sync.Cond
+}
+
+// New returns an Expecter that reads the output of connection until it
+// returns an error
+func New(connection io.ReadWriter) *Expecter {
+	expecter := &Expecter{connection: connection}
+	expecter.update = sync.NewCond(&expecter.mutex)
+	go expecter.receive()
+	return expecter
+}
+
+func (e *Expecter) receive() {
+	buffer := make([]byte, 4096)
+	for {
+		readLength, err := e.connection.Read(buffer)
+		e.mutex.Lock()
+		e.output.Write(buffer[:readLength])
+		if err != nil {
+			e.err = err
+		}
+		e.update.Broadcast()
+		e.mutex.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Send writes input to the connection
+func (e *Expecter) Send(input string) error {
+	if _, err := e.connection.Write([]byte(input)); err != nil {
+		return fmt.Errorf("failed to send input: %s", err)
+	}
+	return nil
+}
+
+// Expect waits for output received since the previous match to match
+// pattern and returns the match followed by its submatches, a timeout of 0
+// uses DefaultTimeout
+func (e *Expecter) Expect(pattern string, timeout time.Duration) ([]string, error) {
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile pattern '%s': %s", pattern, err)
+	}
+	return e.ExpectRegexp(expression, timeout)
+}
+
+// ExpectRegexp is like Expect but takes a compiled expression
+func (e *Expecter) ExpectRegexp(expression *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	timedOut := false
+	timer := time.AfterFunc(timeout, func() {
+		e.mutex.Lock()
+		timedOut = true
+		e.update.Broadcast()
+		e.mutex.Unlock()
+	})
+	defer timer.Stop()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for {
+		unmatched := e.output.Bytes()[e.offset:]
+		if location := expression.FindSubmatchIndex(unmatched); location != nil {
+			matches := make([]string, 0, len(location)/2)
+			for index := 0; index < len(location); index += 2 {
+				if location[index] < 0 {
+					matches = append(matches, "")
+					continue
+				}
+				matches = append(matches, string(unmatched[location[index]:location[index+1]]))
+			}
+			e.offset += location[1]
+			return matches, nil
+		}
+		if e.err != nil {
+			return nil, fmt.Errorf("failed to match '%s': connection ended: %s", expression, e.err)
+		}
+		if timedOut {
+			return nil, fmt.Errorf("failed to match '%s' within %v: %w", expression, timeout, ErrTimeout)
+		}
+		e.update.Wait()
+	}
+}
+
+// Transcript returns all output received so far
+func (e *Expecter) Transcript() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.output.String()
+}
@@ -0,0 +1,108 @@
+package expect
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Step is a single action of a script, exactly one of Send or Expect
+// should be specified
+type Step struct {
+	// Send is input to write to the connection
+	Send string `json:"send,omitempty"`
+	// Expect is a regular expression the output should match
+	Expect string `json:"expect,omitempty"`
+	// Timeout is the number of seconds to wait for Expect to match
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// GetTimeout returns the time to wait for Expect to match
+func (s Step) GetTimeout() time.Duration {
+	return time.Duration(s.Timeout) * time.Second
+}
+
+// validate returns an error if the step is not usable
+func (s Step) validate() error {
+	if (s.Send == "") == (s.Expect == "") {
+		return errors.New("exactly one of 'send' or 'expect' should be specified")
+	}
+	if s.Timeout < 0 {
+		return errors.New("timeout should not be negative")
+	}
+	return nil
+}
+
+// StepResult is the outcome of running a step
+type StepResult struct {
+	Step
+	// Matches holds the match of Expect followed by its submatches
+	Matches []string `json:"matches,omitempty"`
+	// Error describes why the step failed
+	Error string `json:"error,omitempty"`
+}
+
+// Result is the outcome of running a script
+type Result struct {
+	// Success is true if all steps succeeded
+	Success bool `json:"success"`
+	// Steps holds the results of the steps that were run, the script stops
+	// at the first step that fails
+	Steps []StepResult `json:"steps"`
+	// Transcript is the output received while the script was run
+	Transcript string `json:"transcript"`
+}
+
+// Validate returns an error if any of steps is not usable
+func Validate(steps []Step) error {
+	if len(steps) == 0 {
+		return errors.New("at least one step should be specified")
+	}
+	for index, step := range steps {
+		if err := step.validate(); err != nil {
+			return &StepError{Index: index, Err: err}
+		}
+	}
+	return nil
+}
+
+// StepError is returned when a step is not usable
+type StepError struct {
+	Index int
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %v: %s", e.Index, e.Err)
+}
+
+// Run runs steps in order using e and stops at the first step that fails
+func (e *Expecter) Run(steps []Step) Result {
+	e.mutex.Lock()
+	transcriptStart := e.output.Len()
+	e.mutex.Unlock()
+
+	result := Result{Success: true, Steps: []StepResult{}}
+	for _, step := range steps {
+		stepResult := StepResult{Step: step}
+		var err error
+		if step.Send != "" {
+			err = e.Send(step.Send)
+		} else {
+			stepResult.Matches, err = e.Expect(step.Expect, step.GetTimeout())
+		}
+		if err != nil {
+			stepResult.Error = err.Error()
+			result.Success = false
+		}
+		result.Steps = append(result.Steps, stepResult)
+		if err != nil {
+			break
+		}
+	}
+
+	e.mutex.Lock()
+	result.Transcript = string(e.output.Bytes()[transcriptStart:])
+	e.mutex.Unlock()
+	return result
+}
@@ -0,0 +1,220 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requestTimeout is the time allowed for requests to the api, exec
+// connections are long lived and not limited by it
+const requestTimeout = 30 * time.Second
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("not found")
+
+// Client makes requests to the Kubernetes API
+type Client struct {
+	config *Config
+	client *http.Client
+}
+
+// NewClient returns a client of the api defined by config
+func NewClient(config *Config) *Client {
+	return &Client{
+		config: config,
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLS}, Timeout: requestTimeout},
+	}
+}
+
+// pod is the subset of the pod object that is read
+type pod struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+		// DeletionTimestamp is set once the pod is being deleted
+		DeletionTimestamp string `json:"deletionTimestamp"`
+	} `json:"metadata"`
+	Status struct {
+		Phase      string `json:"phase"`
+		Message    string `json:"message"`
+		PodIP      string `json:"podIP"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+		ContainerStatuses []struct {
+			Name  string `json:"name"`
+			State struct {
+				Waiting *struct {
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"waiting"`
+			} `json:"state"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// FindPod returns the name of the first running pod in namespace, in
+// alphabetical order, whose labels match selector
+func (c *Client) FindPod(namespace, selector string) (string, error) {
+	pods := struct {
+		Items []pod `json:"items"`
+	}{}
+	query := url.Values{"labelSelector": {selector}, "fieldSelector": {"status.phase=Running"}}
+	if err := c.do(http.MethodGet, podsPath(namespace), query, nil, &pods); err != nil {
+		return "", fmt.Errorf("failed to list pods in namespace '%s': %s", namespace, err)
+	}
+	names := []string{}
+	for _, item := range pods.Items {
+		if item.Status.Phase == "Running" {
+			names = append(names, item.Metadata.Name)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("failed to find a running pod matching '%s' in namespace '%s'", selector, namespace)
+	}
+	sort.Strings(names)
+	return names[0], nil
+}
+
+// listManagedPods returns the names of the pods in namespace that were
+// created by a provisioner
+func (c *Client) listManagedPods(namespace string) ([]string, error) {
+	pods := struct {
+		Items []pod `json:"items"`
+	}{}
+	query := url.Values{"labelSelector": {labelManagedBy + "=" + managedBy}}
+	if err := c.do(http.MethodGet, podsPath(namespace), query, nil, &pods); err != nil {
+		return nil, fmt.Errorf("failed to list pods in namespace '%s': %s", namespace, err)
+	}
+	names := make([]string, 0, len(pods.Items))
+	for _, item := range pods.Items {
+		names = append(names, item.Metadata.Name)
+	}
+	return names, nil
+}
+
+// PodIP returns the ip address of the pod called name in namespace
+func (c *Client) PodIP(namespace, name string) (string, error) {
+	p, err := c.getPod(namespace, name)
+	if err != nil {
+		return "", err
+	}
+	if p.Status.PodIP == "" {
+		return "", fmt.Errorf("pod '%s' in namespace '%s' does not have an ip address", name, namespace)
+	}
+	return p.Status.PodIP, nil
+}
+
+// getPod returns the pod called name in namespace, the error wraps
+// ErrNotFound when it does not exist
+func (c *Client) getPod(namespace, name string) (*pod, error) {
+	var p pod
+	if err := c.do(http.MethodGet, podsPath(namespace)+"/"+url.PathEscape(name), nil, nil, &p); err != nil {
+		return nil, fmt.Errorf("failed to get pod '%s' in namespace '%s': %w", name, namespace, err)
+	}
+	return &p, nil
+}
+
+// createPod creates the pod defined by manifest in namespace
+func (c *Client) createPod(namespace string, manifest map[string]interface{}) error {
+	if err := c.do(http.MethodPost, podsPath(namespace), nil, manifest, nil); err != nil {
+		return fmt.Errorf("failed to create pod in namespace '%s': %s", namespace, err)
+	}
+	return nil
+}
+
+// deletePod deletes the pod called name in namespace
+func (c *Client) deletePod(namespace, name string) error {
+	if err := c.do(http.MethodDelete, podsPath(namespace)+"/"+url.PathEscape(name), nil, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete pod '%s' in namespace '%s': %w", name, namespace, err)
+	}
+	return nil
+}
+
+// do sends a request to path with body encoded as json and decodes the
+// response into result when it is not nil
+func (c *Client) do(method, path string, query url.Values, body, result interface{}) error {
+	var requestBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, c.url(path, query), requestBody)
+	if err != nil {
+		return err
+	}
+	c.authenticate(request.Header)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, readError(response))
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New(readError(response))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// url returns the url of path in the api
+func (c *Client) url(path string, query url.Values) string {
+	endpoint := c.config.Server + path
+	if query != nil {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint
+}
+
+// websocketURL returns the url of path in the api for websocket requests
+func (c *Client) websocketURL(path string, query url.Values) string {
+	endpoint := c.url(path, query)
+	if strings.HasPrefix(endpoint, "https://") {
+		return "wss://" + strings.TrimPrefix(endpoint, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(endpoint, "http://")
+}
+
+// authenticate adds the bearer token of the configuration to header
+func (c *Client) authenticate(header http.Header) {
+	if c.config.Token != "" {
+		header.Set("Authorization", "Bearer "+c.config.Token)
+	}
+}
+
+func podsPath(namespace string) string {
+	return "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+}
+
+// readError returns the message of an error response of the api
+func readError(response *http.Response) string {
+	contents, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64<<10))
+	status := struct {
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(contents, &status) == nil && status.Message != "" {
+		return status.Message
+	}
+	return response.Status
+}
@@ -0,0 +1,93 @@
+package kubernetes
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestClient returns a client of a fake api served by handler that
+// authenticates with the token `secret`
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"kind": "Status", "message": "Unauthorized"}`)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return NewClient(&Config{Server: server.URL, Token: "secret"})
+}
+
+func TestFindPod(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/v1/namespaces/tools/pods" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		query := r.URL.Query()
+		if query.Get("labelSelector") != "app=shell" || query.Get("fieldSelector") != "status.phase=Running" {
+			t.Errorf("unexpected selectors %s", r.URL.RawQuery)
+		}
+		// the phase is checked again in case the field selector is ignored
+		io.WriteString(w, `{"items": [
+			{"metadata": {"name": "shell-c"}, "status": {"phase": "Running"}},
+			{"metadata": {"name": "shell-a"}, "status": {"phase": "Pending"}},
+			{"metadata": {"name": "shell-b"}, "status": {"phase": "Running"}}
+		]}`)
+	})
+	pod, err := client.FindPod("tools", "app=shell")
+	if err != nil {
+		t.Fatalf("failed to find pod: %s", err)
+	}
+	if pod != "shell-b" {
+		t.Errorf("expected the first running pod 'shell-b', got '%s'", pod)
+	}
+}
+
+func TestFindPodNoneRunning(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"items": [{"metadata": {"name": "shell-a"}, "status": {"phase": "Succeeded"}}]}`)
+	})
+	if _, err := client.FindPod("tools", "app=shell"); err == nil {
+		t.Error("expected an error when no pod is running")
+	}
+}
+
+func TestFindPodError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"kind": "Status", "message": "pods is forbidden"}`)
+	})
+	_, err := client.FindPod("tools", "app=shell")
+	if err == nil || !strings.Contains(err.Error(), "pods is forbidden") {
+		t.Errorf("expected the message of the api in the error, got %v", err)
+	}
+}
+
+func TestPodIP(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/tools/pods/shell-a":
+			io.WriteString(w, `{"metadata": {"name": "shell-a"}, "status": {"podIP": "10.0.0.5"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"kind": "Status", "message": "pods \"shell-b\" not found"}`)
+		}
+	})
+	address, err := client.PodIP("tools", "shell-a")
+	if err != nil {
+		t.Fatalf("failed to get pod ip: %s", err)
+	}
+	if address != "10.0.0.5" {
+		t.Errorf("expected address '10.0.0.5', got '%s'", address)
+	}
+	if _, err := client.PodIP("tools", "shell-b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected an error wrapping ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package kubernetes is a minimal client of the Kubernetes API for running
+// processes in the containers of pods with exec
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// serviceAccountDirectory holds the credentials of the service account
+	// of pods
+	serviceAccountDirectory = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// environmentServiceHost and environmentServicePort hold the address of
+	// the api in pods
+	environmentServiceHost = "KUBERNETES_SERVICE_HOST"
+	environmentServicePort = "KUBERNETES_SERVICE_PORT"
+)
+
+// Config defines how the api is reached and authenticated with
+type Config struct {
+	// Server is the url of the api, eg. `https://10.0.0.1:443`
+	Server string
+	// Token is the bearer token to authenticate with
+	Token string
+	// TLS holds the certificate authorities and client certificates
+	TLS *tls.Config
+}
+
+// LoadConfig returns the configuration in the kubeconfig file at
+// kubeconfigFile using its current context, the configuration of the
+// service account of the pod that the server runs in is returned when
+// kubeconfigFile is empty
+func LoadConfig(kubeconfigFile string) (*Config, error) {
+	if kubeconfigFile == "" {
+		return loadInClusterConfig()
+	}
+	return loadKubeconfig(kubeconfigFile)
+}
+
+// loadInClusterConfig returns the configuration of the service account of
+// the pod that the server runs in
+func loadInClusterConfig() (*Config, error) {
+	host, port := os.Getenv(environmentServiceHost), os.Getenv(environmentServicePort)
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("failed to load in-cluster configuration: %s and %s are not set", environmentServiceHost, environmentServicePort)
+	}
+	token, err := ioutil.ReadFile(filepath.Join(serviceAccountDirectory, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %s", err)
+	}
+	certificateAuthority, err := ioutil.ReadFile(filepath.Join(serviceAccountDirectory, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account certificate authority: %s", err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(certificateAuthority) {
+		return nil, fmt.Errorf("failed to parse service account certificate authority")
+	}
+	return &Config{
+		Server: "https://" + net.JoinHostPort(host, port),
+		Token:  strings.TrimSpace(string(token)),
+		TLS:    &tls.Config{RootCAs: rootCAs},
+	}, nil
+}
+
+// kubeconfig is the subset of the kubeconfig file format that is supported
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// loadKubeconfig returns the configuration of the current context of the
+// kubeconfig file at filePath, only tokens and client certificates are
+// supported for authentication
+func loadKubeconfig(filePath string) (*Config, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %s", err)
+	}
+	var file kubeconfig
+	if err := yaml.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %s", err)
+	}
+	clusterName, userName, found := "", "", false
+	for _, context := range file.Contexts {
+		if context.Name == file.CurrentContext {
+			clusterName, userName, found = context.Context.Cluster, context.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("failed to find current context '%s' in kubeconfig", file.CurrentContext)
+	}
+	// relative paths in kubeconfig files are relative to the file
+	directory := filepath.Dir(filePath)
+	config := &Config{TLS: &tls.Config{}}
+	found = false
+	for _, cluster := range file.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		found = true
+		config.Server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		config.TLS.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+		certificateAuthority, err := readData(directory, cluster.Cluster.CertificateAuthority, cluster.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate authority of cluster '%s': %s", clusterName, err)
+		}
+		if certificateAuthority != nil {
+			config.TLS.RootCAs = x509.NewCertPool()
+			if !config.TLS.RootCAs.AppendCertsFromPEM(certificateAuthority) {
+				return nil, fmt.Errorf("failed to parse certificate authority of cluster '%s'", clusterName)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("failed to find cluster '%s' in kubeconfig", clusterName)
+	}
+	for _, user := range file.Users {
+		if user.Name != userName {
+			continue
+		}
+		config.Token = user.User.Token
+		if user.User.TokenFile != "" {
+			token, err := ioutil.ReadFile(resolvePath(directory, user.User.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token of user '%s': %s", userName, err)
+			}
+			config.Token = strings.TrimSpace(string(token))
+		}
+		certificate, err := readData(directory, user.User.ClientCertificate, user.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate of user '%s': %s", userName, err)
+		}
+		key, err := readData(directory, user.User.ClientKey, user.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client key of user '%s': %s", userName, err)
+		}
+		if certificate != nil || key != nil {
+			keyPair, err := tls.X509KeyPair(certificate, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse client certificate of user '%s': %s", userName, err)
+			}
+			config.TLS.Certificates = []tls.Certificate{keyPair}
+		}
+	}
+	return config, nil
+}
+
+// readData returns the base64 encoded data or else the contents of the
+// file at filePath, nil is returned when neither is specified
+func readData(directory, filePath, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if filePath == "" {
+		return nil, nil
+	}
+	return ioutil.ReadFile(resolvePath(directory, filePath))
+}
+
+func resolvePath(directory, filePath string) string {
+	if filepath.IsAbs(filePath) {
+		return filePath
+	}
+	return filepath.Join(directory, filePath)
+}
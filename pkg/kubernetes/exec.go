@@ -0,0 +1,196 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streams of the channel protocol of exec, every message starts with the
+// stream it belongs to
+const (
+	streamStdin  byte = 0
+	streamStdout byte = 1
+	streamStderr byte = 2
+	streamError  byte = 3
+	streamResize byte = 4
+)
+
+// channelProtocols are the websocket subprotocols of exec in order of
+// preference, both use the same framing
+var channelProtocols = []string{"v5.channel.k8s.io", "v4.channel.k8s.io"}
+
+// execHandshakeTimeout is the time allowed for the api to accept exec
+const execHandshakeTimeout = 30 * time.Second
+
+// Exec is a process running with a tty in the container of a pod, reads
+// return its output and writes send input to it
+type Exec struct {
+	connection *websocket.Conn
+	output     *io.PipeReader
+	// exitCode is the exit code reported by the api, it is -1 until it
+	// has been reported
+	exitCode int
+	// done is closed once the connection ended
+	done       chan struct{}
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+}
+
+// Exec starts command with a tty in container of the pod called name in
+// namespace, the default container of the pod is used when container is
+// empty
+func (c *Client) Exec(namespace, name, container string, command []string) (*Exec, error) {
+	query := url.Values{
+		"command": command,
+		"stdin":   {"true"},
+		"stdout":  {"true"},
+		"tty":     {"true"},
+	}
+	if container != "" {
+		query.Set("container", container)
+	}
+	dialer := websocket.Dialer{
+		TLSClientConfig:  c.config.TLS,
+		Subprotocols:     channelProtocols,
+		HandshakeTimeout: execHandshakeTimeout,
+	}
+	header := http.Header{}
+	c.authenticate(header)
+	connection, response, err := dialer.Dial(c.websocketURL(podsPath(namespace)+"/"+url.PathEscape(name)+"/exec", query), header)
+	if err != nil {
+		if response != nil {
+			err = errors.New(readError(response))
+		}
+		return nil, fmt.Errorf("failed to exec in pod '%s' in namespace '%s': %s", name, namespace, err)
+	}
+	output, outputWriter := io.Pipe()
+	e := &Exec{
+		connection: connection,
+		output:     output,
+		exitCode:   -1,
+		done:       make(chan struct{}),
+	}
+	go e.receive(outputWriter)
+	return e, nil
+}
+
+// receive writes the output of the process to outputWriter and records
+// its exit code until the connection ends
+func (e *Exec) receive(outputWriter *io.PipeWriter) {
+	defer close(e.done)
+	defer outputWriter.Close()
+	for {
+		_, message, err := e.connection.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(message) == 0 {
+			continue
+		}
+		switch message[0] {
+		case streamStdout, streamStderr:
+			if _, err := outputWriter.Write(message[1:]); err != nil {
+				return
+			}
+		case streamError:
+			if exitCode, ok := parseExitCode(message[1:]); ok {
+				e.mutex.Lock()
+				e.exitCode = exitCode
+				e.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// Read implements io.Reader by reading the output of the process
+func (e *Exec) Read(p []byte) (int, error) {
+	return e.output.Read(p)
+}
+
+// Write implements io.Writer by writing input to the process
+func (e *Exec) Write(p []byte) (int, error) {
+	if err := e.send(streamStdin, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize sets the window size of the tty of the process
+func (e *Exec) Resize(rows, cols uint16) error {
+	size, err := json.Marshal(map[string]uint16{"Width": cols, "Height": rows})
+	if err != nil {
+		return err
+	}
+	return e.send(streamResize, size)
+}
+
+// Wait waits for the connection to end and returns the exit code of the
+// process, -1 is returned when it was not reported
+func (e *Exec) Wait() int {
+	<-e.done
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.exitCode
+}
+
+// Exited returns true once the connection ended, which it does when the
+// process exits
+func (e *Exec) Exited() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the connection to the process
+func (e *Exec) Close() error {
+	return e.connection.Close()
+}
+
+func (e *Exec) send(stream byte, data []byte) error {
+	e.writeMutex.Lock()
+	defer e.writeMutex.Unlock()
+	return e.connection.WriteMessage(websocket.BinaryMessage, append([]byte{stream}, data...))
+}
+
+// parseExitCode returns the exit code in the status written to the error
+// stream when the process exits
+func parseExitCode(message []byte) (int, bool) {
+	status := struct {
+		Status  string `json:"status"`
+		Reason  string `json:"reason"`
+		Details struct {
+			Causes []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"causes"`
+		} `json:"details"`
+	}{}
+	if err := json.Unmarshal(message, &status); err != nil {
+		return 0, false
+	}
+	if status.Status == "Success" {
+		return 0, true
+	}
+	if status.Reason != "NonZeroExitCode" {
+		return 0, false
+	}
+	for _, cause := range status.Details.Causes {
+		if cause.Reason == "ExitCode" {
+			exitCode, err := strconv.Atoi(cause.Message)
+			return exitCode, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,113 @@
+package kubernetes
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestExec(t *testing.T) {
+	received := make(chan string, 2)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/tools/pods/shell-a/exec" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		query := r.URL.Query()
+		if strings.Join(query["command"], " ") != "bash -l" || query.Get("container") != "shell" || query.Get("tty") != "true" || query.Get("stdin") != "true" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		upgrader := websocket.Upgrader{Subprotocols: []string{"v4.channel.k8s.io"}}
+		connection, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("failed to upgrade: %s", err)
+			return
+		}
+		defer connection.Close()
+		if connection.Subprotocol() != "v4.channel.k8s.io" {
+			t.Errorf("expected the v4 channel protocol, got '%s'", connection.Subprotocol())
+		}
+		connection.WriteMessage(websocket.BinaryMessage, append([]byte{streamStdout}, "$ "...))
+		// the resize and the input are received in the order they are sent
+		for i := 0; i < 2; i++ {
+			_, message, err := connection.ReadMessage()
+			if err != nil {
+				t.Errorf("failed to read message: %s", err)
+				return
+			}
+			received <- string(message)
+		}
+		connection.WriteMessage(websocket.BinaryMessage, append([]byte{streamStderr}, "failed\n"...))
+		connection.WriteMessage(websocket.BinaryMessage, append([]byte{streamError}, `{
+			"status": "Failure",
+			"reason": "NonZeroExitCode",
+			"details": {"causes": [{"reason": "ExitCode", "message": "3"}]}
+		}`...))
+	})
+	exec, err := client.Exec("tools", "shell-a", "shell", []string{"bash", "-l"})
+	if err != nil {
+		t.Fatalf("failed to exec: %s", err)
+	}
+	defer exec.Close()
+	prompt := make([]byte, 2)
+	if _, err := io.ReadFull(exec, prompt); err != nil || string(prompt) != "$ " {
+		t.Fatalf("expected the output of the process, got '%s' (%v)", prompt, err)
+	}
+	if exec.Exited() {
+		t.Error("expected the process to be running")
+	}
+	if err := exec.Resize(40, 120); err != nil {
+		t.Fatalf("failed to resize: %s", err)
+	}
+	if _, err := exec.Write([]byte("exit 3\n")); err != nil {
+		t.Fatalf("failed to write input: %s", err)
+	}
+	if resize := <-received; resize != "\x04"+`{"Height":40,"Width":120}` {
+		t.Errorf("unexpected resize message %q", resize)
+	}
+	if input := <-received; input != "\x00exit 3\n" {
+		t.Errorf("unexpected input message %q", input)
+	}
+	output, err := io.ReadAll(exec)
+	if err != nil || string(output) != "failed\n" {
+		t.Errorf("expected the error output of the process, got '%s' (%v)", output, err)
+	}
+	if exitCode := exec.Wait(); exitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", exitCode)
+	}
+	if !exec.Exited() {
+		t.Error("expected the process to have exited")
+	}
+}
+
+func TestExecError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"kind": "Status", "message": "pods \"shell-a\" is forbidden"}`)
+	})
+	_, err := client.Exec("tools", "shell-a", "", []string{"bash"})
+	if err == nil || !strings.Contains(err.Error(), `pods "shell-a" is forbidden`) {
+		t.Errorf("expected the message of the api in the error, got %v", err)
+	}
+}
+
+func TestParseExitCode(t *testing.T) {
+	tests := []struct {
+		message  string
+		exitCode int
+		ok       bool
+	}{
+		{`{"status": "Success"}`, 0, true},
+		{`{"status": "Failure", "reason": "NonZeroExitCode", "details": {"causes": [{"reason": "ExitCode", "message": "137"}]}}`, 137, true},
+		{`{"status": "Failure", "reason": "InternalError", "message": "container not found"}`, 0, false},
+		{`not json`, 0, false},
+	}
+	for _, test := range tests {
+		exitCode, ok := parseExitCode([]byte(test.message))
+		if exitCode != test.exitCode || ok != test.ok {
+			t.Errorf("expected (%d, %v) for %s, got (%d, %v)", test.exitCode, test.ok, test.message, exitCode, ok)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package kubernetes
+
+import (
+	"cloudshell/internal/log"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// labelManagedBy marks the pods created by the provisioner so that
+	// existing pods are only reused or deleted when they were created by it
+	labelManagedBy = "app.kubernetes.io/managed-by"
+	managedBy      = "cloudshell"
+	// readyPollInterval is the interval between checks of whether a pod is
+	// ready or has been deleted
+	readyPollInterval = time.Second
+)
+
+// Provisioner creates the pods of workspaces when they are first used and
+// deletes them once they have not been used for a while, workspaces are
+// shared by all the sessions that use them
+type Provisioner struct {
+	workspaces map[string]*workspace
+	mutex      sync.Mutex
+}
+
+// workspace is a pod created by the provisioner
+type workspace struct {
+	// users is the number of sessions using the workspace
+	users int
+	// ready is closed once the pod is ready or failed to become ready, err
+	// then holds the reason
+	ready chan struct{}
+	err   error
+	// expiry deletes the pod once the workspace is idle
+	expiry *time.Timer
+	// adopted is true for pods that existed when the server started, they
+	// are only known to be idle and are provisioned again when acquired
+	adopted     bool
+	idleTimeout time.Duration
+	// deleted is set while the pod is deleted after being idle and closed
+	// once the api accepted the deletion, the workspace stays known until
+	// then so that it is not reused meanwhile
+	deleted chan struct{}
+}
+
+// NewProvisioner returns a provisioner without workspaces
+func NewProvisioner() *Provisioner {
+	return &Provisioner{workspaces: map[string]*workspace{}}
+}
+
+// Acquire creates the pod called name in namespace from manifest unless it
+// exists, and waits for it to be ready while writing progress to progress,
+// every successful Acquire has to be followed by a Release
+func (p *Provisioner) Acquire(client *Client, namespace, name string, manifest map[string]interface{}, readyTimeout time.Duration, progress io.Writer) error {
+	key := client.config.Server + "/" + namespace + "/" + name
+	p.mutex.Lock()
+	w, exists := p.workspaces[key]
+	for exists && w.deleted != nil {
+		deleted := w.deleted
+		p.mutex.Unlock()
+		fmt.Fprintf(progress, "waiting for idle workspace pod '%s' to be deleted...\r\n", name)
+		<-deleted
+		p.mutex.Lock()
+		w, exists = p.workspaces[key]
+	}
+	if exists && w.adopted {
+		// the pod is checked again like a pod left behind by another server
+		w.expiry.Stop()
+		exists = false
+	}
+	if exists {
+		if w.expiry != nil {
+			w.expiry.Stop()
+			w.expiry = nil
+		}
+	} else {
+		w = &workspace{ready: make(chan struct{})}
+		p.workspaces[key] = w
+		go func() {
+			w.err = p.provision(client, namespace, name, manifest, readyTimeout, progress)
+			close(w.ready)
+		}()
+	}
+	w.users++
+	p.mutex.Unlock()
+	if exists {
+		fmt.Fprintf(progress, "waiting for workspace pod '%s'...\r\n", name)
+	}
+	<-w.ready
+	if w.err != nil {
+		p.mutex.Lock()
+		w.users--
+		if w.users == 0 && p.workspaces[key] == w {
+			// the next acquire provisions the pod again
+			delete(p.workspaces, key)
+		}
+		p.mutex.Unlock()
+		return w.err
+	}
+	return nil
+}
+
+// Release marks a session as no longer using the pod called name in
+// namespace, the pod is deleted once no session used it for idleTimeout
+func (p *Provisioner) Release(client *Client, namespace, name string, idleTimeout time.Duration) {
+	key := client.config.Server + "/" + namespace + "/" + name
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	w, exists := p.workspaces[key]
+	if !exists || w.users == 0 {
+		return
+	}
+	w.users--
+	if w.users > 0 {
+		return
+	}
+	w.expiry = p.expire(client, namespace, name, w, idleTimeout)
+}
+
+// Adopt deletes the pods in namespace that were created by a provisioner
+// and are not used by a session once they have been idle for idleTimeout,
+// so that the pods of workspaces are not left behind when the server
+// restarts; pods adopted for several profiles keep the longest idle
+// timeout
+func (p *Provisioner) Adopt(client *Client, namespace string, idleTimeout time.Duration) error {
+	names, err := client.listManagedPods(namespace)
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, name := range names {
+		key := client.config.Server + "/" + namespace + "/" + name
+		w, exists := p.workspaces[key]
+		if exists && (!w.adopted || w.idleTimeout >= idleTimeout || w.deleted != nil) {
+			continue
+		}
+		if exists {
+			w.expiry.Stop()
+		}
+		w = &workspace{adopted: true, idleTimeout: idleTimeout}
+		w.expiry = p.expire(client, namespace, name, w, idleTimeout)
+		p.workspaces[key] = w
+		log.Infof("adopted workspace pod '%s' in namespace '%s', it is deleted unless used within %v", name, namespace, idleTimeout)
+	}
+	return nil
+}
+
+// expire returns a timer deleting the pod of w after idleTimeout unless a
+// session acquired it meanwhile, it is called with the mutex held
+func (p *Provisioner) expire(client *Client, namespace, name string, w *workspace, idleTimeout time.Duration) *time.Timer {
+	key := client.config.Server + "/" + namespace + "/" + name
+	return time.AfterFunc(idleTimeout, func() {
+		p.mutex.Lock()
+		if w.users > 0 || p.workspaces[key] != w {
+			p.mutex.Unlock()
+			return
+		}
+		deleted := make(chan struct{})
+		w.deleted = deleted
+		p.mutex.Unlock()
+		log.Infof("deleting workspace pod '%s' in namespace '%s' after being idle for %v", name, namespace, idleTimeout)
+		if err := client.deletePod(namespace, name); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Warnf("failed to delete idle workspace: %s", err)
+		}
+		p.mutex.Lock()
+		delete(p.workspaces, key)
+		p.mutex.Unlock()
+		close(deleted)
+	})
+}
+
+// provision creates the pod unless it exists and waits for it to be ready,
+// pods that do not become ready are deleted
+func (p *Provisioner) provision(client *Client, namespace, name string, manifest map[string]interface{}, readyTimeout time.Duration, progress io.Writer) error {
+	if err := p.create(client, namespace, name, manifest, readyTimeout, progress); err != nil {
+		return err
+	}
+	if err := p.waitUntilReady(client, namespace, name, readyTimeout, progress); err != nil {
+		if deleteErr := client.deletePod(namespace, name); deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+			log.Warnf("failed to delete workspace that did not become ready: %s", deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// create creates the pod unless a pod created by the provisioner exists,
+// a pod that is being deleted is waited for to be gone for up to
+// readyTimeout before it is created again
+func (p *Provisioner) create(client *Client, namespace, name string, manifest map[string]interface{}, readyTimeout time.Duration, progress io.Writer) error {
+	deadline := time.Now().Add(readyTimeout)
+	for waiting := false; ; waiting = true {
+		existing, err := client.getPod(namespace, name)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			fmt.Fprintf(progress, "creating workspace pod '%s'...\r\n", name)
+			return client.createPod(namespace, withIdentity(manifest, namespace, name))
+		case err != nil:
+			return err
+		case existing.Metadata.Labels[labelManagedBy] != managedBy:
+			return fmt.Errorf("pod '%s' in namespace '%s' exists and was not created by %s", name, namespace, managedBy)
+		case existing.Metadata.DeletionTimestamp == "":
+			fmt.Fprintf(progress, "reusing workspace pod '%s'...\r\n", name)
+			return nil
+		}
+		if !waiting {
+			fmt.Fprintf(progress, "waiting for workspace pod '%s' to be deleted...\r\n", name)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("workspace pod '%s' was not deleted within %v", name, readyTimeout)
+		}
+		time.Sleep(readyPollInterval)
+	}
+}
+
+// waitUntilReady waits for the pod to be ready while writing changes of
+// its status to progress
+func (p *Provisioner) waitUntilReady(client *Client, namespace, name string, readyTimeout time.Duration, progress io.Writer) error {
+	deadline := time.Now().Add(readyTimeout)
+	lastStatus := ""
+	for {
+		current, err := client.getPod(namespace, name)
+		if err != nil {
+			return err
+		}
+		// pods being deleted may still be ready until their containers stop
+		if current.Metadata.DeletionTimestamp != "" {
+			return fmt.Errorf("workspace pod '%s' is being deleted", name)
+		}
+		if isReady(current) {
+			fmt.Fprintf(progress, "workspace pod '%s' is ready\r\n", name)
+			return nil
+		}
+		if current.Status.Phase == "Failed" || current.Status.Phase == "Succeeded" {
+			return fmt.Errorf("workspace pod '%s' has stopped: %s %s", name, current.Status.Phase, current.Status.Message)
+		}
+		if status := describeStatus(current); status != lastStatus {
+			fmt.Fprintf(progress, "  %s\r\n", status)
+			lastStatus = status
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("workspace pod '%s' did not become ready within %v", name, readyTimeout)
+		}
+		time.Sleep(readyPollInterval)
+	}
+}
+
+// withIdentity returns a copy of manifest with the name, namespace and
+// the label marking pods created by the provisioner set
+func withIdentity(manifest map[string]interface{}, namespace, name string) map[string]interface{} {
+	identified := map[string]interface{}{}
+	for key, value := range manifest {
+		identified[key] = value
+	}
+	metadata := map[string]interface{}{}
+	if existing, ok := manifest["metadata"].(map[string]interface{}); ok {
+		for key, value := range existing {
+			metadata[key] = value
+		}
+	}
+	labels := map[string]interface{}{}
+	if existing, ok := metadata["labels"].(map[string]interface{}); ok {
+		for key, value := range existing {
+			labels[key] = value
+		}
+	}
+	labels[labelManagedBy] = managedBy
+	metadata["labels"] = labels
+	metadata["name"] = name
+	metadata["namespace"] = namespace
+	delete(metadata, "generateName")
+	identified["metadata"] = metadata
+	identified["apiVersion"] = "v1"
+	identified["kind"] = "Pod"
+	return identified
+}
+
+// isReady returns true when the Ready condition of the pod is true
+func isReady(p *pod) bool {
+	for _, condition := range p.Status.Conditions {
+		if condition.Type == "Ready" {
+			return condition.Status == "True"
+		}
+	}
+	return false
+}
+
+// describeStatus returns the phase of the pod and why its containers are
+// waiting
+func describeStatus(p *pod) string {
+	phase := p.Status.Phase
+	if phase == "" {
+		phase = "Pending"
+	}
+	reasons := []string{}
+	for _, container := range p.Status.ContainerStatuses {
+		if waiting := container.State.Waiting; waiting != nil && waiting.Reason != "" {
+			reason := fmt.Sprintf("%s: %s", container.Name, waiting.Reason)
+			if waiting.Message != "" {
+				reason += " (" + waiting.Message + ")"
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return phase
+	}
+	return phase + ", " + strings.Join(reasons, ", ")
+}
+
+// ParsePodManifest returns the pod defined in YAML or JSON by contents
+func ParsePodManifest(contents []byte) (map[string]interface{}, error) {
+	var parsed interface{}
+	if err := yaml.Unmarshal(contents, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse pod manifest: %s", err)
+	}
+	manifest, ok := toJSONValue(parsed).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to parse pod manifest: it is not an object")
+	}
+	if kind, ok := manifest["kind"]; ok && kind != "Pod" {
+		return nil, fmt.Errorf("failed to parse pod manifest: kind '%v' is not 'Pod'", kind)
+	}
+	return manifest, nil
+}
+
+// toJSONValue converts the maps with keys of any type produced by the
+// yaml parser into maps with string keys that can be encoded as json
+func toJSONValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			converted[fmt.Sprint(key)] = toJSONValue(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(typed))
+		for index, item := range typed {
+			converted[index] = toJSONValue(item)
+		}
+		return converted
+	default:
+		return value
+	}
+}
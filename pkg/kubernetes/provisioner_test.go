@@ -0,0 +1,190 @@
+package kubernetes
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPods serves the pods `workspace-a` and `workspace-b` created by a
+// provisioner and records the pods deleted
+type testPods struct {
+	deleted []string
+	mutex   sync.Mutex
+}
+
+func (p *testPods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/workspaces/pods":
+		if r.URL.Query().Get("labelSelector") != labelManagedBy+"="+managedBy {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"items": [{"metadata": {"name": "workspace-a"}}, {"metadata": {"name": "workspace-b"}}]}`)
+	case r.Method == http.MethodGet:
+		io.WriteString(w, `{
+			"metadata": {"labels": {"app.kubernetes.io/managed-by": "cloudshell"}},
+			"status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}
+		}`)
+	case r.Method == http.MethodDelete:
+		p.deleted = append(p.deleted, r.URL.Path)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *testPods) getDeleted() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string{}, p.deleted...)
+}
+
+func TestProvisionerAdopt(t *testing.T) {
+	pods := &testPods{}
+	client := newTestClient(t, pods.ServeHTTP)
+	provisioner := NewProvisioner()
+	if err := provisioner.Adopt(client, "workspaces", 100*time.Millisecond); err != nil {
+		t.Fatalf("failed to adopt pods: %s", err)
+	}
+	// a session using an adopted pod keeps it
+	if err := provisioner.Acquire(client, "workspaces", "workspace-a", map[string]interface{}{}, time.Second, ioutil.Discard); err != nil {
+		t.Fatalf("failed to acquire workspace: %s", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	deleted := pods.getDeleted()
+	if len(deleted) != 1 || deleted[0] != "/api/v1/namespaces/workspaces/pods/workspace-b" {
+		t.Fatalf("expected only the idle pod to be deleted, got %v", deleted)
+	}
+	provisioner.Release(client, "workspaces", "workspace-a", 100*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	if deleted := pods.getDeleted(); len(deleted) != 2 || deleted[1] != "/api/v1/namespaces/workspaces/pods/workspace-a" {
+		t.Errorf("expected the released pod to be deleted once idle, got %v", deleted)
+	}
+}
+
+func TestProvisionerAdoptKeepsLongestIdleTimeout(t *testing.T) {
+	pods := &testPods{}
+	client := newTestClient(t, pods.ServeHTTP)
+	provisioner := NewProvisioner()
+	if err := provisioner.Adopt(client, "workspaces", time.Hour); err != nil {
+		t.Fatalf("failed to adopt pods: %s", err)
+	}
+	if err := provisioner.Adopt(client, "workspaces", 50*time.Millisecond); err != nil {
+		t.Fatalf("failed to adopt pods: %s", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if deleted := pods.getDeleted(); len(deleted) != 0 {
+		t.Errorf("expected no pod to be deleted before the longest idle timeout, got %v", deleted)
+	}
+}
+
+// testWorkspacePod serves a single pod that is created and deleted through
+// the api, deletions wait for the channel sent on deleting to be closed and
+// leave the pod terminating for terminatingGets requests before it is gone
+type testWorkspacePod struct {
+	exists      bool
+	terminating int
+	created     int
+	// deleting receives a channel for every deletion that is only
+	// accepted once the channel is closed
+	deleting        chan chan struct{}
+	terminatingGets int
+	mutex           sync.Mutex
+}
+
+func (p *testWorkspacePod) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		accepted := make(chan struct{})
+		p.deleting <- accepted
+		<-accepted
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case !p.exists:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"kind": "Status", "message": "not found"}`)
+		case p.terminating > 0:
+			if p.terminating--; p.terminating == 0 {
+				p.exists = false
+			}
+			io.WriteString(w, `{
+				"metadata": {"labels": {"app.kubernetes.io/managed-by": "cloudshell"}, "deletionTimestamp": "2026-01-01T00:00:00Z"},
+				"status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}
+			}`)
+		default:
+			io.WriteString(w, `{
+				"metadata": {"labels": {"app.kubernetes.io/managed-by": "cloudshell"}},
+				"status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}
+			}`)
+		}
+	case http.MethodPost:
+		if p.exists {
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, `{"kind": "Status", "message": "already exists"}`)
+			return
+		}
+		p.exists = true
+		p.created++
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{}`)
+	case http.MethodDelete:
+		p.terminating = p.terminatingGets
+		if p.terminating == 0 {
+			p.exists = false
+		}
+	}
+}
+
+func (p *testWorkspacePod) getCreated() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.created
+}
+
+func TestProvisionerAcquireWhileDeleting(t *testing.T) {
+	pod := &testWorkspacePod{deleting: make(chan chan struct{}), terminatingGets: 1}
+	client := newTestClient(t, pod.ServeHTTP)
+	provisioner := NewProvisioner()
+	if err := provisioner.Acquire(client, "workspaces", "workspace-a", map[string]interface{}{}, 10*time.Second, ioutil.Discard); err != nil {
+		t.Fatalf("failed to acquire workspace: %s", err)
+	}
+	provisioner.Release(client, "workspaces", "workspace-a", 10*time.Millisecond)
+	accepted := <-pod.deleting
+	// the pod that is being deleted is not reused
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- provisioner.Acquire(client, "workspaces", "workspace-a", map[string]interface{}{}, 10*time.Second, ioutil.Discard)
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("expected the workspace being deleted to be waited for, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(accepted)
+	if err := <-acquired; err != nil {
+		t.Fatalf("failed to acquire workspace: %s", err)
+	}
+	if created := pod.getCreated(); created != 2 {
+		t.Errorf("expected the pod to be created again once deleted, got %v creations", created)
+	}
+}
+
+func TestProvisionerWaitsForTerminatingPod(t *testing.T) {
+	// the pod was deleted by someone else and is still terminating
+	pod := &testWorkspacePod{exists: true, terminating: 1}
+	client := newTestClient(t, pod.ServeHTTP)
+	if err := NewProvisioner().Acquire(client, "workspaces", "workspace-a", map[string]interface{}{}, 10*time.Second, ioutil.Discard); err != nil {
+		t.Fatalf("failed to acquire workspace: %s", err)
+	}
+	if created := pod.getCreated(); created != 1 {
+		t.Errorf("expected the pod to be created once the terminating pod is gone, got %v creations", created)
+	}
+}
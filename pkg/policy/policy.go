@@ -0,0 +1,138 @@
+// Package policy decides whether commands entered into sessions may be
+// submitted to the shell
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ActionDeny discards the command
+	ActionDeny = "deny"
+	// ActionWarn shows a warning and submits the command
+	ActionWarn = "warn"
+	// ActionConfirm submits the command only after the user types the
+	// confirmation of the rule
+	ActionConfirm = "confirm"
+	// DefaultConfirmation is the text that has to be typed to confirm a
+	// command when the rule does not specify it
+	DefaultConfirmation = "yes"
+)
+
+// ValidActions are the actions that rules can take
+var ValidActions = []string{ActionDeny, ActionWarn, ActionConfirm}
+
+// whitespacePattern matches runs of whitespace, these are collapsed into
+// a single space before matching commands
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// Rule applies an action to the commands matching a regular expression
+type Rule struct {
+	// Name identifies the rule in messages and the audit log
+	Name string `json:"name"`
+	// Pattern is a regular expression matched against the command line
+	// with runs of whitespace collapsed into a single space
+	Pattern string `json:"pattern"`
+	// Action is one of ValidActions
+	Action string `json:"action"`
+	// Message is shown to the user in addition to the name of the rule
+	Message string `json:"message,omitempty"`
+	// Confirmation is the text that has to be typed to confirm commands
+	// when Action is ActionConfirm, defaults to DefaultConfirmation
+	Confirmation string `json:"confirmation,omitempty"`
+	// Profiles when specified are the names of the profiles that the rule
+	// applies to, rules apply to all profiles otherwise
+	Profiles []string `json:"profiles,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// appliesTo returns true if the rule applies to sessions of the profile
+// named profileName
+func (r Rule) appliesTo(profileName string) bool {
+	if len(r.Profiles) == 0 {
+		return true
+	}
+	for _, name := range r.Profiles {
+		if name == profileName {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is an ordered list of rules, the first rule matching a command
+// decides what happens to it and commands matching no rule are allowed
+type Policy struct {
+	rules []Rule
+}
+
+// New returns a policy applying rules in order
+func New(rules ...Rule) (*Policy, error) {
+	names := map[string]bool{}
+	policy := &Policy{}
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("policy rule with pattern '%s' has no name", rule.Pattern)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("policy rule '%s' is defined more than once", rule.Name)
+		}
+		names[rule.Name] = true
+		if !isOneOf(rule.Action, ValidActions) {
+			return nil, fmt.Errorf("policy rule '%s' action '%s' is not one of ['%s']", rule.Name, rule.Action, strings.Join(ValidActions, "', '"))
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pattern of policy rule '%s': %s", rule.Name, err)
+		}
+		rule.pattern = pattern
+		if rule.Action == ActionConfirm && rule.Confirmation == "" {
+			rule.Confirmation = DefaultConfirmation
+		}
+		policy.rules = append(policy.rules, rule)
+	}
+	return policy, nil
+}
+
+// LoadFile loads a list of rules from the JSON file at filePath
+func LoadFile(filePath string) ([]Rule, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file '%s': %s", filePath, err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(contents, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file '%s': %s", filePath, err)
+	}
+	return rules, nil
+}
+
+// Evaluate returns the first rule matching command entered into a session
+// of the profile named profileName, false is returned when no rule matches
+// and the command is allowed
+func (p *Policy) Evaluate(profileName, command string) (Rule, bool) {
+	command = whitespacePattern.ReplaceAllString(strings.TrimSpace(command), " ")
+	if command == "" {
+		return Rule{}, false
+	}
+	for _, rule := range p.rules {
+		if rule.appliesTo(profileName) && rule.pattern.MatchString(command) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func isOneOf(value string, validValues []string) bool {
+	for _, validValue := range validValues {
+		if value == validValue {
+			return true
+		}
+	}
+	return false
+}
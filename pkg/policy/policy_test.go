@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		rules []Rule
+		err   string
+	}{
+		{[]Rule{{Name: "rm", Pattern: "^rm ", Action: ActionDeny}}, ""},
+		{[]Rule{{Pattern: "^rm ", Action: ActionDeny}}, "has no name"},
+		{[]Rule{{Name: "rm", Pattern: "^rm ", Action: ActionDeny}, {Name: "rm", Pattern: "^rmdir ", Action: ActionWarn}}, "defined more than once"},
+		{[]Rule{{Name: "rm", Pattern: "^rm ", Action: "block"}}, "is not one of"},
+		{[]Rule{{Name: "rm", Pattern: "^rm (", Action: ActionDeny}}, "failed to parse pattern"},
+	}
+	for _, test := range tests {
+		_, err := New(test.rules...)
+		if test.err == "" && err != nil {
+			t.Errorf("expected %+v to be valid, got %s", test.rules, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("expected %+v to be rejected with '%s', got %v", test.rules, test.err, err)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	policy, err := New(
+		Rule{Name: "no-root-rm", Pattern: `\brm -[a-zA-Z]*r[a-zA-Z]* /( |$)`, Action: ActionDeny},
+		Rule{Name: "delete-namespace", Pattern: `^kubectl( .*)? delete (ns|namespace)\b`, Action: ActionConfirm, Profiles: []string{"production"}},
+		Rule{Name: "kubectl-delete", Pattern: `^kubectl( .*)? delete\b`, Action: ActionWarn},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		profile string
+		command string
+		rule    string
+	}{
+		{"default", "rm -rf /", "no-root-rm"},
+		{"default", "sudo rm -fr / --no-preserve-root", "no-root-rm"},
+		// whitespace cannot be used to get around patterns
+		{"default", "  rm   -rf \t /  ", "no-root-rm"},
+		{"default", "rm -rf /tmp/build", ""},
+		{"default", "ls", ""},
+		{"default", "", ""},
+		{"default", "   ", ""},
+		// rules only apply to their profiles and the first match applies
+		{"production", "kubectl -n prod delete ns prod", "delete-namespace"},
+		{"default", "kubectl -n prod delete ns prod", "kubectl-delete"},
+		{"production", "kubectl delete pod api", "kubectl-delete"},
+	}
+	for _, test := range tests {
+		rule, matched := policy.Evaluate(test.profile, test.command)
+		if matched != (test.rule != "") || rule.Name != test.rule {
+			t.Errorf("expected '%s' in profile '%s' to match rule '%s', got '%s' (%v)", test.command, test.profile, test.rule, rule.Name, matched)
+		}
+	}
+	if rule, _ := policy.Evaluate("production", "kubectl delete ns prod"); rule.Confirmation != DefaultConfirmation {
+		t.Errorf("expected the default confirmation '%s', got '%s'", DefaultConfirmation, rule.Confirmation)
+	}
+}
@@ -0,0 +1,37 @@
+package profile
+
+import "fmt"
+
+// DefaultClipboardMaxSizeBytes is the maximum size of the content that
+// programs can copy to the clipboard when Clipboard.MaxSizeBytes is not
+// specified
+const DefaultClipboardMaxSizeBytes = 1 << 20
+
+// Clipboard defines what happens when programs such as tmux and vim copy
+// to the clipboard of the terminal using OSC 52
+type Clipboard struct {
+	// Disabled discards the content copied by programs instead of copying
+	// it to the clipboard of the client
+	Disabled bool `json:"disabled,omitempty"`
+	// MaxSizeBytes is the maximum size of the content copied at once,
+	// larger content is discarded
+	MaxSizeBytes int `json:"max-size-bytes,omitempty"`
+	// Audit records the content copied by programs in the audit log
+	Audit bool `json:"audit,omitempty"`
+}
+
+// GetMaxSizeBytes returns the maximum size of the content copied at once
+func (c Clipboard) GetMaxSizeBytes() int {
+	if c.MaxSizeBytes <= 0 {
+		return DefaultClipboardMaxSizeBytes
+	}
+	return c.MaxSizeBytes
+}
+
+// validate returns an error if the clipboard configuration is invalid
+func (c Clipboard) validate() error {
+	if c.MaxSizeBytes < 0 {
+		return fmt.Errorf("clipboard max-size-bytes cannot be negative")
+	}
+	return nil
+}
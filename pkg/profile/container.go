@@ -0,0 +1,39 @@
+package profile
+
+import "fmt"
+
+// DefaultContainerSocket is the path to the unix socket of the Docker
+// Engine api when Container.Socket is not specified
+const DefaultContainerSocket = "/var/run/docker.sock"
+
+// Container defines a running container that the processes of a profile
+// are started in with the exec api of the Docker Engine instead of being
+// started locally, Command and Arguments form the command that is run
+type Container struct {
+	// Name is the name or id of the container, it may be a template
+	// referencing Parameters
+	Name string `json:"name"`
+	// User is the user processes are run as in the format `user`,
+	// `user:group`, `uid` or `uid:gid`, it may be a template referencing
+	// Parameters and defaults to the user of the container
+	User string `json:"user,omitempty"`
+	// Socket is the path to the unix socket of the Docker Engine api,
+	// defaults to /var/run/docker.sock
+	Socket string `json:"socket,omitempty"`
+}
+
+// GetSocket returns the path to the unix socket of the Docker Engine api
+func (c Container) GetSocket() string {
+	if c.Socket == "" {
+		return DefaultContainerSocket
+	}
+	return c.Socket
+}
+
+// validate returns an error if the container configuration is invalid
+func (c Container) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("container does not specify a name")
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// LoadFile loads a list of profiles from the JSON file at filePath, unknown
+// properties are rejected like in the profiles of the configuration file
+func LoadFile(filePath string) ([]Profile, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles file '%s': %s", filePath, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	var profiles []Profile
+	if err := decoder.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles file '%s': %s", filePath, err)
+	}
+	return profiles, nil
+}
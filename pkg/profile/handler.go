@@ -0,0 +1,38 @@
+package profile
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Summary is the publicly visible representation of a profile
+type Summary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	IsDefault   bool   `json:"default"`
+}
+
+// GetListHandler returns a http handler that responds with a JSON list of
+// the profiles available in registry
+func GetListHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defaultName := registry.Default()
+		summaries := []Summary{}
+		for _, profile := range registry.List() {
+			summaries = append(summaries, Summary{
+				Name:        profile.Name,
+				Description: profile.Description,
+				IsDefault:   profile.Name == defaultName,
+			})
+		}
+		response, err := json.Marshal(summaries)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to list profiles"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
@@ -0,0 +1,122 @@
+package profile
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultKubernetesNamespace is the namespace of the pod when
+// Kubernetes.Namespace is not specified
+const DefaultKubernetesNamespace = "default"
+
+// DefaultWorkspaceIdleTimeout is the time a workspace pod is kept after its
+// last session ended when Workspace.IdleTimeout is not specified
+const DefaultWorkspaceIdleTimeout = 10 * time.Minute
+
+// DefaultWorkspaceReadyTimeout is the time allowed for a workspace pod to
+// become ready when Workspace.ReadyTimeout is not specified
+const DefaultWorkspaceReadyTimeout = 5 * time.Minute
+
+// Kubernetes defines a running pod that the processes of a profile are
+// started in with the exec api of Kubernetes instead of being started
+// locally, Command and Arguments form the command that is run
+type Kubernetes struct {
+	// Namespace is the namespace of the pod, it may be a template
+	// referencing Parameters and defaults to `default`
+	Namespace string `json:"namespace,omitempty"`
+	// Pod is the name of the pod, it may be a template referencing
+	// Parameters
+	Pod string `json:"pod,omitempty"`
+	// Selector is a label selector choosing the first running pod in
+	// alphabetical order when Pod is not specified, it may be a template
+	// referencing Parameters
+	Selector string `json:"selector,omitempty"`
+	// Workspace defines a pod that is created when it does not exist
+	// instead of using an existing Pod or a pod chosen by Selector
+	Workspace *Workspace `json:"workspace,omitempty"`
+	// Container is the container of the pod, it may be a template
+	// referencing Parameters and defaults to the default container of the
+	// pod
+	Container string `json:"container,omitempty"`
+	// Kubeconfig is the path to a kubeconfig file whose current context is
+	// used, the service account of the pod that the server runs in is used
+	// when it is not specified
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+}
+
+// GetNamespace returns the namespace of the pod
+func (k Kubernetes) GetNamespace() string {
+	if k.Namespace == "" {
+		return DefaultKubernetesNamespace
+	}
+	return k.Namespace
+}
+
+// validate returns an error if the kubernetes configuration is invalid
+func (k Kubernetes) validate() error {
+	specified := 0
+	for _, isSpecified := range []bool{k.Pod != "", k.Selector != "", k.Workspace != nil} {
+		if isSpecified {
+			specified++
+		}
+	}
+	if specified == 0 {
+		return fmt.Errorf("kubernetes specifies neither a pod, a selector nor a workspace")
+	}
+	if specified > 1 {
+		return fmt.Errorf("kubernetes can only specify one of a pod, a selector and a workspace")
+	}
+	if k.Workspace != nil {
+		return k.Workspace.validate()
+	}
+	return nil
+}
+
+// Workspace defines a pod per user that is created from a template when a
+// session is started and deleted once no session used it for a while
+type Workspace struct {
+	// Name is the name of the pod, it may be a template referencing
+	// Parameters such as `workspace-{{.user}}` so that every user gets
+	// their own pod
+	Name string `json:"name"`
+	// TemplateFile is the path to a YAML or JSON file with the manifest of
+	// the pod, its name and namespace are replaced
+	TemplateFile string `json:"template-file"`
+	// IdleTimeout is the time in seconds the pod is kept after its last
+	// session ended, defaults to 600
+	IdleTimeout int `json:"idle-timeout,omitempty"`
+	// ReadyTimeout is the time in seconds allowed for the pod to become
+	// ready, defaults to 300
+	ReadyTimeout int `json:"ready-timeout,omitempty"`
+}
+
+// GetIdleTimeout returns the time the pod is kept after its last session
+// ended
+func (w Workspace) GetIdleTimeout() time.Duration {
+	if w.IdleTimeout <= 0 {
+		return DefaultWorkspaceIdleTimeout
+	}
+	return time.Duration(w.IdleTimeout) * time.Second
+}
+
+// GetReadyTimeout returns the time allowed for the pod to become ready
+func (w Workspace) GetReadyTimeout() time.Duration {
+	if w.ReadyTimeout <= 0 {
+		return DefaultWorkspaceReadyTimeout
+	}
+	return time.Duration(w.ReadyTimeout) * time.Second
+}
+
+// validate returns an error if the workspace configuration is invalid
+func (w Workspace) validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("kubernetes workspace does not specify a name")
+	}
+	if w.TemplateFile == "" {
+		return fmt.Errorf("kubernetes workspace does not specify a template file")
+	}
+	if w.IdleTimeout < 0 || w.ReadyTimeout < 0 {
+		return fmt.Errorf("kubernetes workspace timeouts cannot be negative")
+	}
+	return nil
+}
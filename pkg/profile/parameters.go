@@ -0,0 +1,315 @@
+package profile
+
+import (
+	"bytes"
+	"cloudshell/pkg/auth"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const (
+	// SourceQuery indicates a parameter is read from the query string
+	SourceQuery = "query"
+	// SourceClaim indicates a parameter is read from the authenticated
+	// principal's claims
+	SourceClaim = "claim"
+)
+
+var parameterNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Parameter defines a value that can be referenced by templates in the
+// arguments and the ssh host and user of a profile as `{{.name}}`. Every
+// templated argument always results in exactly one argument regardless of
+// the parameter's value and no shell is involved in the expansion
+type Parameter struct {
+	// Name is the identifier used to reference the parameter in templates
+	Name string `json:"name"`
+	// Source is where the value is read from, one of 'query' or 'claim',
+	// defaults to 'query'
+	Source string `json:"source,omitempty"`
+	// Key is the query parameter or claim name to read the value from,
+	// defaults to Name
+	Key string `json:"key,omitempty"`
+	// Pattern is a regular expression the whole value must match
+	Pattern string `json:"pattern"`
+	// Default is the value used when none is provided
+	Default string `json:"default,omitempty"`
+	// Required indicates a value must be provided when there is no Default
+	Required bool `json:"required,omitempty"`
+}
+
+// GetSource returns the source of the parameter's value
+func (p Parameter) GetSource() string {
+	if p.Source == "" {
+		return SourceQuery
+	}
+	return p.Source
+}
+
+// GetKey returns the key the parameter's value is read from
+func (p Parameter) GetKey() string {
+	if p.Key == "" {
+		return p.Name
+	}
+	return p.Key
+}
+
+// getMatcher returns the anchored regular expression values must match
+func (p Parameter) getMatcher() (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + p.Pattern + `)$`)
+}
+
+// validate returns an error if the parameter definition is invalid
+func (p Parameter) validate() error {
+	if !parameterNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("parameter name '%s' should match '%s'", p.Name, parameterNamePattern.String())
+	}
+	if source := p.GetSource(); source != SourceQuery && source != SourceClaim {
+		return fmt.Errorf("parameter '%s' has source '%s' which is not one of ['%s', '%s']", p.Name, source, SourceQuery, SourceClaim)
+	}
+	if p.Pattern == "" {
+		return fmt.Errorf("parameter '%s' does not specify a pattern", p.Name)
+	}
+	matcher, err := p.getMatcher()
+	if err != nil {
+		return fmt.Errorf("parameter '%s' has an invalid pattern: %s", p.Name, err)
+	}
+	if p.Default != "" && !matcher.MatchString(p.Default) {
+		return fmt.Errorf("parameter '%s' has a default value that does not match its pattern", p.Name)
+	}
+	return nil
+}
+
+// resolve returns the validated value of the parameter
+func (p Parameter) resolve(query url.Values, claims map[string]string) (string, error) {
+	var value string
+	var isSet bool
+	switch p.GetSource() {
+	case SourceClaim:
+		value, isSet = claims[p.GetKey()]
+	default:
+		values, ok := query[p.GetKey()]
+		if ok && len(values) > 1 {
+			return "", fmt.Errorf("parameter '%s' was specified more than once", p.Name)
+		}
+		if ok {
+			value, isSet = values[0], true
+		}
+	}
+	if !isSet || value == "" {
+		if p.Required && p.Default == "" {
+			return "", fmt.Errorf("parameter '%s' is required", p.Name)
+		}
+		return p.Default, nil
+	}
+	if strings.HasPrefix(value, "-") {
+		return "", fmt.Errorf("parameter '%s' cannot begin with '-'", p.Name)
+	}
+	matcher, err := p.getMatcher()
+	if err != nil {
+		return "", err
+	}
+	if !matcher.MatchString(value) {
+		return "", fmt.Errorf("parameter '%s' does not match '%s'", p.Name, p.Pattern)
+	}
+	return value, nil
+}
+
+// parseTemplate parses text as the template of the profile called name
+func (p Profile) parseTemplate(name, text string) (*template.Template, error) {
+	parsed, err := template.New(fmt.Sprintf("%s.%s", p.Name, name)).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("profile '%s' has an invalid template in %s: %s", p.Name, name, err)
+	}
+	return parsed, nil
+}
+
+// renderTemplate expands text, the template of the profile called name,
+// using values
+func (p Profile) renderTemplate(name, text string, values map[string]string) (string, error) {
+	parsed, err := p.parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var rendered bytes.Buffer
+	if err := parsed.Execute(&rendered, values); err != nil {
+		return "", fmt.Errorf("profile '%s' failed to render %s: %s", p.Name, name, err)
+	}
+	return rendered.String(), nil
+}
+
+// validateParameters checks parameter definitions and that argument
+// templates only reference defined parameters
+func (p Profile) validateParameters() error {
+	values := map[string]string{}
+	for _, parameter := range p.Parameters {
+		if err := parameter.validate(); err != nil {
+			return fmt.Errorf("profile '%s': %s", p.Name, err)
+		}
+		if _, exists := values[parameter.Name]; exists {
+			return fmt.Errorf("profile '%s' defines parameter '%s' more than once", p.Name, parameter.Name)
+		}
+		values[parameter.Name] = parameter.Default
+	}
+	if _, err := p.renderArguments(values); err != nil {
+		return err
+	}
+	if _, err := p.renderSSH(values); err != nil {
+		return err
+	}
+	if _, err := p.renderContainer(values); err != nil {
+		return err
+	}
+	if _, err := p.renderKubernetes(values); err != nil {
+		return err
+	}
+	return p.validateWorkspaceName(values)
+}
+
+// validateWorkspaceName checks that the name of the workspace pod depends
+// on a required parameter read from the user claim, so that every user
+// gets their own pod rather than sharing it with other users; the name is
+// rendered for two users and has to differ between them
+func (p Profile) validateWorkspaceName(values map[string]string) error {
+	if p.Kubernetes == nil || p.Kubernetes.Workspace == nil {
+		return nil
+	}
+	names := []string{}
+	for _, user := range []string{"user-a", "user-b"} {
+		userValues := map[string]string{}
+		for name, value := range values {
+			userValues[name] = value
+		}
+		for _, parameter := range p.Parameters {
+			if parameter.GetSource() == SourceClaim && parameter.GetKey() == auth.ClaimUser && parameter.Required && parameter.Default == "" {
+				userValues[parameter.Name] = user
+			}
+		}
+		name, err := p.renderTemplate("kubernetes workspace name", p.Kubernetes.Workspace.Name, userValues)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if names[0] == names[1] {
+		return fmt.Errorf("profile '%s' has a workspace name that does not reference a required parameter with the '%s' claim as its source, users would share its pod", p.Name, auth.ClaimUser)
+	}
+	return nil
+}
+
+// renderArguments expands the argument templates using values
+func (p Profile) renderArguments(values map[string]string) ([]string, error) {
+	arguments := make([]string, 0, len(p.Arguments))
+	for index, argument := range p.Arguments {
+		rendered, err := p.renderTemplate(fmt.Sprintf("argument %v", index), argument, values)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, rendered)
+	}
+	return arguments, nil
+}
+
+// renderSSH expands the templates of the ssh host and user using values
+func (p Profile) renderSSH(values map[string]string) (*SSH, error) {
+	if p.SSH == nil {
+		return nil, nil
+	}
+	rendered := *p.SSH
+	var err error
+	if rendered.Host, err = p.renderTemplate("ssh host", p.SSH.Host, values); err != nil {
+		return nil, err
+	}
+	if rendered.User, err = p.renderTemplate("ssh user", p.SSH.User, values); err != nil {
+		return nil, err
+	}
+	return &rendered, nil
+}
+
+// renderContainer expands the templates of the container name and user
+// using values
+func (p Profile) renderContainer(values map[string]string) (*Container, error) {
+	if p.Container == nil {
+		return nil, nil
+	}
+	rendered := *p.Container
+	var err error
+	if rendered.Name, err = p.renderTemplate("container name", p.Container.Name, values); err != nil {
+		return nil, err
+	}
+	if rendered.User, err = p.renderTemplate("container user", p.Container.User, values); err != nil {
+		return nil, err
+	}
+	return &rendered, nil
+}
+
+// renderKubernetes expands the templates of the namespace, pod, selector,
+// workspace name and container using values
+func (p Profile) renderKubernetes(values map[string]string) (*Kubernetes, error) {
+	if p.Kubernetes == nil {
+		return nil, nil
+	}
+	rendered := *p.Kubernetes
+	var err error
+	if rendered.Namespace, err = p.renderTemplate("kubernetes namespace", p.Kubernetes.Namespace, values); err != nil {
+		return nil, err
+	}
+	if rendered.Pod, err = p.renderTemplate("kubernetes pod", p.Kubernetes.Pod, values); err != nil {
+		return nil, err
+	}
+	if rendered.Selector, err = p.renderTemplate("kubernetes selector", p.Kubernetes.Selector, values); err != nil {
+		return nil, err
+	}
+	if rendered.Container, err = p.renderTemplate("kubernetes container", p.Kubernetes.Container, values); err != nil {
+		return nil, err
+	}
+	if p.Kubernetes.Workspace != nil {
+		workspace := *p.Kubernetes.Workspace
+		if workspace.Name, err = p.renderTemplate("kubernetes workspace name", workspace.Name, values); err != nil {
+			return nil, err
+		}
+		rendered.Workspace = &workspace
+	}
+	return &rendered, nil
+}
+
+// Instantiate returns a copy of the profile with its argument, ssh,
+// container and kubernetes templates expanded using parameter values from
+// query and claims
+func (p Profile) Instantiate(query url.Values, claims map[string]string) (Profile, error) {
+	values := map[string]string{}
+	for _, parameter := range p.Parameters {
+		value, err := parameter.resolve(query, claims)
+		if err != nil {
+			return Profile{}, err
+		}
+		values[parameter.Name] = value
+	}
+	arguments, err := p.renderArguments(values)
+	if err != nil {
+		return Profile{}, err
+	}
+	ssh, err := p.renderSSH(values)
+	if err != nil {
+		return Profile{}, err
+	}
+	container, err := p.renderContainer(values)
+	if err != nil {
+		return Profile{}, err
+	}
+	kubernetes, err := p.renderKubernetes(values)
+	if err != nil {
+		return Profile{}, err
+	}
+	instance := p
+	instance.Arguments = arguments
+	instance.SSH = ssh
+	instance.Container = container
+	instance.Kubernetes = kubernetes
+	return instance, nil
+}
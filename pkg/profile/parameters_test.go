@@ -0,0 +1,119 @@
+package profile
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParameterResolve(t *testing.T) {
+	tests := []struct {
+		parameter Parameter
+		query     url.Values
+		claims    map[string]string
+		value     string
+		err       string
+	}{
+		{Parameter{Name: "host", Pattern: "[a-z]+"}, url.Values{"host": {"web"}}, nil, "web", ""},
+		{Parameter{Name: "host", Key: "h", Pattern: "[a-z]+"}, url.Values{"h": {"web"}}, nil, "web", ""},
+		{Parameter{Name: "host", Pattern: "[a-z]+", Default: "db"}, url.Values{}, nil, "db", ""},
+		{Parameter{Name: "host", Pattern: "[a-z]+", Default: "db"}, url.Values{"host": {""}}, nil, "db", ""},
+		{Parameter{Name: "user", Source: SourceClaim, Pattern: "[a-z]+"}, url.Values{"user": {"root"}}, map[string]string{"user": "alice"}, "alice", ""},
+		{Parameter{Name: "host", Pattern: "[a-z]+", Required: true}, url.Values{}, nil, "", "is required"},
+		{Parameter{Name: "user", Source: SourceClaim, Pattern: "[a-z]+", Required: true}, url.Values{"user": {"alice"}}, nil, "", "is required"},
+		// the pattern must match the whole value rather than a part of it
+		{Parameter{Name: "host", Pattern: "[a-z]+"}, url.Values{"host": {"web;reboot"}}, nil, "", "does not match"},
+		{Parameter{Name: "host", Pattern: "[a-z]+"}, url.Values{"host": {"1web"}}, nil, "", "does not match"},
+		{Parameter{Name: "host", Pattern: "web|db"}, url.Values{"host": {"webdb"}}, nil, "", "does not match"},
+		// values are never passed as options even if the pattern allows it
+		{Parameter{Name: "flag", Pattern: ".+"}, url.Values{"flag": {"--help"}}, nil, "", "cannot begin with '-'"},
+		{Parameter{Name: "user", Source: SourceClaim, Pattern: ".+"}, nil, map[string]string{"user": "-oProxyCommand=sh"}, "", "cannot begin with '-'"},
+		{Parameter{Name: "host", Pattern: "[a-z]+"}, url.Values{"host": {"web", "db"}}, nil, "", "more than once"},
+	}
+	for _, test := range tests {
+		value, err := test.parameter.resolve(test.query, test.claims)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("expected %+v to resolve from %v and %v, got %s", test.parameter, test.query, test.claims, err)
+			} else if value != test.value {
+				t.Errorf("expected %+v to resolve to '%s', got '%s'", test.parameter, test.value, value)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected %+v to be rejected from %v and %v with '%s', got %v", test.parameter, test.query, test.claims, test.err, err)
+		}
+	}
+}
+
+func TestInstantiateOneArgumentPerTemplate(t *testing.T) {
+	p := Profile{
+		Name:       "greet",
+		Command:    "/bin/echo",
+		Arguments:  []string{"--message={{.message}}", "{{.message}}"},
+		Parameters: []Parameter{{Name: "message", Pattern: ".+"}},
+	}
+	instance, err := p.Instantiate(url.Values{"message": {"hello world; rm -rf / 'x' $(id)"}}, nil)
+	if err != nil {
+		t.Fatalf("failed to instantiate profile: %s", err)
+	}
+	expected := []string{"--message=hello world; rm -rf / 'x' $(id)", "hello world; rm -rf / 'x' $(id)"}
+	if len(instance.Arguments) != len(expected) {
+		t.Fatalf("expected arguments %q, got %q", expected, instance.Arguments)
+	}
+	for index := range expected {
+		if instance.Arguments[index] != expected[index] {
+			t.Errorf("expected argument %v to be %q, got %q", index, expected[index], instance.Arguments[index])
+		}
+	}
+	if len(p.Arguments) != 2 || p.Arguments[0] != "--message={{.message}}" {
+		t.Errorf("expected the templates of the profile to be left as is, got %q", p.Arguments)
+	}
+}
+
+func TestValidateParametersMissingKey(t *testing.T) {
+	tests := []Profile{
+		{Name: "argument", Command: "/bin/echo", Arguments: []string{"{{.undefined}}"}},
+		{Name: "argument", Command: "/bin/echo", Arguments: []string{"{{.host}}-{{.undefined}}"}, Parameters: []Parameter{{Name: "host", Pattern: "[a-z]+"}}},
+		{Name: "ssh", SSH: &SSH{Host: "{{.undefined}}"}},
+		{Name: "ssh", SSH: &SSH{Host: "example.com", User: "{{.undefined}}"}},
+	}
+	for _, p := range tests {
+		err := p.validateParameters()
+		if err == nil || !strings.Contains(err.Error(), "map has no entry for key \"undefined\"") {
+			t.Errorf("expected profile %+v referencing an undefined parameter to be invalid, got %v", p, err)
+		}
+	}
+}
+
+func TestValidateWorkspaceName(t *testing.T) {
+	tests := []struct {
+		name      string
+		parameter Parameter
+		valid     bool
+	}{
+		{"workspace-{{.user}}", Parameter{Name: "user", Source: SourceClaim, Pattern: "[a-z0-9-]+", Required: true}, true},
+		{"workspace-{{.owner}}", Parameter{Name: "owner", Source: SourceClaim, Key: "user", Pattern: "[a-z0-9-]+", Required: true}, true},
+		{"workspace", Parameter{Name: "user", Source: SourceClaim, Pattern: "[a-z0-9-]+", Required: true}, false},
+		{"workspace-{{.user}}", Parameter{Name: "user", Source: SourceClaim, Pattern: "[a-z0-9-]+"}, false},
+		{"workspace-{{.user}}", Parameter{Name: "user", Source: SourceClaim, Pattern: "[a-z0-9-]+", Required: true, Default: "shared"}, false},
+		{"workspace-{{.user}}", Parameter{Name: "user", Source: SourceQuery, Pattern: "[a-z0-9-]+", Required: true}, false},
+		{"workspace-{{.team}}", Parameter{Name: "team", Source: SourceClaim, Pattern: "[a-z0-9-]+", Required: true}, false},
+		{"workspace{{if .user}}-user{{end}}", Parameter{Name: "user", Source: SourceClaim, Pattern: "[a-z0-9-]+", Required: true}, false},
+	}
+	for _, test := range tests {
+		p := Profile{
+			Name:       "workspace",
+			Command:    "/bin/bash",
+			Parameters: []Parameter{test.parameter},
+			Kubernetes: &Kubernetes{Workspace: &Workspace{Name: test.name, TemplateFile: "/etc/cloudshell/workspace.yaml"}},
+		}
+		err := p.Validate()
+		if test.valid && err != nil {
+			t.Errorf("expected workspace '%s' with %+v to be valid, got %s", test.name, test.parameter, err)
+		}
+		if !test.valid && (err == nil || !strings.Contains(err.Error(), "users would share its pod")) {
+			t.Errorf("expected workspace '%s' with %+v to be shared by users, got %v", test.name, test.parameter, err)
+		}
+	}
+}
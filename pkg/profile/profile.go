@@ -0,0 +1,151 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// NamePattern defines the characters a profile name may contain, names
+// are used in url paths so they are restricted to url-safe characters
+var NamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Profile is a named terminal configuration that a connection can select
+type Profile struct {
+	// Name uniquely identifies this profile and is used to select it
+	Name string `json:"name"`
+	// Description is a human-readable summary of what the profile provides
+	Description string `json:"description,omitempty"`
+	// Command is the path to the binary we should create a TTY for
+	Command string `json:"command"`
+	// Arguments is a list of strings to pass as arguments to Command, each
+	// argument may be a template referencing Parameters
+	Arguments []string `json:"arguments,omitempty"`
+	// Parameters defines the values that templates in Arguments, SSH,
+	// Container and Kubernetes can use
+	Parameters []Parameter `json:"parameters,omitempty"`
+	// Env is a map of environment variables that will be added to the
+	// environment of the server when starting Command
+	Env map[string]string `json:"env,omitempty"`
+	// Workdir is the directory Command will be started in, when not
+	// specified, the working directory of the server is used
+	Workdir string `json:"workdir,omitempty"`
+	// Limits overrides the connection limits of the handler for this profile
+	Limits Limits `json:"limits,omitempty"`
+	// Respawn defines whether the process is restarted when it exits
+	Respawn Respawn `json:"respawn,omitempty"`
+	// ShellIntegration enables the reporting of prompts, commands and the
+	// working directory by bash and zsh shells
+	ShellIntegration bool `json:"shell-integration,omitempty"`
+	// Clipboard defines what happens when programs copy to the clipboard
+	Clipboard Clipboard `json:"clipboard,omitempty"`
+	// SSH when specified opens sessions on a remote host instead of
+	// starting Command locally
+	SSH *SSH `json:"ssh,omitempty"`
+	// Container when specified starts the processes of sessions in a
+	// running container instead of starting Command locally
+	Container *Container `json:"container,omitempty"`
+	// Kubernetes when specified starts the processes of sessions in a
+	// running pod instead of starting Command locally
+	Kubernetes *Kubernetes `json:"kubernetes,omitempty"`
+}
+
+// Limits defines per-profile connection limits, zero values indicate that
+// the handler-wide defaults should be used
+type Limits struct {
+	// ConnectionErrorLimit defines the number of consecutive errors that can
+	// happen before a connection is considered unusable
+	ConnectionErrorLimit int `json:"connection-error-limit,omitempty"`
+	// KeepalivePingTimeout defines the maximum duration in seconds between
+	// a ping and pong cycle
+	KeepalivePingTimeout int `json:"keepalive-ping-timeout,omitempty"`
+	// MaxBufferSizeBytes defines the maximum length of input from the terminal
+	MaxBufferSizeBytes int `json:"max-buffer-size-bytes,omitempty"`
+	// MaxSessions defines the maximum number of concurrent sessions that
+	// can use this profile
+	MaxSessions int `json:"max-sessions,omitempty"`
+}
+
+// GetKeepalivePingTimeout returns the keepalive ping timeout as a duration
+func (l Limits) GetKeepalivePingTimeout() time.Duration {
+	return time.Duration(l.KeepalivePingTimeout) * time.Second
+}
+
+// Environ returns the environment that Command should be started with
+func (p Profile) Environ() []string {
+	environment := os.Environ()
+	keys := make([]string, 0, len(p.Env))
+	for key := range p.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		environment = append(environment, fmt.Sprintf("%s=%s", key, p.Env[key]))
+	}
+	return environment
+}
+
+// countBackends returns the number of backends other than the local pty
+// that the profile specifies
+func (p Profile) countBackends() int {
+	backends := 0
+	if p.SSH != nil {
+		backends++
+	}
+	if p.Container != nil {
+		backends++
+	}
+	if p.Kubernetes != nil {
+		backends++
+	}
+	return backends
+}
+
+// IsLocal returns true when the processes of the profile are started on a
+// local pty rather than on an ssh host, in a container or in a pod
+func (p Profile) IsLocal() bool {
+	return p.countBackends() == 0
+}
+
+// Validate returns an error if the profile cannot be used
+func (p Profile) Validate() error {
+	if !NamePattern.MatchString(p.Name) {
+		return fmt.Errorf("profile name '%s' should match '%s'", p.Name, NamePattern.String())
+	}
+	if p.Command == "" && p.SSH == nil {
+		return fmt.Errorf("profile '%s' does not specify a command", p.Name)
+	}
+	if p.Limits.ConnectionErrorLimit < 0 ||
+		p.Limits.KeepalivePingTimeout < 0 ||
+		p.Limits.MaxBufferSizeBytes < 0 ||
+		p.Limits.MaxSessions < 0 {
+		return fmt.Errorf("profile '%s' has negative limits", p.Name)
+	}
+	if err := p.Respawn.validate(); err != nil {
+		return fmt.Errorf("profile '%s': %s", p.Name, err)
+	}
+	if err := p.Clipboard.validate(); err != nil {
+		return fmt.Errorf("profile '%s': %s", p.Name, err)
+	}
+	if p.SSH != nil {
+		if err := p.SSH.validate(); err != nil {
+			return fmt.Errorf("profile '%s': %s", p.Name, err)
+		}
+	}
+	if p.Container != nil {
+		if err := p.Container.validate(); err != nil {
+			return fmt.Errorf("profile '%s': %s", p.Name, err)
+		}
+	}
+	if p.Kubernetes != nil {
+		if err := p.Kubernetes.validate(); err != nil {
+			return fmt.Errorf("profile '%s': %s", p.Name, err)
+		}
+	}
+	if backends := p.countBackends(); backends > 1 {
+		return fmt.Errorf("profile '%s' can only specify one of ssh, container and kubernetes", p.Name)
+	}
+	return p.validateParameters()
+}
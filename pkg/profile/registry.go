@@ -0,0 +1,80 @@
+package profile
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Registry holds the set of profiles available to connections
+type Registry struct {
+	defaultName string
+	mutex       sync.RWMutex
+	order       []string
+	profiles    map[string]Profile
+}
+
+// NewRegistry returns a registry containing the provided profiles where
+// the profile named defaultName is used when no profile is requested
+func NewRegistry(defaultName string, profiles ...Profile) (*Registry, error) {
+	registry := &Registry{}
+	if err := registry.Replace(defaultName, profiles); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Replace validates the provided profiles and replaces all profiles in
+// the registry with them
+func (r *Registry) Replace(defaultName string, profiles []Profile) error {
+	order := make([]string, 0, len(profiles))
+	byName := make(map[string]Profile, len(profiles))
+	for _, profile := range profiles {
+		if err := profile.Validate(); err != nil {
+			return err
+		}
+		if _, exists := byName[profile.Name]; exists {
+			return fmt.Errorf("profile '%s' is defined more than once", profile.Name)
+		}
+		order = append(order, profile.Name)
+		byName[profile.Name] = profile
+	}
+	if _, exists := byName[defaultName]; !exists {
+		return fmt.Errorf("default profile '%s' is not defined", defaultName)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.defaultName = defaultName
+	r.order = order
+	r.profiles = byName
+	return nil
+}
+
+// Default returns the name of the default profile
+func (r *Registry) Default() string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.defaultName
+}
+
+// Get returns the profile identified by name, the default profile is
+// returned when name is empty
+func (r *Registry) Get(name string) (Profile, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if name == "" {
+		name = r.defaultName
+	}
+	profile, ok := r.profiles[name]
+	return profile, ok
+}
+
+// List returns all profiles in the order they were defined
+func (r *Registry) List() []Profile {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	profiles := make([]Profile, 0, len(r.order))
+	for _, name := range r.order {
+		profiles = append(profiles, r.profiles[name])
+	}
+	return profiles
+}
@@ -0,0 +1,99 @@
+package profile
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// RespawnNever never restarts the process when it exits
+	RespawnNever = "never"
+	// RespawnAlways restarts the process whenever it exits
+	RespawnAlways = "always"
+	// RespawnOnFailure restarts the process when it exits with a non-zero
+	// status or is terminated by a signal
+	RespawnOnFailure = "on-failure"
+
+	// DefaultRespawnBackoff is the delay before the first restart
+	DefaultRespawnBackoff = time.Second
+	// DefaultRespawnMaxBackoff is the upper bound of the delay between restarts
+	DefaultRespawnMaxBackoff = 30 * time.Second
+)
+
+// ValidRespawnPolicies lists the accepted values of Respawn.Policy
+var ValidRespawnPolicies = []string{RespawnNever, RespawnAlways, RespawnOnFailure}
+
+// Respawn defines what should happen when the process of a session exits
+type Respawn struct {
+	// Policy is one of 'never', 'always' or 'on-failure', defaults to 'never'
+	Policy string `json:"policy,omitempty"`
+	// MaxRestarts is the maximum number of consecutive restarts, 0 means
+	// there is no limit
+	MaxRestarts int `json:"max-restarts,omitempty"`
+	// Backoff is the delay in seconds before the first restart which is
+	// doubled with every consecutive restart
+	Backoff int `json:"backoff,omitempty"`
+	// MaxBackoff is the maximum delay in seconds between restarts
+	MaxBackoff int `json:"max-backoff,omitempty"`
+}
+
+// GetPolicy returns the respawn policy
+func (r Respawn) GetPolicy() string {
+	if r.Policy == "" {
+		return RespawnNever
+	}
+	return r.Policy
+}
+
+// ShouldRespawn returns true if a process that exited with exitCode should
+// be restarted after it has already been restarted restarts times
+func (r Respawn) ShouldRespawn(exitCode int, restarts int) bool {
+	if r.MaxRestarts > 0 && restarts >= r.MaxRestarts {
+		return false
+	}
+	switch r.GetPolicy() {
+	case RespawnAlways:
+		return true
+	case RespawnOnFailure:
+		return exitCode != 0
+	}
+	return false
+}
+
+// GetBackoff returns the delay before the restart following restarts
+// consecutive restarts
+func (r Respawn) GetBackoff(restarts int) time.Duration {
+	backoff := DefaultRespawnBackoff
+	if r.Backoff > 0 {
+		backoff = time.Duration(r.Backoff) * time.Second
+	}
+	maxBackoff := DefaultRespawnMaxBackoff
+	if r.MaxBackoff > 0 {
+		maxBackoff = time.Duration(r.MaxBackoff) * time.Second
+	}
+	for i := 0; i < restarts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// validate returns an error if the respawn configuration is invalid
+func (r Respawn) validate() error {
+	policy := r.GetPolicy()
+	isValid := false
+	for _, validPolicy := range ValidRespawnPolicies {
+		if policy == validPolicy {
+			isValid = true
+		}
+	}
+	if !isValid {
+		return fmt.Errorf("respawn policy '%s' is not one of %v", policy, ValidRespawnPolicies)
+	}
+	if r.MaxRestarts < 0 || r.Backoff < 0 || r.MaxBackoff < 0 {
+		return fmt.Errorf("respawn configuration cannot have negative values")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultSSHConnectTimeout is the time allowed for connecting to the host
+// when SSH.ConnectTimeout is not specified
+const DefaultSSHConnectTimeout = 10 * time.Second
+
+// SSH defines the host that sessions of a profile are opened on instead of
+// starting Command locally, Command and Arguments then form the command
+// run on the host and the login shell of the user is started when Command
+// is empty
+type SSH struct {
+	// Host is the address of the host in the format `host` or `host:port`,
+	// it may be a template referencing Parameters
+	Host string `json:"host"`
+	// User is the user to log in as, it may be a template referencing
+	// Parameters
+	User string `json:"user"`
+	// IdentityFile is the path to an unencrypted private key to
+	// authenticate with
+	IdentityFile string `json:"identity-file,omitempty"`
+	// Agent authenticates with the keys of the agent listening on the
+	// socket in SSH_AUTH_SOCK
+	Agent bool `json:"agent,omitempty"`
+	// KnownHostsFile is the path to the known_hosts file that the key of
+	// the host is verified against, defaults to ~/.ssh/known_hosts
+	KnownHostsFile string `json:"known-hosts-file,omitempty"`
+	// ConnectTimeout is the time in seconds allowed for connecting to the
+	// host, defaults to 10
+	ConnectTimeout int `json:"connect-timeout,omitempty"`
+}
+
+// GetKnownHostsFile returns the path to the known_hosts file
+func (s SSH) GetKnownHostsFile() (string, error) {
+	if s.KnownHostsFile != "" {
+		return s.KnownHostsFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// GetConnectTimeout returns the time allowed for connecting to the host
+func (s SSH) GetConnectTimeout() time.Duration {
+	if s.ConnectTimeout <= 0 {
+		return DefaultSSHConnectTimeout
+	}
+	return time.Duration(s.ConnectTimeout) * time.Second
+}
+
+// validate returns an error if the ssh configuration is invalid
+func (s SSH) validate() error {
+	if s.Host == "" {
+		return fmt.Errorf("ssh does not specify a host")
+	}
+	if s.User == "" {
+		return fmt.Errorf("ssh does not specify a user")
+	}
+	if s.IdentityFile == "" && !s.Agent {
+		return fmt.Errorf("ssh specifies neither an identity-file nor agent authentication")
+	}
+	if s.ConnectTimeout < 0 {
+		return fmt.Errorf("ssh connect-timeout cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package recording
+
+import (
+	"cloudshell/internal/log"
+	"cloudshell/pkg/auth"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Summary is the publicly visible representation of a recording
+type Summary struct {
+	ID        string    `json:"id"`
+	Profile   string    `json:"profile"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// IsAccessibleBy returns true if principal is allowed to view the
+// recording, recordings without an owner can be viewed by anyone
+func (h Header) IsAccessibleBy(principal *auth.Principal) bool {
+	if h.Owner == "" {
+		return true
+	}
+	return principal != nil && principal.Name == h.Owner
+}
+
+// GetListHandler returns a http handler that responds with a JSON list of
+// the recordings in store that the requesting principal can view
+func GetListHandler(store *Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		headers, err := store.List()
+		if err != nil {
+			log.Warn(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to list recordings"))
+			return
+		}
+		principal := auth.GetPrincipal(r.Context())
+		summaries := []Summary{}
+		for _, header := range headers {
+			if header.IsAccessibleBy(principal) {
+				summaries = append(summaries, Summary{
+					ID:        header.SessionID,
+					Profile:   header.Profile,
+					Owner:     header.Owner,
+					CreatedAt: header.GetTime(),
+				})
+			}
+		}
+		response, err := json.Marshal(summaries)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to list recordings"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
+
+// GetHandler returns a http handler that responds with the recording
+// identified by the `id` route variable in the asciicast v2 format
+func GetHandler(store *Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		header, err := store.Get(id)
+		if err == nil && !header.IsAccessibleBy(auth.GetPrincipal(r.Context())) {
+			err = ErrNotFound
+		}
+		var cast io.ReadCloser
+		if err == nil {
+			cast, err = store.Open(id)
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			message := fmt.Sprintf("failed to get recording '%s'", id)
+			log.Warnf("%s: %s", message, err)
+			w.WriteHeader(status)
+			w.Write([]byte(message))
+			return
+		}
+		defer cast.Close()
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, cast)
+	}
+}
@@ -0,0 +1,151 @@
+package recording
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxIndexLineLength is the length after which a line of output is
+// indexed even if it has not ended
+const maxIndexLineLength = 4096
+
+// stripperState is the state of the escape sequence stripper
+type stripperState int
+
+const (
+	stripGround stripperState = iota
+	stripEscape
+	stripCSI
+	// stripString is used for OSC, DCS, SOS, PM and APC strings
+	stripString
+	stripStringEscape
+)
+
+// stripper removes escape sequences and control characters from terminal
+// output, sequences can be split across writes
+type stripper struct {
+	state stripperState
+	line  []byte
+	// onLineStart is called before the first character of each line
+	onLineStart func()
+	// onLine is called with each line of text
+	onLine func(string)
+}
+
+// newStripper returns a stripper which calls onLineStart when a line of
+// text starts and onLine with each line of text
+func newStripper(onLineStart func(), onLine func(string)) *stripper {
+	return &stripper{onLineStart: onLineStart, onLine: onLine}
+}
+
+// Write implements io.Writer
+func (s *stripper) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.handle(b)
+	}
+	return len(p), nil
+}
+
+// flush ends the current line
+func (s *stripper) flush() {
+	if len(s.line) > 0 {
+		s.onLine(string(s.line))
+		s.line = s.line[:0]
+	}
+}
+
+func (s *stripper) handle(b byte) {
+	switch s.state {
+	case stripEscape:
+		switch {
+		case b == '[':
+			s.state = stripCSI
+		case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+			s.state = stripString
+		case b >= 0x20 && b < 0x30:
+			// intermediate bytes such as those selecting character sets
+		default:
+			s.state = stripGround
+		}
+		return
+	case stripCSI:
+		if b >= 0x40 && b <= 0x7e {
+			s.state = stripGround
+		}
+		return
+	case stripString:
+		switch b {
+		case 0x07:
+			s.state = stripGround
+		case 0x1b:
+			s.state = stripStringEscape
+		}
+		return
+	case stripStringEscape:
+		s.state = stripString
+		if b == '\\' {
+			s.state = stripGround
+		}
+		return
+	}
+	switch {
+	case b == 0x1b:
+		s.state = stripEscape
+	case b == '\n':
+		s.flush()
+	case b == '\b':
+		if _, size := utf8.DecodeLastRune(s.line); size > 0 {
+			s.line = s.line[:len(s.line)-size]
+		}
+	case b == '\t':
+		if len(s.line) == 0 {
+			s.onLineStart()
+		}
+		s.line = append(s.line, ' ')
+	case b < 0x20 || b == 0x7f:
+	default:
+		if len(s.line) == 0 {
+			s.onLineStart()
+		}
+		s.line = append(s.line, b)
+		if len(s.line) >= maxIndexLineLength {
+			s.flush()
+		}
+	}
+}
+
+// indexer writes each line of text in the output of a session together
+// with the time it started to an index in the format "<seconds>\t<text>"
+type indexer struct {
+	writer    io.Writer
+	stripper  *stripper
+	lineStart float64
+	elapsed   float64
+}
+
+func newIndexer(writer io.Writer) *indexer {
+	i := &indexer{writer: writer}
+	i.stripper = newStripper(i.startLine, i.writeLine)
+	return i
+}
+
+func (i *indexer) write(elapsed float64, data []byte) {
+	i.elapsed = elapsed
+	i.stripper.Write(data)
+}
+
+func (i *indexer) startLine() {
+	i.lineStart = i.elapsed
+}
+
+func (i *indexer) writeLine(text string) {
+	if text = strings.TrimSpace(text); text != "" {
+		fmt.Fprintf(i.writer, "%.6f\t%s\n", i.lineStart, text)
+	}
+}
+
+func (i *indexer) flush() {
+	i.stripper.flush()
+}
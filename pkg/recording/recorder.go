@@ -0,0 +1,197 @@
+// Package recording records the output of sessions in the asciicast v2
+// format together with an index of their text for searching
+package recording
+
+import (
+	"cloudshell/pkg/redact"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// castExtension is the extension of recordings
+	castExtension = ".cast"
+	// indexExtension is the extension of the text index of recordings
+	indexExtension = ".idx"
+	// redactIdleDelay is how long output has to stop for before output held
+	// back by redaction up to the current word is recorded
+	redactIdleDelay = 100 * time.Millisecond
+)
+
+// idPattern matches valid recording ids, these are used as file names
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Header is the first line of an asciicast v2 recording, the session
+// fields are additions which players ignore
+type Header struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Command   string `json:"command,omitempty"`
+	Title     string `json:"title,omitempty"`
+	SessionID string `json:"session_id"`
+	Profile   string `json:"profile"`
+	Owner     string `json:"owner,omitempty"`
+}
+
+// GetTime returns the time the recording started
+func (h Header) GetTime() time.Time {
+	return time.Unix(h.Timestamp, 0)
+}
+
+// Recorder writes the output of a session to a recording and its index
+type Recorder struct {
+	cast    *os.File
+	index   *os.File
+	indexer *indexer
+	// pending holds the bytes of a character split across outputs
+	pending []byte
+	// redaction when not nil removes secrets from the output before it is
+	// recorded, idle records the output it holds back once output stops
+	redaction *redact.Stream
+	idle      *time.Timer
+	startedAt time.Time
+	mutex     sync.Mutex
+}
+
+// Create starts a recording in directory for the session described by
+// header, the version and timestamp of header are set by Create, secrets
+// are removed from the output using redactor when it is not nil
+func Create(directory string, header Header, redactor *redact.Redactor) (*Recorder, error) {
+	if !idPattern.MatchString(header.SessionID) {
+		return nil, fmt.Errorf("failed to create recording: invalid session id '%s'", header.SessionID)
+	}
+	startedAt := time.Now()
+	header.Version = 2
+	header.Timestamp = startedAt.Unix()
+	castPath := filepath.Join(directory, header.SessionID+castExtension)
+	cast, err := os.OpenFile(castPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %s", err)
+	}
+	index, err := os.OpenFile(filepath.Join(directory, header.SessionID+indexExtension), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		cast.Close()
+		os.Remove(castPath)
+		return nil, fmt.Errorf("failed to create recording index: %s", err)
+	}
+	recorder := &Recorder{
+		cast:      cast,
+		index:     index,
+		startedAt: startedAt,
+	}
+	recorder.indexer = newIndexer(index)
+	if redactor != nil {
+		recorder.redaction = redactor.NewStream()
+	}
+	if err := recorder.writeLine(header); err != nil {
+		recorder.Close()
+		return nil, err
+	}
+	return recorder, nil
+}
+
+// writeLine writes value as a line of JSON to the recording
+func (r *Recorder) writeLine(value interface{}) error {
+	serialised, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to serialise recording event: %s", err)
+	}
+	if _, err := r.cast.Write(append(serialised, '\n')); err != nil {
+		return fmt.Errorf("failed to write recording: %s", err)
+	}
+	return nil
+}
+
+// elapsed returns the number of seconds since the recording started
+func (r *Recorder) elapsed() float64 {
+	return float64(time.Since(r.startedAt).Microseconds()) / 1e6
+}
+
+// Output records output of the session
+func (r *Recorder) Output(data []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.redaction == nil {
+		return r.output(data)
+	}
+	if r.idle == nil {
+		r.idle = time.AfterFunc(redactIdleDelay, r.onIdle)
+	} else {
+		r.idle.Reset(redactIdleDelay)
+	}
+	return r.output(r.redaction.Write(data))
+}
+
+// onIdle records the output held back by redaction up to the current word
+func (r *Recorder) onIdle() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cast == nil {
+		return
+	}
+	r.output(r.redaction.Idle())
+}
+
+// output records output of the session that was redacted
+func (r *Recorder) output(data []byte) error {
+	data, r.pending = splitIncompleteRune(append(r.pending, data...))
+	if len(data) == 0 {
+		return nil
+	}
+	elapsed := r.elapsed()
+	r.indexer.write(elapsed, data)
+	return r.writeLine([]interface{}{elapsed, "o", string(data)})
+}
+
+// splitIncompleteRune splits data before an incomplete UTF-8 encoded
+// character at its end
+func splitIncompleteRune(data []byte) ([]byte, []byte) {
+	for index := len(data) - 1; index >= 0 && index >= len(data)-utf8.UTFMax; index-- {
+		if !utf8.RuneStart(data[index]) {
+			continue
+		}
+		if utf8.FullRune(data[index:]) {
+			return data, nil
+		}
+		return data[:index], append([]byte{}, data[index:]...)
+	}
+	return data, nil
+}
+
+// Resize records a change to the size of the terminal
+func (r *Recorder) Resize(rows, cols int) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.writeLine([]interface{}{r.elapsed(), "r", fmt.Sprintf("%dx%d", cols, rows)})
+}
+
+// Close ends the recording
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cast == nil {
+		return nil
+	}
+	if r.redaction != nil {
+		if r.idle != nil {
+			r.idle.Stop()
+		}
+		r.output(r.redaction.Flush())
+	}
+	r.indexer.flush()
+	indexErr := r.index.Close()
+	err := r.cast.Close()
+	r.cast = nil
+	if err != nil {
+		return err
+	}
+	return indexErr
+}
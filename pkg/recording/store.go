@@ -0,0 +1,103 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned when a recording does not exist
+var ErrNotFound = errors.New("recording not found")
+
+// Store provides access to the recordings in a directory
+type Store struct {
+	directory string
+}
+
+// NewStore returns a store for the recordings in directory
+func NewStore(directory string) *Store {
+	return &Store{directory: directory}
+}
+
+// Directory returns the directory recordings are stored in
+func (s *Store) Directory() string {
+	return s.directory
+}
+
+// getPath returns the path of the file of recording id with extension
+func (s *Store) getPath(id, extension string) (string, error) {
+	if !idPattern.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.directory, id+extension), nil
+}
+
+// Get returns the header of recording id
+func (s *Store) Get(id string) (Header, error) {
+	cast, err := s.Open(id)
+	if err != nil {
+		return Header{}, err
+	}
+	defer cast.Close()
+	header := Header{}
+	firstLine, err := bufio.NewReader(cast).ReadBytes('\n')
+	if err != nil {
+		return Header{}, fmt.Errorf("failed to read header of recording '%s': %s", id, err)
+	}
+	if err := json.Unmarshal(firstLine, &header); err != nil {
+		return Header{}, fmt.Errorf("failed to parse header of recording '%s': %s", id, err)
+	}
+	return header, nil
+}
+
+// List returns the headers of all recordings from newest to oldest
+func (s *Store) List() ([]Header, error) {
+	files, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recordings: %s", err)
+	}
+	headers := []Header{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), castExtension) {
+			continue
+		}
+		header, err := s.Get(strings.TrimSuffix(file.Name(), castExtension))
+		if err != nil {
+			continue
+		}
+		headers = append(headers, header)
+	}
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].Timestamp > headers[j].Timestamp
+	})
+	return headers, nil
+}
+
+// Open opens recording id for reading
+func (s *Store) Open(id string) (*os.File, error) {
+	return s.open(id, castExtension)
+}
+
+// OpenIndex opens the text index of recording id for reading, each line of
+// the index is in the format "<seconds>\t<text>"
+func (s *Store) OpenIndex(id string) (*os.File, error) {
+	return s.open(id, indexExtension)
+}
+
+func (s *Store) open(id, extension string) (*os.File, error) {
+	filePath, err := s.getPath(id, extension)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
@@ -0,0 +1,26 @@
+package redact
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// LoadFile returns a redactor using the built-in detectors and the
+// patterns in the file at filePath, the file has a pattern on each line
+// and lines that are empty or start with '#' are ignored
+func LoadFile(filePath string) (*Redactor, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction patterns file '%s': %s", filePath, err)
+	}
+	patterns := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return New(patterns)
+}
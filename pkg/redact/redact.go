@@ -0,0 +1,116 @@
+// Package redact removes credentials from terminal output before it is
+// recorded or logged
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Replacement is the text that secrets are replaced with
+const Replacement = "[REDACTED]"
+
+// detector finds a kind of secret, the first capturing group of pattern is
+// redacted when it has one and the whole match otherwise
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// isSecret when specified further checks the text that would be
+	// redacted to reduce false positives of broad patterns
+	isSecret func(string) bool
+}
+
+// builtinDetectors detect common credential formats
+var builtinDetectors = []detector{
+	{name: "aws-access-key-id", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "github-token", pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	{name: "gitlab-token", pattern: regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{name: "slack-token", pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{name: "stripe-key", pattern: regexp.MustCompile(`\b[rs]k_(?:live|test)_[A-Za-z0-9]{16,}\b`)},
+	{name: "google-api-key", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{name: "jwt", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{name: "bearer-token", pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]{16,}=*)`)},
+	{name: "url-password", pattern: regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`)},
+	// assignments such as those printed by env and set in configuration
+	// files, eg. DB_PASSWORD=hunter22 or "api_key": "..."
+	{name: "credential-assignment", pattern: regexp.MustCompile(`(?i)[a-z0-9_.-]*(?:password|passwd|secret|token|api_?key|access_?key|private_?key|credentials?)[a-z0-9_.-]*["']?\s*[=:]\s*["']?([^\s"',;]{6,})`)},
+	// values of the data of kubernetes secrets, eg. `kubectl get secret -o yaml`
+	{name: "base64-value", pattern: regexp.MustCompile(`^\s+[A-Za-z0-9._-]+:\s+([A-Za-z0-9+/]{16,}={0,2})\s*$`), isSecret: isLikelyBase64},
+}
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+)
+
+// isLikelyBase64 returns true if value looks like encoded data rather than
+// a word, encoded data either has padding or mixes digits and letters of
+// both cases
+func isLikelyBase64(value string) bool {
+	if strings.HasSuffix(value, "=") {
+		return true
+	}
+	hasDigit, hasUpper, hasLower := false, false, false
+	for _, r := range value {
+		hasDigit = hasDigit || unicode.IsDigit(r)
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+	}
+	return hasDigit && hasUpper && hasLower
+}
+
+// Redactor replaces secrets detected by the built-in detectors and any
+// additional patterns
+type Redactor struct {
+	detectors []detector
+}
+
+// New returns a redactor using the built-in detectors and patterns, the
+// first capturing group of a pattern is redacted when it has one and the
+// whole match otherwise
+func New(patterns []string) (*Redactor, error) {
+	detectors := append([]detector{}, builtinDetectors...)
+	for index, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redaction pattern '%s': %s", pattern, err)
+		}
+		detectors = append(detectors, detector{name: fmt.Sprintf("pattern-%v", index), pattern: compiled})
+	}
+	return &Redactor{detectors: detectors}, nil
+}
+
+// String returns text with the secrets in it redacted
+func (r *Redactor) String(text string) string {
+	stream := r.NewStream()
+	return string(append(stream.Write([]byte(text)), stream.Flush()...))
+}
+
+// redactLine redacts the secrets in a single line of text
+func (r *Redactor) redactLine(text string) string {
+	for _, d := range r.detectors {
+		matches := d.pattern.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		var redacted strings.Builder
+		last := 0
+		for _, match := range matches {
+			start, end := match[0], match[1]
+			if len(match) >= 4 && match[2] >= 0 {
+				start, end = match[2], match[3]
+			}
+			if start < last || (d.isSecret != nil && !d.isSecret(text[start:end])) {
+				continue
+			}
+			redacted.WriteString(text[last:start])
+			redacted.WriteString(Replacement)
+			last = end
+		}
+		redacted.WriteString(text[last:])
+		text = redacted.String()
+	}
+	return text
+}
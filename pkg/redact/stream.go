@@ -0,0 +1,146 @@
+package redact
+
+import (
+	"bytes"
+	"strings"
+)
+
+// maxPendingBytes is the length of a line after which it is redacted even
+// though it has not ended, secrets split at this point are not detected
+const maxPendingBytes = 8192
+
+// Stream redacts a stream of terminal output that may split secrets
+// across writes, output is held back until the line it is part of ends or
+// returns to its start
+type Stream struct {
+	redactor *Redactor
+	pending  []byte
+	// inPrivateKey is true between the lines beginning and ending a PEM
+	// encoded private key
+	inPrivateKey bool
+	// returnedText is the start of the current line that Idle already
+	// returned and returnedRedacted is how it was returned, the start is
+	// redacted again along with the rest of the line so that secrets whose
+	// context such as `Bearer ` was returned are still detected
+	returnedText     string
+	returnedRedacted string
+	// returnedInPrivateKey is inPrivateKey at the start of the current line
+	returnedInPrivateKey bool
+}
+
+// NewStream returns a stream redacting output using r
+func (r *Redactor) NewStream() *Stream {
+	return &Stream{redactor: r}
+}
+
+// Write adds p to the stream and returns the redacted output of the lines
+// that ended
+func (s *Stream) Write(p []byte) []byte {
+	s.pending = append(s.pending, p...)
+	end := bytes.LastIndexAny(s.pending, "\r\n") + 1
+	if len(s.pending)-end > maxPendingBytes {
+		end = len(s.pending)
+	}
+	return s.take(end)
+}
+
+// Idle returns the redacted pending output up to the last word, it should
+// be called when no output was written for a while so that output such as
+// prompts which do not end lines is not held back indefinitely, the start
+// of a line returned early is taken into account once the line continues
+func (s *Stream) Idle() []byte {
+	end := len(s.pending)
+	for end > 0 && !isBoundary(s.pending[end-1]) {
+		end--
+	}
+	return s.take(end)
+}
+
+// Flush returns all of the pending output redacted
+func (s *Stream) Flush() []byte {
+	return s.take(len(s.pending))
+}
+
+// isBoundary returns true if b cannot be part of a secret
+func isBoundary(b byte) bool {
+	return b <= ' ' || b == 0x7f
+}
+
+// take redacts and removes the first end bytes of the pending output
+func (s *Stream) take(end int) []byte {
+	if end == 0 {
+		return nil
+	}
+	var output strings.Builder
+	text := string(s.pending[:end])
+	for len(text) > 0 {
+		length := strings.IndexAny(text, "\r\n") + 1
+		if length == 0 {
+			length = len(text)
+		}
+		output.WriteString(s.redactSegment(text[:length]))
+		text = text[length:]
+	}
+	s.pending = append(s.pending[:0], s.pending[end:]...)
+	return []byte(output.String())
+}
+
+// redactSegment redacts a line or the start of a line and returns the part
+// that was not returned yet
+func (s *Stream) redactSegment(segment string) string {
+	text, returned := segment, ""
+	if s.returnedText != "" {
+		text, returned = s.returnedText+segment, s.returnedRedacted
+		s.inPrivateKey = s.returnedInPrivateKey
+	} else {
+		s.returnedInPrivateKey = s.inPrivateKey
+	}
+	redacted := s.redactLine(text)
+	var output string
+	if strings.HasPrefix(redacted, returned) {
+		output = redacted[len(returned):]
+	} else {
+		// a secret begins in the part that was returned, the rest of the
+		// line is redacted
+		output = Replacement + segment[len(strings.TrimRight(segment, "\r\n")):]
+	}
+	if strings.HasSuffix(segment, "\r") || strings.HasSuffix(segment, "\n") || len(text) > maxPendingBytes {
+		s.returnedText, s.returnedRedacted = "", ""
+	} else {
+		s.returnedText, s.returnedRedacted = text, returned+output
+	}
+	return output
+}
+
+// redactLine redacts a line or part of a line, lines of private keys are
+// replaced entirely
+func (s *Stream) redactLine(line string) string {
+	if s.inPrivateKey {
+		if location := privateKeyEnd.FindStringIndex(line); location != nil {
+			s.inPrivateKey = false
+			if strings.TrimSpace(line[:location[0]]) == "" {
+				return line
+			}
+			return Replacement + line[location[0]:]
+		}
+		content := strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(content) == "" {
+			return line
+		}
+		return Replacement + line[len(content):]
+	}
+	if location := privateKeyBegin.FindStringIndex(line); location != nil {
+		if privateKeyEnd.FindStringIndex(line[location[1]:]) == nil {
+			s.inPrivateKey = true
+			content := strings.TrimRight(line, "\r\n")
+			redacted := line[:location[1]]
+			if location[1] < len(content) {
+				redacted += Replacement
+			}
+			return s.redactor.redactLine(redacted) + line[len(content):]
+		}
+		end := location[1] + privateKeyEnd.FindStringIndex(line[location[1]:])[0]
+		return s.redactor.redactLine(line[:location[1]]) + Replacement + s.redactor.redactLine(line[end:])
+	}
+	return s.redactor.redactLine(line)
+}
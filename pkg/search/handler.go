@@ -0,0 +1,50 @@
+package search
+
+import (
+	"cloudshell/internal/log"
+	"cloudshell/pkg/auth"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// GetHandler returns a http handler that responds with a JSON list of the
+// results of the query in the `q` query parameter, `regex=true` treats the
+// query as a regular expression and `limit` limits the number of results
+func GetHandler(searcher *Searcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parameters := r.URL.Query()
+		limit := 0
+		if limitParameter := parameters.Get("limit"); limitParameter != "" {
+			var err error
+			if limit, err = strconv.Atoi(limitParameter); err != nil || limit <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("limit '%s' should be a positive integer", limitParameter)))
+				return
+			}
+		}
+		query, err := NewQuery(parameters.Get("q"), parameters.Get("regex") == "true", limit)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		results, err := searcher.Search(query, auth.GetPrincipal(r.Context()))
+		if err != nil {
+			log.Warnf("failed to search: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to search"))
+			return
+		}
+		response, err := json.Marshal(results)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to search"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	}
+}
@@ -0,0 +1,216 @@
+// Package search finds text in the output of live sessions and in the
+// indexes of recordings
+package search
+
+import (
+	"bufio"
+	"cloudshell/pkg/auth"
+	"cloudshell/pkg/recording"
+	"cloudshell/pkg/session"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the maximum number of results returned when no limit
+	// is specified
+	DefaultLimit = 100
+	// SourceLive identifies results found in the screen and scrollback of a
+	// running session
+	SourceLive = "live"
+	// SourceRecording identifies results found in a recording
+	SourceRecording = "recording"
+)
+
+// Result is a line of output that matched a query
+type Result struct {
+	SessionID string    `json:"sessionId"`
+	Profile   string    `json:"profile"`
+	Owner     string    `json:"owner,omitempty"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+	// Offset is the number of seconds into the recording at which the line
+	// was output, it is only set for recordings
+	Offset float64 `json:"offset,omitempty"`
+	Before string  `json:"before,omitempty"`
+	Line   string  `json:"line"`
+	After  string  `json:"after,omitempty"`
+	Link   string  `json:"link"`
+}
+
+// Query describes what to search for
+type Query struct {
+	pattern *regexp.Regexp
+	// Limit is the maximum number of results to return
+	Limit int
+}
+
+// NewQuery returns a case-insensitive query for text, text is treated as a
+// regular expression when isRegexp is true
+func NewQuery(text string, isRegexp bool, limit int) (Query, error) {
+	if text == "" {
+		return Query{}, fmt.Errorf("query cannot be empty")
+	}
+	if !isRegexp {
+		text = regexp.QuoteMeta(text)
+	}
+	pattern, err := regexp.Compile("(?i)" + text)
+	if err != nil {
+		return Query{}, fmt.Errorf("failed to parse query: %s", err)
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	return Query{pattern: pattern, Limit: limit}, nil
+}
+
+// Searcher searches sessions and recordings
+type Searcher struct {
+	// Recordings when specified is the store of recordings to search
+	Recordings *recording.Store
+	// Sessions when specified is the registry of live sessions to search
+	Sessions *session.Registry
+	// PathRecordings is the url path the recordings are served from, it is
+	// used to link results to the playback page
+	PathRecordings string
+}
+
+// Search returns the results of query that principal is allowed to see,
+// results from live sessions are followed by results from recordings
+// from newest to oldest
+func (s *Searcher) Search(query Query, principal *auth.Principal) ([]Result, error) {
+	results := []Result{}
+	if s.Sessions != nil {
+		for _, liveSession := range s.Sessions.List() {
+			if len(results) >= query.Limit {
+				return results, nil
+			}
+			if liveSession.IsAccessibleBy(principal) {
+				results = append(results, s.searchSession(query, liveSession, query.Limit-len(results))...)
+			}
+		}
+	}
+	if s.Recordings == nil {
+		return results, nil
+	}
+	headers, err := s.Recordings.List()
+	if err != nil {
+		return results, err
+	}
+	for _, header := range headers {
+		if len(results) >= query.Limit {
+			break
+		}
+		if !header.IsAccessibleBy(principal) {
+			continue
+		}
+		recordingResults, err := s.searchRecording(query, header, query.Limit-len(results))
+		if errors.Is(err, recording.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return results, err
+		}
+		results = append(results, recordingResults...)
+	}
+	return results, nil
+}
+
+// searchSession returns up to limit matches in the screen and scrollback
+// of liveSession
+func (s *Searcher) searchSession(query Query, liveSession *session.Session, limit int) []Result {
+	results := []Result{}
+	lines := liveSession.Lines()
+	for index, text := range lines {
+		if len(results) >= limit {
+			break
+		}
+		if !query.pattern.MatchString(text) {
+			continue
+		}
+		result := Result{
+			SessionID: liveSession.ID,
+			Profile:   liveSession.Profile.Name,
+			Owner:     liveSession.Owner,
+			Source:    SourceLive,
+			Timestamp: time.Now(),
+			Line:      strings.TrimSpace(text),
+			Link:      "/?" + url.Values{"session": {liveSession.ID}}.Encode(),
+		}
+		if index > 0 {
+			result.Before = strings.TrimSpace(lines[index-1])
+		}
+		if index < len(lines)-1 {
+			result.After = strings.TrimSpace(lines[index+1])
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// searchRecording returns up to limit matches in the index of the
+// recording described by header
+func (s *Searcher) searchRecording(query Query, header recording.Header, limit int) ([]Result, error) {
+	index, err := s.Recordings.OpenIndex(header.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index of recording '%s': %w", header.SessionID, err)
+	}
+	defer index.Close()
+	results := []Result{}
+	previousLine := ""
+	// awaitingAfter is the index of the result that is waiting for the
+	// line after it
+	awaitingAfter := -1
+	scanner := bufio.NewScanner(index)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		offset, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		text := fields[1]
+		if awaitingAfter >= 0 {
+			results[awaitingAfter].After = text
+			awaitingAfter = -1
+		}
+		if len(results) < limit && query.pattern.MatchString(text) {
+			results = append(results, Result{
+				SessionID: header.SessionID,
+				Profile:   header.Profile,
+				Owner:     header.Owner,
+				Source:    SourceRecording,
+				Timestamp: header.GetTime().Add(time.Duration(offset * float64(time.Second))),
+				Offset:    offset,
+				Before:    previousLine,
+				Line:      text,
+				Link:      s.getPlaybackLink(header.SessionID, offset),
+			})
+			awaitingAfter = len(results) - 1
+		} else if len(results) >= limit {
+			break
+		}
+		previousLine = text
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read index of recording '%s': %s", header.SessionID, err)
+	}
+	return results, nil
+}
+
+// getPlaybackLink returns the link to the playback page that starts
+// recording id at offset seconds
+func (s *Searcher) getPlaybackLink(id string, offset float64) string {
+	return "/playback.html?" + url.Values{
+		"src": {path.Join(s.PathRecordings, id)},
+		"t":   {strconv.FormatFloat(offset, 'f', -1, 64)},
+	}.Encode()
+}
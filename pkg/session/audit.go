@@ -0,0 +1,182 @@
+package session
+
+import (
+	"bytes"
+	"cloudshell/internal/constants"
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/policy"
+	"cloudshell/pkg/redact"
+	"time"
+)
+
+// commandAuditor reports the commands executed in a session to an audit
+// sink and applies the command policy to them, commands are taken from
+// shell integration marks when the shell emits them and are otherwise
+// reconstructed from the input
+type commandAuditor struct {
+	session *Session
+	// sink when not nil receives the audit events
+	sink   audit.Sink
+	editor audit.LineEditor
+	// policy when not nil decides whether lines may be submitted
+	policy *policy.Policy
+	// confirmation is the confirmation being typed when a line required it
+	confirmation *confirmation
+	// redactor when not nil removes secrets from the commands
+	redactor *redact.Redactor
+	// shellIntegration is true once the shell emitted a mark, input is
+	// then only used when a mark lacks the command
+	shellIntegration bool
+	// lastLine is the last line submitted while the shell was reading input
+	lastLine *audit.Line
+	// hasOutputSinceLine is false when no output was received since the
+	// last line was submitted, lines submitted in the meantime were typed
+	// ahead and may be read by the previous command instead of the shell
+	hasOutputSinceLine bool
+}
+
+// newCommandAuditor returns an auditor for session using the audit sink,
+// policy and redactor of opts, shellIntegration is true when the shell is
+// known to emit shell integration marks
+func newCommandAuditor(session *Session, opts Options, shellIntegration bool) *commandAuditor {
+	return &commandAuditor{
+		session:            session,
+		sink:               opts.Audit,
+		policy:             opts.Policy,
+		redactor:           opts.Redactor,
+		shellIntegration:   shellIntegration,
+		hasOutputSinceLine: true,
+	}
+}
+
+// input processes input sent to the session and returns the input to send
+// to the tty, it is called with the lock of the session held
+func (a *commandAuditor) input(p []byte) []byte {
+	if a.confirmation == nil && a.session.screen.IsAlternateScreen() {
+		// keys sent to full screen programs are not commands
+		a.editor.Reset()
+		return p
+	}
+	forwarded := make([]byte, 0, len(p))
+	for len(p) > 0 {
+		if a.confirmation != nil {
+			consumed, confirmed := a.confirm(p)
+			forwarded = append(forwarded, confirmed...)
+			p = p[consumed:]
+			continue
+		}
+		end := bytes.IndexAny(p, "\r\n")
+		if end < 0 {
+			a.submitted(a.editor.Write(p))
+			forwarded = append(forwarded, p...)
+			break
+		}
+		a.submitted(a.editor.Write(p[:end]))
+		forwarded = append(forwarded, p[:end]...)
+		forwarded = append(forwarded, a.enforce(p[end:end+1])...)
+		p = p[end+1:]
+		if a.confirmation != nil {
+			// the confirmation has to be typed after it was asked for, the
+			// input sent along with the line is discarded except for the
+			// end of a paste that the shell waits for
+			if bytes.Contains(p, constants.KeySeqPasteEnd) {
+				forwarded = append(forwarded, constants.KeySeqPasteEnd...)
+			}
+			break
+		}
+	}
+	return forwarded
+}
+
+// submitted processes the lines submitted to the shell
+func (a *commandAuditor) submitted(lines []audit.Line) {
+	for _, line := range lines {
+		if !a.session.tty.IsShellInForeground() {
+			continue
+		}
+		if !a.hasOutputSinceLine {
+			line.Approximate = true
+		}
+		a.hasOutputSinceLine = false
+		if a.shellIntegration {
+			submitted := line
+			a.lastLine = &submitted
+			continue
+		}
+		a.write(audit.Event{
+			Type:        audit.EventTypeCommand,
+			Cwd:         a.session.workingDirectory(),
+			Command:     line.Text,
+			Source:      audit.SourceInput,
+			Approximate: line.Approximate,
+		})
+	}
+}
+
+// output notes that output was received, it is called with the lock of
+// the session held
+func (a *commandAuditor) output() {
+	a.hasOutputSinceLine = true
+}
+
+// mark notes that the shell emits shell integration marks, it is called
+// with the lock of the session held
+func (a *commandAuditor) mark() {
+	a.shellIntegration = true
+}
+
+// lastCommand returns the last line submitted to the shell and whether it
+// is approximate, it is used when the command of a mark is not known
+func (a *commandAuditor) lastCommand() (string, bool) {
+	if a.lastLine == nil {
+		return "", false
+	}
+	line := a.lastLine
+	a.lastLine = nil
+	return line.Text, line.Approximate
+}
+
+// executed reports a command that the shell started executing
+func (a *commandAuditor) executed(command Command, fromShell, approximate bool) {
+	a.lastLine = nil
+	source := audit.SourceInput
+	if fromShell {
+		source = audit.SourceShell
+	}
+	a.write(audit.Event{
+		Type:        audit.EventTypeCommand,
+		Cwd:         command.Cwd,
+		Command:     command.Command,
+		Source:      source,
+		Approximate: approximate,
+	})
+}
+
+// finished reports a command that finished executing
+func (a *commandAuditor) finished(command Command) {
+	a.write(audit.Event{
+		Type:     audit.EventTypeCommandFinished,
+		Cwd:      command.Cwd,
+		Command:  command.Command,
+		Source:   audit.SourceShell,
+		ExitCode: command.ExitCode,
+		Duration: command.Duration,
+	})
+}
+
+func (a *commandAuditor) write(event audit.Event) {
+	if a.sink == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.User = a.session.Owner
+	event.SessionID = a.session.ID
+	event.Profile = a.session.Profile.Name
+	if a.redactor != nil {
+		event.Command = a.redactor.String(event.Command)
+		event.Content = a.redactor.String(event.Content)
+	}
+	if err := a.sink.Write(event); err != nil {
+		a.session.log.Warnf("failed to write audit event: %s", err)
+	}
+}
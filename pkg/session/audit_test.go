@@ -0,0 +1,170 @@
+package session
+
+import (
+	"bytes"
+	"cloudshell/internal/log"
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/clipboard"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/vt"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// testBackend is a backend without processes that records the input sent
+// to it
+type testBackend struct {
+	input bytes.Buffer
+	// shellInForeground is returned by IsShellInForeground
+	shellInForeground bool
+	mutex             sync.Mutex
+}
+
+func (b *testBackend) Read(p []byte) (int, error) { return 0, io.EOF }
+func (b *testBackend) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.input.Write(p)
+}
+func (b *testBackend) Start() error                   { return nil }
+func (b *testBackend) Wait() int                      { return 0 }
+func (b *testBackend) Release() error                 { return nil }
+func (b *testBackend) Resize(rows, cols uint16) error { return nil }
+func (b *testBackend) Kill() error                    { return nil }
+func (b *testBackend) Signal(syscall.Signal) error    { return nil }
+func (b *testBackend) IsShellInForeground() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.shellInForeground
+}
+func (b *testBackend) WorkingDirectory() string { return "/home/alice" }
+func (b *testBackend) Credential() (*syscall.Credential, error) {
+	return nil, errors.New("no credential")
+}
+func (b *testBackend) Dial(ctx context.Context, port int) (net.Conn, error) {
+	return nil, errors.New("no network")
+}
+func (b *testBackend) Close() error { return nil }
+
+// sentInput returns the input that reached the backend and forgets it
+func (b *testBackend) sentInput() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	input := b.input.String()
+	b.input.Reset()
+	return input
+}
+
+// testSink collects audit events
+type testSink struct {
+	events []audit.Event
+}
+
+func (s *testSink) Write(event audit.Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+// newTestAuditedSession returns a session on a test backend whose input
+// is audited using the sink and policy of opts, no goroutines are started
+// so output is only processed when passed to broadcast
+func newTestAuditedSession(opts Options) (*Session, *testBackend) {
+	tty := &testBackend{shellInForeground: true}
+	selectedProfile := profile.Profile{Name: "default", Command: "/bin/sh"}
+	session := &Session{
+		ID:          "test-session",
+		Profile:     selectedProfile,
+		Owner:       "alice",
+		clipboard:   clipboard.NewFilter(selectedProfile.Clipboard.GetMaxSizeBytes()),
+		done:        make(chan struct{}),
+		exitCode:    -1,
+		log:         log.WithField("session_id", "test-session"),
+		screen:      vt.New(vt.DefaultRows, vt.DefaultCols, DefaultScrollbackLines),
+		stop:        make(chan struct{}),
+		subscribers: map[*Subscription]struct{}{},
+		tty:         tty,
+	}
+	session.auditor = newCommandAuditor(session, opts, false)
+	session.screen.OnMark(session.mark)
+	return session, tty
+}
+
+// commands returns the commands of the command events of sink and whether
+// they are approximate
+func commands(sink *testSink) []audit.Line {
+	lines := []audit.Line{}
+	for _, event := range sink.events {
+		if event.Type == audit.EventTypeCommand {
+			lines = append(lines, audit.Line{Text: event.Command, Approximate: event.Approximate})
+		}
+	}
+	return lines
+}
+
+func TestCommandAuditorInput(t *testing.T) {
+	tests := []struct {
+		name string
+		// writes are sent to the session, each followed by output from the
+		// shell unless typedAhead is true
+		writes     []string
+		typedAhead bool
+		commands   []audit.Line
+	}{
+		{"typed", []string{"l", "s", " /tmp", "\r"}, false, []audit.Line{{Text: "ls /tmp"}}},
+		{"edited", []string{"rm -rf /tmp\x17\x17\x17", "ls\x1b[D\x1b[D", "echo \x1b[F\r"}, false, []audit.Line{{Text: "echo ls"}}},
+		{"ctrl-u", []string{"rm -rf /\x15", "pwd\r"}, false, []audit.Line{{Text: "pwd"}}},
+		{"bracketed paste", []string{"\x1b[200~echo one\recho two\r\x1b[201~"}, false, []audit.Line{{Text: "echo one"}, {Text: "echo two", Approximate: true}}},
+		{"enter split from line", []string{"ls", "\r", "pwd", "\n"}, false, []audit.Line{{Text: "ls"}, {Text: "pwd"}}},
+		{"typed ahead", []string{"sleep 1\r", "ls\r"}, true, []audit.Line{{Text: "sleep 1"}, {Text: "ls", Approximate: true}}},
+		{"history", []string{"ls\r", "\x1b[A\r"}, false, []audit.Line{{Text: "ls"}, {Text: "ls", Approximate: true}}},
+		{"tab completion", []string{"cat /etc/pass\t", "wd\r"}, false, []audit.Line{{Text: "cat /etc/passwd", Approximate: true}}},
+	}
+	for _, test := range tests {
+		sink := &testSink{}
+		session, tty := newTestAuditedSession(Options{Audit: sink})
+		sent := ""
+		for _, write := range test.writes {
+			if _, err := session.Write([]byte(write)); err != nil {
+				t.Fatalf("%s: failed to write: %s", test.name, err)
+			}
+			sent += write
+			if !test.typedAhead {
+				session.broadcast([]byte("output"))
+			}
+		}
+		if !reflect.DeepEqual(commands(sink), test.commands) {
+			t.Errorf("%s: expected commands %+v, got %+v", test.name, test.commands, commands(sink))
+		}
+		if input := tty.sentInput(); input != sent {
+			t.Errorf("%s: expected the input %q to be sent as is, got %q", test.name, sent, input)
+		}
+		for _, event := range sink.events {
+			if event.User != "alice" || event.SessionID != "test-session" || event.Profile != "default" || event.Cwd != "/home/alice" || event.Source != audit.SourceInput {
+				t.Errorf("%s: expected the event to describe the session, got %+v", test.name, event)
+			}
+		}
+	}
+}
+
+func TestCommandAuditorIgnoresInputToPrograms(t *testing.T) {
+	sink := &testSink{}
+	session, tty := newTestAuditedSession(Options{Audit: sink})
+	// keys sent to full screen programs are not commands
+	session.broadcast([]byte("\x1b[?1049h"))
+	session.Write([]byte(":wq\r"))
+	session.broadcast([]byte("\x1b[?1049l"))
+	// lines read by programs other than the shell are not commands
+	tty.shellInForeground = false
+	session.Write([]byte("password\r"))
+	tty.shellInForeground = true
+	session.Write([]byte("ls\r"))
+	if expected := []audit.Line{{Text: "ls"}}; !reflect.DeepEqual(commands(sink), expected) {
+		t.Errorf("expected commands %+v, got %+v", expected, commands(sink))
+	}
+}
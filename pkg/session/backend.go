@@ -0,0 +1,60 @@
+package session
+
+import (
+	"cloudshell/pkg/profile"
+	"context"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Backend runs the processes of a session on a terminal, reads return the
+// output of the processes and writes send input to them, processes run on
+// a local pty, on ssh hosts, in containers or in pods depending on the
+// profile
+type Backend interface {
+	io.ReadWriter
+	// Start starts a new process on the terminal
+	Start() error
+	// Wait waits for the current process to exit and returns its exit code
+	Wait() int
+	// Release makes reads return an error once the remaining output has
+	// been read, no further processes can be started after this
+	Release() error
+	// Resize sets the window size of the terminal
+	Resize(rows, cols uint16) error
+	// Kill terminates the current process if it is still running
+	Kill() error
+	// Signal delivers sig to the foreground process of the terminal
+	Signal(sig syscall.Signal) error
+	// IsShellInForeground returns true when input is read by the process
+	// that was started rather than by a program it started
+	IsShellInForeground() bool
+	// WorkingDirectory returns the working directory of the process the
+	// user interacts with, an empty string is returned when it cannot be
+	// determined
+	WorkingDirectory() string
+	// Credential returns the credential of the process the user interacts
+	// with, which local files are accessed with
+	Credential() (*syscall.Credential, error)
+	// Dial connects to port on localhost in the network that the processes
+	// run in
+	Dial(ctx context.Context, port int) (net.Conn, error)
+	// Close releases all resources held by the backend
+	Close() error
+}
+
+// NewBackend returns the backend running the processes of selectedProfile
+// in the session identified by sessionID
+func NewBackend(sessionID string, selectedProfile profile.Profile) (Backend, error) {
+	if selectedProfile.SSH != nil {
+		return newSSHTerminal(sessionID, selectedProfile), nil
+	}
+	if selectedProfile.Container != nil {
+		return newContainerTerminal(sessionID, selectedProfile), nil
+	}
+	if selectedProfile.Kubernetes != nil {
+		return newPodTerminal(sessionID, selectedProfile), nil
+	}
+	return newTerminal(sessionID, selectedProfile)
+}
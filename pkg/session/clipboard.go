@@ -0,0 +1,39 @@
+package session
+
+import (
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/clipboard"
+	"fmt"
+)
+
+// copied forwards content that a program copied to the clipboard to the
+// subscribers according to the clipboard settings of the profile, it is
+// called with the lock of the session held
+func (s *Session) copied(c clipboard.Copy) {
+	settings := s.Profile.Clipboard
+	decision := audit.DecisionCopied
+	switch {
+	case settings.Disabled:
+		decision = audit.DecisionDiscarded
+	case c.TooLarge:
+		decision = audit.DecisionTooLarge
+		s.notice(noticeWarning, fmt.Sprintf("discarded %v bytes copied to the clipboard, the limit is %v bytes", c.Size, settings.GetMaxSizeBytes()), true)
+	default:
+		for subscription := range s.subscribers {
+			if !subscription.copy(c) {
+				s.log.Warn("discarding clipboard content for subscription that is not keeping up")
+			}
+		}
+	}
+	s.log.Debugf("program copied %v bytes to the clipboard (%s)", c.Size, decision)
+	if settings.Audit && s.auditor != nil {
+		size := c.Size
+		s.auditor.write(audit.Event{
+			Type:     audit.EventTypeClipboard,
+			Cwd:      s.workingDirectory(),
+			Decision: decision,
+			Size:     &size,
+			Content:  string(c.Data),
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"cloudshell/pkg/vt"
+	"time"
+)
+
+// commandHistoryLength is the number of commands of a session that are kept
+const commandHistoryLength = 100
+
+// Command is a command executed in a session as reported by the shell
+// integration marks of the shell
+type Command struct {
+	Command    string     `json:"command"`
+	Cwd        string     `json:"cwd,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Duration is the number of seconds the command ran for
+	Duration *float64 `json:"duration,omitempty"`
+	ExitCode *int     `json:"exitCode,omitempty"`
+}
+
+// IsRunning returns true if the command has not finished
+func (c Command) IsRunning() bool {
+	return c.FinishedAt == nil
+}
+
+// Commands returns the most recent commands executed in the session from
+// oldest to newest, commands are only known when the shell emits shell
+// integration marks
+func (s *Session) Commands() []Command {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Command{}, s.commands...)
+}
+
+// WorkingDirectory returns the working directory of the shell as reported
+// using OSC 7, or as read from the process when it is not reported
+func (s *Session) WorkingDirectory() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.workingDirectory()
+}
+
+func (s *Session) workingDirectory() string {
+	if cwd := s.screen.WorkingDirectory(); cwd != "" {
+		return cwd
+	}
+	return s.tty.WorkingDirectory()
+}
+
+// mark processes the shell integration marks in the output, it is called
+// with the lock of the session held
+func (s *Session) mark(mark vt.Mark) {
+	if s.auditor != nil {
+		s.auditor.mark()
+	}
+	switch mark.Kind {
+	case 'C':
+		command := Command{
+			Command:   mark.Command,
+			Cwd:       s.workingDirectory(),
+			StartedAt: time.Now(),
+		}
+		approximate := false
+		if command.Command == "" && s.auditor != nil {
+			command.Command, approximate = s.auditor.lastCommand()
+		}
+		s.commands = append(s.commands, command)
+		if len(s.commands) > commandHistoryLength {
+			s.commands = s.commands[len(s.commands)-commandHistoryLength:]
+		}
+		if s.auditor != nil && command.Command != "" {
+			s.auditor.executed(command, mark.Command != "", approximate)
+		}
+	case 'D':
+		if len(s.commands) == 0 || !s.commands[len(s.commands)-1].IsRunning() {
+			// the prompt was shown again without running a command
+			return
+		}
+		command := &s.commands[len(s.commands)-1]
+		finishedAt := time.Now()
+		duration := finishedAt.Sub(command.StartedAt).Seconds()
+		command.FinishedAt = &finishedAt
+		command.Duration = &duration
+		command.ExitCode = mark.ExitCode
+		if s.auditor != nil && command.Command != "" {
+			s.auditor.finished(*command)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package session
+
+import (
+	"cloudshell/pkg/docker"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/vt"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// execExitPollInterval is the interval between checks of whether a
+	// process has exited after its output ended
+	execExitPollInterval = 50 * time.Millisecond
+	// execExitPollAttempts is the number of checks of whether a process
+	// has exited before its exit code is considered unknown
+	execExitPollAttempts = 40
+	// killTimeout is the time allowed for killing the processes of a
+	// session in its container
+	killTimeout = 10 * time.Second
+)
+
+// killScript kills the processes in a container whose environment contains
+// the variable passed as $1, which identifies the session that started
+// them; the pids reported by the exec api are those of the host and cannot
+// be used in the container
+const killScript = `for p in /proc/[0-9]*; do tr '\0' '\n' 2>/dev/null < "$p/environ" | grep -qxF "$1" && kill -KILL "${p#/proc/}"; done; true`
+
+// controlCharacters are written to the tty of processes in containers to
+// deliver the signals that the tty generates for them, the exec api
+// cannot signal processes
+var controlCharacters = map[syscall.Signal][]byte{
+	syscall.SIGINT:  {0x03},
+	syscall.SIGQUIT: {0x1c},
+	syscall.SIGTSTP: {0x1a},
+}
+
+// containerTerminal is a tty in a running container that processes for a
+// profile are (re)started on through the exec api of the Docker Engine
+type containerTerminal struct {
+	profile profile.Profile
+	client  *docker.Client
+	// sessionID identifies the session to processes through the
+	// CLOUDSHELL_SESSION_ID environment variable
+	sessionID string
+	// output is read from by the session and written to by the processes,
+	// it is kept open across processes like the tty of local terminals
+	output       *io.PipeReader
+	outputWriter *io.PipeWriter
+	// execID identifies the current process in the api
+	execID     string
+	connection net.Conn
+	// copied is closed once the output of the current process ended
+	copied     chan struct{}
+	rows, cols uint16
+	released   bool
+	mutex      sync.Mutex
+}
+
+// newContainerTerminal returns a terminal for processes of selectedProfile
+// in its container in the session identified by sessionID
+func newContainerTerminal(sessionID string, selectedProfile profile.Profile) *containerTerminal {
+	output, outputWriter := io.Pipe()
+	return &containerTerminal{
+		profile:      selectedProfile,
+		client:       docker.NewClient(selectedProfile.Container.GetSocket()),
+		sessionID:    sessionID,
+		output:       output,
+		outputWriter: outputWriter,
+		rows:         vt.DefaultRows,
+		cols:         vt.DefaultCols,
+	}
+}
+
+// Start starts a new process of the profile in the container
+func (t *containerTerminal) Start() error {
+	t.mutex.Lock()
+	if t.released {
+		t.mutex.Unlock()
+		return errors.New("failed to start process: tty has been released")
+	}
+	rows, cols := t.rows, t.cols
+	t.mutex.Unlock()
+	env := []string{"TERM=xterm-256color"}
+	keys := make([]string, 0, len(t.profile.Env))
+	for key := range t.profile.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", key, t.profile.Env[key]))
+	}
+	env = append(env, SessionIDEnvironmentVariable+"="+t.sessionID)
+	execID, err := t.client.CreateExec(t.profile.Container.Name, docker.ExecConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Cmd:          append([]string{t.profile.Command}, t.profile.Arguments...),
+		Env:          env,
+		User:         t.profile.Container.User,
+		WorkingDir:   t.profile.Workdir,
+	})
+	if err != nil {
+		return err
+	}
+	connection, err := t.client.StartExec(execID)
+	if err != nil {
+		return err
+	}
+	// the tty of the process only exists once it was started
+	if err := t.client.ResizeExec(execID, rows, cols); err != nil {
+		t.killProcesses()
+		connection.Close()
+		return err
+	}
+	t.mutex.Lock()
+	if t.released {
+		t.mutex.Unlock()
+		t.killProcesses()
+		connection.Close()
+		return errors.New("failed to start process: tty has been released")
+	}
+	defer t.mutex.Unlock()
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		io.Copy(t.outputWriter, connection)
+	}()
+	t.execID = execID
+	t.connection = connection
+	t.copied = copied
+	// the window may have been resized while the process was started
+	if t.rows != rows || t.cols != cols {
+		t.client.ResizeExec(execID, t.rows, t.cols)
+	}
+	return nil
+}
+
+// Wait waits for the current process to exit and returns its exit code,
+// -1 is returned when the exit code cannot be determined
+func (t *containerTerminal) Wait() int {
+	t.mutex.Lock()
+	execID, connection, copied := t.execID, t.connection, t.copied
+	t.mutex.Unlock()
+	if connection == nil {
+		return -1
+	}
+	<-copied
+	connection.Close()
+	// the output may end shortly before the exit of the process is recorded
+	for attempt := 0; attempt < execExitPollAttempts; attempt++ {
+		state, err := t.client.InspectExec(execID)
+		if err != nil {
+			return -1
+		}
+		if !state.Running {
+			return state.ExitCode
+		}
+		time.Sleep(execExitPollInterval)
+	}
+	return -1
+}
+
+// Release closes the output so that reads return io.EOF once the
+// remaining output has been read; no further processes can be started
+// after this
+func (t *containerTerminal) Release() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.released = true
+	return t.outputWriter.Close()
+}
+
+// Read implements io.Reader by reading the output of the process
+func (t *containerTerminal) Read(p []byte) (int, error) {
+	return t.output.Read(p)
+}
+
+// Write implements io.Writer by writing input to the process
+func (t *containerTerminal) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	connection := t.connection
+	t.mutex.Unlock()
+	if connection == nil {
+		return 0, errors.New("failed to write input: no process has been started")
+	}
+	return connection.Write(p)
+}
+
+// Resize sets the window size of the tty of the process
+func (t *containerTerminal) Resize(rows, cols uint16) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rows, t.cols = rows, cols
+	if t.execID == "" {
+		return nil
+	}
+	return t.client.ResizeExec(t.execID, rows, cols)
+}
+
+// Kill ends the current process and the processes it started, which
+// inherit the session id in their environment, by killing them with a
+// short lived process in the container as the exec api cannot signal
+// processes, this requires `sh` in the container; the connection is closed
+// afterwards which ends the input of processes that could not be killed
+func (t *containerTerminal) Kill() error {
+	t.mutex.Lock()
+	execID, connection := t.execID, t.connection
+	t.mutex.Unlock()
+	if connection == nil {
+		return nil
+	}
+	var err error
+	if state, inspectErr := t.client.InspectExec(execID); inspectErr != nil {
+		err = inspectErr
+	} else if state.Running {
+		err = t.killProcesses()
+	}
+	if closeErr := connection.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// killProcesses kills the processes of the session in the container and
+// waits for the process doing so to exit
+func (t *containerTerminal) killProcesses() error {
+	execID, err := t.client.CreateExec(t.profile.Container.Name, docker.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Cmd:          []string{"sh", "-c", killScript, "sh", SessionIDEnvironmentVariable + "=" + t.sessionID},
+		User:         t.profile.Container.User,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to kill processes: %s", err)
+	}
+	connection, err := t.client.StartExec(execID)
+	if err != nil {
+		return fmt.Errorf("failed to kill processes: %s", err)
+	}
+	defer connection.Close()
+	// the output ends when the process exits
+	connection.SetDeadline(time.Now().Add(killTimeout))
+	if _, err := io.Copy(ioutil.Discard, connection); err != nil {
+		return fmt.Errorf("failed to kill processes: %s", err)
+	}
+	return nil
+}
+
+// Signal delivers the signals that the tty generates for control
+// characters, SIGINT, SIGQUIT and SIGTSTP, by writing the character to the
+// tty, other signals cannot be delivered through the exec api
+func (t *containerTerminal) Signal(sig syscall.Signal) error {
+	character, ok := controlCharacters[sig]
+	if !ok {
+		return fmt.Errorf("failed to signal process: %s cannot be delivered to processes in containers", unix.SignalName(sig))
+	}
+	_, err := t.Write(character)
+	return err
+}
+
+// IsShellInForeground returns true as the processes of the container
+// cannot be inspected, input is treated as if it was read by the shell
+func (t *containerTerminal) IsShellInForeground() bool {
+	return true
+}
+
+// WorkingDirectory returns an empty string as the processes of the
+// container cannot be inspected
+func (t *containerTerminal) WorkingDirectory() string {
+	return ""
+}
+
+// Credential returns an error as the files of the container cannot be
+// accessed locally
+func (t *containerTerminal) Credential() (*syscall.Credential, error) {
+	return nil, errors.New("failed to get credential: the files of sessions in containers cannot be accessed")
+}
+
+// Dial connects to port on the address of the container, or on localhost
+// when the container uses the network of the host
+func (t *containerTerminal) Dial(ctx context.Context, port int) (net.Conn, error) {
+	address, err := t.client.ContainerAddress(t.profile.Container.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial port: %s", err)
+	}
+	if address == "" {
+		address = "localhost"
+	}
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+}
+
+// Close releases all resources held by the terminal
+func (t *containerTerminal) Close() error {
+	t.Release()
+	return t.Kill()
+}
@@ -0,0 +1,240 @@
+package session
+
+import (
+	"cloudshell/pkg/docker"
+	"cloudshell/pkg/profile"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDockerAPI is a fake Docker Engine API whose processes write a prompt
+// and run until they are killed by a kill exec, which exits immediately
+type testDockerAPI struct {
+	execs   []docker.ExecConfig
+	running map[string]bool
+	resizes []string
+	// creating, when set, receives every request creating an exec, which
+	// waits until it is closed
+	creating chan chan struct{}
+	mutex    sync.Mutex
+}
+
+func newTestDockerAPI(t *testing.T) (*testDockerAPI, string) {
+	t.Helper()
+	api := &testDockerAPI{running: map[string]bool{}}
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(api)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return api, socket
+}
+
+func (a *testDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.creating != nil && strings.HasSuffix(r.URL.Path, "/exec") {
+		created := make(chan struct{})
+		a.creating <- created
+		<-created
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1.40/"), "/")
+	switch {
+	case len(segments) == 3 && segments[0] == "containers" && segments[2] == "exec":
+		config := docker.ExecConfig{}
+		json.NewDecoder(r.Body).Decode(&config)
+		a.execs = append(a.execs, config)
+		id := fmt.Sprintf("exec-%d", len(a.execs))
+		a.running[id] = true
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"Id": "%s"}`, id)
+	case len(segments) == 3 && segments[2] == "start":
+		id := segments[1]
+		index := 0
+		fmt.Sscanf(id, "exec-%d", &index)
+		config := a.execs[index-1]
+		connection, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		io.WriteString(connection, "HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		if config.Cmd[0] == "sh" {
+			// the kill exec ends every process of the session
+			for running := range a.running {
+				a.running[running] = false
+			}
+			connection.Close()
+			return
+		}
+		io.WriteString(connection, "$ ")
+		go func() {
+			defer connection.Close()
+			io.Copy(io.Discard, connection)
+			a.mutex.Lock()
+			defer a.mutex.Unlock()
+			a.running[id] = false
+		}()
+	case len(segments) == 3 && segments[2] == "resize":
+		a.resizes = append(a.resizes, segments[1]+" "+r.URL.RawQuery)
+	case len(segments) == 3 && segments[2] == "json":
+		fmt.Fprintf(w, `{"Running": %v, "ExitCode": 137}`, a.running[segments[1]])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestContainerTerminal(socket string) *containerTerminal {
+	return newContainerTerminal("test-session", profile.Profile{
+		Command:   "bash",
+		Arguments: []string{"-l"},
+		Env:       map[string]string{"GREETING": "hello"},
+		Container: &profile.Container{Name: "dev", User: "dev", Socket: socket},
+	})
+}
+
+func TestContainerTerminalStart(t *testing.T) {
+	api, socket := newTestDockerAPI(t)
+	tty := newTestContainerTerminal(socket)
+	defer tty.Close()
+	if err := tty.Start(); err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	prompt := make([]byte, 2)
+	if _, err := io.ReadFull(tty, prompt); err != nil || string(prompt) != "$ " {
+		t.Fatalf("expected the output of the process, got '%s' (%v)", prompt, err)
+	}
+	if err := tty.Resize(40, 120); err != nil {
+		t.Fatalf("failed to resize: %s", err)
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	config := api.execs[0]
+	if strings.Join(config.Cmd, " ") != "bash -l" || config.User != "dev" || !config.Tty {
+		t.Errorf("unexpected exec %+v", config)
+	}
+	expectedEnv := "TERM=xterm-256color GREETING=hello " + SessionIDEnvironmentVariable + "=test-session"
+	if strings.Join(config.Env, " ") != expectedEnv {
+		t.Errorf("expected environment '%s', got '%s'", expectedEnv, strings.Join(config.Env, " "))
+	}
+	expectedResizes := "exec-1 h=24&w=80,exec-1 h=40&w=120"
+	if strings.Join(api.resizes, ",") != expectedResizes {
+		t.Errorf("expected resizes '%s', got '%s'", expectedResizes, strings.Join(api.resizes, ","))
+	}
+}
+
+func TestContainerTerminalKill(t *testing.T) {
+	api, socket := newTestDockerAPI(t)
+	tty := newTestContainerTerminal(socket)
+	defer tty.Close()
+	if err := tty.Start(); err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	// the output is a pipe that has to be read for the process to exit
+	go io.Copy(io.Discard, tty)
+	if err := tty.Kill(); err != nil {
+		t.Fatalf("failed to kill process: %s", err)
+	}
+	if exitCode := tty.Wait(); exitCode != 137 {
+		t.Errorf("expected exit code 137, got %d", exitCode)
+	}
+	api.mutex.Lock()
+	if len(api.execs) != 2 {
+		t.Fatalf("expected a kill exec, got %+v", api.execs)
+	}
+	kill := api.execs[1]
+	expectedCommand := []string{"sh", "-c", killScript, "sh", SessionIDEnvironmentVariable + "=test-session"}
+	if strings.Join(kill.Cmd, "\x00") != strings.Join(expectedCommand, "\x00") || kill.User != "dev" {
+		t.Errorf("unexpected kill exec %+v", kill)
+	}
+	api.mutex.Unlock()
+	// processes that already exited are not killed again
+	if err := tty.Kill(); err != nil {
+		t.Fatalf("failed to kill process: %s", err)
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	if len(api.execs) != 2 {
+		t.Errorf("expected no further exec, got %+v", api.execs)
+	}
+}
+
+// releaseCreations lets the requests creating execs that follow proceed
+// without waiting
+func releaseCreations(api *testDockerAPI) {
+	for created := range api.creating {
+		close(created)
+	}
+}
+
+func TestContainerTerminalStartDoesNotBlock(t *testing.T) {
+	api, socket := newTestDockerAPI(t)
+	api.creating = make(chan chan struct{})
+	tty := newTestContainerTerminal(socket)
+	defer tty.Close()
+	started := make(chan error, 1)
+	go func() { started <- tty.Start() }()
+	created := <-api.creating
+	// the terminal can be used while the api is slow to start the process
+	resized := make(chan error, 1)
+	go func() { resized <- tty.Resize(40, 120) }()
+	select {
+	case err := <-resized:
+		if err != nil {
+			t.Fatalf("failed to resize: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected resizing not to wait for the process to start")
+	}
+	close(created)
+	go releaseCreations(api)
+	if err := <-started; err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	// the window size set while starting is applied once the process runs
+	expectedResizes := "exec-1 h=24&w=80,exec-1 h=40&w=120"
+	if strings.Join(api.resizes, ",") != expectedResizes {
+		t.Errorf("expected resizes '%s', got '%s'", expectedResizes, strings.Join(api.resizes, ","))
+	}
+}
+
+func TestContainerTerminalReleasedWhileStarting(t *testing.T) {
+	api, socket := newTestDockerAPI(t)
+	api.creating = make(chan chan struct{})
+	tty := newTestContainerTerminal(socket)
+	defer tty.Close()
+	started := make(chan error, 1)
+	go func() { started <- tty.Start() }()
+	created := <-api.creating
+	released := make(chan error, 1)
+	go func() { released <- tty.Release() }()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected releasing not to wait for the process to start")
+	}
+	close(created)
+	go releaseCreations(api)
+	if err := <-started; err == nil {
+		t.Fatal("expected the process started after the tty was released to be rejected")
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	if len(api.execs) != 2 || api.execs[1].Cmd[0] != "sh" || api.running["exec-1"] {
+		t.Errorf("expected the process to be killed, got %+v", api.execs)
+	}
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readCredential returns the user, group and supplementary groups of the
+// process identified by pid, processes whose real, effective and saved ids
+// differ, such as setuid programs like sudo or passwd, are rejected as they
+// may act with the privileges of another user
+func readCredential(pid int) (*syscall.Credential, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process status: %s", err)
+	}
+	defer file.Close()
+	credential := &syscall.Credential{}
+	fields := map[string][]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if parts := strings.SplitN(scanner.Text(), ":", 2); len(parts) == 2 {
+			fields[parts[0]] = strings.Fields(parts[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read process status: %s", err)
+	}
+	if len(fields["Uid"]) < 3 || len(fields["Gid"]) < 3 {
+		return nil, fmt.Errorf("failed to read process status: missing user or group")
+	}
+	uid, err := parseID(fields["Uid"][:3])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user of process: %s", err)
+	}
+	gid, err := parseID(fields["Gid"][:3])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse group of process: %s", err)
+	}
+	credential.Uid, credential.Gid = uid, gid
+	for _, field := range fields["Groups"] {
+		group, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse groups of process: %s", err)
+		}
+		credential.Groups = append(credential.Groups, uint32(group))
+	}
+	return credential, nil
+}
+
+// parseID parses the real, effective and saved ids of a process and
+// returns the real id, an error is returned unless all of them are equal
+func parseID(fields []string) (uint32, error) {
+	ids := make([]uint32, len(fields))
+	for i, field := range fields {
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		ids[i] = uint32(id)
+	}
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			return 0, fmt.Errorf("real id %d differs from effective or saved ids %v", ids[0], ids[1:])
+		}
+	}
+	return ids[0], nil
+}
+
+// isWithinCredential returns whether credential grants no more than limit,
+// root may become any user, other users may only drop groups
+func isWithinCredential(credential, limit *syscall.Credential) bool {
+	if limit.Uid == 0 {
+		return true
+	}
+	if credential.Uid != limit.Uid {
+		return false
+	}
+	groups := map[uint32]bool{limit.Gid: true}
+	for _, group := range limit.Groups {
+		groups[group] = true
+	}
+	if !groups[credential.Gid] {
+		return false
+	}
+	for _, group := range credential.Groups {
+		if !groups[group] {
+			return false
+		}
+	}
+	return true
+}
+
+// runAs runs fn with the file system permissions of credential, files
+// created by fn are owned by its user and group
+func runAs(credential *syscall.Credential, fn func() error) error {
+	if credential.Uid == uint32(os.Geteuid()) && credential.Gid == uint32(os.Getegid()) {
+		return fn()
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("failed to access files as user %d: the server is not running as root", credential.Uid)
+	}
+	result := make(chan error, 1)
+	go func() {
+		// the credentials only change for the current thread, the thread
+		// is discarded rather than reused when the goroutine exits as it
+		// is never unlocked
+		runtime.LockOSThread()
+		if err := setThreadCredential(credential); err != nil {
+			result <- err
+			return
+		}
+		result <- fn()
+	}()
+	return <-result
+}
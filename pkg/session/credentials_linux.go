@@ -0,0 +1,33 @@
+package session
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// setThreadCredential sets the supplementary groups and the user and group
+// used for file system access of the current thread, the glibc wrappers
+// and the functions of package syscall change all threads of the process
+func setThreadCredential(credential *syscall.Credential) error {
+	groups := make([]uint32, len(credential.Groups))
+	copy(groups, credential.Groups)
+	var groupsPointer unsafe.Pointer
+	if len(groups) > 0 {
+		groupsPointer = unsafe.Pointer(&groups[0])
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(groupsPointer), 0); errno != 0 {
+		return fmt.Errorf("failed to set groups: %s", errno)
+	}
+	// setfsgid and setfsuid return the previous id rather than an error,
+	// calling them with an invalid id returns the current one
+	syscall.RawSyscall(syscall.SYS_SETFSGID, uintptr(credential.Gid), 0, 0)
+	if gid, _, _ := syscall.RawSyscall(syscall.SYS_SETFSGID, ^uintptr(0), 0, 0); uint32(gid) != credential.Gid {
+		return fmt.Errorf("failed to set file system group to %d", credential.Gid)
+	}
+	syscall.RawSyscall(syscall.SYS_SETFSUID, uintptr(credential.Uid), 0, 0)
+	if uid, _, _ := syscall.RawSyscall(syscall.SYS_SETFSUID, ^uintptr(0), 0, 0); uint32(uid) != credential.Uid {
+		return fmt.Errorf("failed to set file system user to %d", credential.Uid)
+	}
+	return nil
+}
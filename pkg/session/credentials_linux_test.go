@@ -0,0 +1,138 @@
+package session
+
+import (
+	"bufio"
+	"cloudshell/pkg/profile"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// nobody is the user and group the helper process switches to
+const nobody = 65534
+
+// TestHelperProcess is not a test, it is the process started by the tests
+// below to change its credential as set in CLOUDSHELL_TEST_CREDENTIAL, a
+// setuid program is mimicked by only changing the effective user
+func TestHelperProcess(t *testing.T) {
+	var err error
+	switch os.Getenv("CLOUDSHELL_TEST_CREDENTIAL") {
+	case "":
+		return
+	case "effective":
+		err = syscall.Setresuid(0, nobody, 0)
+	case "all":
+		if err = syscall.Setgroups(nil); err == nil {
+			if err = syscall.Setresgid(nobody, nobody, nobody); err == nil {
+				err = syscall.Setresuid(nobody, nobody, nobody)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("failed to change credential: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("ready")
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+// startHelperTerminal starts a terminal running the helper process which
+// changes its credential as set in mode
+func startHelperTerminal(t *testing.T, mode string) *terminal {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the credential of a process requires root")
+	}
+	tty, err := newTerminal("test-session", profile.Profile{
+		Name:      "helper",
+		Command:   os.Args[0],
+		Arguments: []string{"-test.run=^TestHelperProcess$"},
+		Env:       map[string]string{"CLOUDSHELL_TEST_CREDENTIAL": mode},
+	})
+	if err != nil {
+		t.Fatalf("failed to open terminal: %s", err)
+	}
+	t.Cleanup(func() {
+		tty.Kill()
+		tty.Close()
+	})
+	if err := tty.Start(); err != nil {
+		t.Fatalf("failed to start helper: %s", err)
+	}
+	line, err := bufio.NewReader(tty.pty).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ready" {
+		t.Fatalf("expected the helper to be ready, got %q (%v)", line, err)
+	}
+	return tty
+}
+
+func TestCredential(t *testing.T) {
+	tty := startHelperTerminal(t, "all")
+	credential, err := tty.Credential()
+	if err != nil {
+		t.Fatalf("failed to get credential: %s", err)
+	}
+	if credential.Uid != nobody || credential.Gid != nobody || len(credential.Groups) != 0 {
+		t.Errorf("expected the credential of the process, got %+v", credential)
+	}
+}
+
+func TestCredentialRejectsEffectiveUser(t *testing.T) {
+	tty := startHelperTerminal(t, "effective")
+	if credential, err := tty.Credential(); err == nil {
+		t.Errorf("expected a process whose effective user differs from its real user to be rejected, got %+v", credential)
+	}
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		fields []string
+		id     uint32
+		valid  bool
+	}{
+		{[]string{"1000", "1000", "1000"}, 1000, true},
+		{[]string{"0", "0", "0"}, 0, true},
+		// setuid programs have an effective or saved id of root
+		{[]string{"1000", "0", "0"}, 0, false},
+		{[]string{"1000", "1000", "0"}, 0, false},
+		{[]string{"0", "1000", "0"}, 0, false},
+		{[]string{"1000", "x", "1000"}, 0, false},
+	}
+	for _, test := range tests {
+		id, err := parseID(test.fields)
+		if test.valid && (err != nil || id != test.id) {
+			t.Errorf("expected %v to be id %d, got %d (%v)", test.fields, test.id, id, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %v to be rejected, got %d", test.fields, id)
+		}
+	}
+}
+
+func TestIsWithinCredential(t *testing.T) {
+	root := &syscall.Credential{Uid: 0, Gid: 0}
+	alice := &syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{1000, 27}}
+	tests := []struct {
+		credential *syscall.Credential
+		limit      *syscall.Credential
+		within     bool
+	}{
+		{alice, root, true},
+		{root, root, true},
+		{alice, alice, true},
+		{&syscall.Credential{Uid: 1000, Gid: 27}, alice, true},
+		{&syscall.Credential{Uid: 1000, Gid: 1000}, alice, true},
+		{root, alice, false},
+		{&syscall.Credential{Uid: 1001, Gid: 1000}, alice, false},
+		{&syscall.Credential{Uid: 1000, Gid: 0}, alice, false},
+		{&syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{0}}, alice, false},
+	}
+	for _, test := range tests {
+		if within := isWithinCredential(test.credential, test.limit); within != test.within {
+			t.Errorf("expected %+v within %+v to be %v, got %v", test.credential, test.limit, test.within, within)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package session
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setThreadCredential is only supported on linux
+func setThreadCredential(credential *syscall.Credential) error {
+	return errors.New("failed to change file system credentials: not supported on this platform")
+}
@@ -0,0 +1,298 @@
+package session
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidFileName is returned when a file name is empty or refers
+	// to a directory
+	ErrInvalidFileName = errors.New("invalid file name")
+	// ErrFileExists is returned when uploading a file that already exists
+	// without overwriting it
+	ErrFileExists = errors.New("file already exists")
+	// ErrFileTooLarge is returned when an uploaded file exceeds the size
+	// limit
+	ErrFileTooLarge = errors.New("file is too large")
+	// ErrOutsideWorkingDirectory is returned when downloading a path which
+	// is not in the working directory of the session
+	ErrOutsideWorkingDirectory = errors.New("path is outside of the working directory")
+	// ErrNotDownloadable is returned when downloading a path which is
+	// neither a regular file nor a directory
+	ErrNotDownloadable = errors.New("path is not a regular file or directory")
+)
+
+// UploadedFile describes a file written into the working directory of a
+// session
+type UploadedFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Upload writes content to a file called name in the working directory of
+// the shell with the permissions of the user it runs as, existing files are
+// only replaced when overwrite is true and at most maxSizeBytes are
+// written; the working directory is read from the process as OSC 7 reports
+// can be written by any program in the session
+func (s *Session) Upload(name string, content io.Reader, overwrite bool, maxSizeBytes int64) (UploadedFile, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	directory := s.tty.WorkingDirectory()
+	if directory == "" {
+		return UploadedFile{}, errors.New("failed to determine the working directory of the session")
+	}
+	credential, err := s.tty.Credential()
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	uploaded := UploadedFile{Name: name, Path: filepath.Join(directory, name)}
+	err = runAs(credential, func() error {
+		mode := os.FileMode(0644)
+		if info, err := os.Lstat(uploaded.Path); err == nil {
+			if !overwrite {
+				return ErrFileExists
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("failed to replace '%s': not a regular file", uploaded.Path)
+			}
+			mode = info.Mode().Perm()
+		}
+		// the file is written next to its destination and renamed once it
+		// is complete so that failed uploads do not leave partial files
+		file, err := ioutil.TempFile(directory, "."+name+".*.upload")
+		if err != nil {
+			return fmt.Errorf("failed to create file: %s", err)
+		}
+		uploaded.Size, err = io.Copy(file, io.LimitReader(content, maxSizeBytes+1))
+		if err == nil && uploaded.Size > maxSizeBytes {
+			err = ErrFileTooLarge
+		}
+		if err == nil {
+			err = file.Chmod(mode)
+		}
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(file.Name(), uploaded.Path)
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			if errors.Is(err, ErrFileTooLarge) {
+				return err
+			}
+			return fmt.Errorf("failed to write file: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	s.log.Infof("uploaded '%s' (%v bytes)", uploaded.Path, uploaded.Size)
+	s.Notify(fmt.Sprintf("cloudshell: uploaded '%s' (%s) to %s", uploaded.Name, formatSize(uploaded.Size), directory))
+	return uploaded, nil
+}
+
+// cleanFileName returns name without any directories, ErrInvalidFileName
+// is returned when nothing remains
+func cleanFileName(name string) (string, error) {
+	name = filepath.Base(filepath.Clean("/" + strings.ReplaceAll(name, "\\", "/")))
+	if name == "/" || name == "." || name == ".." {
+		return "", ErrInvalidFileName
+	}
+	return name, nil
+}
+
+// DownloadedFile describes a file or directory downloaded from the working
+// directory of a session
+type DownloadedFile struct {
+	// Name is the name of the file to save the download as
+	Name string
+	// Path is the path of the file or directory with symbolic links resolved
+	Path string
+	// Size is the size of files, it is -1 for archives of directories
+	Size int64
+	// IsArchive is true when a directory is downloaded as a gzip compressed
+	// tar archive
+	IsArchive bool
+}
+
+// Download writes the file at filePath relative to the working directory
+// of the shell, read from the process like for Upload, to w with the
+// permissions of the user the shell runs as, directories are written as
+// gzip compressed tar archives, prepare is called with the file before
+// anything is written
+func (s *Session) Download(filePath string, w io.Writer, prepare func(DownloadedFile)) error {
+	directory := s.tty.WorkingDirectory()
+	if directory == "" {
+		return errors.New("failed to determine the working directory of the session")
+	}
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(directory, filePath)
+	}
+	credential, err := s.tty.Credential()
+	if err != nil {
+		return err
+	}
+	return runAs(credential, func() error {
+		file, err := resolveDownload(directory, filePath)
+		if err != nil {
+			return err
+		}
+		s.log.Infof("downloading '%s'", file.Path)
+		if !file.IsArchive {
+			source, err := os.Open(file.Path)
+			if err != nil {
+				return err
+			}
+			defer source.Close()
+			prepare(file)
+			_, err = io.Copy(w, source)
+			return err
+		}
+		prepare(file)
+		return writeArchive(w, file.Path, s.log.Warnf)
+	})
+}
+
+// resolveDownload returns the file or directory at filePath, an error is
+// returned when it is not in directory once symbolic links are resolved
+func resolveDownload(directory, filePath string) (DownloadedFile, error) {
+	resolvedDirectory, err := filepath.EvalSymlinks(directory)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	resolvedPath, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	if resolvedPath != resolvedDirectory && !strings.HasPrefix(resolvedPath, strings.TrimSuffix(resolvedDirectory, "/")+"/") {
+		return DownloadedFile{}, ErrOutsideWorkingDirectory
+	}
+	info, err := os.Stat(resolvedPath)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+	file := DownloadedFile{Name: filepath.Base(resolvedPath), Path: resolvedPath, Size: info.Size()}
+	switch {
+	case info.IsDir():
+		file.Name += ".tar.gz"
+		file.Size = -1
+		file.IsArchive = true
+	case !info.Mode().IsRegular():
+		return DownloadedFile{}, ErrNotDownloadable
+	}
+	return file, nil
+}
+
+// writeArchive writes a gzip compressed tar archive of directory to w,
+// entries which cannot be read are skipped and reported to warn
+func writeArchive(w io.Writer, directory string, warn func(string, ...interface{})) error {
+	compressor := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressor)
+	parent := filepath.Dir(directory)
+	err := filepath.Walk(directory, func(entryPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			warn("skipping '%s' in archive: %s", entryPath, err)
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name, err := filepath.Rel(parent, entryPath)
+		if err != nil {
+			return err
+		}
+		link := ""
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(entryPath); err != nil {
+				warn("skipping '%s' in archive: %s", entryPath, err)
+				return nil
+			}
+		case !info.IsDir() && !info.Mode().IsRegular():
+			// devices, sockets and pipes have no contents to archive
+			return nil
+		}
+		var source *os.File
+		if info.Mode().IsRegular() {
+			if source, err = os.Open(entryPath); err != nil {
+				warn("skipping '%s' in archive: %s", entryPath, err)
+				return nil
+			}
+			defer source.Close()
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if source != nil {
+			// files that shrank since they were read are padded with zeros
+			// as the archive requires as many bytes as its header specifies
+			if _, err := io.CopyN(archive, io.MultiReader(source, zeros{}), header.Size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+// zeros is a reader of an endless stream of zeros
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for index := range p {
+		p[index] = 0
+	}
+	return len(p), nil
+}
+
+// Notify displays message on a line of its own in the terminal of the
+// session, the line the cursor was on is repeated below the message so
+// that the line being edited remains visible, nothing is displayed while
+// a full screen program is running
+func (s *Session) Notify(message string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.screen.IsAlternateScreen() {
+		return
+	}
+	text := "\r\n" + noticeWarning + message + noticeReset + "\r\n"
+	s.display(append([]byte(text), s.screen.CursorLine()...))
+}
+
+// formatSize returns a human readable representation of sizeBytes
+func formatSize(sizeBytes int64) string {
+	const unit = 1024
+	if sizeBytes < unit {
+		return fmt.Sprintf("%d B", sizeBytes)
+	}
+	divisor, exponent := int64(unit), 0
+	for remaining := sizeBytes / unit; remaining >= unit; remaining /= unit {
+		divisor *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(sizeBytes)/float64(divisor), "KMGTPE"[exponent])
+}
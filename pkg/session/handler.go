@@ -0,0 +1,552 @@
+package session
+
+import (
+	"cloudshell/internal/log"
+	"cloudshell/pkg/auth"
+	"cloudshell/pkg/expect"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/zmodem"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxRequestSizeBytes is the maximum size of request bodies accepted by
+// the session handlers
+const maxRequestSizeBytes = 1 << 20
+
+// credentialHeaders are the headers carrying the credentials of the viewer
+// which are not forwarded to ports in sessions
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// forwardedContentSecurityPolicy sandboxes the pages of forwarded ports
+// without allow-same-origin so that they do not share the origin of the
+// server
+const forwardedContentSecurityPolicy = "sandbox allow-downloads allow-forms allow-modals allow-popups allow-scripts"
+
+// Summary is the publicly visible representation of a session
+type Summary struct {
+	ID        string    `json:"id"`
+	Profile   string    `json:"profile"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Headless  bool      `json:"headless"`
+	Exited    bool      `json:"exited"`
+	ExitCode  *int      `json:"exitCode,omitempty"`
+	Cwd       string    `json:"cwd,omitempty"`
+	// LastCommand is the last command reported by the shell integration
+	LastCommand *Command `json:"lastCommand,omitempty"`
+}
+
+// GetSummary returns the summary of s
+func (s *Session) GetSummary() Summary {
+	summary := Summary{
+		ID:        s.ID,
+		Profile:   s.Profile.Name,
+		Owner:     s.Owner,
+		CreatedAt: s.CreatedAt,
+		Headless:  s.Headless,
+	}
+	if exitCode, ok := s.ExitCode(); ok {
+		summary.Exited = true
+		summary.ExitCode = &exitCode
+	} else {
+		summary.Cwd = s.WorkingDirectory()
+	}
+	if commands := s.Commands(); len(commands) > 0 {
+		summary.LastCommand = &commands[len(commands)-1]
+	}
+	return summary
+}
+
+// CreateRequest is the body of a request to the create handler
+type CreateRequest struct {
+	// Profile is the name of the profile to start, an empty name selects
+	// the default profile
+	Profile string `json:"profile"`
+	// Parameters are the values of the parameters of the profile
+	Parameters map[string]string `json:"parameters"`
+}
+
+// ExpectRequest is the body of a request to the expect handler
+type ExpectRequest struct {
+	Steps []expect.Step `json:"steps"`
+}
+
+// GetListHandler returns a http handler that responds with a JSON list of
+// the sessions in registry that the requesting principal can access
+func GetListHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := auth.GetPrincipal(r.Context())
+		summaries := []Summary{}
+		for _, session := range registry.List() {
+			if session.IsAccessibleBy(principal) {
+				summaries = append(summaries, session.GetSummary())
+			}
+		}
+		writeJSON(w, http.StatusOK, summaries)
+	}
+}
+
+// GetHandler returns a http handler that responds with the summary of the
+// session identified by the `id` route variable
+func GetHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, session.GetSummary())
+	}
+}
+
+// GetCommandsHandler returns a http handler that responds with the most
+// recent commands executed in the session identified by the `id` route
+// variable together with their exit codes and durations
+func GetCommandsHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, session.Commands())
+	}
+}
+
+// GetCreateHandler returns a http handler that starts a headless session
+// using the profile in the request body and responds with its summary, the
+// session keeps running until its process exits or it is deleted
+func GetCreateHandler(registry *Registry, profiles *profile.Registry, opts Options) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isJSONRequest(r) {
+			writeError(w, http.StatusUnsupportedMediaType, "failed to parse request: content type is not application/json")
+			return
+		}
+		request := CreateRequest{}
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSizeBytes))
+		decoder.DisallowUnknownFields()
+		// an empty body starts the default profile
+		if err := decoder.Decode(&request); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
+			return
+		}
+		selectedProfile, ok := profiles.Get(request.Profile)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("failed to find profile '%s'", request.Profile))
+			return
+		}
+		parameters := url.Values{}
+		for name, value := range request.Parameters {
+			parameters.Set(name, value)
+		}
+		profileName := selectedProfile.Name
+		selectedProfile, err := selectedProfile.Instantiate(parameters, auth.GetClaims(r.Context()))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to use profile '%s': %s", profileName, err))
+			return
+		}
+		id, err := uuid.NewUUID()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to get a session uuid")
+			return
+		}
+		sessionOpts := opts
+		sessionOpts.Headless = true
+		if principal := auth.GetPrincipal(r.Context()); principal != nil {
+			sessionOpts.Owner = principal.Name
+		}
+		if selectedProfile.Limits.MaxBufferSizeBytes > 0 {
+			sessionOpts.MaxBufferSizeBytes = selectedProfile.Limits.MaxBufferSizeBytes
+		}
+		session, err := registry.Create(id.String(), selectedProfile, sessionOpts)
+		if err != nil {
+			var limitErr *LimitError
+			status := http.StatusInternalServerError
+			if errors.As(err, &limitErr) {
+				status = http.StatusServiceUnavailable
+			}
+			writeError(w, status, fmt.Sprintf("failed to start session: %s", err))
+			return
+		}
+		log.Infof("started headless session '%s' using profile '%s'", session.ID, selectedProfile.Name)
+		writeJSON(w, http.StatusCreated, session.GetSummary())
+	}
+}
+
+// GetDeleteHandler returns a http handler that ends the session identified
+// by the `id` route variable
+func GetDeleteHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		log.Infof("ending session '%s'", session.ID)
+		session.Close()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetExpectHandler returns a http handler that runs the steps in the
+// request body against the session identified by the `id` route variable
+// and responds with the result, output from before the request is not
+// matched against
+func GetExpectHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		if !isJSONRequest(r) {
+			writeError(w, http.StatusUnsupportedMediaType, "failed to parse request: content type is not application/json")
+			return
+		}
+		request := ExpectRequest{}
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSizeBytes))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
+			return
+		}
+		if err := expect.Validate(request.Steps); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid steps: %s", err))
+			return
+		}
+		subscription := session.Subscribe()
+		defer subscription.Close()
+		log.Infof("running %v expect step(s) against session '%s'", len(request.Steps), session.ID)
+		writeJSON(w, http.StatusOK, expect.New(subscription).Run(request.Steps))
+	}
+}
+
+// GetUploadHandler returns a http handler that writes the files of a
+// multipart/form-data request body into the working directory of the
+// session identified by the `id` route variable and responds with the
+// list of files written, existing files are only replaced when the
+// `overwrite` query parameter is true
+func GetUploadHandler(registry *Registry, maxSizeBytes int64) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
+			return
+		}
+		overwrite := r.URL.Query().Get("overwrite") == "true"
+		uploaded := []UploadedFile{}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
+				return
+			}
+			if part.FileName() == "" {
+				continue
+			}
+			file, err := session.Upload(part.FileName(), part, overwrite, maxSizeBytes)
+			if err != nil {
+				status := http.StatusInternalServerError
+				switch {
+				case errors.Is(err, ErrInvalidFileName):
+					status = http.StatusBadRequest
+				case errors.Is(err, ErrFileExists):
+					status = http.StatusConflict
+				case errors.Is(err, ErrFileTooLarge):
+					status = http.StatusRequestEntityTooLarge
+				}
+				writeError(w, status, fmt.Sprintf("failed to upload '%s': %s", part.FileName(), err))
+				return
+			}
+			uploaded = append(uploaded, file)
+		}
+		if len(uploaded) == 0 {
+			writeError(w, http.StatusBadRequest, "failed to find a file in the request")
+			return
+		}
+		writeJSON(w, http.StatusCreated, uploaded)
+	}
+}
+
+// GetDownloadHandler returns a http handler that responds with the file at
+// the `path` query parameter relative to the working directory of the
+// session identified by the `id` route variable, directories are sent as
+// gzip compressed tar archives
+func GetDownloadHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		filePath := r.URL.Query().Get("path")
+		if filePath == "" {
+			writeError(w, http.StatusBadRequest, "failed to download: the path query parameter is required")
+			return
+		}
+		started := false
+		err := session.Download(filePath, w, func(file DownloadedFile) {
+			started = true
+			contentType := "application/octet-stream"
+			if file.IsArchive {
+				contentType = "application/gzip"
+			} else {
+				w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+			w.WriteHeader(http.StatusOK)
+		})
+		if err != nil && started {
+			log.Warnf("failed to download '%s' from session '%s': %s", filePath, session.ID, err)
+		} else if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				status = http.StatusNotFound
+			case errors.Is(err, os.ErrPermission), errors.Is(err, ErrOutsideWorkingDirectory):
+				status = http.StatusForbidden
+			case errors.Is(err, ErrNotDownloadable):
+				status = http.StatusBadRequest
+			}
+			writeError(w, status, fmt.Sprintf("failed to download '%s': %s", filePath, err))
+		}
+	}
+}
+
+// GetTransferDownloadHandler returns a http handler that responds with the
+// file received from `sz` identified by the `transfer` route variable in
+// the session identified by the `id` route variable
+func GetTransferDownloadHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		id := mux.Vars(r)["transfer"]
+		received, file, err := session.OpenTransferredFile(id)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrTransferNotFound) {
+				status = http.StatusNotFound
+			}
+			writeError(w, status, fmt.Sprintf("failed to download transferred file '%s': %s", id, err))
+			return
+		}
+		defer file.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(received.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": received.Name}))
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, file); err != nil {
+			log.Warnf("failed to download transferred file '%s' from session '%s': %s", id, session.ID, err)
+		}
+	}
+}
+
+// GetTransferUploadHandler returns a http handler that sends the files of a
+// multipart/form-data request body to the `rz` waiting for the transfer
+// identified by the `transfer` route variable in the session identified by
+// the `id` route variable and responds with the list of files sent once
+// the transfer ended
+func GetTransferUploadHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		id := mux.Vars(r)["transfer"]
+		reader, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to parse request: %s", err))
+			return
+		}
+		files, err := session.SendFiles(id, func() (string, io.Reader, error) {
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					return "", nil, err
+				}
+				if part.FileName() != "" {
+					return part.FileName(), part, nil
+				}
+			}
+		})
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrTransferNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, ErrInvalidFileName):
+				status = http.StatusBadRequest
+			case errors.Is(err, ErrFileTooLarge):
+				status = http.StatusRequestEntityTooLarge
+			case errors.Is(err, zmodem.ErrAborted):
+				status = http.StatusConflict
+			}
+			writeError(w, status, fmt.Sprintf("failed to send files with transfer '%s': %s", id, err))
+			return
+		}
+		writeJSON(w, http.StatusOK, files)
+	}
+}
+
+// GetTransferCancelHandler returns a http handler that cancels the transfer
+// identified by the `transfer` route variable in the session identified by
+// the `id` route variable
+func GetTransferCancelHandler(registry *Registry) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		id := mux.Vars(r)["transfer"]
+		if err := session.CancelTransfer(id); err != nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("failed to cancel transfer '%s': %s", id, err))
+			return
+		}
+		log.Infof("cancelled transfer '%s' of session '%s'", id, session.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetPortHandler returns a http handler that proxies http and websocket
+// requests to the port identified by the `port` route variable on
+// localhost in the session identified by the `id` route variable, the
+// request path is taken from the `path` route variable and requests
+// without it are redirected to the root of the port, only ports within
+// ports can be reached; credentials, including authHeaders, are not
+// forwarded and responses are sandboxed as they are served from the origin
+// of the server
+func GetPortHandler(registry *Registry, ports PortRange, authHeaders []string) func(http.ResponseWriter, *http.Request) {
+	// connections are pooled by the session id and port used as the host
+	// of the requests so that they are never shared between sessions
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			id, portValue, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			session, ok := registry.Get(id)
+			if !ok {
+				return nil, fmt.Errorf("failed to find session '%s'", id)
+			}
+			port, err := strconv.Atoi(portValue)
+			if err != nil {
+				return nil, err
+			}
+			return session.DialPort(ctx, port)
+		},
+		IdleConnTimeout: 90 * time.Second,
+		MaxIdleConns:    100,
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := getAccessibleSession(registry, w, r)
+		if !ok {
+			return
+		}
+		vars := mux.Vars(r)
+		port, err := strconv.Atoi(vars["port"])
+		if err != nil || !ports.Contains(port) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("port '%s' is not in the range of ports that can be forwarded (%s)", vars["port"], ports))
+			return
+		}
+		requestPath, ok := vars["path"]
+		if !ok {
+			target := vars["port"] + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		prefix := strings.TrimSuffix(r.URL.Path, requestPath)
+		proxy := &httputil.ReverseProxy{
+			Director: func(request *http.Request) {
+				request.URL.Scheme = "http"
+				request.URL.Host = net.JoinHostPort(session.ID, vars["port"])
+				request.URL.Path = "/" + requestPath
+				request.URL.RawPath = ""
+				// development servers commonly reject unknown hosts
+				request.Host = net.JoinHostPort("localhost", vars["port"])
+				request.Header.Set("X-Forwarded-Host", r.Host)
+				request.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(prefix, "/"))
+				for _, header := range append(credentialHeaders, authHeaders...) {
+					request.Header.Del(header)
+				}
+			},
+			ModifyResponse: func(response *http.Response) error {
+				// scripts of forwarded applications run in an opaque origin
+				// so that they cannot use the apis with the credentials of
+				// the viewer, and cannot set cookies for the server
+				response.Header.Add("Content-Security-Policy", forwardedContentSecurityPolicy)
+				response.Header.Del("Set-Cookie")
+				return nil
+			},
+			Transport: transport,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to reach port %v of session '%s': %s", port, session.ID, err))
+			},
+		}
+		log.Debugf("forwarding %s %s to port %v of session '%s'", r.Method, r.URL.Path, port, session.ID)
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// getAccessibleSession returns the session identified by the `id` route
+// variable, an error response is written if it cannot be used by the
+// requesting principal
+func getAccessibleSession(registry *Registry, w http.ResponseWriter, r *http.Request) (*Session, bool) {
+	id := mux.Vars(r)["id"]
+	session, ok := registry.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("failed to find session '%s'", id))
+		return nil, false
+	}
+	if !session.IsAccessibleBy(auth.GetPrincipal(r.Context())) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("not allowed to access session '%s'", id))
+		return nil, false
+	}
+	return session, true
+}
+
+// isJSONRequest returns true when the body of r is declared to be json,
+// which browsers cannot send to other websites without their consent
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	response, err := json.Marshal(value)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to serialise response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	log.Warn(message)
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
@@ -0,0 +1,454 @@
+package session
+
+import (
+	"cloudshell/internal/log"
+	"cloudshell/pkg/kubernetes"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/vt"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// workspaces provisions the pods of profiles with a kubernetes workspace,
+// it is shared by all sessions so that the sessions of a user share a pod
+var workspaces = kubernetes.NewProvisioner()
+
+// AdoptWorkspaces arms the idle timers of the workspace pods left behind
+// in the namespaces of the workspaces of profiles when the server stopped,
+// namespaces that reference parameters cannot be known in advance and are
+// skipped
+func AdoptWorkspaces(profiles []profile.Profile) {
+	for _, p := range profiles {
+		if p.Kubernetes == nil || p.Kubernetes.Workspace == nil {
+			continue
+		}
+		namespace := p.Kubernetes.GetNamespace()
+		if strings.Contains(namespace, "{{") {
+			log.Warnf("not adopting workspace pods of profile '%s' as its namespace references parameters", p.Name)
+			continue
+		}
+		config, err := kubernetes.LoadConfig(p.Kubernetes.Kubeconfig)
+		if err != nil {
+			log.Warnf("failed to adopt workspace pods of profile '%s': %s", p.Name, err)
+			continue
+		}
+		if err := workspaces.Adopt(kubernetes.NewClient(config), namespace, p.Kubernetes.Workspace.GetIdleTimeout()); err != nil {
+			log.Warnf("failed to adopt workspace pods of profile '%s': %s", p.Name, err)
+		}
+	}
+}
+
+// podTerminal is a tty in the container of a running pod that processes
+// for a profile are (re)started on through the exec api of Kubernetes
+type podTerminal struct {
+	profile profile.Profile
+	// client is created when the first process is started
+	client *kubernetes.Client
+	// sessionID identifies the session to processes through the
+	// CLOUDSHELL_SESSION_ID environment variable
+	sessionID string
+	// output is read from by the session and written to by the processes,
+	// it is kept open across processes like the tty of local terminals
+	output       *io.PipeReader
+	outputWriter *io.PipeWriter
+	// pod is the name of the pod that the current process runs in
+	pod  string
+	exec *kubernetes.Exec
+	// copied is closed once the output of the current process ended
+	copied     chan struct{}
+	rows, cols uint16
+	released   bool
+	// acquired is true while the session uses the pod of the workspace of
+	// the profile
+	acquired bool
+	mutex    sync.Mutex
+}
+
+// newPodTerminal returns a terminal for processes of selectedProfile in
+// its pod in the session identified by sessionID, the api is only
+// connected to when the first process is started
+func newPodTerminal(sessionID string, selectedProfile profile.Profile) *podTerminal {
+	output, outputWriter := io.Pipe()
+	return &podTerminal{
+		profile:      selectedProfile,
+		sessionID:    sessionID,
+		output:       output,
+		outputWriter: outputWriter,
+		rows:         vt.DefaultRows,
+		cols:         vt.DefaultCols,
+	}
+}
+
+// Start starts a new process of the profile in the pod, pods chosen by a
+// selector are chosen again for every process, the pods of workspaces are
+// provisioned in the background while their progress is written to the
+// output
+func (t *podTerminal) Start() error {
+	t.mutex.Lock()
+	if t.released {
+		t.mutex.Unlock()
+		return errors.New("failed to start process: tty has been released")
+	}
+	client, rows, cols := t.client, t.rows, t.cols
+	t.mutex.Unlock()
+	settings := t.profile.Kubernetes
+	if client == nil {
+		config, err := kubernetes.LoadConfig(settings.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		client = kubernetes.NewClient(config)
+	}
+	if settings.Workspace != nil {
+		return t.startWorkspace(client)
+	}
+	pod := settings.Pod
+	if pod == "" {
+		var err error
+		if pod, err = client.FindPod(settings.GetNamespace(), settings.Selector); err != nil {
+			return err
+		}
+	}
+	exec, err := t.startExec(client, pod, rows, cols)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	if t.released {
+		t.mutex.Unlock()
+		t.discardExec(client, pod, exec)
+		return errors.New("failed to start process: tty has been released")
+	}
+	defer t.mutex.Unlock()
+	t.client = client
+	t.pod = pod
+	t.exec = exec
+	t.copied = t.copy(exec)
+	t.applySize(exec, rows, cols)
+	return nil
+}
+
+// startWorkspace starts a new process in the pod of the workspace of the
+// profile once it is ready
+func (t *podTerminal) startWorkspace(client *kubernetes.Client) error {
+	settings := t.profile.Kubernetes
+	contents, err := ioutil.ReadFile(settings.Workspace.TemplateFile)
+	if err != nil {
+		return fmt.Errorf("failed to read workspace template: %s", err)
+	}
+	manifest, err := kubernetes.ParsePodManifest(contents)
+	if err != nil {
+		return err
+	}
+	copied := make(chan struct{})
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.released {
+		return errors.New("failed to start process: tty has been released")
+	}
+	t.client = client
+	t.pod = settings.Workspace.Name
+	t.exec = nil
+	t.copied = copied
+	// the output is only read once the session has started so the pod
+	// cannot be waited for here
+	go func() {
+		if err := t.attachWorkspace(client, manifest); err != nil {
+			fmt.Fprintf(t.outputWriter, "failed to start workspace: %s\r\n", err)
+			close(copied)
+		}
+	}()
+	return nil
+}
+
+// attachWorkspace provisions the pod of the workspace unless the session
+// already uses it and starts a process in it whose output is copied
+func (t *podTerminal) attachWorkspace(client *kubernetes.Client, manifest map[string]interface{}) error {
+	settings := t.profile.Kubernetes
+	workspace := settings.Workspace
+	t.mutex.Lock()
+	acquired := t.acquired
+	t.mutex.Unlock()
+	if !acquired {
+		if err := workspaces.Acquire(client, settings.GetNamespace(), workspace.Name, manifest, workspace.GetReadyTimeout(), t.outputWriter); err != nil {
+			return err
+		}
+		t.mutex.Lock()
+		t.acquired = true
+		t.mutex.Unlock()
+	}
+	t.mutex.Lock()
+	if t.released {
+		// the session ended while the pod was provisioned
+		t.releaseWorkspace()
+		t.mutex.Unlock()
+		return errors.New("tty has been released")
+	}
+	rows, cols := t.rows, t.cols
+	t.mutex.Unlock()
+	exec, err := t.startExec(client, workspace.Name, rows, cols)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	if t.released {
+		t.releaseWorkspace()
+		t.mutex.Unlock()
+		t.discardExec(client, workspace.Name, exec)
+		return errors.New("tty has been released")
+	}
+	defer t.mutex.Unlock()
+	t.exec = exec
+	t.applySize(exec, rows, cols)
+	copied := t.copied
+	go func() {
+		<-t.copy(exec)
+		close(copied)
+	}()
+	return nil
+}
+
+// releaseWorkspace stops using the pod of the workspace of the profile,
+// it is called with the lock of the terminal held
+func (t *podTerminal) releaseWorkspace() {
+	if !t.acquired {
+		return
+	}
+	t.acquired = false
+	workspace := t.profile.Kubernetes.Workspace
+	workspaces.Release(t.client, t.profile.Kubernetes.GetNamespace(), workspace.Name, workspace.GetIdleTimeout())
+}
+
+// startExec starts the command of the profile in pod with a tty of rows
+// and cols
+func (t *podTerminal) startExec(client *kubernetes.Client, pod string, rows, cols uint16) (*kubernetes.Exec, error) {
+	exec, err := client.Exec(t.profile.Kubernetes.GetNamespace(), pod, t.profile.Kubernetes.Container, t.remoteCommand())
+	if err != nil {
+		return nil, err
+	}
+	if err := exec.Resize(rows, cols); err != nil {
+		exec.Close()
+		return nil, err
+	}
+	return exec, nil
+}
+
+// applySize resizes the tty of exec, which was started with rows and cols,
+// when the window was resized while it was started, it is called with the
+// lock of the terminal held
+func (t *podTerminal) applySize(exec *kubernetes.Exec, rows, cols uint16) {
+	if t.rows != rows || t.cols != cols {
+		exec.Resize(t.rows, t.cols)
+	}
+}
+
+// discardExec kills the processes of exec in pod, which was started after
+// the tty was released
+func (t *podTerminal) discardExec(client *kubernetes.Client, pod string, exec *kubernetes.Exec) {
+	t.killProcesses(client, pod)
+	exec.Close()
+}
+
+// copy copies the output of exec to the output of the terminal, the
+// returned channel is closed once the output ended
+func (t *podTerminal) copy(exec *kubernetes.Exec) chan struct{} {
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		io.Copy(t.outputWriter, exec)
+	}()
+	return copied
+}
+
+// remoteCommand returns the command run in the container, the exec api
+// cannot set the environment or working directory of processes so the
+// command is run through `env` and `sh` when the profile specifies a
+// working directory
+func (t *podTerminal) remoteCommand() []string {
+	command := []string{"env", "TERM=xterm-256color"}
+	keys := make([]string, 0, len(t.profile.Env))
+	for key := range t.profile.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		command = append(command, fmt.Sprintf("%s=%s", key, t.profile.Env[key]))
+	}
+	command = append(command, SessionIDEnvironmentVariable+"="+t.sessionID, t.profile.Command)
+	command = append(command, t.profile.Arguments...)
+	if t.profile.Workdir != "" {
+		command = append([]string{"sh", "-c", `cd "$0" && exec "$@"`, t.profile.Workdir}, command...)
+	}
+	return command
+}
+
+// Wait waits for the current process to exit and returns its exit code,
+// -1 is returned when the api did not report it or the pod of the
+// workspace could not be started
+func (t *podTerminal) Wait() int {
+	t.mutex.Lock()
+	copied := t.copied
+	t.mutex.Unlock()
+	if copied == nil {
+		return -1
+	}
+	<-copied
+	t.mutex.Lock()
+	exec := t.exec
+	t.mutex.Unlock()
+	if exec == nil {
+		// the pod of the workspace could not be started
+		return -1
+	}
+	return exec.Wait()
+}
+
+// Release closes the output so that reads return io.EOF once the
+// remaining output has been read; no further processes can be started
+// after this
+func (t *podTerminal) Release() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.released = true
+	return t.outputWriter.Close()
+}
+
+// Read implements io.Reader by reading the output of the process
+func (t *podTerminal) Read(p []byte) (int, error) {
+	return t.output.Read(p)
+}
+
+// Write implements io.Writer by writing input to the process
+func (t *podTerminal) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	exec := t.exec
+	t.mutex.Unlock()
+	if exec == nil {
+		return 0, errors.New("failed to write input: no process has been started")
+	}
+	return exec.Write(p)
+}
+
+// Resize sets the window size of the tty of the process
+func (t *podTerminal) Resize(rows, cols uint16) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rows, t.cols = rows, cols
+	if t.exec == nil {
+		return nil
+	}
+	return t.exec.Resize(rows, cols)
+}
+
+// Kill ends the current process and the processes it started by killing
+// them with a short lived process in the container as for containers of
+// the Docker Engine, the connection is closed afterwards which ends the
+// input of processes that could not be killed
+func (t *podTerminal) Kill() error {
+	t.mutex.Lock()
+	client, pod, exec := t.client, t.pod, t.exec
+	t.mutex.Unlock()
+	if exec == nil {
+		return nil
+	}
+	var err error
+	if !exec.Exited() {
+		err = t.killProcesses(client, pod)
+	}
+	if closeErr := exec.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// killProcesses kills the processes of the session in the container of pod
+// and waits for the process doing so to exit
+func (t *podTerminal) killProcesses(client *kubernetes.Client, pod string) error {
+	settings := t.profile.Kubernetes
+	exec, err := client.Exec(settings.GetNamespace(), pod, settings.Container, []string{"sh", "-c", killScript, "sh", SessionIDEnvironmentVariable + "=" + t.sessionID})
+	if err != nil {
+		return fmt.Errorf("failed to kill processes: %s", err)
+	}
+	defer exec.Close()
+	go io.Copy(ioutil.Discard, exec)
+	exited := make(chan struct{})
+	go func() {
+		exec.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(killTimeout):
+		return errors.New("failed to kill processes: timed out")
+	}
+}
+
+// Signal delivers the signals that the tty generates for control
+// characters, SIGINT, SIGQUIT and SIGTSTP, by writing the character to the
+// tty, other signals cannot be delivered through the exec api
+func (t *podTerminal) Signal(sig syscall.Signal) error {
+	character, ok := controlCharacters[sig]
+	if !ok {
+		return fmt.Errorf("failed to signal process: %s cannot be delivered to processes in pods", unix.SignalName(sig))
+	}
+	_, err := t.Write(character)
+	return err
+}
+
+// IsShellInForeground returns true as the processes of the pod cannot be
+// inspected, input is treated as if it was read by the shell
+func (t *podTerminal) IsShellInForeground() bool {
+	return true
+}
+
+// WorkingDirectory returns an empty string as the processes of the pod
+// cannot be inspected
+func (t *podTerminal) WorkingDirectory() string {
+	return ""
+}
+
+// Credential returns an error as the files of the pod cannot be accessed
+// locally
+func (t *podTerminal) Credential() (*syscall.Credential, error) {
+	return nil, errors.New("failed to get credential: the files of sessions in pods cannot be accessed")
+}
+
+// Dial connects to port on the ip address of the pod
+func (t *podTerminal) Dial(ctx context.Context, port int) (net.Conn, error) {
+	t.mutex.Lock()
+	client, pod := t.client, t.pod
+	t.mutex.Unlock()
+	if client == nil {
+		return nil, errors.New("failed to dial port: no process has been started")
+	}
+	address, err := client.PodIP(t.profile.Kubernetes.GetNamespace(), pod)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial port: %s", err)
+	}
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+}
+
+// Close releases all resources held by the terminal, the pod of the
+// workspace of the profile is deleted once no session used it for its
+// idle timeout
+func (t *podTerminal) Close() error {
+	t.Release()
+	t.mutex.Lock()
+	t.releaseWorkspace()
+	t.mutex.Unlock()
+	return t.Kill()
+}
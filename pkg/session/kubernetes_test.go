@@ -0,0 +1,246 @@
+package session
+
+import (
+	"cloudshell/pkg/profile"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testKubernetesAPI is a fake Kubernetes API whose processes write a prompt
+// and run until they are killed by a kill exec, which exits immediately
+type testKubernetesAPI struct {
+	// execs are the pods, containers and commands of the execs
+	execs   []string
+	running []*websocket.Conn
+	// resizes are the window sizes sent to processes
+	resizes []string
+	// listing, when set, receives every request listing pods, which waits
+	// until it is closed
+	listing chan chan struct{}
+	mutex   sync.Mutex
+}
+
+func newTestKubernetesAPI(t *testing.T) (*testKubernetesAPI, string) {
+	t.Helper()
+	api := &testKubernetesAPI{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	contents := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s
+users:
+- name: test
+  user:
+    token: secret
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+`, server.URL)
+	if err := os.WriteFile(kubeconfig, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return api, kubeconfig
+}
+
+func (a *testKubernetesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/api/v1/namespaces/tools/pods" {
+		if a.listing != nil {
+			listed := make(chan struct{})
+			a.listing <- listed
+			<-listed
+		}
+		io.WriteString(w, `{"items": [
+			{"metadata": {"name": "shell-b"}, "status": {"phase": "Running"}},
+			{"metadata": {"name": "shell-a"}, "status": {"phase": "Running"}}
+		]}`)
+		return
+	}
+	pod := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/tools/pods/"), "/exec")
+	command := r.URL.Query()["command"]
+	upgrader := websocket.Upgrader{Subprotocols: []string{"v5.channel.k8s.io"}}
+	connection, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	a.mutex.Lock()
+	a.execs = append(a.execs, pod+" "+r.URL.Query().Get("container")+" "+strings.Join(command, " "))
+	if command[0] == "sh" {
+		// the kill exec ends every process of the session
+		for _, running := range a.running {
+			running.WriteMessage(websocket.BinaryMessage, append([]byte{3}, `{"status": "Failure", "reason": "NonZeroExitCode", "details": {"causes": [{"reason": "ExitCode", "message": "137"}]}}`...))
+			running.Close()
+		}
+		a.running = nil
+		a.mutex.Unlock()
+		connection.WriteMessage(websocket.BinaryMessage, append([]byte{3}, `{"status": "Success"}`...))
+		connection.Close()
+		return
+	}
+	a.running = append(a.running, connection)
+	connection.WriteMessage(websocket.BinaryMessage, append([]byte{1}, "$ "...))
+	a.mutex.Unlock()
+	for {
+		_, message, err := connection.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(message) > 0 && message[0] == 4 {
+			a.mutex.Lock()
+			a.resizes = append(a.resizes, string(message[1:]))
+			a.mutex.Unlock()
+		}
+	}
+}
+
+func newTestPodTerminal(kubeconfig string) *podTerminal {
+	return newPodTerminal("test-session", profile.Profile{
+		Command:   "bash",
+		Arguments: []string{"-l"},
+		Kubernetes: &profile.Kubernetes{
+			Namespace:  "tools",
+			Selector:   "app=shell",
+			Container:  "shell",
+			Kubeconfig: kubeconfig,
+		},
+	})
+}
+
+func TestPodTerminalStart(t *testing.T) {
+	api, kubeconfig := newTestKubernetesAPI(t)
+	tty := newTestPodTerminal(kubeconfig)
+	defer tty.Close()
+	if err := tty.Start(); err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	prompt := make([]byte, 2)
+	if _, err := io.ReadFull(tty, prompt); err != nil || string(prompt) != "$ " {
+		t.Fatalf("expected the output of the process, got '%s' (%v)", prompt, err)
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	expected := "shell-a shell env TERM=xterm-256color " + SessionIDEnvironmentVariable + "=test-session bash -l"
+	if len(api.execs) != 1 || api.execs[0] != expected {
+		t.Errorf("expected exec '%s', got %v", expected, api.execs)
+	}
+}
+
+func TestPodTerminalKill(t *testing.T) {
+	api, kubeconfig := newTestKubernetesAPI(t)
+	tty := newTestPodTerminal(kubeconfig)
+	defer tty.Close()
+	if err := tty.Start(); err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	// the output is a pipe that has to be read for the process to exit
+	go io.Copy(io.Discard, tty)
+	if err := tty.Kill(); err != nil {
+		t.Fatalf("failed to kill process: %s", err)
+	}
+	if exitCode := tty.Wait(); exitCode != 137 {
+		t.Errorf("expected exit code 137, got %d", exitCode)
+	}
+	api.mutex.Lock()
+	expected := "shell-a shell " + strings.Join([]string{"sh", "-c", killScript, "sh", SessionIDEnvironmentVariable + "=test-session"}, " ")
+	if len(api.execs) != 2 || api.execs[1] != expected {
+		t.Fatalf("expected kill exec '%s', got %v", expected, api.execs)
+	}
+	api.mutex.Unlock()
+	// processes that already exited are not killed again
+	if err := tty.Kill(); err != nil {
+		t.Fatalf("failed to kill process: %s", err)
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	if len(api.execs) != 2 {
+		t.Errorf("expected no further exec, got %v", api.execs)
+	}
+}
+
+func TestPodTerminalStartDoesNotBlock(t *testing.T) {
+	api, kubeconfig := newTestKubernetesAPI(t)
+	api.listing = make(chan chan struct{}, 1)
+	tty := newTestPodTerminal(kubeconfig)
+	defer tty.Close()
+	started := make(chan error, 1)
+	go func() { started <- tty.Start() }()
+	listed := <-api.listing
+	// the terminal can be used while the api is slow to find the pod
+	resized := make(chan error, 1)
+	go func() { resized <- tty.Resize(40, 120) }()
+	select {
+	case err := <-resized:
+		if err != nil {
+			t.Fatalf("failed to resize: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected resizing not to wait for the process to start")
+	}
+	close(listed)
+	if err := <-started; err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	prompt := make([]byte, 2)
+	if _, err := io.ReadFull(tty, prompt); err != nil {
+		t.Fatalf("expected the output of the process: %s", err)
+	}
+	// the window size set while starting is applied once the process runs
+	expected := `{"Height":24,"Width":80},{"Height":40,"Width":120}`
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		api.mutex.Lock()
+		resizes := strings.Join(api.resizes, ",")
+		api.mutex.Unlock()
+		if resizes == expected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected resizes '%s', got '%s'", expected, resizes)
+		}
+	}
+}
+
+func TestPodTerminalReleasedWhileStarting(t *testing.T) {
+	api, kubeconfig := newTestKubernetesAPI(t)
+	api.listing = make(chan chan struct{}, 1)
+	tty := newTestPodTerminal(kubeconfig)
+	defer tty.Close()
+	started := make(chan error, 1)
+	go func() { started <- tty.Start() }()
+	listed := <-api.listing
+	released := make(chan error, 1)
+	go func() { released <- tty.Release() }()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected releasing not to wait for the process to start")
+	}
+	close(listed)
+	if err := <-started; err == nil {
+		t.Fatal("expected the process started after the tty was released to be rejected")
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	if len(api.execs) != 2 || !strings.HasPrefix(api.execs[1], "shell-a shell sh -c") || len(api.running) != 0 {
+		t.Errorf("expected the process to be killed, got %v", api.execs)
+	}
+}
@@ -0,0 +1,61 @@
+package session
+
+import (
+	"cloudshell/internal/log"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// OriginChecker rejects requests sent by browsers from pages that are not
+// served from one of the allowed hostnames, so that other websites cannot
+// make the browsers of users change their sessions
+type OriginChecker struct {
+	allowedHostnames []string
+	mutex            sync.RWMutex
+}
+
+// NewOriginChecker returns a checker allowing requests from pages served
+// from allowedHostnames
+func NewOriginChecker(allowedHostnames []string) *OriginChecker {
+	return &OriginChecker{allowedHostnames: allowedHostnames}
+}
+
+// Update replaces the allowed hostnames
+func (c *OriginChecker) Update(allowedHostnames []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.allowedHostnames = allowedHostnames
+}
+
+// Middleware returns a http middleware that rejects requests whose `Origin`
+// header is not one of the allowed hostnames, requests without the header
+// are not sent by browsers on behalf of other websites and are passed on
+func (c *OriginChecker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && !c.isAllowed(origin) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("requests from origin '%s' are not allowed", origin))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAllowed returns true when the hostname of origin is allowed
+func (c *OriginChecker) isAllowed(origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Hostname() == "" {
+		log.Warnf("failed to parse origin '%s'", origin)
+		return false
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, allowedHostname := range c.allowedHostnames {
+		if parsed.Hostname() == allowedHostname {
+			return true
+		}
+	}
+	return false
+}
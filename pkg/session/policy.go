@@ -0,0 +1,138 @@
+package session
+
+import (
+	"cloudshell/internal/constants"
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/policy"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// noticeWarning is the style of notices about warnings and confirmations
+	noticeWarning = "\x1b[1;33m"
+	// noticeError is the style of notices about discarded commands
+	noticeError = "\x1b[1;31m"
+	// noticeReset resets the style after a notice
+	noticeReset = "\x1b[0m"
+)
+
+// confirmation is the state of a line waiting for the user to confirm it
+type confirmation struct {
+	rule policy.Rule
+	line audit.Line
+	// enter is the key that submits the line once it is confirmed
+	enter []byte
+	typed []rune
+	// sequence is true while skipping an escape sequence sent by a key
+	sequence bool
+}
+
+// enforce applies the policy to the line being edited before the enter key
+// submitting it is sent to the shell and returns the input to send instead
+// of the key
+func (a *commandAuditor) enforce(enter []byte) []byte {
+	line := a.editor.Current()
+	if a.policy == nil || line.Text == "" || !a.session.tty.IsShellInForeground() {
+		a.submitted(a.editor.Write(enter))
+		return enter
+	}
+	rule, matched := a.policy.Evaluate(a.session.Profile.Name, line.Text)
+	if !matched {
+		a.submitted(a.editor.Write(enter))
+		return enter
+	}
+	switch rule.Action {
+	case policy.ActionWarn:
+		a.session.notice(noticeWarning, fmt.Sprintf("cloudshell: warning from policy rule '%s'%s", rule.Name, formatRuleMessage(rule)), false)
+		a.decided(rule, line, audit.DecisionWarned)
+		a.submitted(a.editor.Write(enter))
+		return enter
+	case policy.ActionConfirm:
+		a.session.notice(noticeWarning, fmt.Sprintf("cloudshell: policy rule '%s' requires confirmation%s, type '%s' to run the command: ", rule.Name, formatRuleMessage(rule), rule.Confirmation), false)
+		a.decided(rule, line, audit.DecisionConfirmationRequired)
+		a.confirmation = &confirmation{rule: rule, line: line, enter: append([]byte{}, enter...)}
+		return nil
+	}
+	a.session.notice(noticeError, fmt.Sprintf("cloudshell: command denied by policy rule '%s'%s", rule.Name, formatRuleMessage(rule)), true)
+	a.decided(rule, line, audit.DecisionDenied)
+	a.editor.Reset()
+	return constants.KeySeqSigInt
+}
+
+// confirm processes the input typed while a confirmation is pending and
+// returns the number of bytes of p it consumed and the input to send to
+// the shell once the confirmation ended
+func (a *commandAuditor) confirm(p []byte) (int, []byte) {
+	c := a.confirmation
+	for index := 0; index < len(p); {
+		r, size := utf8.DecodeRune(p[index:])
+		index += size
+		switch {
+		case c.sequence:
+			// escape sequences end with a letter or a tilde
+			c.sequence = r == '[' || r == 'O' || (r < '@' || r > '~')
+		case r == 0x1b:
+			c.sequence = true
+		case r == '\r' || r == '\n':
+			a.confirmation = nil
+			if string(c.typed) != c.rule.Confirmation {
+				return index, a.reject(c)
+			}
+			a.decided(c.rule, c.line, audit.DecisionConfirmed)
+			a.submitted(a.editor.Write(c.enter))
+			return index, c.enter
+		case r == 0x03:
+			a.confirmation = nil
+			return index, a.reject(c)
+		case r == 0x7f || r == 0x08:
+			if len(c.typed) > 0 {
+				c.typed = c.typed[:len(c.typed)-1]
+				a.session.display([]byte("\b \b"))
+			}
+		case unicode.IsPrint(r) && r != utf8.RuneError:
+			c.typed = append(c.typed, r)
+			a.session.display([]byte(string(r)))
+		}
+	}
+	return len(p), nil
+}
+
+// reject discards the line whose confirmation did not match
+func (a *commandAuditor) reject(c *confirmation) []byte {
+	a.session.notice(noticeError, fmt.Sprintf("cloudshell: command not confirmed, discarded by policy rule '%s'", c.rule.Name), true)
+	a.decided(c.rule, c.line, audit.DecisionRejected)
+	a.editor.Reset()
+	return constants.KeySeqSigInt
+}
+
+// decided reports a decision of the policy about line
+func (a *commandAuditor) decided(rule policy.Rule, line audit.Line, decision string) {
+	a.write(audit.Event{
+		Type:        audit.EventTypePolicy,
+		Cwd:         a.session.workingDirectory(),
+		Command:     line.Text,
+		Source:      audit.SourceInput,
+		Approximate: line.Approximate,
+		Rule:        rule.Name,
+		Decision:    decision,
+	})
+}
+
+func formatRuleMessage(rule policy.Rule) string {
+	if rule.Message == "" {
+		return ""
+	}
+	return ": " + rule.Message
+}
+
+// notice displays message on a line of its own below the line being
+// edited, it is called with the lock of the session held
+func (s *Session) notice(style, message string, endLine bool) {
+	text := "\r\n" + style + message + noticeReset
+	if endLine {
+		text += "\r\n"
+	}
+	s.display([]byte(text))
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/policy"
+	"reflect"
+	"testing"
+)
+
+// newTestPolicy returns a policy denying the recursive removal of / and
+// requiring confirmation to delete namespaces
+func newTestPolicy(t *testing.T) *policy.Policy {
+	commandPolicy, err := policy.New(
+		policy.Rule{Name: "no-root-rm", Pattern: `\brm -[a-zA-Z]*r[a-zA-Z]* /( |$)`, Action: policy.ActionDeny},
+		policy.Rule{Name: "delete-namespace", Pattern: `^kubectl( .*)? delete (ns|namespace)\b`, Action: policy.ActionConfirm, Confirmation: "delete"},
+		policy.Rule{Name: "sudo", Pattern: `^sudo\b`, Action: policy.ActionWarn},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commandPolicy
+}
+
+// decisions returns the decisions of the policy events of sink
+func decisions(sink *testSink) []string {
+	decisions := []string{}
+	for _, event := range sink.events {
+		if event.Type == audit.EventTypePolicy {
+			decisions = append(decisions, event.Rule+":"+event.Decision)
+		}
+	}
+	return decisions
+}
+
+func TestPolicyEnforcement(t *testing.T) {
+	tests := []struct {
+		name string
+		// writes are sent to the session one after another
+		writes []string
+		// sent is the input that reaches the shell
+		sent      string
+		decisions []string
+		commands  []audit.Line
+	}{
+		{"allowed", []string{"ls /\r"}, "ls /\r", []string{}, []audit.Line{{Text: "ls /"}}},
+		{"denied", []string{"rm -rf /\r"}, "rm -rf /\x03", []string{"no-root-rm:denied"}, []audit.Line{}},
+		{"denied with newline", []string{"rm -rf /\n"}, "rm -rf /\x03", []string{"no-root-rm:denied"}, []audit.Line{}},
+		{"denied split", []string{"rm -r", "f /", "\r"}, "rm -rf /\x03", []string{"no-root-rm:denied"}, []audit.Line{}},
+		{"denied after editing", []string{"rm -rf x\x7f/", "\x1b[D\x1b[D \x1b[F\r"}, "rm -rf x\x7f/\x1b[D\x1b[D \x1b[F\x03", []string{"no-root-rm:denied"}, []audit.Line{}},
+		{"denied with extra whitespace", []string{"rm   -rf   /\r"}, "rm   -rf   /\x03", []string{"no-root-rm:denied"}, []audit.Line{}},
+		{"denied typed ahead", []string{"ls\rrm -rf /\rpwd\r"}, "ls\rrm -rf /\x03pwd\r", []string{"no-root-rm:denied"}, []audit.Line{{Text: "ls"}, {Text: "pwd", Approximate: true}}},
+		{"denied in paste", []string{"\x1b[200~echo\rrm -rf /\r\x1b[201~"}, "\x1b[200~echo\rrm -rf /\x03\x1b[201~", []string{"no-root-rm:denied"}, []audit.Line{{Text: "echo"}}},
+		{"denied line is discarded", []string{"rm -rf /\r", "\r"}, "rm -rf /\x03\r", []string{"no-root-rm:denied"}, []audit.Line{}},
+		{"warned", []string{"sudo ls\r"}, "sudo ls\r", []string{"sudo:warned"}, []audit.Line{{Text: "sudo ls"}}},
+		{"confirmed", []string{"kubectl delete ns prod\r", "delete\r"}, "kubectl delete ns prod\r", []string{"delete-namespace:confirmation_required", "delete-namespace:confirmed"}, []audit.Line{{Text: "kubectl delete ns prod"}}},
+		{"confirmed with newline", []string{"kubectl delete ns prod\n", "delete\n"}, "kubectl delete ns prod\n", []string{"delete-namespace:confirmation_required", "delete-namespace:confirmed"}, []audit.Line{{Text: "kubectl delete ns prod"}}},
+		{"confirmed after correction", []string{"kubectl delete ns prod\r", "delx\x7f", "ete\r"}, "kubectl delete ns prod\r", []string{"delete-namespace:confirmation_required", "delete-namespace:confirmed"}, []audit.Line{{Text: "kubectl delete ns prod"}}},
+		{"confirmation ignores escape sequences", []string{"kubectl delete ns prod\r", "del\x1b[Dete\r"}, "kubectl delete ns prod\r", []string{"delete-namespace:confirmation_required", "delete-namespace:confirmed"}, []audit.Line{{Text: "kubectl delete ns prod"}}},
+		{"rejected", []string{"kubectl delete ns prod\r", "yes\r"}, "kubectl delete ns prod\x03", []string{"delete-namespace:confirmation_required", "delete-namespace:rejected"}, []audit.Line{}},
+		{"rejected with ctrl-c", []string{"kubectl delete ns prod\r", "dele\x03"}, "kubectl delete ns prod\x03", []string{"delete-namespace:confirmation_required", "delete-namespace:rejected"}, []audit.Line{}},
+		{"input after rejection", []string{"kubectl delete ns prod\r", "no\rls\r"}, "kubectl delete ns prod\x03ls\r", []string{"delete-namespace:confirmation_required", "delete-namespace:rejected"}, []audit.Line{{Text: "ls"}}},
+		// the confirmation has to be typed after it was asked for
+		{"confirmation typed ahead", []string{"kubectl delete ns prod\rdelete\r"}, "kubectl delete ns prod", []string{"delete-namespace:confirmation_required"}, []audit.Line{}},
+		{"confirmation pasted", []string{"\x1b[200~kubectl delete ns prod\rdelete\r\x1b[201~"}, "\x1b[200~kubectl delete ns prod\x1b[201~", []string{"delete-namespace:confirmation_required"}, []audit.Line{}},
+	}
+	for _, test := range tests {
+		sink := &testSink{}
+		session, tty := newTestAuditedSession(Options{Audit: sink, Policy: newTestPolicy(t)})
+		for _, write := range test.writes {
+			if _, err := session.Write([]byte(write)); err != nil {
+				t.Fatalf("%s: failed to write: %s", test.name, err)
+			}
+		}
+		if sent := tty.sentInput(); sent != test.sent {
+			t.Errorf("%s: expected %q to be sent to the shell, got %q", test.name, test.sent, sent)
+		}
+		if !reflect.DeepEqual(decisions(sink), test.decisions) {
+			t.Errorf("%s: expected decisions %q, got %q", test.name, test.decisions, decisions(sink))
+		}
+		if !reflect.DeepEqual(commands(sink), test.commands) {
+			t.Errorf("%s: expected commands %+v, got %+v", test.name, test.commands, commands(sink))
+		}
+	}
+}
+
+func TestPolicyConfirmationIsDisplayed(t *testing.T) {
+	session, _ := newTestAuditedSession(Options{Policy: newTestPolicy(t)})
+	subscription := session.Subscribe()
+	session.Write([]byte("kubectl delete ns prod\r"))
+	session.Write([]byte("dx\x7f"))
+	session.Write([]byte("elete\r"))
+	output := []byte{}
+	for len(subscription.Output()) > 0 {
+		output = append(output, <-subscription.Output()...)
+	}
+	expected := "\r\n" + noticeWarning + "cloudshell: policy rule 'delete-namespace' requires confirmation, type 'delete' to run the command: " + noticeReset + "dx\b \belete"
+	if string(output) != expected {
+		t.Errorf("expected the confirmation prompt and the typed confirmation %q, got %q", expected, output)
+	}
+}
+
+func TestPolicyIgnoresInputToPrograms(t *testing.T) {
+	session, tty := newTestAuditedSession(Options{Policy: newTestPolicy(t)})
+	// input to full screen programs is not checked
+	session.broadcast([]byte("\x1b[?1049h"))
+	session.Write([]byte("rm -rf /\r"))
+	session.broadcast([]byte("\x1b[?1049l"))
+	// lines read by programs other than the shell are not checked
+	tty.shellInForeground = false
+	session.Write([]byte("rm -rf /\r"))
+	if sent := tty.sentInput(); sent != "rm -rf /\rrm -rf /\r" {
+		t.Errorf("expected the input to be sent as is, got %q", sent)
+	}
+	tty.shellInForeground = true
+	session.Write([]byte("rm -rf /\r"))
+	if sent := tty.sentInput(); sent != "rm -rf /\x03" {
+		t.Errorf("expected the command to be denied once the shell reads input, got %q", sent)
+	}
+}
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	First int
+	Last  int
+}
+
+// ParsePortRange parses a range in the format `<first>-<last>` or a single
+// port, an empty value returns an empty range
+func ParsePortRange(value string) (PortRange, error) {
+	if value == "" {
+		return PortRange{}, nil
+	}
+	bounds := strings.SplitN(value, "-", 2)
+	if len(bounds) == 1 {
+		bounds = append(bounds, bounds[0])
+	}
+	first, err := parsePort(bounds[0])
+	if err != nil {
+		return PortRange{}, err
+	}
+	last, err := parsePort(bounds[1])
+	if err != nil {
+		return PortRange{}, err
+	}
+	if first > last {
+		return PortRange{}, fmt.Errorf("port range '%s' ends before it starts", value)
+	}
+	return PortRange{First: first, Last: last}, nil
+}
+
+// IsEmpty returns true when the range does not contain any port
+func (r PortRange) IsEmpty() bool {
+	return r.First == 0 && r.Last == 0
+}
+
+// Contains returns true when port is within the range
+func (r PortRange) Contains(port int) bool {
+	return !r.IsEmpty() && port >= r.First && port <= r.Last
+}
+
+// String returns the range in the format accepted by ParsePortRange
+func (r PortRange) String() string {
+	if r.IsEmpty() {
+		return ""
+	}
+	return fmt.Sprintf("%v-%v", r.First, r.Last)
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port '%s' is not a number between 1 and 65535", value)
+	}
+	return port, nil
+}
+
+// DialPort connects to port on localhost in the network that the process
+// of the session runs in
+func (s *Session) DialPort(ctx context.Context, port int) (net.Conn, error) {
+	return s.tty.Dial(ctx, port)
+}
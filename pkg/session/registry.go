@@ -0,0 +1,105 @@
+package session
+
+import (
+	"cloudshell/pkg/profile"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// LimitError is returned when creating a session would exceed the session
+// limit of its profile
+type LimitError struct {
+	Profile string
+	Limit   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("profile '%s' has reached its limit of %v session(s)", e.Profile, e.Limit)
+}
+
+// Registry holds the active sessions, sessions are removed from the
+// registry when they end
+type Registry struct {
+	counts   map[string]int
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewRegistry returns an empty session registry
+func NewRegistry() *Registry {
+	return &Registry{
+		counts:   map[string]int{},
+		sessions: map[string]*Session{},
+	}
+}
+
+// Create starts a session running selectedProfile and adds it to the
+// registry, a *LimitError is returned if the profile already has as many
+// sessions as its limits allow
+func (r *Registry) Create(id string, selectedProfile profile.Profile, opts Options) (*Session, error) {
+	if err := r.acquire(id, selectedProfile); err != nil {
+		return nil, err
+	}
+	session, err := New(id, selectedProfile, opts)
+	if err != nil {
+		r.release(id, selectedProfile.Name)
+		return nil, err
+	}
+	r.mutex.Lock()
+	r.sessions[id] = session
+	r.mutex.Unlock()
+	go func() {
+		<-session.Done()
+		r.release(id, selectedProfile.Name)
+	}()
+	return session, nil
+}
+
+// acquire reserves a session for selectedProfile
+func (r *Registry) acquire(id string, selectedProfile profile.Profile) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.sessions[id]; ok {
+		return fmt.Errorf("session '%s' already exists", id)
+	}
+	limit := selectedProfile.Limits.MaxSessions
+	if limit > 0 && r.counts[selectedProfile.Name] >= limit {
+		return &LimitError{Profile: selectedProfile.Name, Limit: limit}
+	}
+	r.counts[selectedProfile.Name]++
+	return nil
+}
+
+// release removes the session identified by id and frees its reservation
+func (r *Registry) release(id, profileName string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sessions, id)
+	r.counts[profileName]--
+	if r.counts[profileName] <= 0 {
+		delete(r.counts, profileName)
+	}
+}
+
+// Get returns the active session identified by id
+func (r *Registry) Get(id string) (*Session, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	session, ok := r.sessions[id]
+	return session, ok
+}
+
+// List returns the active sessions ordered by their creation time
+func (r *Registry) List() []*Session {
+	r.mutex.RLock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	r.mutex.RUnlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions
+}
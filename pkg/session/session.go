@@ -0,0 +1,486 @@
+package session
+
+import (
+	"cloudshell/internal/log"
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/auth"
+	"cloudshell/pkg/clipboard"
+	"cloudshell/pkg/policy"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/recording"
+	"cloudshell/pkg/redact"
+	"cloudshell/pkg/shellintegration"
+	"cloudshell/pkg/vt"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultMaxBufferSizeBytes is the size of reads from the tty used when
+	// Options.MaxBufferSizeBytes is not specified
+	DefaultMaxBufferSizeBytes = 512
+	// DefaultScrollbackLines is the number of lines that scrolled off the
+	// screen that are kept for new attachments when Options.ScrollbackLines
+	// is not specified
+	DefaultScrollbackLines = 1000
+)
+
+// SessionIDEnvironmentVariable is the environment variable identifying the
+// session to the processes running in it
+const SessionIDEnvironmentVariable = "CLOUDSHELL_SESSION_ID"
+
+// ErrClosed is returned when using a session that has ended
+var ErrClosed = errors.New("session has ended")
+
+// Options configures a session
+type Options struct {
+	// Audit when specified receives the commands executed in the session
+	Audit audit.Sink
+	// Headless indicates that the session was not created by a connection
+	// and should keep running when its attachments disconnect
+	Headless bool
+	// Logger when specified is used to log the activity of the session
+	Logger log.Logger
+	// MaxBufferSizeBytes is the maximum size of each read from the tty
+	MaxBufferSizeBytes int
+	// Owner is the name of the principal that created the session, an empty
+	// owner means anyone can access the session
+	Owner string
+	// Policy when specified decides whether the commands entered into the
+	// session may be submitted to the shell
+	Policy *policy.Policy
+	// RecordingsDirectory when specified is the directory that the output
+	// of the session is recorded to
+	RecordingsDirectory string
+	// Redactor when specified removes secrets from the recorded output and
+	// the audited commands of the session
+	Redactor *redact.Redactor
+	// ScrollbackLines is the number of lines that scrolled off the screen
+	// that are sent to new attachments
+	ScrollbackLines int
+	// ZModemMaxSizeBytes when greater than 0 enables handing ZMODEM
+	// transfers started by `sz` and `rz` to the browser and is the maximum
+	// size of each file transferred
+	ZModemMaxSizeBytes int64
+}
+
+// Session is a process running in a tty whose output is distributed to any
+// number of subscribers and whose input can come from any number of sources
+type Session struct {
+	// ID uniquely identifies the session
+	ID string
+	// Profile is the profile the session was started with
+	Profile profile.Profile
+	// Owner is the name of the principal that created the session
+	Owner string
+	// CreatedAt is the time the session was started
+	CreatedAt time.Time
+	// Headless indicates that the session keeps running when its
+	// attachments disconnect
+	Headless bool
+
+	// attachments is the number of open subscriptions returned by Attach,
+	// sessions that are not headless end when it drops to zero
+	attachments int
+	auditor     *commandAuditor
+	// clipboard removes the sequences that copy to the clipboard from the
+	// output
+	clipboard *clipboard.Filter
+	// commands are the most recent commands reported by the shell
+	commands  []Command
+	done      chan struct{}
+	exitCode  int
+	hasExited bool
+	log       log.Logger
+	mutex     sync.Mutex
+	recorder  *recording.Recorder
+	// redactor when not nil removes secrets from the lines of the session
+	// returned by Lines
+	redactor *redact.Redactor
+	// screen models the screen of the terminal so that new attachments can
+	// be brought to its current state
+	screen      *vt.Screen
+	stop        chan struct{}
+	stopOnce    sync.Once
+	subscribers map[*Subscription]struct{}
+	tty         Backend
+	// zmodem when not nil hands ZMODEM transfers to the browser
+	zmodem *zmodemBridge
+}
+
+// New starts a session running selectedProfile
+func New(id string, selectedProfile profile.Profile, opts Options) (*Session, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.WithField("session_id", id)
+	}
+	maxBufferSizeBytes := opts.MaxBufferSizeBytes
+	if maxBufferSizeBytes <= 0 {
+		maxBufferSizeBytes = DefaultMaxBufferSizeBytes
+	}
+	scrollbackLines := opts.ScrollbackLines
+	if scrollbackLines <= 0 {
+		scrollbackLines = DefaultScrollbackLines
+	}
+	tty, err := NewBackend(id, selectedProfile)
+	if err != nil {
+		return nil, err
+	}
+	var recorder *recording.Recorder
+	if opts.RecordingsDirectory != "" {
+		recorder, err = recording.Create(opts.RecordingsDirectory, recording.Header{
+			Width:     vt.DefaultCols,
+			Height:    vt.DefaultRows,
+			Command:   strings.Join(append([]string{selectedProfile.Command}, selectedProfile.Arguments...), " "),
+			Title:     selectedProfile.Name,
+			SessionID: id,
+			Profile:   selectedProfile.Name,
+			Owner:     opts.Owner,
+		}, opts.Redactor)
+		if err != nil {
+			tty.Close()
+			return nil, err
+		}
+	}
+	if err := tty.Start(); err != nil {
+		tty.Close()
+		if recorder != nil {
+			recorder.Close()
+		}
+		return nil, fmt.Errorf("failed to start process: %s", err)
+	}
+	session := &Session{
+		ID:          id,
+		Profile:     selectedProfile,
+		Owner:       opts.Owner,
+		CreatedAt:   time.Now(),
+		Headless:    opts.Headless,
+		clipboard:   clipboard.NewFilter(selectedProfile.Clipboard.GetMaxSizeBytes()),
+		done:        make(chan struct{}),
+		exitCode:    -1,
+		log:         logger,
+		recorder:    recorder,
+		redactor:    opts.Redactor,
+		screen:      vt.New(vt.DefaultRows, vt.DefaultCols, scrollbackLines),
+		stop:        make(chan struct{}),
+		subscribers: map[*Subscription]struct{}{},
+		tty:         tty,
+	}
+	// shell integration is set up by starting the shell locally
+	shellIntegration := selectedProfile.ShellIntegration && selectedProfile.IsLocal() && shellintegration.Supports(selectedProfile.Command, selectedProfile.Arguments)
+	if selectedProfile.ShellIntegration && !shellIntegration {
+		logger.Warnf("shell integration is not supported for '%s' with arguments ['%s']", selectedProfile.Command, strings.Join(selectedProfile.Arguments, "', '"))
+	}
+	if opts.Audit != nil || opts.Policy != nil {
+		session.auditor = newCommandAuditor(session, opts, shellIntegration)
+	}
+	if opts.ZModemMaxSizeBytes > 0 {
+		session.zmodem = newZModemBridge(session, opts.ZModemMaxSizeBytes)
+	}
+	session.screen.OnMark(session.mark)
+	go session.supervise()
+	go session.pump(maxBufferSizeBytes)
+	return session, nil
+}
+
+// supervise waits for the process to exit and restarts it according to
+// the respawn policy of the profile
+func (s *Session) supervise() {
+	respawn := s.Profile.Respawn
+	restarts := 0
+	for {
+		startedAt := time.Now()
+		exitCode := s.tty.Wait()
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		s.log.Infof("process exited with status %v", exitCode)
+		if time.Now().Sub(startedAt) > respawn.GetBackoff(restarts) {
+			restarts = 0
+		}
+		if !respawn.ShouldRespawn(exitCode, restarts) {
+			s.mutex.Lock()
+			s.exitCode = exitCode
+			s.hasExited = true
+			s.mutex.Unlock()
+			// releasing the tty causes the output pump to end the session
+			// after distributing the remaining output
+			if err := s.tty.Release(); err != nil {
+				s.log.Warnf("failed to release tty: %s", err)
+			}
+			return
+		}
+		backoff := respawn.GetBackoff(restarts)
+		restarts++
+		separator := fmt.Sprintf("\r\n\x1b[2m----- process exited with status %v, restarting in %v (restart %v) -----\x1b[0m\r\n", exitCode, backoff, restarts)
+		s.broadcast([]byte(separator))
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(backoff):
+		}
+		s.log.Infof("restarting process (restart %v)...", restarts)
+		if err := s.tty.Start(); err != nil {
+			s.log.Warnf("failed to restart process: %s", err)
+			s.broadcast([]byte(fmt.Sprintf("failed to restart process: %s\r\n", err)))
+			s.Close()
+			return
+		}
+	}
+}
+
+// pump distributes the output of the tty to subscribers until the tty
+// can no longer be read from
+func (s *Session) pump(maxBufferSizeBytes int) {
+	for {
+		buffer := make([]byte, maxBufferSizeBytes)
+		readLength, err := s.tty.Read(buffer)
+		if err != nil {
+			s.log.Debugf("stopped reading from tty: %s", err)
+			s.Close()
+			return
+		}
+		s.broadcast(buffer[:readLength])
+	}
+}
+
+// broadcast sends the output of the tty to all subscribers and applies it
+// to the screen
+func (s *Session) broadcast(data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.auditor != nil {
+		s.auditor.output()
+	}
+	if s.zmodem != nil {
+		// the data of transfers is not displayed
+		if data = s.zmodem.output(data); len(data) == 0 {
+			return
+		}
+	}
+	data, copies := s.clipboard.Write(data)
+	for _, c := range copies {
+		s.copied(c)
+	}
+	if len(data) == 0 {
+		return
+	}
+	s.display(data)
+}
+
+// display sends data to all subscribers, applies it to the screen and
+// records it, it is called with the lock of the session held
+func (s *Session) display(data []byte) {
+	s.screen.Write(data)
+	if s.recorder != nil {
+		if err := s.recorder.Output(data); err != nil {
+			s.log.Warnf("failed to record output: %s", err)
+		}
+	}
+	for subscription := range s.subscribers {
+		if !subscription.send(data) {
+			s.log.Warn("closing subscription that is not keeping up with output")
+			if s.remove(subscription) {
+				// closing the session takes the lock that is held here
+				s.log.Info("last attachment dropped, ending session...")
+				go s.Close()
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription receiving the output of the session
+// from this point on
+func (s *Session) Subscribe() *Subscription {
+	return s.subscribe(false)
+}
+
+// Attach returns a subscription that first receives a snapshot of the
+// screen and scrollback of the session followed by its output from this
+// point on, sessions that are not headless end once the last subscription
+// returned by Attach is closed
+func (s *Session) Attach() *Subscription {
+	return s.subscribe(true)
+}
+
+// subscribe returns a new subscription, attachments receive a snapshot
+// first and are counted
+func (s *Session) subscribe(attachment bool) *Subscription {
+	subscription := newSubscription(s)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if attachment {
+		subscription.send(s.screen.Snapshot())
+	}
+	select {
+	case <-s.done:
+		subscription.close()
+		return subscription
+	default:
+	}
+	s.subscribers[subscription] = struct{}{}
+	if attachment {
+		subscription.attachment = true
+		s.attachments++
+	}
+	return subscription
+}
+
+// unsubscribe stops sending output to subscription and ends sessions that
+// are not headless when it was their last attachment
+func (s *Session) unsubscribe(subscription *Subscription) {
+	s.mutex.Lock()
+	lastAttachment := s.remove(subscription)
+	s.mutex.Unlock()
+	if lastAttachment {
+		s.log.Info("last attachment closed, ending session...")
+		s.Close()
+	}
+}
+
+// remove stops sending output to subscription and returns true if it was
+// the last attachment of a session that is not headless, subscriptions
+// that were already removed are not counted again; this must be called
+// with the mutex held
+func (s *Session) remove(subscription *Subscription) bool {
+	if _, ok := s.subscribers[subscription]; !ok {
+		return false
+	}
+	delete(s.subscribers, subscription)
+	subscription.close()
+	if !subscription.attachment {
+		return false
+	}
+	s.attachments--
+	return s.attachments == 0 && !s.Headless
+}
+
+// Write implements io.Writer by writing input to the tty
+func (s *Session) Write(p []byte) (int, error) {
+	select {
+	case <-s.done:
+		return 0, ErrClosed
+	default:
+	}
+	if s.auditor == nil && s.zmodem == nil {
+		return s.tty.Write(p)
+	}
+	s.mutex.Lock()
+	forwarded := p
+	if s.zmodem != nil && s.zmodem.input(p) {
+		forwarded = nil
+	} else if s.auditor != nil {
+		forwarded = s.auditor.input(p)
+	}
+	s.mutex.Unlock()
+	if len(forwarded) == 0 {
+		return len(p), nil
+	}
+	if _, err := s.tty.Write(forwarded); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize sets the window size of the tty
+func (s *Session) Resize(rows, cols uint16) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.tty.Resize(rows, cols); err != nil {
+		return err
+	}
+	if currentRows, currentCols := s.screen.Size(); int(rows) == currentRows && int(cols) == currentCols {
+		return nil
+	}
+	s.screen.Resize(int(rows), int(cols))
+	if s.recorder != nil {
+		if err := s.recorder.Resize(int(rows), int(cols)); err != nil {
+			s.log.Warnf("failed to record resize: %s", err)
+		}
+	}
+	return nil
+}
+
+// Lines returns the text of the scrollback and screen of the session with
+// secrets redacted like in recordings, secrets spanning several lines are
+// replaced by a single line
+func (s *Session) Lines() []string {
+	s.mutex.Lock()
+	lines := s.screen.Lines()
+	s.mutex.Unlock()
+	if s.redactor == nil {
+		return lines
+	}
+	return strings.Split(s.redactor.String(strings.Join(lines, "\n")), "\n")
+}
+
+// Size returns the number of rows and columns of the tty
+func (s *Session) Size() (int, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.screen.Size()
+}
+
+// Signal delivers sig to the foreground process of the tty
+func (s *Session) Signal(sig syscall.Signal) error {
+	return s.tty.Signal(sig)
+}
+
+// Done returns a channel that is closed when the session ends
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// ExitCode returns the exit status of the process and whether the process
+// exited on its own, it is only available after the session ends
+func (s *Session) ExitCode() (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.exitCode, s.hasExited
+}
+
+// IsAccessibleBy returns true if principal is allowed to use the session
+func (s *Session) IsAccessibleBy(principal *auth.Principal) bool {
+	if s.Owner == "" {
+		return true
+	}
+	return principal != nil && principal.Name == s.Owner
+}
+
+// Close ends the session by terminating the process and releasing the tty,
+// all subscriptions are closed
+func (s *Session) Close() error {
+	var err error
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.log.Info("gracefully stopping spawned tty...")
+		if killErr := s.tty.Kill(); killErr != nil {
+			s.log.Warnf("failed to kill process: %s", killErr)
+		}
+		if err = s.tty.Close(); err != nil {
+			s.log.Warnf("failed to close spawned tty gracefully: %s", err)
+		}
+		s.mutex.Lock()
+		for subscription := range s.subscribers {
+			subscription.close()
+		}
+		s.subscribers = map[*Subscription]struct{}{}
+		if s.zmodem != nil {
+			s.zmodem.close()
+		}
+		if s.recorder != nil {
+			if err := s.recorder.Close(); err != nil {
+				s.log.Warnf("failed to close recording: %s", err)
+			}
+		}
+		close(s.done)
+		s.mutex.Unlock()
+	})
+	return err
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"cloudshell/pkg/profile"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// newTestSession starts a session running yes which produces output faster
+// than subscribers that never read can take it
+func newTestSession(t *testing.T, headless bool) *Session {
+	command, err := exec.LookPath("yes")
+	if err != nil {
+		t.Skip("yes is not available")
+	}
+	session, err := New("test-session", profile.Profile{Name: "yes", Command: command}, Options{Headless: headless})
+	if err != nil {
+		t.Fatalf("failed to start session: %s", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+// waitForDrop waits until subscription has been dropped by the session
+// without reading from it
+func waitForDrop(t *testing.T, session *Session, subscription *Subscription) {
+	timeout := time.After(10 * time.Second)
+	for {
+		session.mutex.Lock()
+		_, subscribed := session.subscribers[subscription]
+		session.mutex.Unlock()
+		if !subscribed {
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatal("expected the subscription that does not read to be dropped")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestSessionEndsWhenLastAttachmentIsDropped(t *testing.T) {
+	session := newTestSession(t, false)
+	// the attachment never reads and is dropped once its backlog is full
+	session.Attach()
+	select {
+	case <-session.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the session to end once its only attachment was dropped")
+	}
+}
+
+func TestSessionKeepsRunningWhileAttached(t *testing.T) {
+	session := newTestSession(t, false)
+	dropped := session.Attach()
+	attached := session.Attach()
+	go func() {
+		for range attached.Output() {
+		}
+	}()
+	waitForDrop(t, session, dropped)
+	// closing the dropped attachment must not count it a second time
+	dropped.Close()
+	select {
+	case <-session.Done():
+		t.Fatal("expected the session to keep running while an attachment remains")
+	case <-time.After(200 * time.Millisecond):
+	}
+	attached.Close()
+	select {
+	case <-session.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the session to end with its last attachment")
+	}
+}
+
+func TestHeadlessSessionKeepsRunningWhenAttachmentIsDropped(t *testing.T) {
+	session := newTestSession(t, true)
+	subscription := session.Attach()
+	waitForDrop(t, session, subscription)
+	select {
+	case <-session.Done():
+		t.Fatal("expected the headless session to keep running")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,383 @@
+package session
+
+import (
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/vt"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/sys/unix"
+)
+
+// sshTerminal is a terminal on a remote host that processes for a profile
+// are (re)started on through ssh
+type sshTerminal struct {
+	profile profile.Profile
+	// sessionID identifies the session to processes through the
+	// CLOUDSHELL_SESSION_ID environment variable when the host accepts it
+	sessionID string
+	// output is read from by the session and written to by the processes,
+	// it is kept open across processes like the tty of local terminals
+	output       *io.PipeReader
+	outputWriter *io.PipeWriter
+	client       *ssh.Client
+	// agent is the connection to the ssh agent used for authentication
+	agent      net.Conn
+	session    *ssh.Session
+	stdin      io.WriteCloser
+	rows, cols uint16
+	released   bool
+	mutex      sync.Mutex
+}
+
+// newSSHTerminal returns a terminal for processes of selectedProfile on
+// its ssh host in the session identified by sessionID, the host is only
+// connected to when the first process is started
+func newSSHTerminal(sessionID string, selectedProfile profile.Profile) *sshTerminal {
+	output, outputWriter := io.Pipe()
+	return &sshTerminal{
+		profile:      selectedProfile,
+		sessionID:    sessionID,
+		output:       output,
+		outputWriter: outputWriter,
+		rows:         vt.DefaultRows,
+		cols:         vt.DefaultCols,
+	}
+}
+
+// connect connects to the host, verifying its key against the known hosts,
+// and returns the client along with the connection to the ssh agent if one
+// is used; the handshake must complete within the connect timeout too so
+// that hosts which accept connections but never answer do not stall
+// sessions
+func connect(settings *profile.SSH) (*ssh.Client, net.Conn, error) {
+	knownHostsFile, err := settings.GetKnownHostsFile()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find known hosts: %s", err)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load known hosts: %s", err)
+	}
+	methods := []ssh.AuthMethod{}
+	if settings.IdentityFile != "" {
+		key, err := ioutil.ReadFile(settings.IdentityFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read identity file: %s", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse identity file: %s", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	var agentConnection net.Conn
+	if settings.Agent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, nil, errors.New("failed to connect to ssh agent: SSH_AUTH_SOCK is not set")
+		}
+		if agentConnection, err = net.Dial("unix", socket); err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to ssh agent: %s", err)
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(agentConnection).Signers))
+	}
+	address := settings.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+	client, err := dial(address, settings.GetConnectTimeout(), &ssh.ClientConfig{
+		User:            settings.User,
+		Auth:            methods,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		if agentConnection != nil {
+			agentConnection.Close()
+		}
+		return nil, nil, fmt.Errorf("failed to connect to '%s': %s", address, err)
+	}
+	return client, agentConnection, nil
+}
+
+// dial connects to address and performs the ssh handshake, both of which
+// are aborted once timeout has passed
+func dial(address string, timeout time.Duration, config *ssh.ClientConfig) (*ssh.Client, error) {
+	connection, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := connection.SetDeadline(time.Now().Add(timeout)); err != nil {
+		connection.Close()
+		return nil, err
+	}
+	clientConnection, channels, requests, err := ssh.NewClientConn(connection, address, config)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+	if err := connection.SetDeadline(time.Time{}); err != nil {
+		clientConnection.Close()
+		return nil, err
+	}
+	return ssh.NewClient(clientConnection, channels, requests), nil
+}
+
+// newSession opens a session on the host through client, reconnecting once
+// when there is no client or the connection was lost since the last
+// process; this talks to the host and must be called without the mutex
+// held
+func (t *sshTerminal) newSession(client *ssh.Client) (*ssh.Session, error) {
+	if client != nil {
+		if session, err := client.NewSession(); err == nil {
+			return session, nil
+		}
+		client.Close()
+	}
+	client, agentConnection, err := connect(t.profile.SSH)
+	if err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	if t.released {
+		t.mutex.Unlock()
+		client.Close()
+		if agentConnection != nil {
+			agentConnection.Close()
+		}
+		return nil, errors.New("failed to start process: tty has been released")
+	}
+	if t.agent != nil {
+		t.agent.Close()
+	}
+	t.client, t.agent = client, agentConnection
+	t.mutex.Unlock()
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ssh session: %s", err)
+	}
+	return session, nil
+}
+
+// Start starts a new process of the profile on the host, the mutex is only
+// held to read and update the state of the terminal so that input, resizes
+// and kills are not blocked while the host is connected to
+func (t *sshTerminal) Start() error {
+	t.mutex.Lock()
+	if t.released {
+		t.mutex.Unlock()
+		return errors.New("failed to start process: tty has been released")
+	}
+	client := t.client
+	rows, cols := t.rows, t.cols
+	t.mutex.Unlock()
+	session, err := t.newSession(client)
+	if err != nil {
+		return err
+	}
+	// hosts only accept the variables allowed by their configuration
+	keys := make([]string, 0, len(t.profile.Env))
+	for key := range t.profile.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		session.Setenv(key, t.profile.Env[key])
+	}
+	session.Setenv(SessionIDEnvironmentVariable, t.sessionID)
+	modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 38400, ssh.TTY_OP_OSPEED: 38400}
+	if err := session.RequestPty("xterm-256color", int(rows), int(cols), modes); err != nil {
+		session.Close()
+		return fmt.Errorf("failed to request pty: %s", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return err
+	}
+	session.Stdout = t.outputWriter
+	session.Stderr = t.outputWriter
+	if command := t.remoteCommand(); command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		session.Close()
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.released {
+		session.Close()
+		return errors.New("failed to start process: tty has been released")
+	}
+	t.session = session
+	t.stdin = stdin
+	// the window may have been resized while the process was started
+	if t.rows != rows || t.cols != cols {
+		session.WindowChange(int(t.rows), int(t.cols))
+	}
+	return nil
+}
+
+// remoteCommand returns the command line run on the host, the arguments
+// are quoted so that the shell of the host passes them as is while the
+// command may use the syntax of the shell
+func (t *sshTerminal) remoteCommand() string {
+	if t.profile.Command == "" {
+		return ""
+	}
+	words := []string{t.profile.Command}
+	for _, argument := range t.profile.Arguments {
+		words = append(words, "'"+strings.ReplaceAll(argument, "'", `'\''`)+"'")
+	}
+	return strings.Join(words, " ")
+}
+
+// Wait waits for the current process to exit and returns its exit code,
+// processes terminated by a signal have an exit code of 128 + the signal
+// number and 255 is returned when the host did not report an exit code as
+// is the convention of ssh
+func (t *sshTerminal) Wait() int {
+	t.mutex.Lock()
+	session := t.session
+	t.mutex.Unlock()
+	if session == nil {
+		return -1
+	}
+	err := session.Wait()
+	session.Close()
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		if signal := unix.SignalNum("SIG" + exitErr.Signal()); exitErr.Signal() != "" && signal > 0 {
+			return 128 + int(signal)
+		}
+		return exitErr.ExitStatus()
+	default:
+		return 255
+	}
+}
+
+// Release closes the output so that reads return io.EOF once the
+// remaining output has been read; no further processes can be started
+// after this
+func (t *sshTerminal) Release() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.released = true
+	return t.outputWriter.Close()
+}
+
+// Read implements io.Reader by reading the output of the process
+func (t *sshTerminal) Read(p []byte) (int, error) {
+	return t.output.Read(p)
+}
+
+// Write implements io.Writer by writing input to the process
+func (t *sshTerminal) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	stdin := t.stdin
+	t.mutex.Unlock()
+	if stdin == nil {
+		return 0, errors.New("failed to write input: no process has been started")
+	}
+	return stdin.Write(p)
+}
+
+// Resize sets the window size of the pty on the host
+func (t *sshTerminal) Resize(rows, cols uint16) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rows, t.cols = rows, cols
+	if t.session == nil {
+		return nil
+	}
+	return t.session.WindowChange(int(rows), int(cols))
+}
+
+// Kill terminates the current process by closing its session
+func (t *sshTerminal) Kill() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.session == nil {
+		return nil
+	}
+	t.session.Signal(ssh.SIGKILL)
+	if err := t.session.Close(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Signal delivers sig to the process that was started, hosts may ignore
+// signals sent through ssh
+func (t *sshTerminal) Signal(sig syscall.Signal) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.session == nil {
+		return errors.New("failed to signal process: no process has been started")
+	}
+	return t.session.Signal(ssh.Signal(strings.TrimPrefix(unix.SignalName(sig), "SIG")))
+}
+
+// IsShellInForeground returns true as the processes of the host cannot
+// be inspected, input is treated as if it was read by the shell
+func (t *sshTerminal) IsShellInForeground() bool {
+	return true
+}
+
+// WorkingDirectory returns an empty string as the processes of the host
+// cannot be inspected
+func (t *sshTerminal) WorkingDirectory() string {
+	return ""
+}
+
+// Credential returns an error as the files of the host cannot be accessed
+// locally
+func (t *sshTerminal) Credential() (*syscall.Credential, error) {
+	return nil, errors.New("failed to get credential: the files of sessions on ssh hosts cannot be accessed")
+}
+
+// Dial connects to port on localhost of the host through the connection
+// to the host
+func (t *sshTerminal) Dial(ctx context.Context, port int) (net.Conn, error) {
+	t.mutex.Lock()
+	client := t.client
+	t.mutex.Unlock()
+	if client == nil {
+		return nil, errors.New("failed to dial port: not connected to the host")
+	}
+	return client.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+}
+
+// Close releases all resources held by the terminal
+func (t *sshTerminal) Close() error {
+	t.Release()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.agent != nil {
+		t.agent.Close()
+	}
+	if t.client == nil {
+		return nil
+	}
+	return t.client.Close()
+}
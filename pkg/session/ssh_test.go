@@ -0,0 +1,250 @@
+package session
+
+import (
+	"cloudshell/pkg/profile"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is an in-process ssh server that runs no commands, exec
+// requests print the command line and the environment that was set and
+// exit with the status 3
+type testSSHServer struct {
+	listener       net.Listener
+	config         *ssh.ServerConfig
+	knownHostsFile string
+	identityFile   string
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	directory := t.TempDir()
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublicKey, clientPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey, err := ssh.NewPublicKey(clientPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(clientPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(directory, "id_ed25519")
+	if err := os.WriteFile(identityFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(metadata ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if metadata.User() != "alice" || string(key.Marshal()) != string(authorizedKey.Marshal()) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	knownHostsFile := filepath.Join(directory, "known_hosts")
+	line := knownhosts.Line([]string{listener.Addr().String()}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := &testSSHServer{
+		listener:       listener,
+		config:         config,
+		knownHostsFile: knownHostsFile,
+		identityFile:   identityFile,
+	}
+	go server.serve()
+	return server
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		connection, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, channels, requests, err := ssh.NewServerConn(connection, s.config)
+			if err != nil {
+				connection.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			for newChannel := range channels {
+				if newChannel.ChannelType() != "session" {
+					newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+					continue
+				}
+				channel, channelRequests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go serveTestSSHSession(channel, channelRequests)
+			}
+		}()
+	}
+}
+
+func serveTestSSHSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	env := []string{}
+	for request := range requests {
+		switch request.Type {
+		case "env":
+			var variable struct{ Name, Value string }
+			ssh.Unmarshal(request.Payload, &variable)
+			env = append(env, variable.Name+"="+variable.Value)
+			request.Reply(true, nil)
+		case "pty-req":
+			request.Reply(true, nil)
+		case "exec":
+			var command struct{ Command string }
+			ssh.Unmarshal(request.Payload, &command)
+			request.Reply(true, nil)
+			io.WriteString(channel, command.Command+"\r\n"+strings.Join(env, " ")+"\r\n")
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, 3)
+			channel.SendRequest("exit-status", false, status)
+			return
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
+
+func TestSSHTerminalStart(t *testing.T) {
+	server := newTestSSHServer(t)
+	tty := newSSHTerminal("test-session", profile.Profile{
+		Command:   "greet",
+		Arguments: []string{"it's"},
+		Env:       map[string]string{"GREETING": "hello"},
+		SSH: &profile.SSH{
+			Host:           server.listener.Addr().String(),
+			User:           "alice",
+			IdentityFile:   server.identityFile,
+			KnownHostsFile: server.knownHostsFile,
+		},
+	})
+	defer tty.Close()
+	// the output is a pipe that has to be read for the process to exit
+	read := make(chan []byte, 1)
+	go func() {
+		output, _ := io.ReadAll(tty)
+		read <- output
+	}()
+	if err := tty.Start(); err != nil {
+		t.Fatalf("failed to start process: %s", err)
+	}
+	if exitCode := tty.Wait(); exitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", exitCode)
+	}
+	tty.Release()
+	output := <-read
+	expected := "greet 'it'\\''s'\r\nGREETING=hello " + SessionIDEnvironmentVariable + "=test-session\r\n"
+	if string(output) != expected {
+		t.Errorf("expected output %q, got %q", expected, output)
+	}
+}
+
+func TestSSHTerminalUnknownHost(t *testing.T) {
+	server := newTestSSHServer(t)
+	other := newTestSSHServer(t)
+	tty := newSSHTerminal("test-session", profile.Profile{
+		Command: "greet",
+		SSH: &profile.SSH{
+			Host:           server.listener.Addr().String(),
+			User:           "alice",
+			IdentityFile:   server.identityFile,
+			KnownHostsFile: other.knownHostsFile,
+		},
+	})
+	defer tty.Close()
+	if err := tty.Start(); err == nil {
+		t.Fatal("expected the key of a host missing from the known hosts to be rejected")
+	}
+}
+
+func TestSSHTerminalHandshakeTimeout(t *testing.T) {
+	server := newTestSSHServer(t)
+	// the listener accepts connections but never sends the ssh version
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if err == nil {
+			accepted <- connection
+		}
+	}()
+	tty := newSSHTerminal("test-session", profile.Profile{
+		Command: "greet",
+		SSH: &profile.SSH{
+			Host:           listener.Addr().String(),
+			User:           "alice",
+			IdentityFile:   server.identityFile,
+			KnownHostsFile: server.knownHostsFile,
+			ConnectTimeout: 1,
+		},
+	})
+	defer tty.Close()
+	started := make(chan error, 1)
+	go func() { started <- tty.Start() }()
+	select {
+	case connection := <-accepted:
+		defer connection.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the host to be connected to")
+	}
+	// the terminal must not be locked while the handshake is pending
+	resized := make(chan error, 1)
+	go func() {
+		tty.Resize(40, 120)
+		resized <- tty.Kill()
+	}()
+	select {
+	case <-resized:
+	case <-started:
+		t.Fatal("expected resizing and killing to not wait for the handshake")
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected resizing and killing to not wait for the handshake")
+	}
+	select {
+	case err := <-started:
+		if err == nil {
+			t.Fatal("expected the stalled handshake to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stalled handshake to time out")
+	}
+}
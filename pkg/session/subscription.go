@@ -0,0 +1,109 @@
+package session
+
+import (
+	"cloudshell/pkg/clipboard"
+	"io"
+	"sync"
+)
+
+// subscriptionBacklog is the number of unread outputs a subscription can
+// hold before it is considered to not be keeping up with the session
+const subscriptionBacklog = 1024
+
+// clipboardBacklog is the number of unread copies to the clipboard a
+// subscription can hold before further copies are discarded
+const clipboardBacklog = 16
+
+// Subscription receives the output of a session and sends input to it, it
+// implements io.ReadWriteCloser so that it can be used as a connection to
+// the session
+type Subscription struct {
+	output    chan []byte
+	clipboard chan clipboard.Copy
+	remaining []byte
+	session   *Session
+	// attachment is true for subscriptions returned by Session.Attach
+	attachment bool
+	closeOnce  sync.Once
+}
+
+func newSubscription(session *Session) *Subscription {
+	return &Subscription{
+		output:    make(chan []byte, subscriptionBacklog),
+		clipboard: make(chan clipboard.Copy, clipboardBacklog),
+		session:   session,
+	}
+}
+
+// send queues data for the subscriber, returning false if the subscriber
+// has too much unread output; this must be called with the mutex of the
+// session held
+func (s *Subscription) send(data []byte) bool {
+	select {
+	case s.output <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// copy queues content copied to the clipboard for the subscriber,
+// returning false if the subscriber has too many unread copies; this must
+// be called with the mutex of the session held
+func (s *Subscription) copy(c clipboard.Copy) bool {
+	select {
+	case s.clipboard <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the delivery of output, reads return io.EOF once the queued
+// output has been read; this must be called with the mutex of the session
+// held
+func (s *Subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.output)
+		close(s.clipboard)
+	})
+}
+
+// Output returns a channel that delivers the output of the session and is
+// closed when the subscription ends
+func (s *Subscription) Output() <-chan []byte {
+	return s.output
+}
+
+// Clipboard returns a channel that delivers the content that programs copy
+// to the clipboard and is closed when the subscription ends, copies are
+// not part of the output
+func (s *Subscription) Clipboard() <-chan clipboard.Copy {
+	return s.clipboard
+}
+
+// Read implements io.Reader by reading the output of the session
+func (s *Subscription) Read(p []byte) (int, error) {
+	if len(s.remaining) == 0 {
+		data, ok := <-s.output
+		if !ok {
+			return 0, io.EOF
+		}
+		s.remaining = data
+	}
+	readLength := copy(p, s.remaining)
+	s.remaining = s.remaining[readLength:]
+	return readLength, nil
+}
+
+// Write implements io.Writer by sending input to the session
+func (s *Subscription) Write(p []byte) (int, error) {
+	return s.session.Write(p)
+}
+
+// Close ends the subscription, sessions that are not headless end when it
+// was their last attachment
+func (s *Subscription) Close() error {
+	s.session.unsubscribe(s)
+	return nil
+}
@@ -0,0 +1,234 @@
+package session
+
+import (
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/shellintegration"
+	"cloudshell/pkg/vt"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// terminal is a pty that processes for a profile can be (re)started on
+type terminal struct {
+	// pty is the controlling side of the pty that output is read from and
+	// input is written to
+	pty *os.File
+	// tty is the process side of the pty, it is kept open for as long as
+	// the terminal is in use so that a new process can be started on it
+	// after the previous one exits
+	tty     *os.File
+	profile profile.Profile
+	// sessionID identifies the session to processes through the
+	// CLOUDSHELL_SESSION_ID environment variable
+	sessionID string
+	cmd       *exec.Cmd
+	mutex     sync.Mutex
+}
+
+// newTerminal opens a new pty for processes of selectedProfile in the
+// session identified by sessionID
+func newTerminal(sessionID string, selectedProfile profile.Profile) (*terminal, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pty: %s", err)
+	}
+	if err := pty.Setsize(ptmx, &pty.Winsize{Rows: vt.DefaultRows, Cols: vt.DefaultCols}); err != nil {
+		ptmx.Close()
+		tty.Close()
+		return nil, fmt.Errorf("failed to set pty size: %s", err)
+	}
+	return &terminal{
+		pty:       ptmx,
+		tty:       tty,
+		profile:   selectedProfile,
+		sessionID: sessionID,
+	}, nil
+}
+
+// Start starts a new process of the profile on the pty
+func (t *terminal) Start() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.tty == nil {
+		return errors.New("failed to start process: tty has been released")
+	}
+	cmd := exec.Command(t.profile.Command, t.profile.Arguments...)
+	cmd.Env = append(t.profile.Environ(), SessionIDEnvironmentVariable+"="+t.sessionID)
+	cmd.Dir = t.profile.Workdir
+	cmd.Stdin = t.tty
+	cmd.Stdout = t.tty
+	cmd.Stderr = t.tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if t.profile.ShellIntegration {
+		if err := shellintegration.Apply(cmd); err != nil && !errors.Is(err, shellintegration.ErrUnsupported) {
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	t.cmd = cmd
+	return nil
+}
+
+// Wait waits for the current process to exit and returns its exit code,
+// processes terminated by a signal have an exit code of 128 + the signal
+// number as is the convention in shells
+func (t *terminal) Wait() int {
+	t.mutex.Lock()
+	cmd := t.cmd
+	t.mutex.Unlock()
+	if cmd == nil {
+		return -1
+	}
+	cmd.Wait()
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// Release closes the process side of the pty so that reads from the pty
+// return an error once the remaining output has been read; no further
+// processes can be started after this
+func (t *terminal) Release() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.tty == nil {
+		return nil
+	}
+	err := t.tty.Close()
+	t.tty = nil
+	return err
+}
+
+// Read implements io.Reader by reading the output of the process
+func (t *terminal) Read(p []byte) (int, error) {
+	return t.pty.Read(p)
+}
+
+// Write implements io.Writer by writing input to the process
+func (t *terminal) Write(p []byte) (int, error) {
+	return t.pty.Write(p)
+}
+
+// Resize sets the window size of the pty
+func (t *terminal) Resize(rows, cols uint16) error {
+	return pty.Setsize(t.pty, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// Kill terminates the current process if it is still running
+func (t *terminal) Kill() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.cmd == nil {
+		return nil
+	}
+	if err := t.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
+
+// Signal delivers sig to the foreground process group of the pty, falling
+// back to the process that was started when it cannot be determined
+func (t *terminal) Signal(sig syscall.Signal) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.cmd == nil {
+		return errors.New("failed to signal process: no process has been started")
+	}
+	if foregroundProcessGroup, err := unix.IoctlGetInt(int(t.pty.Fd()), unix.TIOCGPGRP); err == nil && foregroundProcessGroup > 0 {
+		return syscall.Kill(-foregroundProcessGroup, sig)
+	}
+	return t.cmd.Process.Signal(sig)
+}
+
+// IsShellInForeground returns true when the process that was started is
+// the foreground process group of the pty, meaning that input is read by
+// it rather than by a program it started
+func (t *terminal) IsShellInForeground() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.cmd == nil {
+		return false
+	}
+	foregroundProcessGroup, err := unix.IoctlGetInt(int(t.pty.Fd()), unix.TIOCGPGRP)
+	if err != nil {
+		return true
+	}
+	return foregroundProcessGroup == t.cmd.Process.Pid
+}
+
+// WorkingDirectory returns the working directory of the process the user
+// interacts with, an empty string is returned when it cannot be determined
+func (t *terminal) WorkingDirectory() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, pid := range t.interactiveProcesses() {
+		if directory, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid)); err == nil {
+			return directory
+		}
+	}
+	return ""
+}
+
+// Credential returns the credential of the process the user interacts
+// with, the credential of the foreground process is only used if it grants
+// no more than the credential of the process that was started, eg. after
+// `su` the shell of the target user is used but not `sudo` at its prompt
+func (t *terminal) Credential() (*syscall.Credential, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	pids := t.interactiveProcesses()
+	if len(pids) == 0 {
+		return nil, errors.New("failed to get credential: no process has been started")
+	}
+	started, err := readCredential(pids[len(pids)-1])
+	if err != nil {
+		return nil, err
+	}
+	for _, pid := range pids[:len(pids)-1] {
+		if credential, err := readCredential(pid); err == nil && isWithinCredential(credential, started) {
+			return credential, nil
+		}
+	}
+	return started, nil
+}
+
+// interactiveProcesses returns the ids of the processes that the user may
+// interact with in order of preference, the leader of the foreground
+// process group of the pty is preferred over the process that was started
+// as the started process may run the shell as another user, eg. `su`
+func (t *terminal) interactiveProcesses() []int {
+	if t.cmd == nil {
+		return nil
+	}
+	pids := []int{}
+	if foregroundProcessGroup, err := unix.IoctlGetInt(int(t.pty.Fd()), unix.TIOCGPGRP); err == nil && foregroundProcessGroup > 0 && foregroundProcessGroup != t.cmd.Process.Pid {
+		pids = append(pids, foregroundProcessGroup)
+	}
+	return append(pids, t.cmd.Process.Pid)
+}
+
+// Dial connects to port on localhost
+func (t *terminal) Dial(ctx context.Context, port int) (net.Conn, error) {
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, "tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+}
+
+// Close releases all resources held by the terminal
+func (t *terminal) Close() error {
+	t.Release()
+	return t.pty.Close()
+}
@@ -0,0 +1,541 @@
+package session
+
+import (
+	"cloudshell/pkg/shellintegration"
+	"cloudshell/pkg/zmodem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// zmodemIdleTimeout is how long a transfer waits for the other side
+	// before it is cancelled
+	zmodemIdleTimeout = time.Minute
+	// zmodemFilesTimeout is how long a transfer to `rz` waits for the files
+	// to send to be chosen, rz gives up after about two and a half minutes
+	zmodemFilesTimeout = 2 * time.Minute
+)
+
+var (
+	// ErrTransferNotFound is returned when using a ZMODEM transfer or a
+	// received file that does not exist
+	ErrTransferNotFound = errors.New("transfer not found")
+	// errTransferTimeout is returned when the other side of a transfer
+	// stops responding
+	errTransferTimeout = errors.New("timed out waiting for the other side of the transfer")
+)
+
+// TransferredFile describes a file transferred with ZMODEM
+type TransferredFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Skipped is true when the receiver did not accept the file, `rz`
+	// skips files that already exist
+	Skipped bool `json:"skipped,omitempty"`
+
+	path string
+}
+
+// zmodemBridge detects ZMODEM transfers started by `sz` and `rz` in the
+// output of a session and hands them to the browser, files sent by `sz`
+// are kept until the session ends so that they can be downloaded and
+// files to receive with `rz` are uploaded, output, input, start and cancel
+// are called with the lock of the session held
+type zmodemBridge struct {
+	session      *Session
+	maxSizeBytes int64
+	// tail is the end of the last output, headers split across reads are
+	// detected in it
+	tail []byte
+	// overAndOut is the number of `O` ending a session of `sz` which are
+	// still to be removed from the output
+	overAndOut int
+	// active is the transfer in progress
+	active *transfer
+	// directory holds the files received and the files being sent
+	directory string
+	// files are the received files by their id
+	files map[string]TransferredFile
+}
+
+// transfer is a ZMODEM transfer in progress
+type transfer struct {
+	id    string
+	kind  zmodem.Kind
+	input *transferInput
+	// sources receives the files to send to `rz`
+	sources chan []zmodem.Source
+	// claimed is true once files to send were provided
+	claimed bool
+	// sent receives the result of sending the files
+	sent       chan sendResult
+	cancelled  chan struct{}
+	cancelOnce sync.Once
+}
+
+type sendResult struct {
+	files []zmodem.File
+	err   error
+}
+
+func newZModemBridge(session *Session, maxSizeBytes int64) *zmodemBridge {
+	return &zmodemBridge{session: session, maxSizeBytes: maxSizeBytes, files: map[string]TransferredFile{}}
+}
+
+// output processes output of the tty and returns the part of it to display
+func (b *zmodemBridge) output(data []byte) []byte {
+	if b.active != nil {
+		b.active.input.write(data)
+		return nil
+	}
+	for b.overAndOut > 0 && len(data) > 0 {
+		if data[0] != 'O' {
+			b.overAndOut = 0
+			break
+		}
+		data = data[1:]
+		b.overAndOut--
+	}
+	searched := append(b.tail, data...)
+	index, kind, found := zmodem.Detect(searched)
+	if !found {
+		keep := zmodem.HeaderLength - 1
+		if len(searched) < keep {
+			keep = len(searched)
+		}
+		b.tail = append([]byte{}, searched[len(searched)-keep:]...)
+		return data
+	}
+	displayed := index - len(b.tail)
+	if displayed < 0 {
+		// the start of the header was displayed with the previous output
+		displayed = 0
+	}
+	b.tail = nil
+	b.start(kind, searched[index:])
+	return data[:displayed]
+}
+
+// input processes input sent to the session while a transfer is in
+// progress and returns false when no transfer is in progress, input is
+// discarded during transfers and ^C cancels them
+func (b *zmodemBridge) input(p []byte) bool {
+	if b.active == nil {
+		return false
+	}
+	for _, key := range p {
+		if key == 0x03 {
+			b.cancel(b.active)
+			break
+		}
+	}
+	return true
+}
+
+// start hands the output from the header starting a transfer on to a new
+// transfer
+func (b *zmodemBridge) start(kind zmodem.Kind, data []byte) {
+	t := &transfer{
+		id:        uuid.New().String(),
+		kind:      kind,
+		input:     newTransferInput(),
+		sources:   make(chan []zmodem.Source, 1),
+		sent:      make(chan sendResult, 1),
+		cancelled: make(chan struct{}),
+	}
+	t.input.write(data)
+	b.active = t
+	b.session.log.Infof("starting zmodem transfer '%s'", t.id)
+	go b.run(t)
+}
+
+// cancel stops t and makes the other side give up
+func (b *zmodemBridge) cancel(t *transfer) {
+	t.cancelOnce.Do(func() {
+		close(t.cancelled)
+		t.input.close(zmodem.ErrAborted)
+		b.session.tty.Write(zmodem.AbortSequence)
+	})
+}
+
+// run runs t until it ends and displays the output received meanwhile
+// that does not belong to it
+func (b *zmodemBridge) run(t *transfer) {
+	var err error
+	if t.kind == zmodem.KindReceive {
+		err = b.receive(t)
+	} else {
+		err = b.send(t)
+	}
+	s := b.session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b.active = nil
+	remaining := t.input.close(ErrClosed)
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if err != nil {
+		s.log.Warnf("zmodem transfer '%s' failed: %s", t.id, err)
+		s.notice(noticeError, fmt.Sprintf("cloudshell: zmodem transfer failed: %s", err), true)
+	} else if t.kind == zmodem.KindReceive {
+		b.overAndOut = 2
+	}
+	if remaining = b.output(remaining); len(remaining) > 0 {
+		s.display(remaining)
+	}
+}
+
+// receive receives the files sent by `sz` and offers each of them to the
+// browser for download
+func (b *zmodemBridge) receive(t *transfer) error {
+	b.notify("cloudshell: receiving files with zmodem, press ctrl+c to cancel", "")
+	directory, err := b.transferDirectory()
+	if err != nil {
+		b.session.tty.Write(zmodem.AbortSequence)
+		return err
+	}
+	var current *os.File
+	defer func() {
+		if current != nil {
+			current.Close()
+			os.Remove(current.Name())
+		}
+	}()
+	return zmodem.Receive(t.input, b.session.tty, func(file zmodem.File) (io.Writer, error) {
+		name, err := cleanFileName(file.Name)
+		if err != nil {
+			b.notify(fmt.Sprintf("cloudshell: skipping file with invalid name '%s'", file.Name), "")
+			return nil, err
+		}
+		if file.Size > b.maxSizeBytes {
+			b.notify(fmt.Sprintf("cloudshell: skipping '%s' (%s), files larger than %s cannot be received", name, formatSize(file.Size), formatSize(b.maxSizeBytes)), "")
+			return nil, ErrFileTooLarge
+		}
+		if current, err = ioutil.TempFile(directory, "*.received"); err != nil {
+			return nil, err
+		}
+		return &limitedWriter{w: current, remaining: b.maxSizeBytes}, nil
+	}, func(file zmodem.File) error {
+		name, _ := cleanFileName(file.Name)
+		received := TransferredFile{Name: name, Size: file.Size, path: current.Name()}
+		err := current.Close()
+		current = nil
+		if err != nil {
+			os.Remove(received.path)
+			return fmt.Errorf("failed to write file: %s", err)
+		}
+		id := uuid.New().String()
+		b.session.mutex.Lock()
+		b.files[id] = received
+		b.session.mutex.Unlock()
+		b.session.log.Infof("received '%s' (%v bytes) with zmodem transfer '%s'", received.Name, received.Size, t.id)
+		b.notify(fmt.Sprintf("cloudshell: received '%s' (%s)", received.Name, formatSize(received.Size)), transferSequence("zmodem-download", b.session.ID, id))
+		return nil
+	})
+}
+
+// send asks the browser for the files to send to `rz` and sends them
+func (b *zmodemBridge) send(t *transfer) error {
+	b.notify("cloudshell: choose the files to send with zmodem, press ctrl+c to cancel", transferSequence("zmodem-upload", b.session.ID, t.id))
+	var sources []zmodem.Source
+	select {
+	case sources = <-t.sources:
+	case <-t.cancelled:
+		return zmodem.ErrAborted
+	case <-time.After(zmodemFilesTimeout):
+		b.cancelLocked(t)
+		return errors.New("no files were chosen in time")
+	}
+	// rz repeats its ZRINIT while it waits, the repetitions are discarded as
+	// the sender asks for a new one
+	t.input.clear()
+	sent, err := zmodem.Send(t.input, b.session.tty, sources)
+	t.sent <- sendResult{files: sent, err: err}
+	for _, file := range sent {
+		b.session.log.Infof("sent '%s' (%v bytes) with zmodem transfer '%s'", file.Name, file.Size, t.id)
+		b.notify(fmt.Sprintf("cloudshell: sent '%s' (%s)", file.Name, formatSize(file.Size)), "")
+	}
+	return err
+}
+
+// notify displays message on a line of its own followed by sequence,
+// which is not displayed itself
+func (b *zmodemBridge) notify(message string, sequence string) {
+	b.session.mutex.Lock()
+	defer b.session.mutex.Unlock()
+	b.session.notice(noticeWarning, message, true)
+	if sequence != "" {
+		b.session.display([]byte(sequence))
+	}
+}
+
+// transferDirectory returns the directory holding the files of transfers
+func (b *zmodemBridge) transferDirectory() (string, error) {
+	b.session.mutex.Lock()
+	defer b.session.mutex.Unlock()
+	if b.directory == "" {
+		directory, err := ioutil.TempDir("", "cloudshell-zmodem-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create transfer directory: %s", err)
+		}
+		b.directory = directory
+	}
+	return b.directory, nil
+}
+
+// close cancels the transfer in progress and removes the files of
+// transfers
+func (b *zmodemBridge) close() {
+	if b.active != nil {
+		b.cancel(b.active)
+	}
+	if b.directory != "" {
+		if err := os.RemoveAll(b.directory); err != nil {
+			b.session.log.Warnf("failed to remove transfer directory: %s", err)
+		}
+	}
+	b.files = map[string]TransferredFile{}
+}
+
+// transferSequence returns the sequence asking the browser to download or
+// upload the files of a transfer
+func transferSequence(action, sessionID, id string) string {
+	return fmt.Sprintf("\x1b]%d;%s;%s;%s\x07", shellintegration.DownloadOSC, action, sessionID, id)
+}
+
+// OpenTransferredFile returns the file received by `sz` identified by id
+// and opens it for reading
+func (s *Session) OpenTransferredFile(id string) (TransferredFile, *os.File, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.zmodem == nil {
+		return TransferredFile{}, nil, ErrTransferNotFound
+	}
+	received, ok := s.zmodem.files[id]
+	if !ok {
+		return TransferredFile{}, nil, ErrTransferNotFound
+	}
+	file, err := os.Open(received.path)
+	if err != nil {
+		return TransferredFile{}, nil, err
+	}
+	return received, file, nil
+}
+
+// SendFiles sends the files returned by next to the `rz` waiting for the
+// transfer identified by transferID and returns them once it ended, next
+// returns the name and contents of each file and io.EOF once all files
+// were returned
+func (s *Session) SendFiles(transferID string, next func() (string, io.Reader, error)) ([]TransferredFile, error) {
+	s.mutex.Lock()
+	bridge := s.zmodem
+	var t *transfer
+	if bridge != nil && bridge.active != nil && bridge.active.id == transferID && bridge.active.kind == zmodem.KindSend && !bridge.active.claimed {
+		t = bridge.active
+		t.claimed = true
+	}
+	s.mutex.Unlock()
+	if t == nil {
+		return nil, ErrTransferNotFound
+	}
+	directory, err := bridge.transferDirectory()
+	if err != nil {
+		bridge.cancelLocked(t)
+		return nil, err
+	}
+	var sources []zmodem.Source
+	files := []TransferredFile{}
+	defer func() {
+		for _, source := range sources {
+			file := source.Content.(*os.File)
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	for {
+		name, content, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			name, err = cleanFileName(name)
+		}
+		var source zmodem.Source
+		if err == nil {
+			source, err = bridge.stage(directory, name, content)
+		}
+		if err != nil {
+			bridge.cancelLocked(t)
+			return nil, err
+		}
+		sources = append(sources, source)
+		files = append(files, TransferredFile{Name: source.Name, Size: source.Size, Skipped: true})
+	}
+	if len(sources) == 0 {
+		bridge.cancelLocked(t)
+		return nil, ErrInvalidFileName
+	}
+	t.sources <- sources
+	var result sendResult
+	select {
+	case result = <-t.sent:
+	case <-t.cancelled:
+		return nil, zmodem.ErrAborted
+	}
+	for _, sent := range result.files {
+		for index := range files {
+			if files[index].Name == sent.Name && files[index].Skipped {
+				files[index].Skipped = false
+				break
+			}
+		}
+	}
+	return files, result.err
+}
+
+// stage writes content to a file in directory from which it is sent
+func (b *zmodemBridge) stage(directory, name string, content io.Reader) (zmodem.Source, error) {
+	file, err := ioutil.TempFile(directory, "*.sent")
+	if err != nil {
+		return zmodem.Source{}, fmt.Errorf("failed to create file: %s", err)
+	}
+	size, err := io.Copy(file, io.LimitReader(content, b.maxSizeBytes+1))
+	if err == nil && size > b.maxSizeBytes {
+		err = ErrFileTooLarge
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		if errors.Is(err, ErrFileTooLarge) {
+			return zmodem.Source{}, err
+		}
+		return zmodem.Source{}, fmt.Errorf("failed to write file: %s", err)
+	}
+	return zmodem.Source{
+		File:    zmodem.File{Name: name, Size: size, ModTime: time.Now(), Mode: 0644},
+		Content: file,
+	}, nil
+}
+
+// cancelLocked cancels t if it is still in progress
+func (b *zmodemBridge) cancelLocked(t *transfer) {
+	b.session.mutex.Lock()
+	defer b.session.mutex.Unlock()
+	b.cancel(t)
+}
+
+// CancelTransfer cancels the transfer identified by id
+func (s *Session) CancelTransfer(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.zmodem == nil || s.zmodem.active == nil || s.zmodem.active.id != id {
+		return ErrTransferNotFound
+	}
+	s.zmodem.cancel(s.zmodem.active)
+	return nil
+}
+
+// transferInput buffers the output of the tty for the transfer reading it
+type transferInput struct {
+	mutex sync.Mutex
+	data  []byte
+	err   error
+	// ready is signalled when data is written or the input is closed
+	ready chan struct{}
+}
+
+func newTransferInput() *transferInput {
+	return &transferInput{ready: make(chan struct{}, 1)}
+}
+
+func (i *transferInput) write(p []byte) {
+	i.mutex.Lock()
+	if i.err == nil {
+		i.data = append(i.data, p...)
+	}
+	i.mutex.Unlock()
+	i.signal()
+}
+
+func (i *transferInput) signal() {
+	select {
+	case i.ready <- struct{}{}:
+	default:
+	}
+}
+
+// ReadByte implements io.ByteReader, errTransferTimeout is returned when
+// no data arrives in time
+func (i *transferInput) ReadByte() (byte, error) {
+	for {
+		i.mutex.Lock()
+		if len(i.data) > 0 {
+			b := i.data[0]
+			i.data = i.data[1:]
+			i.mutex.Unlock()
+			return b, nil
+		}
+		err := i.err
+		i.mutex.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-i.ready:
+		case <-time.After(zmodemIdleTimeout):
+			return 0, errTransferTimeout
+		}
+	}
+}
+
+// clear discards the data that was not read yet
+func (i *transferInput) clear() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.data = nil
+}
+
+// close makes reads fail with err once the buffered data was read and
+// returns the data that was not read yet
+func (i *transferInput) close(err error) []byte {
+	i.mutex.Lock()
+	defer i.signal()
+	defer i.mutex.Unlock()
+	remaining := i.data
+	if i.err == nil {
+		i.err = err
+	}
+	if err == zmodem.ErrAborted {
+		remaining = nil
+	}
+	i.data = nil
+	return remaining
+}
+
+// limitedWriter fails with ErrFileTooLarge once more than remaining bytes
+// are written to it
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, ErrFileTooLarge
+	}
+	l.remaining -= int64(len(p))
+	return l.w.Write(p)
+}
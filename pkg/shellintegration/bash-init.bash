@@ -0,0 +1,15 @@
+# cloudshell starts bash with this file instead of the usual startup files
+# when shell integration is enabled, it loads the startup files that bash
+# would have loaded before enabling the integration
+if [[ -n "${CLOUDSHELL_LOGIN_SHELL:-}" ]]; then
+  unset CLOUDSHELL_LOGIN_SHELL
+  if [[ -r /etc/profile ]]; then . /etc/profile; fi
+  if [[ -r ~/.bash_profile ]]; then . ~/.bash_profile
+  elif [[ -r ~/.bash_login ]]; then . ~/.bash_login
+  elif [[ -r ~/.profile ]]; then . ~/.profile
+  fi
+else
+  if [[ -r /etc/bash.bashrc ]]; then . /etc/bash.bashrc; fi
+  if [[ -r ~/.bashrc ]]; then . ~/.bashrc; fi
+fi
+. "${BASH_SOURCE[0]%/*}/integration.bash"
@@ -0,0 +1,31 @@
+# cloudshell shell integration for bash, this reports the prompt and
+# commands using OSC 133 and the working directory using OSC 7 and adds the
+# helper commands of cloudshell to the path
+if [[ $- == *i* && -z "${__cloudshell_integration:-}" ]]; then
+  __cloudshell_integration=1
+  __cloudshell_prompted=
+  if [[ ":$PATH:" != *":${BASH_SOURCE[0]%/*}/bin:"* ]]; then
+    PATH="$PATH:${BASH_SOURCE[0]%/*}/bin"
+  fi
+
+  __cloudshell_prompt_command() {
+    local exit_status=$?
+    # the first prompt does not follow a command
+    if [[ -n "$__cloudshell_prompted" ]]; then
+      printf '\e]133;D;%s\a' "$exit_status"
+    fi
+    __cloudshell_prompted=1
+    printf '\e]7;file://%s%s\a' "$HOSTNAME" "${PWD// /%20}"
+    if [[ "$PS1" != *'133;B'* ]]; then
+      PS1='\[\e]133;A\a\]'"$PS1"'\[\e]133;B\a\]'
+    fi
+    return $exit_status
+  }
+
+  if [[ "$(declare -p PROMPT_COMMAND 2>/dev/null)" == "declare -a"* ]]; then
+    PROMPT_COMMAND=(__cloudshell_prompt_command "${PROMPT_COMMAND[@]}")
+  else
+    PROMPT_COMMAND="__cloudshell_prompt_command${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+  fi
+  PS0="${PS0:-}"$'\e]133;C\a'
+fi
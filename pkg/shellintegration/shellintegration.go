@@ -0,0 +1,149 @@
+// Package shellintegration enables the shell integration marks of bash
+// and zsh in shells started by the server without changing the startup
+// files of the user, the shells can also use the helper commands of the
+// server such as cloudshell-download
+package shellintegration
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupported is returned when the shell integration cannot be enabled
+// for a command
+var ErrUnsupported = errors.New("shell integration is only supported for interactive bash and zsh shells")
+
+//go:embed bash-init.bash cloudshell-download integration.bash integration.zsh zshenv
+var files embed.FS
+
+// DownloadOSC is the identifier of the OSC sequence written by the
+// cloudshell-download helper command, its parameters are `download`, the
+// id of the session and the base64 encoded path to download, sessions
+// also use it to hand ZMODEM transfers to the browser
+const DownloadOSC = 5379
+
+var (
+	directory      string
+	directoryErr   error
+	directoryOnce  sync.Once
+	bashArguments  = []string{"-i", "-l", "--login"}
+	loginArguments = []string{"-l", "--login"}
+)
+
+// getDirectory returns the directory the integration files are written to,
+// the files are written on first use
+func getDirectory() (string, error) {
+	directoryOnce.Do(func() {
+		directory, directoryErr = ioutil.TempDir("", "cloudshell-shell-integration-")
+		if directoryErr != nil {
+			directoryErr = fmt.Errorf("failed to create shell integration directory: %s", directoryErr)
+			return
+		}
+		if directoryErr = os.Mkdir(filepath.Join(directory, "bin"), 0755); directoryErr != nil {
+			directoryErr = fmt.Errorf("failed to create shell integration directory: %s", directoryErr)
+			return
+		}
+		for source, destination := range map[string]string{
+			"bash-init.bash":      "bash-init.bash",
+			"cloudshell-download": "bin/cloudshell-download",
+			"integration.bash":    "integration.bash",
+			"integration.zsh":     "integration.zsh",
+			"zshenv":              ".zshenv",
+		} {
+			mode := os.FileMode(0644)
+			if filepath.Dir(destination) == "bin" {
+				// the helper commands in bin are added to the path of shells
+				mode = 0755
+			}
+			contents, err := files.ReadFile(source)
+			if err == nil {
+				err = ioutil.WriteFile(filepath.Join(directory, destination), contents, mode)
+			}
+			if err != nil {
+				directoryErr = fmt.Errorf("failed to write shell integration file '%s': %s", destination, err)
+				return
+			}
+		}
+		directoryErr = os.Chmod(directory, 0755)
+	})
+	return directory, directoryErr
+}
+
+// Supports returns true if the shell integration can be enabled for
+// command started with arguments, bash can only be started with the
+// arguments of interactive and login shells as its startup file is replaced
+func Supports(command string, arguments []string) bool {
+	switch filepath.Base(command) {
+	case "bash":
+		for _, argument := range arguments {
+			if !isOneOf(argument, bashArguments) {
+				return false
+			}
+		}
+		return true
+	case "zsh":
+		return true
+	}
+	return false
+}
+
+// Apply changes cmd to start the shell with the shell integration enabled,
+// ErrUnsupported is returned when this is not possible
+func Apply(cmd *exec.Cmd) error {
+	arguments := cmd.Args[1:]
+	if !Supports(cmd.Path, arguments) {
+		return ErrUnsupported
+	}
+	integrationDirectory, err := getDirectory()
+	if err != nil {
+		return err
+	}
+	environment := cmd.Env
+	if environment == nil {
+		environment = os.Environ()
+	}
+	switch filepath.Base(cmd.Path) {
+	case "bash":
+		for _, argument := range arguments {
+			if isOneOf(argument, loginArguments) {
+				environment = append(environment, "CLOUDSHELL_LOGIN_SHELL=1")
+				break
+			}
+		}
+		cmd.Args = []string{cmd.Args[0], "--init-file", filepath.Join(integrationDirectory, "bash-init.bash"), "-i"}
+	case "zsh":
+		if zdotdir, ok := lookupEnv(environment, "ZDOTDIR"); ok {
+			environment = append(environment, "CLOUDSHELL_ZDOTDIR="+zdotdir)
+		}
+		environment = append(environment, "ZDOTDIR="+integrationDirectory)
+	}
+	cmd.Env = environment
+	return nil
+}
+
+// lookupEnv returns the value of key in environment, later entries take
+// precedence as they do for exec.Cmd
+func lookupEnv(environment []string, key string) (string, bool) {
+	for index := len(environment) - 1; index >= 0; index-- {
+		if strings.HasPrefix(environment[index], key+"=") {
+			return strings.TrimPrefix(environment[index], key+"="), true
+		}
+	}
+	return "", false
+}
+
+func isOneOf(value string, validValues []string) bool {
+	for _, validValue := range validValues {
+		if value == validValue {
+			return true
+		}
+	}
+	return false
+}
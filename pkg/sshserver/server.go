@@ -0,0 +1,135 @@
+// Package sshserver lets native terminals start, list and attach to the
+// sessions of the server over ssh
+package sshserver
+
+import (
+	"cloudshell/internal/log"
+	"cloudshell/pkg/auth"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/session"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// extensionKey is the permission extension holding the key that a
+// connection authenticated with
+const extensionKey = "key"
+
+// Options configures the server
+type Options struct {
+	// HostKey identifies the server to clients
+	HostKey ssh.Signer
+	// Keys identifies the principals of clients by their public keys
+	Keys *auth.KeyAuthenticator
+	// Profiles are the profiles that sessions can be started with
+	Profiles *profile.Registry
+	// Sessions holds the sessions that can be listed and attached to
+	Sessions *session.Registry
+	// SessionOptions are the options of sessions started over ssh, their
+	// owner is the principal of the client
+	SessionOptions session.Options
+}
+
+// Server accepts ssh connections
+type Server struct {
+	opts   Options
+	config *ssh.ServerConfig
+}
+
+// New returns a server using opts
+func New(opts Options) *Server {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(metadata ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if _, err := opts.Keys.AuthenticateKey(key); err != nil {
+				return nil, err
+			}
+			return &ssh.Permissions{Extensions: map[string]string{extensionKey: string(key.Marshal())}}, nil
+		},
+	}
+	config.AddHostKey(opts.HostKey)
+	return &Server{opts: opts, config: config}
+}
+
+// Serve accepts connections on listener until it is closed
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConnection(connection)
+	}
+}
+
+// handleConnection authenticates the client of connection and handles its
+// channels
+func (s *Server) handleConnection(connection net.Conn) {
+	serverConnection, channels, requests, err := ssh.NewServerConn(connection, s.config)
+	if err != nil {
+		log.Debugf("failed to accept ssh connection from '%s': %s", connection.RemoteAddr(), err)
+		return
+	}
+	defer serverConnection.Close()
+	go ssh.DiscardRequests(requests)
+	key, err := ssh.ParsePublicKey([]byte(serverConnection.Permissions.Extensions[extensionKey]))
+	if err != nil {
+		log.Warnf("failed to parse key of ssh connection: %s", err)
+		return
+	}
+	principal, err := s.opts.Keys.AuthenticateKey(key)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	log.Infof("accepted ssh connection from '%s' for principal '%s'", connection.RemoteAddr(), principal.Name)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("channel type '%s' is not supported", newChannel.ChannelType()))
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Warnf("failed to accept ssh channel: %s", err)
+			continue
+		}
+		go newShell(s, channel, principal).handleRequests(channelRequests)
+	}
+}
+
+// parseWindowSize returns the rows and columns of a window-change
+// request, whose payload starts with the columns followed by the rows
+func parseWindowSize(payload []byte) (uint16, uint16, bool) {
+	if len(payload) < 8 {
+		return 0, 0, false
+	}
+	return uint16(binary.BigEndian.Uint32(payload[4:])), uint16(binary.BigEndian.Uint32(payload)), true
+}
+
+// parsePtyRequest returns the window size requested by a pty-req request
+func parsePtyRequest(payload []byte) (uint16, uint16, bool) {
+	request := struct {
+		Term    string
+		Columns uint32
+		Rows    uint32
+		Width   uint32
+		Height  uint32
+		Modes   string
+	}{}
+	if err := ssh.Unmarshal(payload, &request); err != nil {
+		return 0, 0, false
+	}
+	return uint16(request.Rows), uint16(request.Columns), true
+}
+
+// parseCommand returns the command of an exec request
+func parseCommand(payload []byte) (string, bool) {
+	request := struct{ Command string }{}
+	if err := ssh.Unmarshal(payload, &request); err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(request.Command), true
+}
@@ -0,0 +1,326 @@
+package sshserver
+
+import (
+	"cloudshell/internal/log"
+	"cloudshell/pkg/auth"
+	"cloudshell/pkg/clipboard"
+	"cloudshell/pkg/session"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// usage describes the commands of the server
+const usage = `usage:
+  ssh <server>                            starts a session with the default profile
+  ssh -t <server> new [profile] [name=value ...]
+                                          starts a session with a profile and its parameters
+  ssh -t <server> attach <session id>     attaches to a session
+  ssh <server> list                       lists sessions and profiles
+`
+
+// shell runs the command of a channel of a connection
+type shell struct {
+	server    *Server
+	channel   ssh.Channel
+	principal *auth.Principal
+	// hasTerminal is true once the client requested a pty, the output of
+	// commands then uses CRLF line endings as the terminal of the client
+	// is in raw mode
+	hasTerminal bool
+	rows, cols  uint16
+	started     bool
+	// attached is the session that the channel is attached to
+	attached *session.Session
+	mutex    sync.Mutex
+}
+
+func newShell(server *Server, channel ssh.Channel, principal *auth.Principal) *shell {
+	return &shell{server: server, channel: channel, principal: principal}
+}
+
+// handleRequests handles the requests of the channel until it is closed
+func (s *shell) handleRequests(requests <-chan *ssh.Request) {
+	for request := range requests {
+		ok := false
+		switch request.Type {
+		case "pty-req":
+			var rows, cols uint16
+			if rows, cols, ok = parsePtyRequest(request.Payload); ok {
+				s.mutex.Lock()
+				s.hasTerminal = true
+				s.mutex.Unlock()
+				s.resize(rows, cols)
+			}
+		case "window-change":
+			var rows, cols uint16
+			if rows, cols, ok = parseWindowSize(request.Payload); ok {
+				s.resize(rows, cols)
+			}
+		case "signal":
+			signalRequest := struct{ Signal string }{}
+			if ok = ssh.Unmarshal(request.Payload, &signalRequest) == nil; ok {
+				s.signal(signalRequest.Signal)
+			}
+		case "shell", "exec":
+			command := ""
+			if request.Type == "exec" {
+				command, ok = parseCommand(request.Payload)
+			} else {
+				ok = true
+			}
+			s.mutex.Lock()
+			ok = ok && !s.started
+			s.started = true
+			s.mutex.Unlock()
+			if ok {
+				go s.run(command)
+			}
+		}
+		if request.WantReply {
+			request.Reply(ok, nil)
+		}
+	}
+}
+
+// run runs command and closes the channel with its exit status
+func (s *shell) run(command string) {
+	words := strings.Fields(command)
+	if len(words) == 0 {
+		words = []string{"new"}
+	}
+	exitStatus := 0
+	switch words[0] {
+	case "new":
+		exitStatus = s.new(words[1:])
+	case "attach":
+		if len(words) != 2 {
+			exitStatus = s.fail(2, "attach expects a session id\n\n%s", usage)
+			break
+		}
+		exitStatus = s.attachTo(words[1])
+	case "list":
+		s.list()
+	case "help":
+		s.printf("%s", usage)
+	default:
+		exitStatus = s.fail(2, "unknown command '%s'\n\n%s", words[0], usage)
+	}
+	status := make([]byte, 4)
+	binary.BigEndian.PutUint32(status, uint32(exitStatus))
+	s.channel.SendRequest("exit-status", false, status)
+	s.channel.Close()
+}
+
+// new starts a session with the profile and parameters in arguments and
+// attaches to it
+func (s *shell) new(arguments []string) int {
+	profileName := s.server.opts.Profiles.Default()
+	if len(arguments) > 0 && !strings.Contains(arguments[0], "=") {
+		profileName = arguments[0]
+		arguments = arguments[1:]
+	}
+	parameters := url.Values{}
+	for _, argument := range arguments {
+		pair := strings.SplitN(argument, "=", 2)
+		if len(pair) != 2 {
+			return s.fail(2, "parameter '%s' should be in the format 'name=value'\n", argument)
+		}
+		parameters.Add(pair[0], pair[1])
+	}
+	if !s.isTerminal() {
+		return s.fail(1, "a terminal is required to start a session, connect with `ssh -t`\n")
+	}
+	selectedProfile, ok := s.server.opts.Profiles.Get(profileName)
+	if !ok {
+		return s.fail(1, "failed to find profile '%s'\n", profileName)
+	}
+	selectedProfile, err := selectedProfile.Instantiate(parameters, s.principal.Claims)
+	if err != nil {
+		return s.fail(1, "failed to use profile '%s': %s\n", profileName, err)
+	}
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return s.fail(1, "failed to get a session id: %s\n", err)
+	}
+	opts := s.server.opts.SessionOptions
+	opts.Owner = s.principal.Name
+	if selectedProfile.Limits.MaxBufferSizeBytes > 0 {
+		opts.MaxBufferSizeBytes = selectedProfile.Limits.MaxBufferSizeBytes
+	}
+	tty, err := s.server.opts.Sessions.Create(id.String(), selectedProfile, opts)
+	if err != nil {
+		return s.fail(1, "failed to start session: %s\n", err)
+	}
+	log.Infof("principal '%s' started session '%s' using profile '%s' over ssh", s.principal.Name, tty.ID, selectedProfile.Name)
+	return s.attach(tty)
+}
+
+// attachTo attaches to the session identified by id
+func (s *shell) attachTo(id string) int {
+	if !s.isTerminal() {
+		return s.fail(1, "a terminal is required to attach to a session, connect with `ssh -t`\n")
+	}
+	tty, ok := s.server.opts.Sessions.Get(id)
+	if !ok {
+		return s.fail(1, "failed to find session '%s'\n", id)
+	}
+	if !tty.IsAccessibleBy(s.principal) {
+		return s.fail(1, "not allowed to access session '%s'\n", id)
+	}
+	log.Infof("principal '%s' attached to session '%s' over ssh", s.principal.Name, tty.ID)
+	return s.attach(tty)
+}
+
+// attach connects the channel to tty until either of them ends, sessions
+// that are not headless end when the channel was their last attachment as
+// they do when their last browser disconnects, the exit status of the
+// process is returned when it exited
+func (s *shell) attach(tty *session.Session) int {
+	output := tty.Attach()
+	defer output.Close()
+	s.mutex.Lock()
+	s.attached = tty
+	rows, cols := s.rows, s.cols
+	s.mutex.Unlock()
+	if rows > 0 && cols > 0 {
+		if err := tty.Resize(rows, cols); err != nil {
+			log.Warnf("failed to resize session '%s': %s", tty.ID, err)
+		}
+	}
+	detached := make(chan struct{})
+	go func() {
+		io.Copy(output, s.channel)
+		close(detached)
+	}()
+	outputs := output.Output()
+	copies := output.Clipboard()
+	for outputs != nil {
+		select {
+		case data, ok := <-outputs:
+			if !ok {
+				outputs = nil
+			} else if _, err := s.channel.Write(data); err != nil {
+				outputs = nil
+			}
+		case copied, ok := <-copies:
+			if !ok {
+				copies = nil
+				continue
+			}
+			// copies are written as OSC 52 for the terminal of the client
+			s.channel.Write(clipboard.Format(copied))
+		case <-detached:
+			log.Infof("principal '%s' detached from session '%s' over ssh", s.principal.Name, tty.ID)
+			return 0
+		}
+	}
+	if exitCode, ok := tty.ExitCode(); ok {
+		return exitCode
+	}
+	return 0
+}
+
+// list writes the sessions accessible by the principal and the profiles
+func (s *shell) list() {
+	var table strings.Builder
+	writer := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "SESSION\tPROFILE\tOWNER\tCREATED\tSTATUS\tCWD")
+	for _, tty := range s.server.opts.Sessions.List() {
+		if !tty.IsAccessibleBy(s.principal) {
+			continue
+		}
+		summary := tty.GetSummary()
+		// sessions are removed from the registry once they end, so only
+		// running sessions are listed
+		status := "running"
+		if summary.Headless {
+			status = "running (headless)"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", summary.ID, summary.Profile, summary.Owner, summary.CreatedAt.Format(time.RFC3339), status, summary.Cwd)
+	}
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "PROFILE\tDESCRIPTION")
+	defaultName := s.server.opts.Profiles.Default()
+	for _, p := range s.server.opts.Profiles.List() {
+		name := p.Name
+		if name == defaultName {
+			name += " (default)"
+		}
+		fmt.Fprintf(writer, "%s\t%s\n", name, p.Description)
+	}
+	writer.Flush()
+	s.printf("%s", table.String())
+}
+
+// resize resizes the attached session to the window of the client
+func (s *shell) resize(rows, cols uint16) {
+	s.mutex.Lock()
+	s.rows, s.cols = rows, cols
+	attached := s.attached
+	s.mutex.Unlock()
+	if attached != nil {
+		if err := attached.Resize(rows, cols); err != nil {
+			log.Warnf("failed to resize session '%s': %s", attached.ID, err)
+		}
+	}
+}
+
+// signal delivers the signal called name, without the SIG prefix, to the
+// attached session
+func (s *shell) signal(name string) {
+	s.mutex.Lock()
+	attached := s.attached
+	s.mutex.Unlock()
+	signal, ok := signals[name]
+	if attached == nil || !ok {
+		return
+	}
+	if err := attached.Signal(signal); err != nil {
+		log.Warnf("failed to signal session '%s': %s", attached.ID, err)
+	}
+}
+
+// signals maps the names of signals in ssh signal requests to their values
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+func (s *shell) isTerminal() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.hasTerminal
+}
+
+// printf writes to the output of the channel
+func (s *shell) printf(format string, arguments ...interface{}) {
+	s.write(s.channel, fmt.Sprintf(format, arguments...))
+}
+
+// fail writes to the error output of the channel and returns exitStatus
+func (s *shell) fail(exitStatus int, format string, arguments ...interface{}) int {
+	s.write(s.channel.Stderr(), fmt.Sprintf(format, arguments...))
+	return exitStatus
+}
+
+func (s *shell) write(w io.Writer, text string) {
+	if s.isTerminal() {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	io.WriteString(w, text)
+}
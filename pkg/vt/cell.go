@@ -0,0 +1,181 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// color is a foreground or background color, the highest byte holds the
+// kind of color and the lower bytes hold its value
+type color uint32
+
+const (
+	colorDefault color = 0
+	colorIndexed color = 1 << 24
+	colorRGB     color = 2 << 24
+
+	colorKindMask  color = 0xff << 24
+	colorValueMask color = 0xffffff
+)
+
+func indexedColor(index int) color {
+	return colorIndexed | color(index&0xff)
+}
+
+func rgbColor(r, g, b int) color {
+	return colorRGB | color((r&0xff)<<16|(g&0xff)<<8|(b&0xff))
+}
+
+// sgr returns the parameters selecting c, base is 30 for foreground and 40
+// for background colors
+func (c color) sgr(base int) string {
+	value := int(c & colorValueMask)
+	switch c & colorKindMask {
+	case colorIndexed:
+		if value < 8 {
+			return strconv.Itoa(base + value)
+		}
+		if value < 16 {
+			return strconv.Itoa(base + 60 + value - 8)
+		}
+		return strconv.Itoa(base+8) + ";5;" + strconv.Itoa(value)
+	case colorRGB:
+		return strconv.Itoa(base+8) + ";2;" + strconv.Itoa(value>>16) + ";" + strconv.Itoa(value>>8&0xff) + ";" + strconv.Itoa(value&0xff)
+	}
+	return strconv.Itoa(base + 9)
+}
+
+// flags are the text attributes of a cell
+type flags uint16
+
+const (
+	flagBold flags = 1 << iota
+	flagFaint
+	flagItalic
+	flagUnderline
+	flagBlink
+	flagInverse
+	flagHidden
+	flagStrikethrough
+)
+
+// flagParameters maps each flag to the SGR parameter enabling it
+var flagParameters = []struct {
+	flag      flags
+	parameter string
+}{
+	{flagBold, "1"},
+	{flagFaint, "2"},
+	{flagItalic, "3"},
+	{flagUnderline, "4"},
+	{flagBlink, "5"},
+	{flagInverse, "7"},
+	{flagHidden, "8"},
+	{flagStrikethrough, "9"},
+}
+
+// attributes describe how a cell is rendered
+type attributes struct {
+	fg    color
+	bg    color
+	flags flags
+}
+
+// sgr returns the escape sequence that selects a from the default
+// attributes
+func (a attributes) sgr() string {
+	parameters := []string{"0"}
+	for _, flagParameter := range flagParameters {
+		if a.flags&flagParameter.flag != 0 {
+			parameters = append(parameters, flagParameter.parameter)
+		}
+	}
+	if a.fg != colorDefault {
+		parameters = append(parameters, a.fg.sgr(30))
+	}
+	if a.bg != colorDefault {
+		parameters = append(parameters, a.bg.sgr(40))
+	}
+	return "\x1b[" + strings.Join(parameters, ";") + "m"
+}
+
+// wideContinuation is the rune of the cell following a double width
+// character
+const wideContinuation rune = -1
+
+// cell is a single character position of the screen
+type cell struct {
+	r     rune
+	attrs attributes
+}
+
+// blank returns an empty cell using the background color of attrs as is
+// done by terminals when erasing
+func blank(attrs attributes) cell {
+	return cell{r: ' ', attrs: attributes{bg: attrs.bg}}
+}
+
+// line is a row of cells
+type line struct {
+	cells []cell
+	// wrapped is true when the text of the line continues on the next line
+	// because it reached the end of the line
+	wrapped bool
+}
+
+func newLine(cols int, attrs attributes) *line {
+	l := &line{cells: make([]cell, cols)}
+	l.clear(0, cols, attrs)
+	return l
+}
+
+// clear erases the cells from start up to but excluding end
+func (l *line) clear(start, end int, attrs attributes) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(l.cells) {
+		end = len(l.cells)
+	}
+	for index := start; index < end; index++ {
+		l.cells[index] = blank(attrs)
+	}
+}
+
+// resize changes the number of cells of the line to cols
+func (l *line) resize(cols int) {
+	if cols <= len(l.cells) {
+		l.cells = l.cells[:cols]
+		return
+	}
+	for len(l.cells) < cols {
+		l.cells = append(l.cells, blank(attributes{}))
+	}
+}
+
+// String returns the text of the line without trailing spaces
+func (l *line) String() string {
+	var builder strings.Builder
+	for _, c := range l.cells {
+		if c.r != wideContinuation {
+			builder.WriteRune(c.r)
+		}
+	}
+	return strings.TrimRight(builder.String(), " ")
+}
+
+// isWide returns true if r occupies two cells, this covers the common
+// double width ranges of East Asian scripts and emoji
+func isWide(r rune) bool {
+	return r >= 0x1100 && (r <= 0x115f ||
+		r == 0x2329 || r == 0x232a ||
+		(r >= 0x2e80 && r <= 0xa4cf && r != 0x303f) ||
+		(r >= 0xac00 && r <= 0xd7a3) ||
+		(r >= 0xf900 && r <= 0xfaff) ||
+		(r >= 0xfe30 && r <= 0xfe4f) ||
+		(r >= 0xff00 && r <= 0xff60) ||
+		(r >= 0xffe0 && r <= 0xffe6) ||
+		(r >= 0x1f300 && r <= 0x1f64f) ||
+		(r >= 0x1f900 && r <= 0x1f9ff) ||
+		(r >= 0x20000 && r <= 0x3fffd))
+}
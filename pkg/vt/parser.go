@@ -0,0 +1,538 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parserState is the state of the escape sequence parser
+type parserState int
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateCSI
+	stateOSC
+	// stateString is used for DCS, SOS, PM and APC strings which are ignored
+	stateString
+)
+
+// maxSequenceLength limits the size of escape sequences that are buffered,
+// longer sequences are truncated
+const maxSequenceLength = 4096
+
+// parser holds the state of partially received escape sequences and
+// characters so that they can be split across writes
+type parser struct {
+	state         parserState
+	intermediates []byte
+	parameters    []byte
+	private       byte
+	osc           []byte
+	// stringEscape is true when an ESC was received within an OSC or
+	// ignored string and may be followed by a '\' terminating it
+	stringEscape bool
+	utf8         []byte
+}
+
+// Write implements io.Writer by applying the output of a terminal to the
+// screen, it never returns an error
+func (s *Screen) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.handle(b)
+	}
+	return len(p), nil
+}
+
+func (s *Screen) handle(b byte) {
+	p := &s.parser
+	if p.state == stateOSC || p.state == stateString {
+		s.handleString(b)
+		return
+	}
+	if len(p.utf8) > 0 {
+		if b >= 0x80 && b < 0xc0 {
+			p.utf8 = append(p.utf8, b)
+			if utf8.FullRune(p.utf8) {
+				r, _ := utf8.DecodeRune(p.utf8)
+				p.utf8 = p.utf8[:0]
+				s.print(r)
+			}
+			return
+		}
+		// the sequence was interrupted
+		p.utf8 = p.utf8[:0]
+		s.print(utf8.RuneError)
+	}
+	switch {
+	case b == 0x1b:
+		p.state = stateEscape
+		p.intermediates = p.intermediates[:0]
+		return
+	case b == 0x18 || b == 0x1a:
+		p.state = stateGround
+		return
+	case b < 0x20:
+		s.execute(b)
+		return
+	case b == 0x7f:
+		return
+	}
+	switch p.state {
+	case stateGround:
+		if b >= 0x80 {
+			p.utf8 = append(p.utf8, b)
+			if utf8.FullRune(p.utf8) {
+				// invalid leading bytes are complete on their own
+				p.utf8 = p.utf8[:0]
+				s.print(utf8.RuneError)
+			}
+			return
+		}
+		s.print(rune(b))
+	case stateEscape:
+		s.handleEscape(b)
+	case stateEscapeIntermediate:
+		if b < 0x30 {
+			p.intermediates = append(p.intermediates, b)
+			return
+		}
+		p.state = stateGround
+		s.escapeDispatch(p.intermediates, b)
+	case stateCSI:
+		switch {
+		case b >= 0x40 && b <= 0x7e:
+			p.state = stateGround
+			s.csiDispatch(b)
+		case b >= 0x20 && b < 0x30:
+			p.intermediates = append(p.intermediates, b)
+		case b >= 0x3c && b <= 0x3f && len(p.parameters) == 0 && p.private == 0:
+			p.private = b
+		case len(p.parameters) < maxSequenceLength:
+			p.parameters = append(p.parameters, b)
+		}
+	}
+}
+
+// handleString processes bytes of OSC and ignored strings
+func (s *Screen) handleString(b byte) {
+	p := &s.parser
+	if p.stringEscape {
+		p.stringEscape = false
+		if b == '\\' {
+			s.finishString()
+			return
+		}
+		// any other sequence aborts the string
+		s.finishString()
+		p.state = stateEscape
+		p.intermediates = p.intermediates[:0]
+		s.handle(b)
+		return
+	}
+	switch b {
+	case 0x1b:
+		p.stringEscape = true
+	case 0x07:
+		s.finishString()
+	case 0x18, 0x1a:
+		p.state = stateGround
+	default:
+		if p.state == stateOSC && len(p.osc) < maxSequenceLength {
+			p.osc = append(p.osc, b)
+		}
+	}
+}
+
+func (s *Screen) finishString() {
+	p := &s.parser
+	if p.state == stateOSC {
+		s.oscDispatch(string(p.osc))
+	}
+	p.state = stateGround
+}
+
+func (s *Screen) handleEscape(b byte) {
+	p := &s.parser
+	switch {
+	case b == '[':
+		p.state = stateCSI
+		p.parameters = p.parameters[:0]
+		p.intermediates = p.intermediates[:0]
+		p.private = 0
+	case b == ']':
+		p.state = stateOSC
+		p.osc = p.osc[:0]
+	case b == 'P' || b == 'X' || b == '^' || b == '_':
+		p.state = stateString
+	case b < 0x30:
+		p.state = stateEscapeIntermediate
+		p.intermediates = append(p.intermediates, b)
+	default:
+		p.state = stateGround
+		s.escapeDispatch(nil, b)
+	}
+}
+
+// execute handles C0 control characters
+func (s *Screen) execute(b byte) {
+	switch b {
+	case '\b':
+		s.cursor.pendingWrap = false
+		if s.cursor.x > 0 {
+			s.cursor.x--
+		}
+	case '\t':
+		s.tab(1)
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\r':
+		s.cursor.pendingWrap = false
+		s.cursor.x = 0
+	}
+}
+
+func (s *Screen) escapeDispatch(intermediates []byte, final byte) {
+	if len(intermediates) > 0 {
+		switch intermediates[0] {
+		case '(':
+			s.cursor.lineDrawing = final == '0'
+		case '#':
+			if final == '8' {
+				// DECALN fills the screen with 'E'
+				for _, l := range s.active.lines {
+					for index := range l.cells {
+						l.cells[index] = cell{r: 'E'}
+					}
+				}
+			}
+		}
+		return
+	}
+	switch final {
+	case '7':
+		s.saveCursor()
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.cursor.x = 0
+		s.lineFeed()
+	case 'H':
+		s.tabStops[s.cursor.x] = true
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.reset()
+		s.title = ""
+	case '=':
+		s.keypadMode = true
+	case '>':
+		s.keypadMode = false
+	}
+}
+
+// csiParameters parses the parameters of a CSI sequence, each parameter is
+// a list of its colon separated sub parameters where -1 means omitted
+func csiParameters(raw []byte) [][]int {
+	parameters := [][]int{}
+	if len(raw) == 0 {
+		return parameters
+	}
+	for _, parameter := range strings.Split(string(raw), ";") {
+		subParameters := []int{}
+		for _, subParameter := range strings.Split(parameter, ":") {
+			value, err := strconv.Atoi(subParameter)
+			if err != nil || value < 0 {
+				value = -1
+			}
+			if value > 65535 {
+				value = 65535
+			}
+			subParameters = append(subParameters, value)
+		}
+		parameters = append(parameters, subParameters)
+	}
+	return parameters
+}
+
+func (s *Screen) csiDispatch(final byte) {
+	p := &s.parser
+	parameters := csiParameters(p.parameters)
+	// get returns the parameter at index or fallback when it is omitted or 0
+	get := func(index, fallback int) int {
+		if index >= len(parameters) || parameters[index][0] <= 0 {
+			return fallback
+		}
+		return parameters[index][0]
+	}
+	// getMode returns the parameter at index with 0 as the default
+	getMode := func(index int) int {
+		if index >= len(parameters) || parameters[index][0] < 0 {
+			return 0
+		}
+		return parameters[index][0]
+	}
+
+	if p.private == '?' {
+		switch final {
+		case 'h', 'l':
+			for index := range parameters {
+				s.setPrivateMode(getMode(index), final == 'h')
+			}
+		case 'J':
+			s.eraseInDisplay(getMode(0))
+		case 'K':
+			s.eraseInLine(getMode(0))
+		}
+		return
+	}
+	if p.private != 0 {
+		return
+	}
+	if len(p.intermediates) > 0 {
+		switch {
+		case p.intermediates[0] == ' ' && final == 'q':
+			s.cursorStyle = getMode(0)
+		case p.intermediates[0] == '!' && final == 'p':
+			s.softReset()
+		}
+		return
+	}
+
+	switch final {
+	case '@':
+		s.insertCharacters(get(0, 1))
+	case 'A':
+		s.moveVertically(-get(0, 1))
+	case 'B', 'e':
+		s.moveVertically(get(0, 1))
+	case 'C', 'a':
+		s.cursor.pendingWrap = false
+		s.cursor.x = clamp(s.cursor.x+get(0, 1), 0, s.cols-1)
+	case 'D':
+		s.cursor.pendingWrap = false
+		s.cursor.x = clamp(s.cursor.x-get(0, 1), 0, s.cols-1)
+	case 'E':
+		s.moveVertically(get(0, 1))
+		s.cursor.x = 0
+	case 'F':
+		s.moveVertically(-get(0, 1))
+		s.cursor.x = 0
+	case 'G', '`':
+		s.cursor.pendingWrap = false
+		s.cursor.x = clamp(get(0, 1)-1, 0, s.cols-1)
+	case 'H', 'f':
+		s.moveTo(get(0, 1)-1, get(1, 1)-1)
+	case 'I':
+		s.tab(get(0, 1))
+	case 'J':
+		s.eraseInDisplay(getMode(0))
+	case 'K':
+		s.eraseInLine(getMode(0))
+	case 'L':
+		s.insertLines(get(0, 1))
+	case 'M':
+		s.deleteLines(get(0, 1))
+	case 'P':
+		s.deleteCharacters(get(0, 1))
+	case 'S':
+		s.scrollUp(get(0, 1))
+	case 'T':
+		if len(parameters) <= 1 {
+			s.scrollDown(get(0, 1))
+		}
+	case 'X':
+		s.eraseCharacters(get(0, 1))
+	case 'Z':
+		s.backTab(get(0, 1))
+	case 'b':
+		if s.lastPrinted != 0 {
+			for count := get(0, 1); count > 0; count-- {
+				s.print(s.lastPrinted)
+			}
+		}
+	case 'd':
+		row := get(0, 1) - 1
+		if s.cursor.originMode {
+			row += s.scrollTop
+		}
+		s.cursor.pendingWrap = false
+		s.cursor.y = clamp(row, 0, s.rows-1)
+	case 'g':
+		switch getMode(0) {
+		case 0:
+			s.tabStops[s.cursor.x] = false
+		case 3:
+			for index := range s.tabStops {
+				s.tabStops[index] = false
+			}
+		}
+	case 'h', 'l':
+		for index := range parameters {
+			if getMode(index) == 4 {
+				s.insertMode = final == 'h'
+			}
+		}
+	case 'm':
+		s.selectGraphicRendition(parameters)
+	case 'r':
+		top, bottom := get(0, 1)-1, get(1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.scrollTop, s.scrollBottom = top, bottom
+			s.moveTo(0, 0)
+		}
+	case 's':
+		if len(parameters) == 0 {
+			s.saveCursor()
+		}
+	case 'u':
+		s.restoreCursor()
+	}
+}
+
+// softReset implements DECSTR
+func (s *Screen) softReset() {
+	s.cursorVisible = true
+	s.insertMode = false
+	s.autowrap = true
+	s.keypadMode = false
+	s.cursor.originMode = false
+	s.cursor.attrs = attributes{}
+	s.cursor.lineDrawing = false
+	s.cursor.pendingWrap = false
+	s.scrollTop, s.scrollBottom = 0, s.rows-1
+	delete(s.privateModes, 1)
+}
+
+// selectGraphicRendition implements SGR
+func (s *Screen) selectGraphicRendition(parameters [][]int) {
+	attrs := &s.cursor.attrs
+	if len(parameters) == 0 {
+		*attrs = attributes{}
+		return
+	}
+	for index := 0; index < len(parameters); index++ {
+		parameter := parameters[index]
+		value := parameter[0]
+		switch {
+		case value <= 0:
+			*attrs = attributes{}
+		case value == 1:
+			attrs.flags |= flagBold
+		case value == 2:
+			attrs.flags |= flagFaint
+		case value == 3:
+			attrs.flags |= flagItalic
+		case value == 4:
+			if len(parameter) > 1 && parameter[1] == 0 {
+				attrs.flags &^= flagUnderline
+			} else {
+				attrs.flags |= flagUnderline
+			}
+		case value == 5 || value == 6:
+			attrs.flags |= flagBlink
+		case value == 7:
+			attrs.flags |= flagInverse
+		case value == 8:
+			attrs.flags |= flagHidden
+		case value == 9:
+			attrs.flags |= flagStrikethrough
+		case value == 21:
+			attrs.flags |= flagUnderline
+		case value == 22:
+			attrs.flags &^= flagBold | flagFaint
+		case value == 23:
+			attrs.flags &^= flagItalic
+		case value == 24:
+			attrs.flags &^= flagUnderline
+		case value == 25:
+			attrs.flags &^= flagBlink
+		case value == 27:
+			attrs.flags &^= flagInverse
+		case value == 28:
+			attrs.flags &^= flagHidden
+		case value == 29:
+			attrs.flags &^= flagStrikethrough
+		case value >= 30 && value <= 37:
+			attrs.fg = indexedColor(value - 30)
+		case value == 38 || value == 48:
+			var selected color
+			selected, index = extendedColor(parameters, index)
+			if value == 38 {
+				attrs.fg = selected
+			} else {
+				attrs.bg = selected
+			}
+		case value == 39:
+			attrs.fg = colorDefault
+		case value >= 40 && value <= 47:
+			attrs.bg = indexedColor(value - 40)
+		case value == 49:
+			attrs.bg = colorDefault
+		case value >= 90 && value <= 97:
+			attrs.fg = indexedColor(value - 90 + 8)
+		case value >= 100 && value <= 107:
+			attrs.bg = indexedColor(value - 100 + 8)
+		}
+	}
+}
+
+// extendedColor parses the color selected by the 38 or 48 parameter at
+// index in either the semicolon or colon separated form, it returns the
+// color and the index of the last parameter used
+func extendedColor(parameters [][]int, index int) (color, int) {
+	values := parameters[index][1:]
+	colonForm := len(values) > 0
+	if !colonForm {
+		for _, parameter := range parameters[index+1:] {
+			values = append(values, parameter[0])
+		}
+	}
+	component := func(position int) int {
+		if position >= len(values) || values[position] < 0 {
+			return 0
+		}
+		return values[position]
+	}
+	if len(values) == 0 {
+		return colorDefault, index
+	}
+	switch values[0] {
+	case 5:
+		if !colonForm {
+			index += 2
+		}
+		return indexedColor(component(1)), index
+	case 2:
+		offset := 1
+		// the colon form may include a color space identifier
+		if colonForm && len(values) >= 5 {
+			offset = 2
+		}
+		if !colonForm {
+			index += 4
+		}
+		return rgbColor(component(offset), component(offset+1), component(offset+2)), index
+	}
+	return colorDefault, index
+}
+
+// oscDispatch handles operating system commands
+func (s *Screen) oscDispatch(command string) {
+	parts := strings.SplitN(command, ";", 2)
+	if len(parts) != 2 {
+		return
+	}
+	switch parts[0] {
+	case "0", "2":
+		s.title = parts[1]
+	case "7":
+		s.setWorkingDirectory(parts[1])
+	case "133":
+		s.shellIntegrationMark(parts[1])
+	}
+}
@@ -0,0 +1,518 @@
+// Package vt implements a headless VT100/xterm screen model which is fed the
+// output of a terminal and can serialize the current screen, cursor, modes
+// and a bounded scrollback so that another terminal can be brought to the
+// same state without replaying the full output
+package vt
+
+// DefaultRows and DefaultCols are the size of screens created without a size
+const (
+	DefaultRows = 24
+	DefaultCols = 80
+)
+
+// cursor is the position and rendering state used for printing
+type cursor struct {
+	x, y  int
+	attrs attributes
+	// pendingWrap is true when a character was printed in the last column,
+	// the next character is printed at the start of the next line
+	pendingWrap bool
+	// lineDrawing is true when the DEC special graphics character set is
+	// selected into G0
+	lineDrawing bool
+	originMode  bool
+}
+
+// buffer is the contents of either the main or the alternate screen
+type buffer struct {
+	lines       []*line
+	savedCursor cursor
+}
+
+func newBuffer(rows, cols int) *buffer {
+	b := &buffer{lines: make([]*line, rows)}
+	for index := range b.lines {
+		b.lines[index] = newLine(cols, attributes{})
+	}
+	return b
+}
+
+// Screen is a model of the screen of a terminal, it is not safe for
+// concurrent use
+type Screen struct {
+	rows, cols int
+
+	main      *buffer
+	alternate *buffer
+	active    *buffer
+	// scrollback holds the lines that scrolled off the top of the main screen
+	scrollback *lineRing
+
+	cursor       cursor
+	scrollTop    int
+	scrollBottom int
+	tabStops     []bool
+	// lastPrinted is the last printed character, it is repeated by REP
+	lastPrinted rune
+
+	autowrap      bool
+	insertMode    bool
+	cursorVisible bool
+	keypadMode    bool
+	// cursorStyle is the parameter of the last DECSCUSR sequence
+	cursorStyle int
+	// privateModes holds the state of DEC private modes which do not
+	// affect the screen contents but should be restored, such as mouse
+	// tracking and bracketed paste
+	privateModes map[int]bool
+	title        string
+	// workingDirectory is the directory last reported using OSC 7
+	workingDirectory string
+	// commandStart is the position of the last OSC 133 command start mark
+	commandStart *commandStart
+	onMark       func(Mark)
+
+	parser parser
+}
+
+// replayedPrivateModes lists the DEC private modes tracked in privateModes
+var replayedPrivateModes = []int{1, 12, 1000, 1002, 1003, 1004, 1005, 1006, 1015, 2004}
+
+// New returns a blank screen of the given size that keeps up to
+// scrollbackLines lines which scroll off the top of the screen
+func New(rows, cols, scrollbackLines int) *Screen {
+	if rows <= 0 {
+		rows = DefaultRows
+	}
+	if cols <= 0 {
+		cols = DefaultCols
+	}
+	s := &Screen{scrollback: newLineRing(scrollbackLines)}
+	s.rows, s.cols = rows, cols
+	s.reset()
+	return s
+}
+
+// reset returns the screen to its initial state, the scrollback is kept
+func (s *Screen) reset() {
+	s.main = newBuffer(s.rows, s.cols)
+	s.alternate = newBuffer(s.rows, s.cols)
+	s.active = s.main
+	s.cursor = cursor{}
+	s.scrollTop, s.scrollBottom = 0, s.rows-1
+	s.tabStops = make([]bool, s.cols)
+	for index := 0; index < s.cols; index += 8 {
+		s.tabStops[index] = true
+	}
+	s.autowrap = true
+	s.insertMode = false
+	s.cursorVisible = true
+	s.keypadMode = false
+	s.cursorStyle = 0
+	s.privateModes = map[int]bool{}
+	s.parser = parser{}
+}
+
+// Size returns the number of rows and columns of the screen
+func (s *Screen) Size() (int, int) {
+	return s.rows, s.cols
+}
+
+// Title returns the window title set by the program
+func (s *Screen) Title() string {
+	return s.title
+}
+
+// Lines returns the text of the scrollback followed by the main screen
+// without any formatting
+func (s *Screen) Lines() []string {
+	lines := []string{}
+	s.scrollback.each(func(l *line) {
+		lines = append(lines, l.String())
+	})
+	for _, l := range s.main.lines {
+		lines = append(lines, l.String())
+	}
+	return lines
+}
+
+// Resize changes the size of the screen, lines above the cursor are moved
+// into the scrollback when the number of rows shrinks
+func (s *Screen) Resize(rows, cols int) {
+	if rows <= 0 || cols <= 0 || (rows == s.rows && cols == s.cols) {
+		return
+	}
+	for _, b := range []*buffer{s.main, s.alternate} {
+		for _, l := range b.lines {
+			l.resize(cols)
+		}
+		if rows < len(b.lines) {
+			// drop blank lines below the cursor before scrolling lines away
+			excess := len(b.lines) - rows
+			for excess > 0 && len(b.lines)-1 > s.cursor.y && b.lines[len(b.lines)-1].String() == "" {
+				b.lines = b.lines[:len(b.lines)-1]
+				excess--
+			}
+			if excess > 0 {
+				if b == s.main {
+					for _, l := range b.lines[:excess] {
+						s.scrollback.push(l)
+					}
+				}
+				b.lines = b.lines[excess:]
+				if b == s.active {
+					s.cursor.y -= excess
+				}
+			}
+		}
+		for len(b.lines) < rows {
+			b.lines = append(b.lines, newLine(cols, attributes{}))
+		}
+		b.savedCursor.x = clamp(b.savedCursor.x, 0, cols-1)
+		b.savedCursor.y = clamp(b.savedCursor.y, 0, rows-1)
+	}
+	tabStops := make([]bool, cols)
+	copy(tabStops, s.tabStops)
+	for index := len(s.tabStops); index < cols; index++ {
+		tabStops[index] = index%8 == 0
+	}
+	s.tabStops = tabStops
+	s.rows, s.cols = rows, cols
+	s.scrollTop, s.scrollBottom = 0, rows-1
+	s.cursor.x = clamp(s.cursor.x, 0, cols-1)
+	s.cursor.y = clamp(s.cursor.y, 0, rows-1)
+	s.cursor.pendingWrap = false
+}
+
+func clamp(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+// print writes r at the cursor
+func (s *Screen) print(r rune) {
+	if s.cursor.lineDrawing && r >= 0x5f && r <= 0x7e {
+		r = lineDrawingCharacters[r-0x5f]
+	}
+	width := 1
+	if isWide(r) {
+		width = 2
+		if s.cols < 2 {
+			return
+		}
+	}
+	if s.cursor.pendingWrap || (width == 2 && s.cursor.x == s.cols-1) {
+		if s.autowrap {
+			s.active.lines[s.cursor.y].wrapped = true
+			s.cursor.x = 0
+			s.lineFeed()
+		} else if width == 2 {
+			s.cursor.x = s.cols - 2
+		}
+		s.cursor.pendingWrap = false
+	}
+	l := s.active.lines[s.cursor.y]
+	if s.insertMode {
+		copy(l.cells[s.cursor.x+width:], l.cells[s.cursor.x:])
+	}
+	s.clearWideCharacterAt(l, s.cursor.x)
+	l.cells[s.cursor.x] = cell{r: r, attrs: s.cursor.attrs}
+	if width == 2 {
+		s.clearWideCharacterAt(l, s.cursor.x+1)
+		l.cells[s.cursor.x+1] = cell{r: wideContinuation, attrs: s.cursor.attrs}
+	}
+	s.lastPrinted = r
+	if s.cursor.x+width >= s.cols {
+		s.cursor.x = s.cols - 1
+		s.cursor.pendingWrap = true
+		return
+	}
+	s.cursor.x += width
+}
+
+// clearWideCharacterAt blanks the other half of a double width character
+// that is partially overwritten at x
+func (s *Screen) clearWideCharacterAt(l *line, x int) {
+	if l.cells[x].r == wideContinuation && x > 0 {
+		l.cells[x-1] = blank(l.cells[x-1].attrs)
+	} else if x+1 < len(l.cells) && l.cells[x+1].r == wideContinuation {
+		l.cells[x+1] = blank(l.cells[x+1].attrs)
+	}
+}
+
+// lineFeed moves the cursor down, scrolling the scroll region when the
+// cursor is at its bottom
+func (s *Screen) lineFeed() {
+	s.cursor.pendingWrap = false
+	if s.cursor.y == s.scrollBottom {
+		s.scrollUp(1)
+		return
+	}
+	if s.cursor.y < s.rows-1 {
+		s.cursor.y++
+	}
+}
+
+// reverseIndex moves the cursor up, scrolling the scroll region down when
+// the cursor is at its top
+func (s *Screen) reverseIndex() {
+	s.cursor.pendingWrap = false
+	if s.cursor.y == s.scrollTop {
+		s.scrollDown(1)
+		return
+	}
+	if s.cursor.y > 0 {
+		s.cursor.y--
+	}
+}
+
+// scrollUp moves the lines of the scroll region up by n lines, lines
+// scrolling off the top of the main screen are kept in the scrollback
+func (s *Screen) scrollUp(n int) {
+	s.scrollUpFrom(s.scrollTop, n)
+}
+
+func (s *Screen) scrollUpFrom(top, n int) {
+	n = clamp(n, 0, s.scrollBottom-top+1)
+	lines := s.active.lines
+	for index := 0; index < n; index++ {
+		if s.active == s.main && top == 0 {
+			s.scrollback.push(lines[top+index])
+		}
+	}
+	copy(lines[top:], lines[top+n:s.scrollBottom+1])
+	for index := s.scrollBottom - n + 1; index <= s.scrollBottom; index++ {
+		lines[index] = newLine(s.cols, s.cursor.attrs)
+	}
+}
+
+// scrollDown moves the lines of the scroll region down by n lines
+func (s *Screen) scrollDown(n int) {
+	s.scrollDownFrom(s.scrollTop, n)
+}
+
+func (s *Screen) scrollDownFrom(top, n int) {
+	n = clamp(n, 0, s.scrollBottom-top+1)
+	lines := s.active.lines
+	copy(lines[top+n:s.scrollBottom+1], lines[top:s.scrollBottom+1-n])
+	for index := top; index < top+n; index++ {
+		lines[index] = newLine(s.cols, s.cursor.attrs)
+	}
+}
+
+// moveTo moves the cursor to the given position, rows are relative to the
+// scroll region in origin mode
+func (s *Screen) moveTo(y, x int) {
+	s.cursor.pendingWrap = false
+	s.cursor.x = clamp(x, 0, s.cols-1)
+	if s.cursor.originMode {
+		s.cursor.y = clamp(y+s.scrollTop, s.scrollTop, s.scrollBottom)
+		return
+	}
+	s.cursor.y = clamp(y, 0, s.rows-1)
+}
+
+// moveVertically moves the cursor up or down by n rows without leaving
+// the scroll region if the cursor is within it
+func (s *Screen) moveVertically(n int) {
+	s.cursor.pendingWrap = false
+	top, bottom := 0, s.rows-1
+	if s.cursor.y >= s.scrollTop && s.cursor.y <= s.scrollBottom {
+		top, bottom = s.scrollTop, s.scrollBottom
+	}
+	s.cursor.y = clamp(s.cursor.y+n, top, bottom)
+}
+
+// eraseInDisplay implements ED
+func (s *Screen) eraseInDisplay(mode int) {
+	s.cursor.pendingWrap = false
+	lines := s.active.lines
+	switch mode {
+	case 0:
+		lines[s.cursor.y].clear(s.cursor.x, s.cols, s.cursor.attrs)
+		for _, l := range lines[s.cursor.y+1:] {
+			l.clear(0, s.cols, s.cursor.attrs)
+		}
+	case 1:
+		for _, l := range lines[:s.cursor.y] {
+			l.clear(0, s.cols, s.cursor.attrs)
+		}
+		lines[s.cursor.y].clear(0, s.cursor.x+1, s.cursor.attrs)
+	case 2:
+		for _, l := range lines {
+			l.clear(0, s.cols, s.cursor.attrs)
+			l.wrapped = false
+		}
+	case 3:
+		s.scrollback.clear()
+	}
+}
+
+// eraseInLine implements EL
+func (s *Screen) eraseInLine(mode int) {
+	s.cursor.pendingWrap = false
+	l := s.active.lines[s.cursor.y]
+	switch mode {
+	case 0:
+		l.clear(s.cursor.x, s.cols, s.cursor.attrs)
+		l.wrapped = false
+	case 1:
+		l.clear(0, s.cursor.x+1, s.cursor.attrs)
+	case 2:
+		l.clear(0, s.cols, s.cursor.attrs)
+		l.wrapped = false
+	}
+}
+
+// insertCharacters implements ICH
+func (s *Screen) insertCharacters(n int) {
+	s.cursor.pendingWrap = false
+	l := s.active.lines[s.cursor.y]
+	n = clamp(n, 0, s.cols-s.cursor.x)
+	copy(l.cells[s.cursor.x+n:], l.cells[s.cursor.x:])
+	l.clear(s.cursor.x, s.cursor.x+n, s.cursor.attrs)
+}
+
+// deleteCharacters implements DCH
+func (s *Screen) deleteCharacters(n int) {
+	s.cursor.pendingWrap = false
+	l := s.active.lines[s.cursor.y]
+	n = clamp(n, 0, s.cols-s.cursor.x)
+	copy(l.cells[s.cursor.x:], l.cells[s.cursor.x+n:])
+	l.clear(s.cols-n, s.cols, s.cursor.attrs)
+}
+
+// eraseCharacters implements ECH
+func (s *Screen) eraseCharacters(n int) {
+	s.cursor.pendingWrap = false
+	s.active.lines[s.cursor.y].clear(s.cursor.x, s.cursor.x+n, s.cursor.attrs)
+}
+
+// insertLines implements IL
+func (s *Screen) insertLines(n int) {
+	if s.cursor.y < s.scrollTop || s.cursor.y > s.scrollBottom {
+		return
+	}
+	s.scrollDownFrom(s.cursor.y, n)
+	s.cursor.x = 0
+	s.cursor.pendingWrap = false
+}
+
+// deleteLines implements DL
+func (s *Screen) deleteLines(n int) {
+	if s.cursor.y < s.scrollTop || s.cursor.y > s.scrollBottom {
+		return
+	}
+	// deleted lines never enter the scrollback
+	n = clamp(n, 0, s.scrollBottom-s.cursor.y+1)
+	lines := s.active.lines
+	copy(lines[s.cursor.y:], lines[s.cursor.y+n:s.scrollBottom+1])
+	for index := s.scrollBottom - n + 1; index <= s.scrollBottom; index++ {
+		lines[index] = newLine(s.cols, s.cursor.attrs)
+	}
+	s.cursor.x = 0
+	s.cursor.pendingWrap = false
+}
+
+// tab moves the cursor to the next of n tab stops
+func (s *Screen) tab(n int) {
+	s.cursor.pendingWrap = false
+	for ; n > 0 && s.cursor.x < s.cols-1; n-- {
+		s.cursor.x++
+		for s.cursor.x < s.cols-1 && !s.tabStops[s.cursor.x] {
+			s.cursor.x++
+		}
+	}
+}
+
+// backTab moves the cursor to the previous of n tab stops
+func (s *Screen) backTab(n int) {
+	s.cursor.pendingWrap = false
+	for ; n > 0 && s.cursor.x > 0; n-- {
+		s.cursor.x--
+		for s.cursor.x > 0 && !s.tabStops[s.cursor.x] {
+			s.cursor.x--
+		}
+	}
+}
+
+// saveCursor implements DECSC
+func (s *Screen) saveCursor() {
+	s.active.savedCursor = s.cursor
+}
+
+// restoreCursor implements DECRC
+func (s *Screen) restoreCursor() {
+	s.cursor = s.active.savedCursor
+	s.cursor.x = clamp(s.cursor.x, 0, s.cols-1)
+	s.cursor.y = clamp(s.cursor.y, 0, s.rows-1)
+}
+
+// setAlternateScreen switches between the main and alternate screens
+func (s *Screen) setAlternateScreen(enabled, clear bool) {
+	if enabled == (s.active == s.alternate) {
+		return
+	}
+	if enabled {
+		s.active = s.alternate
+		if clear {
+			for _, l := range s.active.lines {
+				l.clear(0, s.cols, attributes{})
+				l.wrapped = false
+			}
+		}
+		return
+	}
+	s.active = s.main
+}
+
+// setPrivateMode implements DECSET and DECRST
+func (s *Screen) setPrivateMode(mode int, enabled bool) {
+	switch mode {
+	case 6:
+		s.cursor.originMode = enabled
+		s.moveTo(0, 0)
+	case 7:
+		s.autowrap = enabled
+	case 25:
+		s.cursorVisible = enabled
+	case 47, 1047:
+		s.setAlternateScreen(enabled, mode == 1047 && enabled)
+	case 1048:
+		if enabled {
+			s.saveCursor()
+		} else {
+			s.restoreCursor()
+		}
+	case 1049:
+		if enabled {
+			s.main.savedCursor = s.cursor
+			s.setAlternateScreen(true, true)
+		} else {
+			s.setAlternateScreen(false, false)
+			s.restoreCursor()
+		}
+	default:
+		for _, replayedMode := range replayedPrivateModes {
+			if mode == replayedMode {
+				if enabled {
+					s.privateModes[mode] = true
+				} else {
+					delete(s.privateModes, mode)
+				}
+			}
+		}
+	}
+}
+
+// lineDrawingCharacters maps characters 0x5f to 0x7e to the DEC special
+// graphics character set
+var lineDrawingCharacters = []rune{
+	' ', '◆', '▒', '␉', '␌', '␍', '␊', '°', '±', '␤', '␋', '┘', '┐', '┌', '└', '┼',
+	'⎺', '⎻', '─', '⎼', '⎽', '├', '┤', '┴', '┬', '│', '≤', '≥', 'π', '≠', '£', '·',
+}
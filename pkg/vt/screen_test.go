@@ -0,0 +1,276 @@
+package vt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// activeLines returns the text of the lines of the screen in use without
+// the blank lines at its bottom
+func activeLines(s *Screen) []string {
+	lines := []string{}
+	for _, l := range s.active.lines {
+		lines = append(lines, l.String())
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func TestScreenWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows, cols int
+		// writes are applied one after another
+		writes []string
+		lines  []string
+		y, x   int
+	}{
+		{"text", 4, 10, []string{"hello"}, []string{"hello"}, 0, 5},
+		{"newlines", 4, 10, []string{"a\r\nb\r\nc"}, []string{"a", "b", "c"}, 2, 1},
+		{"cursor position", 4, 10, []string{"\x1b[2;3Hx"}, []string{"", "  x"}, 1, 3},
+		{"cursor position split", 4, 10, []string{"\x1b", "[2", ";", "3H", "x"}, []string{"", "  x"}, 1, 3},
+		{"cursor movement", 4, 10, []string{"abc\x1b[2D", "\x1b[Bx\x1b[A\x1b[Cy"}, []string{"abcy", " x"}, 0, 4},
+		{"erase in line", 4, 10, []string{"hello\x1b[3D\x1b[K"}, []string{"he"}, 0, 2},
+		{"erase in display", 4, 10, []string{"a\r\nb\r\nc\x1b[2;1H\x1b[J"}, []string{"a"}, 1, 0},
+		{"graphic rendition", 4, 10, []string{"\x1b[1;38;5;196", "mred\x1b[0m"}, []string{"red"}, 0, 3},
+		{"insert and delete characters", 4, 10, []string{"abcd\x1b[3G\x1b[2@xy\x1b[1G\x1b[P"}, []string{"bxycd"}, 0, 0},
+		{"repeat", 4, 10, []string{"a\x1b[3b"}, []string{"aaaa"}, 0, 4},
+		{"tabs", 4, 20, []string{"a\tb\x1b[Zc"}, []string{"a       c"}, 0, 9},
+		{"autowrap", 4, 5, []string{"abcdefg"}, []string{"abcde", "fg"}, 1, 2},
+		{"autowrap disabled", 4, 5, []string{"\x1b[?7labcdefg"}, []string{"abcdg"}, 0, 4},
+		{"pending wrap", 4, 5, []string{"abcde\r\nf"}, []string{"abcde", "f"}, 1, 1},
+		{"utf-8", 4, 10, []string{"caf\xc3\xa9"}, []string{"café"}, 0, 4},
+		{"utf-8 split", 4, 10, []string{"caf\xc3", "\xa9"}, []string{"café"}, 0, 4},
+		{"utf-8 interrupted", 4, 10, []string{"\xc3a"}, []string{"\ufffda"}, 0, 2},
+		{"wide characters", 4, 10, []string{"a世界b"}, []string{"a世界b"}, 0, 6},
+		{"wide character split", 4, 10, []string{"\xe4", "\xb8", "\x96x"}, []string{"世x"}, 0, 3},
+		{"wide character wraps early", 4, 5, []string{"ab世界"}, []string{"ab世", "界"}, 1, 2},
+		{"overwrite first half of wide character", 4, 10, []string{"世界\x1b[1Gx"}, []string{"x 界"}, 0, 1},
+		{"overwrite second half of wide character", 4, 10, []string{"世界\x1b[2Gx"}, []string{" x界"}, 0, 2},
+		{"line drawing", 4, 10, []string{"\x1b(0qx\x1b(Bq"}, []string{"─│q"}, 0, 3},
+		{"save and restore cursor", 4, 10, []string{"ab\x1b7\r\nc\x1b8d"}, []string{"abd", "c"}, 0, 3},
+		{"scroll region line feed", 4, 10, []string{"1\r\n2\r\n3\r\n4", "\x1b[2;3r\x1b[3;1H\nX"}, []string{"1", "3", "X", "4"}, 2, 1},
+		{"scroll region reverse index", 4, 10, []string{"1\r\n2\r\n3\r\n4", "\x1b[2;3r\x1b[2;1H\x1bMX"}, []string{"1", "X", "2", "4"}, 1, 1},
+		{"scroll region origin mode", 4, 10, []string{"\x1b[2;3r\x1b[?6h\x1b[1;1Ha\x1b[9;1Hb"}, []string{"", "a", "b"}, 2, 1},
+		{"scroll region insert lines", 4, 10, []string{"1\r\n2\r\n3\r\n4", "\x1b[1;3r\x1b[2;1H\x1b[L"}, []string{"1", "", "2", "4"}, 1, 0},
+		{"scroll region delete lines", 4, 10, []string{"1\r\n2\r\n3\r\n4", "\x1b[1;3r\x1b[1;1H\x1b[M"}, []string{"2", "3", "", "4"}, 0, 0},
+		{"scroll up and down", 4, 10, []string{"1\r\n2\r\n3", "\x1b[S", "\x1b[2T"}, []string{"", "", "2", "3"}, 2, 1},
+		{"osc title", 4, 10, []string{"\x1b]2;title\x07x"}, []string{"x"}, 0, 1},
+		{"osc split", 4, 10, []string{"\x1b]", "2;ti", "tle\x1b", "\\x"}, []string{"x"}, 0, 1},
+		{"osc aborted by escape sequence", 4, 10, []string{"\x1b]2;title\x1b[2Cx"}, []string{"  x"}, 0, 3},
+		{"ignored strings", 4, 10, []string{"\x1bPq#0;1\x1b", "\\x\x1b_apc\x07y"}, []string{"xy"}, 0, 2},
+		{"cancel", 4, 10, []string{"\x1b[2", "\x18x"}, []string{"x"}, 0, 1},
+		{"private parameters are not treated as cursor movement", 4, 10, []string{"\x1b[>1Cx"}, []string{"x"}, 0, 1},
+	}
+	for _, test := range tests {
+		check := func(how string, s *Screen) {
+			if lines := activeLines(s); !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("%s (%s): expected lines %q, got %q", test.name, how, test.lines, lines)
+			}
+			if s.cursor.y != test.y || s.cursor.x != test.x {
+				t.Errorf("%s (%s): expected the cursor at %v,%v, got %v,%v", test.name, how, test.y, test.x, s.cursor.y, s.cursor.x)
+			}
+		}
+		s := New(test.rows, test.cols, 10)
+		for _, write := range test.writes {
+			s.Write([]byte(write))
+		}
+		check("as written", s)
+		// the result must not depend on how the output is split
+		s = New(test.rows, test.cols, 10)
+		for _, b := range []byte(strings.Join(test.writes, "")) {
+			s.Write([]byte{b})
+		}
+		check("byte by byte", s)
+	}
+}
+
+func TestScreenOSC(t *testing.T) {
+	tests := []struct {
+		writes           []string
+		title            string
+		workingDirectory string
+	}{
+		{[]string{"\x1b]0;first\x07"}, "first", ""},
+		{[]string{"\x1b]2;first\x07\x1b]2;second\x1b\\"}, "second", ""},
+		{[]string{"\x1b]2;a;b\x07"}, "a;b", ""},
+		{[]string{"\x1b]2", ";split", " title", "\x07"}, "split title", ""},
+		{[]string{"\x1b]7;file://localhost/tmp/a%20b\x07"}, "", "/tmp/a b"},
+		{[]string{"\x1b]7;file://", "localhost/tmp", "\x1b", "\\"}, "", "/tmp"},
+		{[]string{"\x1b]7;file:///home\x07"}, "", "/home"},
+		{[]string{"\x1b]7;file://other.invalid/home\x07"}, "", ""},
+		{[]string{"\x1b]7;/home\x07"}, "", ""},
+		{[]string{"\x1b]2;title\x18\x07"}, "", ""},
+	}
+	for _, test := range tests {
+		s := New(4, 10, 10)
+		for _, write := range test.writes {
+			s.Write([]byte(write))
+		}
+		if s.Title() != test.title {
+			t.Errorf("expected %q to set the title %q, got %q", test.writes, test.title, s.Title())
+		}
+		if s.WorkingDirectory() != test.workingDirectory {
+			t.Errorf("expected %q to set the working directory %q, got %q", test.writes, test.workingDirectory, s.WorkingDirectory())
+		}
+	}
+}
+
+func TestScreenShellIntegrationMarks(t *testing.T) {
+	s := New(4, 20, 10)
+	marks := []Mark{}
+	s.OnMark(func(mark Mark) {
+		marks = append(marks, mark)
+	})
+	s.Write([]byte("\x1b]133;A\x07$ \x1b]133;B\x07"))
+	s.Write([]byte("echo hel"))
+	s.Write([]byte("lo world\x1b]133;C\x07\r\nhello world\r\n\x1b]133;D;1\x07"))
+	if len(marks) != 4 {
+		t.Fatalf("expected 4 marks, got %+v", marks)
+	}
+	if marks[2].Kind != 'C' || marks[2].Command != "echo hello world" {
+		t.Errorf("expected the command to be read from the wrapped line, got %+v", marks[2])
+	}
+	if marks[3].Kind != 'D' || marks[3].ExitCode == nil || *marks[3].ExitCode != 1 {
+		t.Errorf("expected the exit code 1, got %+v", marks[3])
+	}
+}
+
+func TestScreenAlternateScreen(t *testing.T) {
+	s := New(3, 10, 10)
+	s.Write([]byte("main\x1b[?1049h"))
+	if !s.IsAlternateScreen() {
+		t.Fatal("expected the alternate screen to be in use")
+	}
+	if lines := activeLines(s); len(lines) != 0 {
+		t.Errorf("expected the alternate screen to be cleared, got %q", lines)
+	}
+	// scrolling the alternate screen does not fill the scrollback
+	s.Write([]byte("\x1b[Halt\r\n\r\n\r\n\r\nend"))
+	if lines := activeLines(s); !reflect.DeepEqual(lines, []string{"", "", "end"}) {
+		t.Errorf("expected the alternate screen to have scrolled, got %q", lines)
+	}
+	if lines := s.Lines(); !reflect.DeepEqual(lines, []string{"main", "", ""}) {
+		t.Errorf("expected the main screen to be left as is, got %q", lines)
+	}
+	s.Write([]byte("\x1b[?1049l!"))
+	if s.IsAlternateScreen() {
+		t.Fatal("expected the main screen to be in use")
+	}
+	if lines := activeLines(s); !reflect.DeepEqual(lines, []string{"main!"}) {
+		t.Errorf("expected the cursor to be restored on the main screen, got %q", lines)
+	}
+	// 47 switches without clearing the alternate screen or saving the
+	// cursor
+	s.Write([]byte("\x1b[?47hx\x1b[?47l\x1b[?47h"))
+	if lines := activeLines(s); !reflect.DeepEqual(lines, []string{"     x", "", "end"}) {
+		t.Errorf("expected the alternate screen to be kept, got %q", lines)
+	}
+}
+
+func TestScreenScrollback(t *testing.T) {
+	s := New(3, 10, 3)
+	s.Write([]byte("1\r\n2\r\n3\r\n4\r\n5\r\n6\r\n7"))
+	if lines := s.Lines(); !reflect.DeepEqual(lines, []string{"2", "3", "4", "5", "6", "7"}) {
+		t.Errorf("expected the oldest lines to be dropped from the scrollback, got %q", lines)
+	}
+	// lines scrolling off a scroll region below the top are discarded
+	s.Write([]byte("\x1b[2;3r\x1b[3;1H\n"))
+	if lines := s.Lines(); !reflect.DeepEqual(lines, []string{"2", "3", "4", "5", "7", ""}) {
+		t.Errorf("expected the scroll region to not fill the scrollback, got %q", lines)
+	}
+	s.Write([]byte("\x1b[3J"))
+	if lines := s.Lines(); !reflect.DeepEqual(lines, []string{"5", "7", ""}) {
+		t.Errorf("expected the scrollback to be cleared, got %q", lines)
+	}
+}
+
+func TestScreenResize(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows, cols int
+		output     string
+		resizeRows int
+		resizeCols int
+		// lines are those of the scrollback and the main screen
+		lines []string
+		y, x  int
+	}{
+		{"fewer rows move lines into the scrollback", 3, 10, "1\r\n2\r\n3", 2, 10, []string{"1", "2", "3"}, 1, 1},
+		{"fewer rows drop blank lines below the cursor", 4, 10, "1\r\n2", 2, 10, []string{"1", "2"}, 1, 1},
+		{"more rows", 2, 10, "1\r\n2", 4, 10, []string{"1", "2", "", ""}, 1, 1},
+		{"fewer columns", 2, 10, "hello", 2, 3, []string{"hel", ""}, 0, 2},
+		{"more columns", 2, 3, "abc", 2, 6, []string{"abc", ""}, 0, 2},
+		{"invalid size", 2, 10, "abc", 0, 5, []string{"abc", ""}, 0, 3},
+	}
+	for _, test := range tests {
+		s := New(test.rows, test.cols, 10)
+		s.Write([]byte(test.output))
+		s.Resize(test.resizeRows, test.resizeCols)
+		if lines := s.Lines(); !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%s: expected lines %q, got %q", test.name, test.lines, lines)
+		}
+		if s.cursor.y != test.y || s.cursor.x != test.x {
+			t.Errorf("%s: expected the cursor at %v,%v, got %v,%v", test.name, test.y, test.x, s.cursor.y, s.cursor.x)
+		}
+	}
+}
+
+func TestScreenResizeResetsScrollRegion(t *testing.T) {
+	s := New(4, 10, 10)
+	s.Write([]byte("\x1b[2;3r\x1b[?1049h"))
+	s.Resize(6, 12)
+	if rows, cols := s.Size(); rows != 6 || cols != 12 {
+		t.Fatalf("expected the size 6x12, got %vx%v", rows, cols)
+	}
+	if s.scrollTop != 0 || s.scrollBottom != 5 {
+		t.Errorf("expected the scroll region to cover the screen, got %v-%v", s.scrollTop, s.scrollBottom)
+	}
+	if len(s.alternate.lines) != 6 || len(s.alternate.lines[0].cells) != 12 {
+		t.Errorf("expected the alternate screen to be resized")
+	}
+	// the new columns have tab stops
+	s.Write([]byte("\x1b[11G\tx"))
+	if lines := activeLines(s); !reflect.DeepEqual(lines, []string{"           x"}) {
+		t.Errorf("expected a tab to move to the last column, got %q", lines)
+	}
+}
+
+func TestScreenSnapshot(t *testing.T) {
+	outputs := []string{
+		"plain\r\ntext",
+		"abcdefghijklmno",
+		"\x1b[1;31mred\x1b[0m and \x1b[4mplain",
+		"a世界b\r\n\x1b[3;4Hcursor",
+		"1\r\n2\r\n3\r\n4\r\n5\r\n6\x1b[2;3r\x1b[3;2H",
+		"main\x1b[?1049halt\x1b[2;2H",
+		"\x1b]2;title\x07\x1b[?2004h\x1b[?25l\x1b7\x1b[2;2H",
+	}
+	for _, output := range outputs {
+		s := New(4, 10, 10)
+		s.Write([]byte(output))
+		replayed := New(4, 10, 10)
+		replayed.Write(s.Snapshot())
+		if !reflect.DeepEqual(replayed.Lines(), s.Lines()) {
+			t.Errorf("%q: expected the replayed lines %q, got %q", output, s.Lines(), replayed.Lines())
+		}
+		if !reflect.DeepEqual(activeLines(replayed), activeLines(s)) {
+			t.Errorf("%q: expected the replayed screen %q, got %q", output, activeLines(s), activeLines(replayed))
+		}
+		if replayed.cursor != s.cursor {
+			t.Errorf("%q: expected the replayed cursor %+v, got %+v", output, s.cursor, replayed.cursor)
+		}
+		if replayed.scrollTop != s.scrollTop || replayed.scrollBottom != s.scrollBottom {
+			t.Errorf("%q: expected the replayed scroll region %v-%v, got %v-%v", output, s.scrollTop, s.scrollBottom, replayed.scrollTop, replayed.scrollBottom)
+		}
+		if replayed.Title() != s.Title() || replayed.cursorVisible != s.cursorVisible || !reflect.DeepEqual(replayed.privateModes, s.privateModes) {
+			t.Errorf("%q: expected the replayed title and modes to match", output)
+		}
+		if replayed.IsAlternateScreen() != s.IsAlternateScreen() {
+			t.Errorf("%q: expected the replayed screen to use the same buffer", output)
+		}
+	}
+}
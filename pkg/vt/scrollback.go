@@ -0,0 +1,44 @@
+package vt
+
+// lineRing holds the most recent lines pushed into it up to its capacity
+type lineRing struct {
+	lines []*line
+	start int
+	count int
+}
+
+func newLineRing(capacity int) *lineRing {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &lineRing{lines: make([]*line, capacity)}
+}
+
+// push adds l as the newest line, discarding the oldest line when full
+func (r *lineRing) push(l *line) {
+	capacity := len(r.lines)
+	if capacity == 0 {
+		return
+	}
+	r.lines[(r.start+r.count)%capacity] = l
+	if r.count < capacity {
+		r.count++
+		return
+	}
+	r.start = (r.start + 1) % capacity
+}
+
+// each calls f with each line from oldest to newest
+func (r *lineRing) each(f func(*line)) {
+	for index := 0; index < r.count; index++ {
+		f(r.lines[(r.start+index)%len(r.lines)])
+	}
+}
+
+// clear removes all lines
+func (r *lineRing) clear() {
+	for index := range r.lines {
+		r.lines[index] = nil
+	}
+	r.start, r.count = 0, 0
+}
@@ -0,0 +1,142 @@
+package vt
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// localHostname is the hostname that OSC 7 reports have to name, reports
+// for other hosts come from programs such as ssh clients running in the
+// session
+var localHostname, _ = os.Hostname()
+
+// Mark is a shell integration mark emitted by shells configured to report
+// the structure of their prompts using OSC 133
+type Mark struct {
+	// Kind is one of 'A' (the prompt starts), 'B' (the command line
+	// starts), 'C' (the command is executed) and 'D' (the command finished)
+	Kind byte
+	// Command is the command line that was entered after the prompt, it is
+	// only set on 'C' marks when it could be read from the screen
+	Command string
+	// ExitCode is the exit status of the command, it is only set on 'D'
+	// marks when the shell reported it
+	ExitCode *int
+}
+
+// commandStart is the position of the start of the command line on the
+// screen, lines are tracked by identity so that it survives scrolling
+type commandStart struct {
+	line *line
+	x    int
+}
+
+// OnMark sets the function called with the shell integration marks in the
+// output, the function is called while the output is being written
+func (s *Screen) OnMark(handler func(Mark)) {
+	s.onMark = handler
+}
+
+// WorkingDirectory returns the working directory last reported by the
+// shell using OSC 7, it is empty when the shell does not report it
+func (s *Screen) WorkingDirectory() string {
+	return s.workingDirectory
+}
+
+// IsAlternateScreen returns true when the alternate screen is in use, this
+// is usually the case when a full screen program is running
+func (s *Screen) IsAlternateScreen() bool {
+	return s.active == s.alternate
+}
+
+// setWorkingDirectory handles OSC 7 whose value is a file:// url, urls
+// naming a host other than the local one are ignored
+func (s *Screen) setWorkingDirectory(value string) {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme != "file" || parsed.Path == "" {
+		return
+	}
+	if !isLocalHost(parsed.Hostname()) {
+		return
+	}
+	s.workingDirectory = parsed.Path
+}
+
+// isLocalHost returns true when host is empty or names the local host
+func isLocalHost(host string) bool {
+	return host == "" || host == "localhost" || strings.EqualFold(host, localHostname)
+}
+
+// shellIntegrationMark handles OSC 133 whose value is the kind of mark
+// optionally followed by parameters separated by semicolons
+func (s *Screen) shellIntegrationMark(value string) {
+	if value == "" {
+		return
+	}
+	mark := Mark{Kind: value[0]}
+	switch mark.Kind {
+	case 'A':
+		s.commandStart = nil
+	case 'B':
+		if s.active == s.main {
+			s.commandStart = &commandStart{line: s.active.lines[s.cursor.y], x: s.cursor.x}
+		}
+	case 'C':
+		mark.Command = s.readCommand()
+		s.commandStart = nil
+	case 'D':
+		parameters := strings.Split(value, ";")
+		if len(parameters) > 1 {
+			if exitCode, err := strconv.Atoi(parameters[1]); err == nil {
+				mark.ExitCode = &exitCode
+			}
+		}
+	default:
+		return
+	}
+	if s.onMark != nil {
+		s.onMark(mark)
+	}
+}
+
+// readCommand returns the text from the start of the command line up to
+// the end of the lines it wraps onto
+func (s *Screen) readCommand() string {
+	if s.commandStart == nil {
+		return ""
+	}
+	lines := []*line{}
+	s.scrollback.each(func(l *line) {
+		lines = append(lines, l)
+	})
+	lines = append(lines, s.main.lines...)
+	for index, l := range lines {
+		if l != s.commandStart.line {
+			continue
+		}
+		var command strings.Builder
+		x := s.commandStart.x
+		for ; index < len(lines); index++ {
+			for _, c := range lines[index].cells[min(x, len(lines[index].cells)):] {
+				if c.r != wideContinuation {
+					command.WriteRune(c.r)
+				}
+			}
+			x = 0
+			if !lines[index].wrapped {
+				break
+			}
+		}
+		return strings.TrimSpace(command.String())
+	}
+	return ""
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,154 @@
+package vt
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Snapshot returns the output that brings a terminal of the same size to
+// the state of the screen, this consists of the scrollback and main screen
+// followed by the alternate screen when it is in use, the modes, the
+// scroll region and finally the cursor
+func (s *Screen) Snapshot() []byte {
+	var output bytes.Buffer
+	// leave the alternate screen, reset attributes and scroll region and
+	// clear the screen of the receiving terminal
+	output.WriteString("\x1b[?1049l\x1b[0m\x1b[r\x1b[?6l\x1b[?7h\x1b[H\x1b[2J")
+
+	lines := []*line{}
+	s.scrollback.each(func(l *line) {
+		lines = append(lines, l)
+	})
+	lines = append(lines, s.main.lines...)
+	for index, l := range lines {
+		wrapsOntoNextLine := s.writeLine(&output, l, index < len(lines)-1)
+		if index < len(lines)-1 && !wrapsOntoNextLine {
+			output.WriteString("\r\n")
+		}
+	}
+
+	if s.active == s.alternate {
+		s.writeCursor(&output, s.main.savedCursor, false)
+		output.WriteString("\x1b[?1049h\x1b[H\x1b[2J")
+		for index, l := range s.alternate.lines {
+			fmt.Fprintf(&output, "\x1b[%d;1H", index+1)
+			s.writeLine(&output, l, false)
+		}
+		s.writeCursor(&output, s.alternate.savedCursor, false)
+	} else {
+		s.writeCursor(&output, s.main.savedCursor, false)
+	}
+	// the saved cursor of the active screen is restored by DECRC
+	output.WriteString("\x1b7")
+
+	if s.scrollTop != 0 || s.scrollBottom != s.rows-1 {
+		fmt.Fprintf(&output, "\x1b[%d;%dr", s.scrollTop+1, s.scrollBottom+1)
+	}
+	if s.title != "" {
+		fmt.Fprintf(&output, "\x1b]2;%s\x07", s.title)
+	}
+	if s.keypadMode {
+		output.WriteString("\x1b=")
+	}
+	if s.cursorStyle != 0 {
+		fmt.Fprintf(&output, "\x1b[%d q", s.cursorStyle)
+	}
+	modes := make([]int, 0, len(s.privateModes))
+	for mode := range s.privateModes {
+		modes = append(modes, mode)
+	}
+	sort.Ints(modes)
+	for _, mode := range modes {
+		fmt.Fprintf(&output, "\x1b[?%dh", mode)
+	}
+	if !s.cursorVisible {
+		output.WriteString("\x1b[?25l")
+	}
+
+	s.writeCursor(&output, s.cursor, true)
+	if s.insertMode {
+		output.WriteString("\x1b[4h")
+	}
+	if !s.autowrap {
+		output.WriteString("\x1b[?7l")
+	}
+	return output.Bytes()
+}
+
+// CursorLine returns output rewriting the line the cursor is on from the
+// start of the current line of the receiving terminal and moving the
+// cursor to the same column, it is used to repeat a line after writing
+// a message below it
+func (s *Screen) CursorLine() []byte {
+	var output bytes.Buffer
+	output.WriteString("\r")
+	s.writeLine(&output, s.active.lines[s.cursor.y], false)
+	output.WriteString("\r")
+	if s.cursor.x > 0 {
+		fmt.Fprintf(&output, "\x1b[%dC", s.cursor.x)
+	}
+	output.WriteString(s.cursor.attrs.sgr())
+	return output.Bytes()
+}
+
+// writeLine writes the cells of l, the line is written in full when
+// allowWrap is true and it wraps onto the next line so that the receiving
+// terminal also considers the lines to be one; it returns true if the
+// line was written in full
+func (s *Screen) writeLine(output *bytes.Buffer, l *line, allowWrap bool) bool {
+	end := len(l.cells)
+	wraps := allowWrap && l.wrapped && end == s.cols
+	if !wraps {
+		// trailing blanks without a background color do not need to be written
+		for end > 0 && l.cells[end-1].r == ' ' && l.cells[end-1].attrs == (attributes{}) {
+			end--
+		}
+	}
+	current := attributes{}
+	for _, c := range l.cells[:end] {
+		if c.r == wideContinuation {
+			continue
+		}
+		if c.attrs != current {
+			output.WriteString(c.attrs.sgr())
+			current = c.attrs
+		}
+		output.WriteRune(c.r)
+	}
+	if current != (attributes{}) {
+		output.WriteString("\x1b[0m")
+	}
+	return wraps
+}
+
+// writeCursor moves the cursor to the position of c and selects its
+// attributes and character set, the origin mode and pending wrap of c are
+// only restored when final is true as restoring them moves the cursor
+func (s *Screen) writeCursor(output *bytes.Buffer, c cursor, final bool) {
+	row := c.y + 1
+	if final && c.originMode {
+		output.WriteString("\x1b[?6h")
+		row -= s.scrollTop
+	}
+	if final && c.pendingWrap && c.x == s.cols-1 {
+		// rewriting the last character of the line leaves the receiving
+		// terminal waiting to wrap as well
+		l := s.active.lines[c.y]
+		column := c.x
+		if l.cells[column].r == wideContinuation && column > 0 {
+			column--
+		}
+		fmt.Fprintf(output, "\x1b[%d;%dH", row, column+1)
+		output.WriteString(l.cells[column].attrs.sgr())
+		output.WriteRune(l.cells[column].r)
+	} else {
+		fmt.Fprintf(output, "\x1b[%d;%dH", row, c.x+1)
+	}
+	output.WriteString(c.attrs.sgr())
+	if c.lineDrawing {
+		output.WriteString("\x1b(0")
+	} else {
+		output.WriteString("\x1b(B")
+	}
+}
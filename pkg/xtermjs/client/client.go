@@ -0,0 +1,285 @@
+// Package client implements a client for the xterm.js websocket protocol
+// served by the xtermjs package so that sessions can be scripted from Go
+package client
+
+import (
+	"bytes"
+	"cloudshell/pkg/clipboard"
+	"cloudshell/pkg/xtermjs"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultKeepalivePingTimeout is the keepalive ping timeout used when
+// Options.KeepalivePingTimeout is not specified, it matches the server's
+const DefaultKeepalivePingTimeout = 20 * time.Second
+
+// ErrClosed is returned when using a client that has been closed
+var ErrClosed = errors.New("connection is closed")
+
+// Options configures a connection created by Dial
+type Options struct {
+	// Header is sent with the connection request, use this to pass
+	// credentials required by an authenticating proxy
+	Header http.Header
+	// HandshakeTimeout is the maximum duration of the websocket handshake
+	HandshakeTimeout time.Duration
+	// KeepalivePingTimeout is the maximum duration between a ping sent by
+	// the client and its response before the connection is considered dead,
+	// pings are sent every half of this duration
+	KeepalivePingTimeout time.Duration
+	// TLSClientConfig is the tls configuration used for wss:// urls
+	TLSClientConfig *tls.Config
+}
+
+// Client is a connection to a xterm.js websocket endpoint, reading returns
+// the output of the remote tty and writing sends input to it. Output is
+// buffered until it is read
+type Client struct {
+	connection *websocket.Conn
+	done       chan struct{}
+	closeOnce  sync.Once
+	writeMutex sync.Mutex
+
+	outputMutex sync.Mutex
+	outputReady *sync.Cond
+	output      bytes.Buffer
+	readErr     error
+	exitCode    int
+	hasExited   bool
+}
+
+// GetURL returns the url of the xterm.js endpoint at endpointPath (eg.
+// '/xterm.js') of the server at serverURL with query, http(s) schemes are
+// converted into their websocket equivalents
+func GetURL(serverURL, endpointPath string, query url.Values) (string, error) {
+	endpointURL, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse server url '%s': %s", serverURL, err)
+	}
+	switch endpointURL.Scheme {
+	case "http", "ws":
+		endpointURL.Scheme = "ws"
+	case "https", "wss":
+		endpointURL.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("server url '%s' should use one of the schemes ['http', 'https', 'ws', 'wss']", serverURL)
+	}
+	endpointURL.Path = path.Join("/", endpointURL.Path, endpointPath)
+	endpointQuery := endpointURL.Query()
+	for key, values := range query {
+		for _, value := range values {
+			endpointQuery.Add(key, value)
+		}
+	}
+	endpointURL.RawQuery = endpointQuery.Encode()
+	return endpointURL.String(), nil
+}
+
+// Dial connects to the xterm.js websocket endpoint at endpointURL
+func Dial(endpointURL string, opts Options) (*Client, error) {
+	handshakeTimeout := opts.HandshakeTimeout
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = 45 * time.Second
+	}
+	keepalivePingTimeout := opts.KeepalivePingTimeout
+	if keepalivePingTimeout <= time.Second {
+		keepalivePingTimeout = DefaultKeepalivePingTimeout
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: handshakeTimeout,
+		Proxy:            http.ProxyFromEnvironment,
+		TLSClientConfig:  opts.TLSClientConfig,
+	}
+	connection, response, err := dialer.Dial(endpointURL, opts.Header)
+	if err != nil {
+		if response != nil {
+			body, _ := ioutil.ReadAll(response.Body)
+			return nil, fmt.Errorf("failed to connect to '%s': %s: %s", endpointURL, response.Status, string(body))
+		}
+		return nil, fmt.Errorf("failed to connect to '%s': %s", endpointURL, err)
+	}
+	client := &Client{
+		connection: connection,
+		done:       make(chan struct{}),
+	}
+	client.outputReady = sync.NewCond(&client.outputMutex)
+	go client.keepalive(keepalivePingTimeout)
+	go client.receive()
+	return client, nil
+}
+
+// keepalive pings the server and closes the connection when the server
+// stops responding, this mirrors the keepalive loop of the server
+func (c *Client) keepalive(timeout time.Duration) {
+	var lastPongTimeMutex sync.Mutex
+	lastPongTime := time.Now()
+	c.connection.SetPongHandler(func(string) error {
+		lastPongTimeMutex.Lock()
+		defer lastPongTimeMutex.Unlock()
+		lastPongTime = time.Now()
+		return nil
+	})
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		if err := c.connection.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(timeout/2)); err != nil {
+			c.closeWithError(fmt.Errorf("failed to write ping message: %s", err))
+			return
+		}
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		lastPongTimeMutex.Lock()
+		elapsed := time.Now().Sub(lastPongTime)
+		lastPongTimeMutex.Unlock()
+		if elapsed > timeout {
+			c.closeWithError(errors.New("failed to get response from ping"))
+			return
+		}
+	}
+}
+
+// receive reads messages from the server into the output buffer until
+// the connection is closed
+func (c *Client) receive() {
+	for {
+		messageType, data, err := c.connection.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				if exitCode, ok := xtermjs.ParseExitCloseReason(closeErr.Text); ok {
+					c.outputMutex.Lock()
+					c.exitCode = exitCode
+					c.hasExited = true
+					c.outputMutex.Unlock()
+					c.closeWithError(io.EOF)
+					return
+				}
+				if closeErr.Code == websocket.CloseNormalClosure {
+					c.closeWithError(io.EOF)
+					return
+				}
+			}
+			c.closeWithError(err)
+			return
+		}
+		if messageType == websocket.TextMessage {
+			// copies to the clipboard are written back as OSC 52 so that
+			// the local terminal sets its clipboard
+			if copied, ok := xtermjs.ParseClipboardMessage(data); ok {
+				data = clipboard.Format(copied)
+			}
+		}
+		c.outputMutex.Lock()
+		c.output.Write(data)
+		c.outputMutex.Unlock()
+		c.outputReady.Broadcast()
+	}
+}
+
+// closeWithError closes the connection, reads return err once the
+// buffered output has been read
+func (c *Client) closeWithError(err error) {
+	c.closeOnce.Do(func() {
+		c.outputMutex.Lock()
+		c.readErr = err
+		c.outputMutex.Unlock()
+		close(c.done)
+		c.connection.Close()
+		c.outputReady.Broadcast()
+	})
+}
+
+// Read implements io.Reader by reading the output of the remote tty, io.EOF
+// is returned after the remote process exits and its output has been read
+func (c *Client) Read(p []byte) (int, error) {
+	c.outputMutex.Lock()
+	defer c.outputMutex.Unlock()
+	for c.output.Len() == 0 && c.readErr == nil {
+		c.outputReady.Wait()
+	}
+	if c.output.Len() > 0 {
+		return c.output.Read(p)
+	}
+	return 0, c.readErr
+}
+
+// Write implements io.Writer by sending p as input to the remote tty
+func (c *Client) Write(p []byte) (int, error) {
+	if err := c.writeMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close implements io.Closer by closing the connection, this terminates
+// the remote process
+func (c *Client) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	c.writeMutex.Lock()
+	c.connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMutex.Unlock()
+	c.closeWithError(ErrClosed)
+	return nil
+}
+
+// Resize sets the size of the remote tty
+func (c *Client) Resize(rows, cols uint16) error {
+	message, err := xtermjs.FormatResizeMessage(xtermjs.TTYSize{Rows: rows, Cols: cols})
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(websocket.BinaryMessage, message)
+}
+
+// Signal delivers signal to the foreground process of the remote tty, only
+// the signals in xtermjs.Signals are supported
+func (c *Client) Signal(signal syscall.Signal) error {
+	message, err := xtermjs.FormatSignalMessage(signal)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(websocket.BinaryMessage, message)
+}
+
+// Done returns a channel that is closed when the connection is closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// ExitCode returns the exit status of the remote process and whether the
+// server reported one, it is only available after the connection is closed
+func (c *Client) ExitCode() (int, bool) {
+	c.outputMutex.Lock()
+	defer c.outputMutex.Unlock()
+	return c.exitCode, c.hasExited
+}
+
+func (c *Client) writeMessage(messageType int, data []byte) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.connection.WriteMessage(messageType, data)
+}
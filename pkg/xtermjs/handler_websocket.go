@@ -3,29 +3,41 @@ package xtermjs
 import (
 	"bytes"
 	"cloudshell/internal/log"
+	"cloudshell/pkg/audit"
+	"cloudshell/pkg/auth"
+	"cloudshell/pkg/policy"
+	"cloudshell/pkg/profile"
+	"cloudshell/pkg/redact"
+	"cloudshell/pkg/session"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"os/exec"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/creack/pty"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 const DefaultConnectionErrorLimit = 10
 
+// SessionQueryParameter is the query parameter identifying an existing
+// session to attach to instead of starting a new one
+const SessionQueryParameter = "session"
+
 type HandlerOpts struct {
 	// AllowedHostnames is a list of strings which will be matched to the client
 	// requesting for a connection upgrade to a websocket connection
 	AllowedHostnames []string
-	// Arguments is a list of strings to pass as arguments to the specified COmmand
+	// Arguments is a list of strings to pass as arguments to the specified COmmand,
+	// this is ignored when Profiles is specified
 	Arguments []string
-	// Command is the path to the binary we should create a TTY for
+	// Audit when specified receives the commands executed in sessions
+	Audit audit.Sink
+	// Command is the path to the binary we should create a TTY for, this is
+	// ignored when Profiles is specified
 	Command string
 	// ConnectionErrorLimit defines the number of consecutive errors that can happen
 	// before a connection is considered unusable
@@ -38,184 +50,379 @@ type HandlerOpts struct {
 	// cycle should be tolerated, beyond this the connection should be deemed dead
 	KeepalivePingTimeout time.Duration
 	MaxBufferSizeBytes   int
+	// GetProfileName when specified should return the name of the profile
+	// requested by the client. When not specified, the `profile` query
+	// parameter is used
+	GetProfileName func(*http.Request) string
+	// Policy when specified decides whether the commands entered into
+	// sessions may be submitted to the shell
+	Policy *policy.Policy
+	// Profiles when specified is the set of profiles that connections can
+	// select from, an empty profile name selects the default profile
+	Profiles *profile.Registry
+	// RecordingsDirectory when specified is the directory that the output
+	// of sessions is recorded to
+	RecordingsDirectory string
+	// Redactor when specified removes secrets from the recorded output and
+	// the audited commands of sessions
+	Redactor *redact.Redactor
+	// ScrollbackLines is the number of lines that scrolled off the screen of
+	// a session that are sent to connections attaching to it
+	ScrollbackLines int
+	// Sessions when specified is the registry that sessions are added to so
+	// that they can be used by other handlers, when not specified sessions
+	// are only accessible through their websocket connection
+	Sessions *session.Registry
+	// ZModemMaxSizeBytes when greater than 0 enables handing ZMODEM
+	// transfers in sessions to the browser and is the maximum size of each
+	// file transferred
+	ZModemMaxSizeBytes int64
+}
+
+// Handler is a xterm.js websocket handler whose options can be updated
+// while it is serving connections, existing connections keep using the
+// options they were established with
+type Handler struct {
+	mutex    sync.RWMutex
+	opts     HandlerOpts
+	sessions *session.Registry
+}
+
+// NewHandler returns a xterm.js websocket handler using opts
+func NewHandler(opts HandlerOpts) *Handler {
+	return &Handler{
+		opts:     opts,
+		sessions: session.NewRegistry(),
+	}
 }
 
+// GetHandler returns a xterm.js websocket handler function using opts
 func GetHandler(opts HandlerOpts) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		connectionErrorLimit := opts.ConnectionErrorLimit
-		if connectionErrorLimit < 0 {
-			connectionErrorLimit = DefaultConnectionErrorLimit
-		}
-		maxBufferSizeBytes := opts.MaxBufferSizeBytes
-		keepalivePingTimeout := opts.KeepalivePingTimeout
-		if keepalivePingTimeout <= time.Second {
-			keepalivePingTimeout = 20 * time.Second
-		}
+	return NewHandler(opts).ServeHTTP
+}
+
+// Update replaces the options used for new connections
+func (h *Handler) Update(opts HandlerOpts) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.opts = opts
+}
 
-		connectionUUID, err := uuid.NewUUID()
-		if err != nil {
-			message := "failed to get a connection uuid"
-			log.Errorf("%s: %s", message, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(message))
+func (h *Handler) getOpts() HandlerOpts {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.opts
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	opts := h.getOpts()
+	sessions := h.sessions
+	if opts.Sessions != nil {
+		sessions = opts.Sessions
+	}
+
+	connectionUUID, err := uuid.NewUUID()
+	if err != nil {
+		message := "failed to get a connection uuid"
+		log.Errorf("%s: %s", message, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(message))
+		return
+	}
+	var clog Logger = defaultLogger
+	if opts.CreateLogger != nil {
+		clog = opts.CreateLogger(connectionUUID.String(), r)
+	}
+
+	// the process of new sessions is only started once the connection has
+	// been upgraded so that rejected connections do not start processes
+	var tty *session.Session
+	var selectedProfile profile.Profile
+	if sessionID := r.URL.Query().Get(SessionQueryParameter); sessionID != "" {
+		if tty = getExistingSession(w, r, sessions, sessionID, clog); tty == nil {
 			return
 		}
-		var clog Logger = defaultLogger
-		if opts.CreateLogger != nil {
-			clog = opts.CreateLogger(connectionUUID.String(), r)
+		selectedProfile = tty.Profile
+	} else {
+		var ok bool
+		if selectedProfile, ok = getSessionProfile(w, r, opts, clog); !ok {
+			return
 		}
-		clog.Info("established connection identity")
-
-		allowedHostnames := opts.AllowedHostnames
-		upgrader := getConnectionUpgrader(allowedHostnames, maxBufferSizeBytes, clog)
-		connection, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			clog.Warnf("failed to upgrade connection: %s", err)
+	}
+	upgrader := getConnectionUpgrader(opts.AllowedHostnames, getLimits(opts, selectedProfile).maxBufferSizeBytes, clog)
+	connection, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		clog.Warnf("failed to upgrade connection: %s", err)
+		return
+	}
+	if tty == nil {
+		if tty = createSession(connection, r, opts, sessions, selectedProfile, connectionUUID.String(), clog); tty == nil {
 			return
 		}
+	}
+	h.serveSession(connection, opts, tty, clog)
+}
 
-		terminal := opts.Command
-		args := opts.Arguments
-		clog.Debugf("starting new tty using command '%s' with arguments ['%s']...", terminal, strings.Join(args, "', '"))
-		cmd := exec.Command(terminal, args...)
-		cmd.Env = os.Environ()
-		tty, err := pty.Start(cmd)
-		if err != nil {
-			message := fmt.Sprintf("failed to start tty: %s", err)
-			clog.Warn(message)
-			connection.WriteMessage(websocket.TextMessage, []byte(message))
-			return
+// getExistingSession returns the session identified by sessionID, an error
+// response is written if it cannot be used by the requesting principal
+func getExistingSession(w http.ResponseWriter, r *http.Request, sessions *session.Registry, sessionID string, clog Logger) *session.Session {
+	tty, ok := sessions.Get(sessionID)
+	if !ok {
+		message := fmt.Sprintf("failed to find session '%s'", sessionID)
+		clog.Warn(message)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(message))
+		return nil
+	}
+	if !tty.IsAccessibleBy(auth.GetPrincipal(r.Context())) {
+		message := fmt.Sprintf("not allowed to access session '%s'", sessionID)
+		clog.Warn(message)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(message))
+		return nil
+	}
+	clog.Infof("established connection identity attaching to session '%s'", sessionID)
+	return tty
+}
+
+// getSessionProfile returns the profile requested in r with its parameters
+// applied, an error response is written if it cannot be used
+func getSessionProfile(w http.ResponseWriter, r *http.Request, opts HandlerOpts, clog Logger) (profile.Profile, bool) {
+	profileName := getProfileName(opts, r)
+	selectedProfile, ok := getProfile(opts, profileName)
+	if !ok {
+		message := fmt.Sprintf("failed to find profile '%s'", profileName)
+		clog.Warn(message)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(message))
+		return profile.Profile{}, false
+	}
+	selectedProfile, err := selectedProfile.Instantiate(r.URL.Query(), auth.GetClaims(r.Context()))
+	if err != nil {
+		message := fmt.Sprintf("failed to use profile '%s': %s", profileName, err)
+		clog.Warn(message)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(message))
+		return profile.Profile{}, false
+	}
+	clog.Infof("established connection identity using profile '%s'", selectedProfile.Name)
+	return selectedProfile, true
+}
+
+// createSession starts a session using selectedProfile, the connection is
+// closed with the reason if it cannot be started
+func createSession(connection *websocket.Conn, r *http.Request, opts HandlerOpts, sessions *session.Registry, selectedProfile profile.Profile, sessionID string, clog Logger) *session.Session {
+	owner := ""
+	if principal := auth.GetPrincipal(r.Context()); principal != nil {
+		owner = principal.Name
+	}
+	clog.Debugf("starting new tty using command '%s' with arguments ['%s']...", selectedProfile.Command, strings.Join(selectedProfile.Arguments, "', '"))
+	tty, err := sessions.Create(sessionID, selectedProfile, session.Options{
+		Audit:               opts.Audit,
+		Logger:              clog,
+		MaxBufferSizeBytes:  getLimits(opts, selectedProfile).maxBufferSizeBytes,
+		Owner:               owner,
+		Policy:              opts.Policy,
+		RecordingsDirectory: opts.RecordingsDirectory,
+		Redactor:            opts.Redactor,
+		ScrollbackLines:     opts.ScrollbackLines,
+		ZModemMaxSizeBytes:  opts.ZModemMaxSizeBytes,
+	})
+	if err != nil {
+		var limitErr *session.LimitError
+		code := websocket.CloseInternalServerErr
+		if errors.As(err, &limitErr) {
+			code = websocket.CloseTryAgainLater
+		}
+		message := fmt.Sprintf("failed to start tty: %s", err)
+		clog.Warn(message)
+		if err := connection.WriteControl(websocket.CloseMessage, FormatErrorCloseMessage(code, message), time.Now().Add(time.Second)); err != nil {
+			clog.Warnf("failed to send error to xterm.js: %s", err)
+		}
+		connection.Close()
+		return nil
+	}
+	return tty
+}
+
+// serveSession connects xterm.js to tty over connection, sessions that are
+// not headless end when their last attachment closes
+func (h *Handler) serveSession(connection *websocket.Conn, opts HandlerOpts, tty *session.Session, clog Logger) {
+	limits := getLimits(opts, tty.Profile)
+	connectionErrorLimit := limits.connectionErrorLimit
+	keepalivePingTimeout := limits.keepalivePingTimeout
+	output := tty.Attach()
+	defer func() {
+		if exitCode, ok := tty.ExitCode(); ok {
+			if err := connection.WriteControl(websocket.CloseMessage, FormatExitCloseMessage(exitCode), time.Now().Add(time.Second)); err != nil {
+				clog.Warnf("failed to send exit status to xterm.js: %s", err)
+			}
 		}
-		defer func() {
-			clog.Info("gracefully stopping spawned tty...")
-			if err := cmd.Process.Kill(); err != nil {
-				clog.Warnf("failed to kill process: %s", err)
+		output.Close()
+		if err := connection.Close(); err != nil {
+			clog.Warnf("failed to close webscoket connection: %s", err)
+		}
+	}()
+
+	var connectionClosed bool
+	var writeMutex sync.Mutex
+	writeMessage := func(messageType int, data []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return connection.WriteMessage(messageType, data)
+	}
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	triggerStop := func() {
+		stopOnce.Do(func() { close(stop) })
+	}
+
+	// this is a keep-alive loop that ensures connection does not hang-up itself
+	lastPongTime := time.Now()
+	connection.SetPongHandler(func(msg string) error {
+		lastPongTime = time.Now()
+		return nil
+	})
+	go func() {
+		for {
+			if err := writeMessage(websocket.PingMessage, []byte("keepalive")); err != nil {
+				clog.Warn("failed to write ping message")
+				return
 			}
-			if _, err := cmd.Process.Wait(); err != nil {
-				clog.Warnf("failed to wait for process to exit: %s", err)
+			time.Sleep(keepalivePingTimeout / 2)
+			if time.Now().Sub(lastPongTime) > keepalivePingTimeout {
+				clog.Warn("failed to get response from ping, triggering disconnect now...")
+				triggerStop()
+				return
 			}
-			if err := tty.Close(); err != nil {
-				clog.Warnf("failed to close spawned tty gracefully: %s", err)
+			clog.Debug("received response from ping successfully")
+		}
+	}()
+
+	// tty >> xterm.js
+	go func() {
+		errorCounter := 0
+		send := func(messageType int, data []byte) bool {
+			// consider the connection closed/errored out so that the socket handler
+			// can be terminated - this frees up memory so the service doesn't get
+			// overloaded
+			if errorCounter > connectionErrorLimit {
+				triggerStop()
+				return false
 			}
-			if err := connection.Close(); err != nil {
-				clog.Warnf("failed to close webscoket connection: %s", err)
+			if err := writeMessage(messageType, data); err != nil {
+				clog.Warnf("failed to send %v bytes from tty to xterm.js", len(data))
+				errorCounter++
+				return true
 			}
-		}()
-
-		var connectionClosed bool
-		var waiter sync.WaitGroup
-		waiter.Add(1)
-
-		// this is a keep-alive loop that ensures connection does not hang-up itself
-		lastPongTime := time.Now()
-		connection.SetPongHandler(func(msg string) error {
-			lastPongTime = time.Now()
-			return nil
-		})
-		go func() {
-			for {
-				if err := connection.WriteMessage(websocket.PingMessage, []byte("keepalive")); err != nil {
-					clog.Warn("failed to write ping message")
-					return
-				}
-				time.Sleep(keepalivePingTimeout / 2)
-				if time.Now().Sub(lastPongTime) > keepalivePingTimeout {
-					clog.Warn("failed to get response from ping, triggering disconnect now...")
-					waiter.Done()
+			clog.Tracef("sent message of size %v bytes from tty to xterm.js", len(data))
+			errorCounter = 0
+			return true
+		}
+		outputs := output.Output()
+		copies := output.Clipboard()
+		for outputs != nil {
+			select {
+			case data, ok := <-outputs:
+				if !ok {
+					outputs = nil
+				} else if !send(websocket.BinaryMessage, data) {
 					return
 				}
-				clog.Debug("received response from ping successfully")
-			}
-		}()
-
-		// tty >> xterm.js
-		go func() {
-			errorCounter := 0
-			for {
-				// consider the connection closed/errored out so that the socket handler
-				// can be terminated - this frees up memory so the service doesn't get
-				// overloaded
-				if errorCounter > connectionErrorLimit {
-					waiter.Done()
-					break
+			case c, ok := <-copies:
+				if !ok {
+					copies = nil
+					continue
 				}
-				buffer := make([]byte, maxBufferSizeBytes)
-				readLength, err := tty.Read(buffer)
+				message, err := FormatClipboardMessage(c)
 				if err != nil {
-					clog.Warnf("failed to read from tty: %s", err)
-					if err := connection.WriteMessage(websocket.TextMessage, []byte("bye!")); err != nil {
-						clog.Warnf("failed to send termination message from tty to xterm.js: %s", err)
-					}
-					waiter.Done()
-					return
-				}
-				if err := connection.WriteMessage(websocket.BinaryMessage, buffer[:readLength]); err != nil {
-					clog.Warnf("failed to send %v bytes from tty to xterm.js", readLength)
-					errorCounter++
+					clog.Warnf("failed to format clipboard message: %s", err)
 					continue
 				}
-				clog.Tracef("sent message of size %v bytes from tty to xterm.js", readLength)
-				errorCounter = 0
-			}
-		}()
-
-		// tty << xterm.js
-		go func() {
-			for {
-				// data processing
-				messageType, data, err := connection.ReadMessage()
-				if err != nil {
-					if !connectionClosed {
-						clog.Warnf("failed to get next reader: %s", err)
-					}
+				if !send(websocket.TextMessage, message) {
 					return
 				}
-				dataLength := len(data)
-				dataBuffer := bytes.Trim(data, "\x00")
-				dataType, ok := WebsocketMessageType[messageType]
-				if !ok {
-					dataType = "uunknown"
-				}
-				clog.Infof("received %s (type: %v) message of size %v byte(s) from xterm.js with key sequence: %v", dataType, messageType, dataLength, dataBuffer)
+			}
+		}
+		clog.Warn("stopped receiving output from tty")
+		if err := writeMessage(websocket.TextMessage, []byte("bye!")); err != nil {
+			clog.Warnf("failed to send termination message from tty to xterm.js: %s", err)
+		}
+		triggerStop()
+	}()
 
-				// process
-				if dataLength == -1 { // invalid
-					clog.Warn("failed to get the correct number of bytes read, ignoring message")
-					continue
+	// tty << xterm.js
+	go func() {
+		for {
+			// data processing
+			messageType, data, err := connection.ReadMessage()
+			if err != nil {
+				if !connectionClosed {
+					clog.Warnf("failed to get next reader: %s", err)
 				}
+				triggerStop()
+				return
+			}
+			dataLength := len(data)
+			dataBuffer := bytes.Trim(data, "\x00")
+			dataType, ok := WebsocketMessageType[messageType]
+			if !ok {
+				dataType = "uunknown"
+			}
+			clog.Tracef("received %s (type: %v) message of size %v byte(s) from xterm.js", dataType, messageType, dataLength)
 
-				// handle resizing
-				if messageType == websocket.BinaryMessage {
-					if dataBuffer[0] == 1 {
-						ttySize := &TTYSize{}
-						resizeMessage := bytes.Trim(dataBuffer[1:], " \n\r\t\x00\x01")
-						if err := json.Unmarshal(resizeMessage, ttySize); err != nil {
-							clog.Warnf("failed to unmarshal received resize message '%s': %s", string(resizeMessage), err)
-							continue
-						}
-						clog.Infof("resizing tty to use %v rows and %v columns...", ttySize.Rows, ttySize.Cols)
-						if err := pty.Setsize(tty, &pty.Winsize{
-							Rows: ttySize.Rows,
-							Cols: ttySize.Cols,
-						}); err != nil {
-							clog.Warnf("failed to resize tty, error: %s", err)
-						}
+			// process
+			if dataLength == -1 { // invalid
+				clog.Warn("failed to get the correct number of bytes read, ignoring message")
+				continue
+			}
+
+			// handle resizing
+			if messageType == websocket.BinaryMessage {
+				if len(dataBuffer) > 0 && dataBuffer[0] == MessageTypeResize {
+					ttySize := &TTYSize{}
+					resizeMessage := bytes.Trim(dataBuffer[1:], " \n\r\t\x00\x01")
+					if err := json.Unmarshal(resizeMessage, ttySize); err != nil {
+						clog.Warnf("failed to unmarshal received resize message '%s': %s", string(resizeMessage), err)
 						continue
 					}
+					clog.Infof("resizing tty to use %v rows and %v columns...", ttySize.Rows, ttySize.Cols)
+					if err := tty.Resize(ttySize.Rows, ttySize.Cols); err != nil {
+						clog.Warnf("failed to resize tty, error: %s", err)
+					}
+					continue
 				}
-
-				// write to tty
-				bytesWritten, err := tty.Write(dataBuffer)
-				if err != nil {
-					clog.Warn(fmt.Sprintf("failed to write %v bytes to tty: %s", len(dataBuffer), err))
+				if len(dataBuffer) > 0 && dataBuffer[0] == MessageTypeSignal {
+					signalMessage := &SignalMessage{}
+					if err := json.Unmarshal(dataBuffer[1:], signalMessage); err != nil {
+						clog.Warnf("failed to unmarshal received signal message '%s': %s", string(dataBuffer[1:]), err)
+						continue
+					}
+					signal, ok := Signals[signalMessage.Signal]
+					if !ok {
+						clog.Warnf("failed to find signal '%s' in the list of supported signals", signalMessage.Signal)
+						continue
+					}
+					clog.Infof("sending signal '%s' to tty...", signalMessage.Signal)
+					if err := tty.Signal(signal); err != nil {
+						clog.Warnf("failed to signal tty, error: %s", err)
+					}
 					continue
 				}
-				clog.Tracef("%v bytes written to tty...", bytesWritten)
 			}
-		}()
 
-		waiter.Wait()
-		log.Info("closing connection...")
-		connectionClosed = true
-	}
+			// write to tty
+			bytesWritten, err := tty.Write(dataBuffer)
+			if err != nil {
+				clog.Warn(fmt.Sprintf("failed to write %v bytes to tty: %s", len(dataBuffer), err))
+				continue
+			}
+			clog.Tracef("%v bytes written to tty...", bytesWritten)
+		}
+	}()
+
+	<-stop
+	log.Info("closing connection...")
+	connectionClosed = true
 }
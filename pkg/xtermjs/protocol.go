@@ -0,0 +1,146 @@
+package xtermjs
+
+import (
+	"cloudshell/pkg/clipboard"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// MessageTypeResize is the first byte of binary messages sent by the
+	// client to resize the tty, it is followed by a JSON-encoded TTYSize
+	MessageTypeResize byte = 1
+	// MessageTypeSignal is the first byte of binary messages sent by the
+	// client to signal the foreground process of the tty, it is followed
+	// by a JSON-encoded SignalMessage
+	MessageTypeSignal byte = 2
+	// MessageTypeClipboard is the first byte of text messages sent by the
+	// server when a program copied to the clipboard, it is followed by a
+	// JSON-encoded ClipboardMessage
+	MessageTypeClipboard byte = 3
+
+	// closeReasonExitPrefix prefixes the reason of the close message sent
+	// when the process exits, it is followed by the exit status
+	closeReasonExitPrefix = "exit:"
+)
+
+// FormatResizeMessage returns the binary message that resizes the tty to size
+func FormatResizeMessage(size TTYSize) ([]byte, error) {
+	encoded, err := json.Marshal(size)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{MessageTypeResize}, encoded...), nil
+}
+
+// SignalMessage represents a JSON structure sent by a client to deliver a
+// signal to the foreground process of the tty
+type SignalMessage struct {
+	// Signal is the name of the signal (eg. 'SIGTERM')
+	Signal string `json:"signal"`
+}
+
+// Signals maps the names of the signals that clients can send to their values
+var Signals = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGKILL":  syscall.SIGKILL,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGCONT":  syscall.SIGCONT,
+	"SIGTSTP":  syscall.SIGTSTP,
+	"SIGWINCH": syscall.SIGWINCH,
+}
+
+// FormatSignalMessage returns the binary message that delivers signal to the
+// foreground process of the tty
+func FormatSignalMessage(signal syscall.Signal) ([]byte, error) {
+	for name, value := range Signals {
+		if value == signal {
+			encoded, err := json.Marshal(SignalMessage{Signal: name})
+			if err != nil {
+				return nil, err
+			}
+			return append([]byte{MessageTypeSignal}, encoded...), nil
+		}
+	}
+	return nil, fmt.Errorf("signal '%s' is not supported", signal)
+}
+
+// ClipboardMessage represents a JSON structure sent to clients with the
+// content that a program copied to the clipboard
+type ClipboardMessage struct {
+	// Selection holds the clipboards that the program asked to set (eg. 'c')
+	Selection string `json:"selection"`
+	// Data is the copied content, it is encoded using base64
+	Data []byte `json:"data"`
+}
+
+// FormatClipboardMessage returns the text message that copies c to the
+// clipboard of the client
+func FormatClipboardMessage(c clipboard.Copy) ([]byte, error) {
+	encoded, err := json.Marshal(ClipboardMessage{Selection: c.Selection, Data: c.Data})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{MessageTypeClipboard}, encoded...), nil
+}
+
+// ParseClipboardMessage returns the content of a text message copying to
+// the clipboard and whether data is such a message
+func ParseClipboardMessage(data []byte) (clipboard.Copy, bool) {
+	if len(data) == 0 || data[0] != MessageTypeClipboard {
+		return clipboard.Copy{}, false
+	}
+	message := ClipboardMessage{}
+	if err := json.Unmarshal(data[1:], &message); err != nil {
+		return clipboard.Copy{}, false
+	}
+	return clipboard.Copy{Selection: message.Selection, Data: message.Data, Size: len(message.Data)}, true
+}
+
+// FormatExitCloseMessage returns the close message sent to the client when
+// the process exits with exitCode
+func FormatExitCloseMessage(exitCode int) []byte {
+	return websocket.FormatCloseMessage(websocket.CloseNormalClosure, fmt.Sprintf("%s%v", closeReasonExitPrefix, exitCode))
+}
+
+// maxCloseReasonBytes is the maximum length of the reason of a close
+// message allowed by the websocket protocol
+const maxCloseReasonBytes = 123
+
+// FormatErrorCloseMessage returns the close message sent to the client
+// when its session cannot be started, message is truncated to the length
+// allowed for reasons
+func FormatErrorCloseMessage(code int, message string) []byte {
+	if len(message) > maxCloseReasonBytes {
+		end := maxCloseReasonBytes
+		// reasons have to be valid utf-8
+		for end > 0 && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		message = message[:end]
+	}
+	return websocket.FormatCloseMessage(code, message)
+}
+
+// ParseExitCloseReason returns the exit status contained in the reason of
+// a close message and whether the reason contained one
+func ParseExitCloseReason(reason string) (int, bool) {
+	if !strings.HasPrefix(reason, closeReasonExitPrefix) {
+		return 0, false
+	}
+	exitCode, err := strconv.Atoi(strings.TrimPrefix(reason, closeReasonExitPrefix))
+	if err != nil {
+		return 0, false
+	}
+	return exitCode, true
+}
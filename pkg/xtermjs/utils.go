@@ -1,8 +1,10 @@
 package xtermjs
 
 import (
+	"cloudshell/pkg/profile"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -23,7 +25,7 @@ func getConnectionUpgrader(
 					return true
 				}
 			}
-			logger.Warnf("failed to find '%s' in the list of allowed hostnames ('%s')", requesterHostname)
+			logger.Warnf("failed to find '%s' in the list of allowed hostnames ('%s')", requesterHostname, strings.Join(allowedHostnames, "', '"))
 			return false
 		},
 		HandshakeTimeout: 0,
@@ -31,3 +33,63 @@ func getConnectionUpgrader(
 		WriteBufferSize:  maxBufferSizeBytes,
 	}
 }
+
+// getProfileName returns the name of the profile requested in r
+func getProfileName(opts HandlerOpts, r *http.Request) string {
+	if opts.GetProfileName != nil {
+		return opts.GetProfileName(r)
+	}
+	return r.URL.Query().Get("profile")
+}
+
+// getProfile returns the profile identified by name, when no profiles are
+// configured, a profile is derived from the Command and Arguments options
+func getProfile(opts HandlerOpts, name string) (profile.Profile, bool) {
+	if opts.Profiles == nil {
+		if name != "" {
+			return profile.Profile{}, false
+		}
+		return profile.Profile{
+			Name:      "default",
+			Command:   opts.Command,
+			Arguments: opts.Arguments,
+		}, true
+	}
+	return opts.Profiles.Get(name)
+}
+
+// connectionLimits are the limits applied to a connection
+type connectionLimits struct {
+	connectionErrorLimit int
+	keepalivePingTimeout time.Duration
+	maxBufferSizeBytes   int
+}
+
+// getLimits returns the limits for connections to sessions of
+// selectedProfile, limits of the profile take precedence over opts
+func getLimits(opts HandlerOpts, selectedProfile profile.Profile) connectionLimits {
+	limits := selectedProfile.Limits
+	connectionErrorLimit := opts.ConnectionErrorLimit
+	if limits.ConnectionErrorLimit > 0 {
+		connectionErrorLimit = limits.ConnectionErrorLimit
+	}
+	if connectionErrorLimit < 0 {
+		connectionErrorLimit = DefaultConnectionErrorLimit
+	}
+	maxBufferSizeBytes := opts.MaxBufferSizeBytes
+	if limits.MaxBufferSizeBytes > 0 {
+		maxBufferSizeBytes = limits.MaxBufferSizeBytes
+	}
+	keepalivePingTimeout := opts.KeepalivePingTimeout
+	if limits.KeepalivePingTimeout > 0 {
+		keepalivePingTimeout = limits.GetKeepalivePingTimeout()
+	}
+	if keepalivePingTimeout <= time.Second {
+		keepalivePingTimeout = 20 * time.Second
+	}
+	return connectionLimits{
+		connectionErrorLimit: connectionErrorLimit,
+		keepalivePingTimeout: keepalivePingTimeout,
+		maxBufferSizeBytes:   maxBufferSizeBytes,
+	}
+}
@@ -0,0 +1,230 @@
+package zmodem
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// errBadEscape is returned when a byte following ZDLE cannot be decoded,
+// which happens when data was lost
+var errBadEscape = errors.New("bad escape sequence")
+
+// conn reads frames sent by the other side from r and writes frames to w
+type conn struct {
+	r       io.ByteReader
+	w       io.Writer
+	escaper escaper
+}
+
+func (c *conn) write(data []byte) error {
+	_, err := c.w.Write(data)
+	return err
+}
+
+func (c *conn) writeHex(h header) error {
+	return c.write(h.encodeHex())
+}
+
+// abort cancels the session on the other side
+func (c *conn) abort() {
+	c.write(AbortSequence)
+}
+
+// readByte returns the next byte that is not used for flow control
+func (c *conn) readByte() (byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case xon, xoff, xon | 0x80, xoff | 0x80:
+			continue
+		}
+		return b, nil
+	}
+}
+
+// readHeader skips input until the next header and returns it, errBadCRC
+// is returned when the header was corrupted
+func (c *conn) readHeader() (header, error) {
+	cancels := 0
+	for {
+		b, err := c.readByte()
+		if err != nil {
+			return header{}, err
+		}
+		if b == can {
+			if cancels++; cancels >= 5 {
+				return header{}, ErrAborted
+			}
+		} else {
+			cancels = 0
+		}
+		if b&0x7f != zpad {
+			continue
+		}
+		for b&0x7f == zpad {
+			if b, err = c.readByte(); err != nil {
+				return header{}, err
+			}
+		}
+		if b != zdle {
+			continue
+		}
+		format, err := c.readByte()
+		if err != nil {
+			return header{}, err
+		}
+		switch format & 0x7f {
+		case zhex:
+			return c.readHexHeader()
+		case zbin:
+			return c.readBinaryHeader(false)
+		case zbin32:
+			return c.readBinaryHeader(true)
+		}
+	}
+}
+
+func (c *conn) readHexHeader() (header, error) {
+	encoded := make([]byte, 14)
+	for index := range encoded {
+		b, err := c.readByte()
+		if err != nil {
+			return header{}, err
+		}
+		encoded[index] = b & 0x7f
+	}
+	raw := make([]byte, 7)
+	if _, err := hex.Decode(raw, encoded); err != nil {
+		return header{}, errBadCRC
+	}
+	if crc16(raw[:5]) != uint16(raw[5])<<8|uint16(raw[6]) {
+		return header{}, errBadCRC
+	}
+	// hex headers end with a carriage return and line feed
+	if b, err := c.readByte(); err != nil {
+		return header{}, err
+	} else if b&0x7f == '\r' {
+		if _, err := c.readByte(); err != nil {
+			return header{}, err
+		}
+	}
+	h := header{kind: raw[0]}
+	copy(h.data[:], raw[1:5])
+	return h, nil
+}
+
+func (c *conn) readBinaryHeader(use32 bool) (header, error) {
+	length := 5 + 2
+	if use32 {
+		length = 5 + 4
+	}
+	raw := make([]byte, length)
+	for index := range raw {
+		b, end, err := c.readEscaped()
+		if err != nil {
+			return header{}, err
+		}
+		if end {
+			return header{}, errBadEscape
+		}
+		raw[index] = b
+	}
+	if use32 {
+		crc := uint32(raw[5]) | uint32(raw[6])<<8 | uint32(raw[7])<<16 | uint32(raw[8])<<24
+		if crc32.ChecksumIEEE(raw[:5]) != crc {
+			return header{}, errBadCRC
+		}
+	} else if crc16(raw[:5]) != uint16(raw[5])<<8|uint16(raw[6]) {
+		return header{}, errBadCRC
+	}
+	h := header{kind: raw[0], crc32: use32}
+	copy(h.data[:], raw[1:5])
+	return h, nil
+}
+
+// readEscaped returns the next byte of binary data with escapes decoded,
+// the returned bool is true when the byte ends a data subpacket
+func (c *conn) readEscaped() (byte, bool, error) {
+	b, err := c.readByte()
+	if err != nil || b != zdle {
+		return b, false, err
+	}
+	if b, err = c.readByte(); err != nil {
+		return 0, false, err
+	}
+	switch b {
+	case zcrce, zcrcg, zcrcq, zcrcw:
+		return b, true, nil
+	case 'l':
+		return 0x7f, false, nil
+	case 'm':
+		return 0xff, false, nil
+	case can:
+		// five cancels in a row abort the session
+		for cancels := 2; cancels < 5; cancels++ {
+			if b, err = c.readByte(); err != nil {
+				return 0, false, err
+			}
+			if b != can {
+				return 0, false, errBadEscape
+			}
+		}
+		return 0, false, ErrAborted
+	}
+	if b&0x60 != 0x40 {
+		return 0, false, errBadEscape
+	}
+	return b ^ 0x40, false, nil
+}
+
+// readSubpacket returns the data of the next data subpacket and the byte
+// it ended with
+func (c *conn) readSubpacket(use32 bool) ([]byte, byte, error) {
+	data := make([]byte, 0, subpacketSize)
+	for {
+		b, end, err := c.readEscaped()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !end {
+			if len(data) == maxSubpacketSize {
+				return nil, 0, errBadEscape
+			}
+			data = append(data, b)
+			continue
+		}
+		length := 2
+		if use32 {
+			length = 4
+		}
+		crc := make([]byte, length)
+		for index := range crc {
+			if crc[index], end, err = c.readEscaped(); err != nil {
+				return nil, 0, err
+			} else if end {
+				return nil, 0, errBadEscape
+			}
+		}
+		checked := append(data, b)
+		if use32 {
+			expected := uint32(crc[0]) | uint32(crc[1])<<8 | uint32(crc[2])<<16 | uint32(crc[3])<<24
+			if crc32.ChecksumIEEE(checked) != expected {
+				return nil, 0, errBadCRC
+			}
+		} else if crc16(checked) != uint16(crc[0])<<8|uint16(crc[1]) {
+			return nil, 0, errBadCRC
+		}
+		return data, b, nil
+	}
+}
+
+// isCorrupted returns true when err is caused by data that was lost or
+// corrupted, which is recovered from by asking for it to be sent again
+func isCorrupted(err error) bool {
+	return errors.Is(err, errBadCRC) || errors.Is(err, errBadEscape)
+}
@@ -0,0 +1,205 @@
+package zmodem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File describes a file being transferred
+type File struct {
+	// Name is the name of the file without any directories
+	Name string
+	// Size is the size of the file, it is -1 when the sender did not
+	// specify it
+	Size int64
+	// ModTime is the modification time of the file, it is zero when the
+	// sender did not specify it
+	ModTime time.Time
+	// Mode holds the permissions of the file, it is zero when the sender
+	// did not specify them
+	Mode os.FileMode
+}
+
+// Receive receives files from the sending side, which is read from r and
+// written to w, create is called for each file offered by the sender and
+// returns the writer the file is written to or an error to skip it and
+// received is called once a file was written completely, r starts with
+// the ZRQINIT of the sender which is answered with the capabilities of the
+// receiver
+func Receive(r io.ByteReader, w io.Writer, create func(File) (io.Writer, error), received func(File) error) error {
+	c := &conn{r: r, w: w}
+	init := newFlagsHeader(zrinit, canfdx|canovio|canfc32)
+	for {
+		h, err := c.readHeader()
+		if isCorrupted(err) {
+			if err := c.writeHex(newPositionHeader(znak, 0)); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		switch h.kind {
+		case zrqinit, zeof:
+			// the sender asks for the capabilities or did not see the last
+			// ZRINIT
+			err = c.writeHex(init)
+		case zsinit:
+			if _, _, err = c.readSubpacket(h.crc32); isCorrupted(err) {
+				err = c.writeHex(newPositionHeader(znak, 0))
+			} else if err == nil {
+				err = c.writeHex(newPositionHeader(zack, 0))
+			}
+		case zfile:
+			var info []byte
+			if info, _, err = c.readSubpacket(h.crc32); isCorrupted(err) {
+				err = c.writeHex(newPositionHeader(znak, 0))
+				break
+			} else if err != nil {
+				return err
+			}
+			file := parseFileInfo(info)
+			destination, createErr := create(file)
+			if createErr != nil {
+				err = c.writeHex(newPositionHeader(zskip, 0))
+				break
+			}
+			if file.Size, err = c.receiveFile(destination); err != nil {
+				if !errors.Is(err, ErrAborted) {
+					c.abort()
+				}
+				return err
+			}
+			if err := received(file); err != nil {
+				c.abort()
+				return err
+			}
+			err = c.writeHex(init)
+		case zfin:
+			// the sender ends with "OO", which is left to the caller as some
+			// senders omit it
+			return c.writeHex(newPositionHeader(zfin, 0))
+		case zcan, zabort:
+			return ErrAborted
+		case zcommand:
+			c.abort()
+			return errors.New("remote commands are not supported")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receiveFile writes the data of the file offered last to destination and
+// returns its size
+func (c *conn) receiveFile(destination io.Writer) (int64, error) {
+	position := int64(0)
+	if err := c.writeHex(newPositionHeader(zrpos, position)); err != nil {
+		return 0, err
+	}
+	for {
+		h, err := c.readHeader()
+		if isCorrupted(err) {
+			err = c.writeHex(newPositionHeader(zrpos, position))
+		} else if err != nil {
+			return 0, err
+		}
+		switch h.kind {
+		case zdata:
+			if h.position() != position {
+				err = c.writeHex(newPositionHeader(zrpos, position))
+				break
+			}
+			if err = c.receiveData(h.crc32, destination, &position); isCorrupted(err) {
+				err = c.writeHex(newPositionHeader(zrpos, position))
+			}
+		case zeof:
+			// end of file headers not matching the data received are stale
+			if h.position() == position {
+				return position, nil
+			}
+		case zfile:
+			// the sender did not see the ZRPOS
+			if _, _, err = c.readSubpacket(h.crc32); err == nil || isCorrupted(err) {
+				err = c.writeHex(newPositionHeader(zrpos, position))
+			}
+		case zcan, zabort:
+			return 0, ErrAborted
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// receiveData writes the data subpackets of a frame to destination until
+// the frame ends
+func (c *conn) receiveData(use32 bool, destination io.Writer, position *int64) error {
+	for {
+		data, end, err := c.readSubpacket(use32)
+		if err != nil {
+			return err
+		}
+		if _, err := destination.Write(data); err != nil {
+			return fmt.Errorf("failed to write file: %s", err)
+		}
+		*position += int64(len(data))
+		switch end {
+		case zcrcw:
+			return c.writeHex(newPositionHeader(zack, *position))
+		case zcrcq:
+			if err := c.writeHex(newPositionHeader(zack, *position)); err != nil {
+				return err
+			}
+		case zcrce:
+			return nil
+		}
+	}
+}
+
+// parseFileInfo parses the data subpacket of ZFILE, which holds the name of
+// the file followed by a NUL and its size, modification time and mode
+func parseFileInfo(info []byte) File {
+	file := File{Size: -1}
+	fields := bytes.SplitN(info, []byte{0}, 3)
+	name := strings.ReplaceAll(string(fields[0]), "\\", "/")
+	file.Name = name[strings.LastIndex(name, "/")+1:]
+	if len(fields) < 2 {
+		return file
+	}
+	attributes := strings.Fields(string(fields[1]))
+	if len(attributes) > 0 {
+		if size, err := strconv.ParseInt(attributes[0], 10, 64); err == nil && size >= 0 {
+			file.Size = size
+		}
+	}
+	if len(attributes) > 1 {
+		if modTime, err := strconv.ParseInt(attributes[1], 8, 64); err == nil && modTime > 0 {
+			file.ModTime = time.Unix(modTime, 0)
+		}
+	}
+	if len(attributes) > 2 {
+		if mode, err := strconv.ParseUint(attributes[2], 8, 32); err == nil {
+			file.Mode = os.FileMode(mode).Perm()
+		}
+	}
+	return file
+}
+
+// formatFileInfo returns the data subpacket of ZFILE describing file,
+// filesLeft and bytesLeft include file
+func formatFileInfo(file File, filesLeft int, bytesLeft int64) []byte {
+	modTime := int64(0)
+	if !file.ModTime.IsZero() {
+		modTime = file.ModTime.Unix()
+	}
+	info := fmt.Sprintf("%s\x00%d %o %o 0 %d %d\x00", file.Name, file.Size, modTime, uint32(0100000|file.Mode.Perm()), filesLeft, bytesLeft)
+	return []byte(info)
+}
@@ -0,0 +1,182 @@
+package zmodem
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// maxAttempts is the number of times a header is sent without getting the
+// expected response before giving up
+const maxAttempts = 10
+
+// Source is a file to send
+type Source struct {
+	File
+	// Content is read from the position the receiver asks for, which is
+	// only before the current position when data was lost
+	Content io.ReadSeeker
+}
+
+// Send sends sources to the receiving side, which is read from r and
+// written to w, and returns the files that the receiver accepted, files
+// which it skipped, for example because they already exist, are omitted
+func Send(r io.ByteReader, w io.Writer, sources []Source) ([]File, error) {
+	c := &conn{r: r, w: w}
+	// the receiver repeats its ZRINIT when asked so that its capabilities
+	// are known even when its first ZRINIT was consumed
+	init, err := c.waitFor(zrinit, newPositionHeader(zrqinit, 0))
+	if err != nil {
+		return nil, err
+	}
+	use32 := init.flags()&canfc32 != 0
+	c.escaper.controls = init.flags()&escctl != 0
+	bytesLeft := int64(0)
+	for _, source := range sources {
+		bytesLeft += source.Size
+	}
+	sent := []File{}
+	for index, source := range sources {
+		accepted, err := c.sendFile(source, use32, len(sources)-index, bytesLeft)
+		if err != nil {
+			if !errors.Is(err, ErrAborted) {
+				c.abort()
+			}
+			return sent, err
+		}
+		if accepted {
+			sent = append(sent, source.File)
+		}
+		bytesLeft -= source.Size
+	}
+	if _, err := c.waitFor(zfin, newPositionHeader(zfin, 0)); err != nil {
+		return sent, err
+	}
+	return sent, c.write([]byte("OO"))
+}
+
+// waitFor sends request until the receiver responds with a header of kind
+func (c *conn) waitFor(kind byte, request header) (header, error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if err := c.writeHex(request); err != nil {
+			return header{}, err
+		}
+		h, err := c.readHeader()
+		if isCorrupted(err) {
+			continue
+		} else if err != nil {
+			return header{}, err
+		}
+		switch h.kind {
+		case kind:
+			return h, nil
+		case zcan, zabort:
+			return header{}, ErrAborted
+		}
+	}
+	c.abort()
+	return header{}, errors.New("receiver did not respond")
+}
+
+// sendFile offers source to the receiver and sends its data from the
+// position the receiver asks for, false is returned when it is skipped
+func (c *conn) sendFile(source Source, use32 bool, filesLeft int, bytesLeft int64) (bool, error) {
+	offer := newFlagsHeader(zfile, 0).encodeBinary(use32, &c.escaper)
+	offer = append(offer, encodeSubpacket(formatFileInfo(source.File, filesLeft, bytesLeft), zcrcw, use32, &c.escaper)...)
+	for attempt := 0; ; attempt++ {
+		if attempt == maxAttempts {
+			return false, errors.New("receiver did not accept the file")
+		}
+		if err := c.write(offer); err != nil {
+			return false, err
+		}
+		h, err := c.readHeader()
+		if isCorrupted(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		switch h.kind {
+		case zrpos:
+			return c.sendData(source.Content, h.position(), use32)
+		case zskip:
+			return false, nil
+		case zcrc:
+			// the receiver asks for the crc of the file to decide whether to
+			// resume an existing file
+			checksum := crc32.NewIEEE()
+			if _, err := source.Content.Seek(0, io.SeekStart); err != nil {
+				return false, err
+			}
+			if _, err := io.Copy(checksum, source.Content); err != nil {
+				return false, err
+			}
+			crc := checksum.Sum32()
+			if err := c.writeHex(header{kind: zcrc, data: [4]byte{byte(crc), byte(crc >> 8), byte(crc >> 16), byte(crc >> 24)}}); err != nil {
+				return false, err
+			}
+		case zcan, zabort:
+			return false, ErrAborted
+		}
+	}
+}
+
+// sendData sends content from position on in frames of up to
+// subpacketsPerWindow subpackets, each frame is acknowledged by the
+// receiver before the next one is sent, true is returned once the receiver
+// received the whole file
+func (c *conn) sendData(content io.ReadSeeker, position int64, use32 bool) (bool, error) {
+	buffer := make([]byte, subpacketSize)
+	for {
+		if _, err := content.Seek(position, io.SeekStart); err != nil {
+			return false, err
+		}
+		if err := c.write(newPositionHeader(zdata, position).encodeBinary(use32, &c.escaper)); err != nil {
+			return false, err
+		}
+		end := byte(zcrcg)
+		for count := 1; end == zcrcg; count++ {
+			length, err := io.ReadFull(content, buffer)
+			switch {
+			case err == io.EOF || err == io.ErrUnexpectedEOF:
+				end = zcrce
+			case err != nil:
+				return false, err
+			case count == subpacketsPerWindow:
+				end = zcrcw
+			}
+			position += int64(length)
+			if err := c.write(encodeSubpacket(buffer[:length], end, use32, &c.escaper)); err != nil {
+				return false, err
+			}
+		}
+		if end == zcrce {
+			if err := c.write(newPositionHeader(zeof, position).encodeBinary(use32, &c.escaper)); err != nil {
+				return false, err
+			}
+		}
+		for waiting := true; waiting; {
+			h, err := c.readHeader()
+			if isCorrupted(err) {
+				continue
+			} else if err != nil {
+				return false, err
+			}
+			switch h.kind {
+			case zack:
+				waiting = end == zcrce
+			case zrpos:
+				position = h.position()
+				waiting = false
+			case zrinit:
+				if end == zcrce {
+					return true, nil
+				}
+			case zskip:
+				return false, nil
+			case zcan, zabort:
+				return false, ErrAborted
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package zmodem implements the sending and receiving sides of the ZMODEM
+// file transfer protocol as used by `sz` and `rz` so that transfers started
+// in a terminal can be handled by the server instead of being displayed
+package zmodem
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	zpad = '*'
+	// zdle escapes the following byte
+	zdle = 0x18
+	zbin = 'A'
+	zhex = 'B'
+	// zbin32 introduces binary headers using 32 bit crcs
+	zbin32 = 'C'
+	xon    = 0x11
+	xoff   = 0x13
+	// can is the ascii cancel character, five in a row abort the session
+	can = 0x18
+)
+
+// frame types
+const (
+	zrqinit  = 0
+	zrinit   = 1
+	zsinit   = 2
+	zack     = 3
+	zfile    = 4
+	zskip    = 5
+	znak     = 6
+	zabort   = 7
+	zfin     = 8
+	zrpos    = 9
+	zdata    = 10
+	zeof     = 11
+	zferr    = 12
+	zcrc     = 13
+	zcan     = 16
+	zcommand = 18
+)
+
+// ends of data subpackets
+const (
+	// zcrce ends the frame, a header follows
+	zcrce = 'h'
+	// zcrcg continues the frame without an acknowledgement
+	zcrcg = 'i'
+	// zcrcq continues the frame and requests an acknowledgement
+	zcrcq = 'j'
+	// zcrcw ends the frame and requests an acknowledgement
+	zcrcw = 'k'
+)
+
+// capabilities of receivers sent in the ZF0 flag of ZRINIT
+const (
+	canfdx  = 0x01
+	canovio = 0x02
+	canfc32 = 0x20
+	escctl  = 0x40
+)
+
+const (
+	// subpacketSize is the size of data subpackets that are sent
+	subpacketSize = 1024
+	// maxSubpacketSize is the size of the largest data subpacket accepted
+	maxSubpacketSize = 8192
+	// subpacketsPerWindow is the number of subpackets sent before waiting
+	// for the receiver to acknowledge them
+	subpacketsPerWindow = 16
+)
+
+var (
+	// ErrAborted is returned when the other side cancels the transfer
+	ErrAborted = errors.New("transfer was cancelled")
+	// errBadCRC is returned when a header or subpacket is corrupted
+	errBadCRC = errors.New("bad crc")
+)
+
+// AbortSequence cancels a ZMODEM session when written to the other side
+var AbortSequence = []byte{can, can, can, can, can, can, can, can, 8, 8, 8, 8, 8, 8, 8, 8}
+
+// Kind is the side of a transfer that the other side requested
+type Kind int
+
+const (
+	// KindReceive is a transfer where the other side sends files using
+	// `sz` and files have to be received from it
+	KindReceive Kind = iota
+	// KindSend is a transfer where the other side receives files using
+	// `rz` and files have to be sent to it
+	KindSend
+)
+
+// HeaderLength is the length of the headers found by Detect, callers
+// detecting headers split across reads keep this many bytes minus one
+const HeaderLength = 4 + 14
+
+// Detect returns the index in data of the first hex header that starts a
+// ZMODEM session and the kind of transfer it requests, sz starts by
+// sending ZRQINIT and rz by sending ZRINIT
+func Detect(data []byte) (int, Kind, bool) {
+	for offset := 0; offset+HeaderLength <= len(data); offset++ {
+		if data[offset] != zpad || data[offset+1] != zpad || data[offset+2] != zdle || data[offset+3] != zhex {
+			continue
+		}
+		decoded := make([]byte, 7)
+		if _, err := hex.Decode(decoded, data[offset+4:offset+HeaderLength]); err != nil {
+			continue
+		}
+		if crc16(decoded[:5]) != uint16(decoded[5])<<8|uint16(decoded[6]) {
+			continue
+		}
+		switch decoded[0] {
+		case zrqinit:
+			return offset, KindReceive, true
+		case zrinit:
+			return offset, KindSend, true
+		}
+	}
+	return -1, KindReceive, false
+}
+
+// header is a ZMODEM frame header
+type header struct {
+	kind byte
+	// data holds ZP0 to ZP3, which are also called ZF3 to ZF0
+	data [4]byte
+	// crc32 is true when the header was sent with a 32 bit crc, the
+	// subpackets following it also use 32 bit crcs
+	crc32 bool
+}
+
+func newPositionHeader(kind byte, position int64) header {
+	return header{kind: kind, data: [4]byte{byte(position), byte(position >> 8), byte(position >> 16), byte(position >> 24)}}
+}
+
+func newFlagsHeader(kind byte, zf0 byte) header {
+	return header{kind: kind, data: [4]byte{0, 0, 0, zf0}}
+}
+
+// position returns the file position of position headers
+func (h header) position() int64 {
+	return int64(h.data[0]) | int64(h.data[1])<<8 | int64(h.data[2])<<16 | int64(h.data[3])<<24
+}
+
+// flags returns the ZF0 flags of flags headers
+func (h header) flags() byte {
+	return h.data[3]
+}
+
+// encodeHex returns the header as a hex header, which is used for headers
+// without data following them
+func (h header) encodeHex() []byte {
+	raw := append([]byte{h.kind}, h.data[:]...)
+	crc := crc16(raw)
+	raw = append(raw, byte(crc>>8), byte(crc))
+	encoded := append([]byte{zpad, zpad, zdle, zhex}, []byte(hex.EncodeToString(raw))...)
+	encoded = append(encoded, '\r', 0x8a)
+	if h.kind != zfin && h.kind != zack {
+		encoded = append(encoded, xon)
+	}
+	return encoded
+}
+
+// encodeBinary returns the header as a binary header with a 16 or 32 bit
+// crc, which is used for headers followed by data subpackets
+func (h header) encodeBinary(use32 bool, e *escaper) []byte {
+	raw := append([]byte{h.kind}, h.data[:]...)
+	encoded := []byte{zpad, zdle, zbin}
+	if use32 {
+		encoded[2] = zbin32
+		crc := crc32.ChecksumIEEE(raw)
+		raw = append(raw, byte(crc), byte(crc>>8), byte(crc>>16), byte(crc>>24))
+	} else {
+		crc := crc16(raw)
+		raw = append(raw, byte(crc>>8), byte(crc))
+	}
+	return e.escape(encoded, raw)
+}
+
+// encodeSubpacket returns data as a data subpacket ending with end
+func encodeSubpacket(data []byte, end byte, use32 bool, e *escaper) []byte {
+	encoded := e.escape(make([]byte, 0, len(data)+len(data)/8+8), data)
+	encoded = append(encoded, zdle, end)
+	e.last = end
+	checked := append(append([]byte{}, data...), end)
+	if use32 {
+		crc := crc32.ChecksumIEEE(checked)
+		return e.escape(encoded, []byte{byte(crc), byte(crc >> 8), byte(crc >> 16), byte(crc >> 24)})
+	}
+	crc := crc16(checked)
+	return e.escape(encoded, []byte{byte(crc >> 8), byte(crc)})
+}
+
+// escaper escapes the bytes of binary headers and subpackets that cannot
+// be sent through terminals and flow control as is
+type escaper struct {
+	// controls is true when the receiver asked for all control characters
+	// to be escaped
+	controls bool
+	last     byte
+}
+
+func (e *escaper) escape(encoded []byte, data []byte) []byte {
+	for _, b := range data {
+		needsEscape := false
+		switch b {
+		case zdle, 0x10, 0x90, xon, 0x91, xoff, 0x93:
+			needsEscape = true
+		case '\r', 0x8d:
+			// `@` followed by a carriage return is a telnet escape
+			needsEscape = e.controls || e.last&0x7f == '@'
+		default:
+			needsEscape = e.controls && b&0x60 == 0
+		}
+		if needsEscape {
+			encoded = append(encoded, zdle, b^0x40)
+		} else {
+			encoded = append(encoded, b)
+		}
+		e.last = b
+	}
+	return encoded
+}
+
+// crc16 returns the CRC-16/XMODEM checksum of data
+func crc16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
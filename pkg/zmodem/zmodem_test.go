@@ -0,0 +1,302 @@
+package zmodem
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestConn returns a conn reading data
+func newTestConn(data []byte) *conn {
+	return &conn{r: bytes.NewReader(data), w: ioutil.Discard}
+}
+
+func TestCRC16(t *testing.T) {
+	// the check value of CRC-16/XMODEM
+	if crc := crc16([]byte("123456789")); crc != 0x31c3 {
+		t.Errorf("expected crc 0x31c3, got %#04x", crc)
+	}
+}
+
+func TestHexHeader(t *testing.T) {
+	tests := []header{
+		newFlagsHeader(zrinit, canfdx|canovio|canfc32),
+		newPositionHeader(zrpos, 0x12345678),
+		newPositionHeader(zfin, 0),
+		newPositionHeader(zack, 1024),
+	}
+	for _, h := range tests {
+		encoded := h.encodeHex()
+		if !bytes.HasPrefix(encoded, []byte{zpad, zpad, zdle, zhex}) {
+			t.Errorf("expected %+v to be encoded as hex header, got %q", h, encoded)
+		}
+		decoded, err := newTestConn(encoded).readHeader()
+		if err != nil || decoded != h {
+			t.Errorf("expected %q to decode to %+v, got %+v (%v)", encoded, h, decoded, err)
+		}
+	}
+	// the header starting the session is found by Detect
+	if index, kind, found := Detect(append([]byte("rz waiting\r\n"), newPositionHeader(zrqinit, 0).encodeHex()...)); !found || index != 12 || kind != KindReceive {
+		t.Errorf("expected ZRQINIT to be detected at 12, got %v %v %v", index, kind, found)
+	}
+}
+
+func TestBinaryHeader(t *testing.T) {
+	for _, use32 := range []bool{false, true} {
+		// the position contains bytes that have to be escaped
+		h := newPositionHeader(zdata, int64(zdle)|int64(xon)<<8|int64(0x8d)<<16|int64(0x91)<<24)
+		h.crc32 = use32
+		encoded := h.encodeBinary(use32, &escaper{})
+		decoded, err := newTestConn(encoded).readHeader()
+		if err != nil || decoded != h {
+			t.Errorf("expected %q to decode to %+v, got %+v (%v)", encoded, h, decoded, err)
+		}
+	}
+}
+
+func TestCorruptedHeader(t *testing.T) {
+	tests := []struct {
+		encoded []byte
+		// index is the index of a byte of the position
+		index int
+	}{
+		{newPositionHeader(zrpos, 1024).encodeHex(), 4 + 2},
+		{newPositionHeader(zdata, 1024).encodeBinary(false, &escaper{}), 3 + 1},
+		{newPositionHeader(zdata, 1024).encodeBinary(true, &escaper{}), 3 + 1},
+	}
+	for _, test := range tests {
+		corrupted := append([]byte{}, test.encoded...)
+		corrupted[test.index] ^= 0x01
+		if _, err := newTestConn(corrupted).readHeader(); !errors.Is(err, errBadCRC) {
+			t.Errorf("expected %q to be rejected as corrupted, got %v", corrupted, err)
+		}
+	}
+}
+
+func TestHeaderSplitAcrossReads(t *testing.T) {
+	h := newPositionHeader(zrpos, 4096)
+	h.crc32 = true
+	encoded := append([]byte("noise\r\n**"), h.encodeBinary(true, &escaper{})...)
+	for split := 1; split < len(encoded); split++ {
+		reader, writer := io.Pipe()
+		go func() {
+			writer.Write(encoded[:split])
+			writer.Write(encoded[split:])
+			writer.Close()
+		}()
+		decoded, err := (&conn{r: bufio.NewReader(reader), w: ioutil.Discard}).readHeader()
+		if err != nil || decoded != h {
+			t.Errorf("expected the header split at %v to decode to %+v, got %+v (%v)", split, h, decoded, err)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	all := make([]byte, 256)
+	for index := range all {
+		all[index] = byte(index)
+	}
+	for _, controls := range []bool{false, true} {
+		encoded := (&escaper{controls: controls}).escape(nil, all)
+		for _, b := range []byte{xon, xoff, xon | 0x80, xoff | 0x80, 0x10, 0x90} {
+			if bytes.IndexByte(encoded, b) != -1 {
+				t.Errorf("expected %#02x to be escaped, got %q", b, encoded)
+			}
+		}
+		if controls {
+			for _, b := range encoded {
+				if b&0x60 == 0 && b != zdle {
+					t.Errorf("expected all control characters to be escaped, got %#02x in %q", b, encoded)
+				}
+			}
+		}
+		c := newTestConn(encoded)
+		decoded := []byte{}
+		for range all {
+			b, end, err := c.readEscaped()
+			if err != nil || end {
+				t.Fatalf("failed to decode %q: %v %v", encoded, end, err)
+			}
+			decoded = append(decoded, b)
+		}
+		if !bytes.Equal(decoded, all) {
+			t.Errorf("expected escaped bytes to decode to themselves, got %q", decoded)
+		}
+	}
+	// a carriage return following @ is escaped as it is a telnet escape
+	if encoded := (&escaper{}).escape(nil, []byte("a\r@\r")); !bytes.Equal(encoded, []byte{'a', '\r', '@', zdle, '\r' ^ 0x40}) {
+		t.Errorf("expected only the carriage return following @ to be escaped, got %q", encoded)
+	}
+	// bytes following ZDLE that are not escapes are rejected
+	if _, _, err := newTestConn([]byte{zdle, '!'}).readEscaped(); !errors.Is(err, errBadEscape) {
+		t.Errorf("expected a bad escape, got %v", err)
+	}
+	if _, _, err := newTestConn([]byte{zdle, can, can, can, can}).readEscaped(); !errors.Is(err, ErrAborted) {
+		t.Errorf("expected five cancels to abort, got %v", err)
+	}
+}
+
+func TestSubpacket(t *testing.T) {
+	data := []byte{zdle, xon, xoff, 0x7f, 0xff, '@', '\r', 0, 'a'}
+	for _, use32 := range []bool{false, true} {
+		for _, end := range []byte{zcrce, zcrcg, zcrcq, zcrcw} {
+			encoded := encodeSubpacket(data, end, use32, &escaper{})
+			decoded, decodedEnd, err := newTestConn(encoded).readSubpacket(use32)
+			if err != nil || !bytes.Equal(decoded, data) || decodedEnd != end {
+				t.Errorf("expected %q to decode to %q ending with %c, got %q ending with %c (%v)", encoded, data, end, decoded, decodedEnd, err)
+			}
+			corrupted := append([]byte{}, encoded...)
+			corrupted[len(corrupted)-1] ^= 0x01
+			if _, _, err := newTestConn(corrupted).readSubpacket(use32); !isCorrupted(err) {
+				t.Errorf("expected %q to be rejected as corrupted, got %v", corrupted, err)
+			}
+		}
+	}
+}
+
+func TestFileInfo(t *testing.T) {
+	file := File{Name: "report.csv", Size: 1234, ModTime: time.Unix(1600000000, 0), Mode: 0640}
+	if parsed := parseFileInfo(formatFileInfo(file, 1, 1234)); !reflect.DeepEqual(parsed, file) {
+		t.Errorf("expected %+v, got %+v", file, parsed)
+	}
+	// directories are removed from names
+	if parsed := parseFileInfo([]byte("../etc\\passwd\x00")); parsed.Name != "passwd" || parsed.Size != -1 {
+		t.Errorf("expected the base name without a size, got %+v", parsed)
+	}
+}
+
+// corrupter is a writer that flips a bit of the byte at offset once
+type corrupter struct {
+	w       io.Writer
+	offset  int
+	written int
+}
+
+func (c *corrupter) Write(p []byte) (int, error) {
+	if c.written <= c.offset && c.offset < c.written+len(p) {
+		p = append([]byte{}, p...)
+		p[c.offset-c.written] ^= 0x01
+	}
+	c.written += len(p)
+	return c.w.Write(p)
+}
+
+// transfer sends sources to a receiver over pipes and returns the files
+// accepted by the sender and those written by the receiver, the output of
+// the sender is passed through wrap, create skips files named skip, the
+// pipes are buffered like the pty of a session as both sides write at the
+// same time when data is sent again
+func transfer(t *testing.T, sources []Source, wrap func(io.Writer) io.Writer, skip string) ([]File, map[string][]byte) {
+	toReceiver, fromSender, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer toReceiver.Close()
+	toSender, fromReceiver, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer toSender.Close()
+	type result struct {
+		files []File
+		err   error
+	}
+	sent := make(chan result, 1)
+	go func() {
+		files, err := Send(bufio.NewReader(toSender), wrap(fromSender), sources)
+		fromSender.Close()
+		sent <- result{files, err}
+	}()
+	received := map[string][]byte{}
+	buffers := map[string]*bytes.Buffer{}
+	receiverInput := bufio.NewReader(toReceiver)
+	err = Receive(receiverInput, fromReceiver, func(file File) (io.Writer, error) {
+		if file.Name == skip {
+			return nil, errors.New("skipped")
+		}
+		buffers[file.Name] = &bytes.Buffer{}
+		return buffers[file.Name], nil
+	}, func(file File) error {
+		received[file.Name] = buffers[file.Name].Bytes()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to receive: %s", err)
+	}
+	// the sender ends with "OO" which is read by the caller of Receive
+	if rest, err := ioutil.ReadAll(receiverInput); err != nil || string(rest) != "OO" {
+		t.Errorf("expected the sender to end with OO, got %q (%v)", rest, err)
+	}
+	fromReceiver.Close()
+	select {
+	case result := <-sent:
+		if result.err != nil {
+			t.Fatalf("failed to send: %s", result.err)
+		}
+		return result.files, received
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the sender to finish")
+	}
+	return nil, nil
+}
+
+func TestTransfer(t *testing.T) {
+	// the content holds every byte value and spans several frames
+	content := make([]byte, subpacketSize*subpacketsPerWindow*2+123)
+	for index := range content {
+		content[index] = byte(index * 7)
+	}
+	sources := []Source{
+		{File: File{Name: "data.bin", Size: int64(len(content)), Mode: 0600}, Content: bytes.NewReader(content)},
+		{File: File{Name: "existing.txt", Size: 5}, Content: bytes.NewReader([]byte("hello"))},
+		{File: File{Name: "empty.txt", Size: 0}, Content: bytes.NewReader(nil)},
+	}
+	tests := []struct {
+		name string
+		wrap func(io.Writer) io.Writer
+	}{
+		{"lossless", func(w io.Writer) io.Writer { return w }},
+		// data that was corrupted is sent again from the position the
+		// receiver asks for
+		{"corrupted data", func(w io.Writer) io.Writer { return &corrupter{w: w, offset: 5000} }},
+		{"corrupted offer", func(w io.Writer) io.Writer { return &corrupter{w: w, offset: 40} }},
+	}
+	for _, test := range tests {
+		for _, source := range sources {
+			source.Content.Seek(0, io.SeekStart)
+		}
+		files, received := transfer(t, sources, test.wrap, "existing.txt")
+		if expected := []File{sources[0].File, sources[2].File}; !reflect.DeepEqual(files, expected) {
+			t.Errorf("%s: expected the files %+v to be accepted, got %+v", test.name, expected, files)
+		}
+		if len(received) != 2 || !bytes.Equal(received["data.bin"], content) || len(received["empty.txt"]) != 0 {
+			t.Errorf("%s: expected the content of the files to be received, got %v", test.name, describe(received))
+		}
+	}
+}
+
+func TestReceiveAborted(t *testing.T) {
+	input := append(newPositionHeader(zrqinit, 0).encodeHex(), AbortSequence...)
+	err := Receive(bytes.NewReader(input), ioutil.Discard, func(File) (io.Writer, error) {
+		return ioutil.Discard, nil
+	}, func(File) error { return nil })
+	if !errors.Is(err, ErrAborted) {
+		t.Errorf("expected the transfer to be cancelled, got %v", err)
+	}
+}
+
+// describe returns the names and sizes of files
+func describe(files map[string][]byte) string {
+	description := ""
+	for name, content := range files {
+		description += fmt.Sprintf("%s (%d bytes) ", name, len(content))
+	}
+	return description
+}
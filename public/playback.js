@@ -0,0 +1,74 @@
+(function() {
+  // ?src=<path of the recording>&t=<seconds to start playing from>
+  var parameters = new URLSearchParams(location.search);
+  var source = parameters.get("src");
+  var startAt = parseFloat(parameters.get("t") || "0");
+  var position = document.getElementById("position");
+  var toggle = document.getElementById("toggle");
+
+  fetch(source, {credentials: "same-origin"}).then(function(response) {
+    if (!response.ok) {
+      throw new Error("failed to fetch recording '" + source + "' (" + response.status + ")");
+    }
+    return response.text();
+  }).then(function(cast) {
+    var lines = cast.split("\n").filter(function(line) { return line !== ""; });
+    var header = JSON.parse(lines[0]);
+    var events = lines.slice(1).map(function(line) { return JSON.parse(line); });
+    document.title = "Cloudshell playback - " + (header.title || header.session_id);
+    var terminal = new Terminal({cols: header.width, rows: header.height, scrollback: 10000});
+    var unicode11Addon = new Unicode11Addon.Unicode11Addon();
+    terminal.loadAddon(unicode11Addon);
+    terminal.open(document.getElementById("terminal"));
+
+    var apply = function(event) {
+      if (event[1] === "o") {
+        terminal.write(event[2]);
+      } else if (event[1] === "r") {
+        var size = event[2].split("x");
+        terminal.resize(parseInt(size[0], 10), parseInt(size[1], 10));
+      }
+    };
+
+    // everything before the starting point is written instantly
+    var next = 0;
+    while (next < events.length && events[next][0] < startAt) {
+      apply(events[next]);
+      next++;
+    }
+
+    var paused = false;
+    var offset = startAt;
+    var timer = null;
+    var schedule = function() {
+      if (paused || next >= events.length) {
+        if (next >= events.length) {
+          toggle.disabled = true;
+        }
+        return;
+      }
+      var delay = Math.max(0, events[next][0] - offset) * 1000;
+      timer = setTimeout(function() {
+        offset = events[next][0];
+        position.textContent = offset.toFixed(1) + "s";
+        apply(events[next]);
+        next++;
+        schedule();
+      }, delay);
+    };
+    toggle.onclick = function() {
+      paused = !paused;
+      toggle.textContent = paused ? "play" : "pause";
+      if (paused) {
+        clearTimeout(timer);
+      } else {
+        schedule();
+      }
+    };
+    position.textContent = offset.toFixed(1) + "s";
+    schedule();
+  }).catch(function(error) {
+    console.log(error);
+    position.textContent = error.message;
+  });
+})();
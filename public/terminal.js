@@ -10,8 +10,27 @@
   });
   terminal.open(document.getElementById("terminal"));
   var protocol = (location.protocol === "https:") ? "wss://" : "ws://";
-  var url = protocol + location.host + "/xterm.js"
+  // forward the query string so that ?profile=<name> selects a profile
+  var url = protocol + location.host + "/xterm.js" + location.search;
   var ws = new WebSocket(url);
+  // text messages starting with \x03 carry what programs such as tmux and
+  // vim copied to the clipboard, this listener is added before the attach
+  // addon's so that they are not written to the terminal
+  ws.addEventListener("message", function(event) {
+    if (typeof event.data !== "string" || event.data.charCodeAt(0) !== 3) {
+      return;
+    }
+    event.stopImmediatePropagation();
+    var message = JSON.parse(event.data.substring(1));
+    var decoded = atob(message.data || "");
+    var bytes = new Uint8Array(decoded.length);
+    for (var index = 0; index < decoded.length; index++) {
+      bytes[index] = decoded.charCodeAt(index);
+    }
+    navigator.clipboard.writeText(new TextDecoder().decode(bytes)).catch(function(error) {
+      console.log("failed to copy to the clipboard", error);
+    });
+  });
   var attachAddon = new AttachAddon.AttachAddon(ws);
   var fitAddon = new FitAddon.FitAddon();
   terminal.loadAddon(fitAddon);
@@ -21,6 +40,74 @@
   terminal.loadAddon(unicode11Addon);
   var serializeAddon = new SerializeAddon.SerializeAddon();
   terminal.loadAddon(serializeAddon);
+  // cloudshell-download writes OSC 5379 with `download;<session id>;<base64
+  // path>` to download a file from the working directory of the session,
+  // zmodem transfers write `zmodem-download;<session id>;<file id>` for
+  // files sent by sz and `zmodem-upload;<session id>;<transfer id>` when rz
+  // waits for files
+  terminal.parser.registerOscHandler(5379, function(data) {
+    var parameters = data.split(";");
+    if (parameters.length !== 3) {
+      return false;
+    }
+    var sessionPath = "/sessions/" + encodeURIComponent(parameters[1]);
+    switch (parameters[0]) {
+      case "download":
+        var encodedPath = atob(parameters[2]);
+        var pathBytes = new Uint8Array(encodedPath.length);
+        for (var index = 0; index < encodedPath.length; index++) {
+          pathBytes[index] = encodedPath.charCodeAt(index);
+        }
+        download(sessionPath + "/files?path=" + encodeURIComponent(new TextDecoder().decode(pathBytes)));
+        return true;
+      case "zmodem-download":
+        download(sessionPath + "/transfers/" + encodeURIComponent(parameters[2]));
+        return true;
+      case "zmodem-upload":
+        chooseTransferFiles(sessionPath + "/transfers/" + encodeURIComponent(parameters[2]));
+        return true;
+    }
+    return false;
+  });
+  function download(href) {
+    var link = document.createElement("a");
+    link.href = href;
+    link.download = "";
+    document.body.appendChild(link);
+    link.click();
+    document.body.removeChild(link);
+  }
+  // browsers only open file choosers in response to user input so the
+  // files to send are chosen in a dialog
+  function chooseTransferFiles(transferPath) {
+    var dialog = document.getElementById("transfer");
+    var files = document.getElementById("transfer-files");
+    var cancel = document.getElementById("transfer-cancel");
+    var close = function() {
+      dialog.style.display = "none";
+      files.value = "";
+      files.onchange = null;
+      cancel.onclick = null;
+      terminal.focus();
+    };
+    files.onchange = function() {
+      var form = new FormData();
+      for (var index = 0; index < files.files.length; index++) {
+        form.append("file", files.files[index]);
+      }
+      close();
+      fetch(transferPath, {method: "POST", body: form}).then(function(response) {
+        if (!response.ok) {
+          response.text().then(console.log);
+        }
+      });
+    };
+    cancel.onclick = function() {
+      close();
+      fetch(transferPath, {method: "DELETE"});
+    };
+    dialog.style.display = "block";
+  }
   ws.onclose = function(event) {
     console.log(event);
     terminal.write('\r\n\nconnection has been terminated from the server-side (hit refresh to restart)\n')